@@ -5,10 +5,17 @@ import (
 	"os"
 
 	"github.com/akamensky/argparse"
+	"github.com/teagan42/snidemind/auth"
+	"github.com/teagan42/snidemind/completions"
 	"github.com/teagan42/snidemind/config"
 	"github.com/teagan42/snidemind/logger"
+	"github.com/teagan42/snidemind/mcp"
+	"github.com/teagan42/snidemind/memory"
 	"github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/quota"
+	"github.com/teagan42/snidemind/responses"
 	"github.com/teagan42/snidemind/server"
+	"github.com/teagan42/snidemind/telemetry"
 	"go.uber.org/fx"
 )
 
@@ -76,7 +83,14 @@ func main() {
 		Module,
 		logger.Module,
 		config.Module,
+		telemetry.Module,
+		mcp.Module,
 		pipeline.Module,
+		quota.Module,
+		auth.Module,
+		completions.Module,
+		responses.Module,
+		memory.Module,
 		server.Module,
 	)
 
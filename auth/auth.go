@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Authenticator resolves bearer tokens to the identities configured under `auth.keys`.
+type Authenticator struct {
+	identities map[string]*models.Identity // Keyed by the hex SHA-256 of the API key
+	enabled    bool
+	logger     *zap.Logger
+}
+
+type Params struct {
+	fx.In
+	Config *config.Config
+	Logger *zap.Logger
+}
+
+type Result struct {
+	fx.Out
+	Authenticator *Authenticator
+}
+
+// HashKey is how API keys are written in config: the hex SHA-256 of the key.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAuthenticator builds the key table from config. Without an `auth` section authentication is off
+// and Authenticate is never asked.
+func NewAuthenticator(p Params) (Result, error) {
+	logger := p.Logger.Named("Authenticator")
+	authenticator := &Authenticator{identities: map[string]*models.Identity{}, logger: logger}
+	if p.Config.Auth == nil {
+		logger.Info("No API keys configured, authentication is off")
+		return Result{Authenticator: authenticator}, nil
+	}
+	authenticator.enabled = true
+	for i, key := range p.Config.Auth.Keys {
+		hash := strings.ToLower(key.KeyHash)
+		if _, ok := authenticator.identities[hash]; ok {
+			return Result{}, fmt.Errorf("auth.keys[%d] (%s): key_hash is already used by another key", i, key.Name)
+		}
+		for _, name := range key.Pipelines {
+			if _, ok := p.Config.GetPipeline(name); !ok {
+				return Result{}, fmt.Errorf("auth.keys[%d] (%s): unknown pipeline %q", i, key.Name, name)
+			}
+		}
+		for _, name := range key.MCPServers {
+			if !hasMCPServer(p.Config, name) {
+				return Result{}, fmt.Errorf("auth.keys[%d] (%s): unknown MCP server %q", i, key.Name, name)
+			}
+		}
+		authenticator.identities[hash] = &models.Identity{
+			Name:       key.Name,
+			Pipelines:  key.Pipelines,
+			Models:     key.Models,
+			MCPServers: key.MCPServers,
+		}
+	}
+	logger.Info("API keys loaded", zap.Int("keys", len(authenticator.identities)))
+	return Result{Authenticator: authenticator}, nil
+}
+
+func hasMCPServer(c *config.Config, name string) bool {
+	if c.MCPServers == nil {
+		return false
+	}
+	return slices.ContainsFunc(*c.MCPServers, func(server config.MCPServerConfig) bool {
+		return server.Name == name
+	})
+}
+
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// Authenticate returns the identity the API key belongs to. Only hashes are compared, so the lookup
+// leaks nothing about the keys themselves.
+func (a *Authenticator) Authenticate(key string) (*models.Identity, bool) {
+	if key == "" {
+		return nil, false
+	}
+	identity, ok := a.identities[HashKey(key)]
+	return identity, ok
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"go.uber.org/zap"
+)
+
+func newAuthenticator(t *testing.T, c *config.Config) (*Authenticator, error) {
+	t.Helper()
+	result, err := NewAuthenticator(Params{Config: c, Logger: zap.NewNop()})
+	return result.Authenticator, err
+}
+
+func TestNewAuthenticator_Disabled(t *testing.T) {
+	authenticator, err := newAuthenticator(t, &config.Config{})
+	require.NoError(t, err)
+	assert.False(t, authenticator.Enabled())
+	_, ok := authenticator.Authenticate("sk-anything")
+	assert.False(t, ok)
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	authenticator, err := newAuthenticator(t, &config.Config{
+		Pipelines:  map[string]config.PipelineConfig{"home": {}},
+		MCPServers: &[]config.MCPServerConfig{{Name: "home-assistant"}},
+		Auth: &config.AuthConfig{Keys: []config.APIKeyConfig{
+			{Name: "kitchen", KeyHash: HashKey("sk-kitchen"), Pipelines: []string{"Home"}, Models: []string{"mistral"}, MCPServers: []string{"home-assistant"}},
+		}},
+	})
+	require.NoError(t, err)
+	assert.True(t, authenticator.Enabled())
+
+	identity, ok := authenticator.Authenticate("sk-kitchen")
+	require.True(t, ok)
+	assert.Equal(t, "kitchen", identity.Name)
+	assert.True(t, identity.AllowsPipeline("home"))
+	assert.False(t, identity.AllowsPipeline("admin"))
+	assert.True(t, identity.AllowsModel("mistral"))
+	assert.False(t, identity.AllowsModel("gpt-4o"))
+	assert.True(t, identity.AllowsMCPServer("home-assistant"))
+	assert.False(t, identity.AllowsMCPServer("github"))
+
+	for _, key := range []string{"", "sk-garage", HashKey("sk-kitchen")} {
+		_, ok := authenticator.Authenticate(key)
+		assert.False(t, ok, key)
+	}
+}
+
+func TestNewAuthenticator_RejectsBadKeys(t *testing.T) {
+	hash := HashKey("sk-kitchen")
+	for name, keys := range map[string][]config.APIKeyConfig{
+		"duplicate hash":     {{Name: "a", KeyHash: hash}, {Name: "b", KeyHash: hash}},
+		"unknown pipeline":   {{Name: "a", KeyHash: hash, Pipelines: []string{"nope"}}},
+		"unknown MCP server": {{Name: "a", KeyHash: hash, MCPServers: []string{"nope"}}},
+	} {
+		_, err := newAuthenticator(t, &config.Config{Auth: &config.AuthConfig{Keys: keys}})
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,10 @@
+package auth
+
+import "go.uber.org/fx"
+
+var Module = fx.Module(
+	"auth",
+	fx.Provide(
+		NewAuthenticator,
+	),
+)
@@ -0,0 +1,10 @@
+package completions
+
+import "go.uber.org/fx"
+
+var Module = fx.Module(
+	"completions",
+	fx.Provide(
+		NewStore,
+	),
+)
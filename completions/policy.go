@@ -0,0 +1,28 @@
+package completions
+
+import (
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+)
+
+// RequestedKey is set by pipeline references to pipelines configured with `store: true`, fork branches
+// asking for it win over those that don't.
+var RequestedKey = models.NewMergeableStateKey("store", func(current bool, incoming bool) bool {
+	return current || incoming
+})
+
+// ShouldStore reports whether the completion made for the message should be stored: because the request
+// said `store: true`, or because the main pipeline or a pipeline it referenced is configured to store.
+func ShouldStore(message *models.PipelineMessage, c *config.Config) bool {
+	if message == nil || message.Response == nil {
+		return false
+	}
+	if message.Request != nil && message.Request.Store != nil && *message.Request.Store {
+		return true
+	}
+	if c != nil && c.Pipeline != nil && c.Pipeline.Store {
+		return true
+	}
+	requested, _ := models.GetState(message, RequestedKey)
+	return requested
+}
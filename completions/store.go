@@ -0,0 +1,246 @@
+package completions
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/filestore"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultDir   = "completions"
+	DefaultLimit = 20
+	OrderAsc     = "asc"
+	OrderDesc    = "desc"
+
+	maxMetadataPairs  = 16
+	maxMetadataKey    = 64
+	maxMetadataValue  = 512
+	completionObject  = "chat.completion"
+	deletedObject     = "chat.completion.deleted"
+	listObject        = "list"
+	generatedIDPrefix = "chatcmpl-"
+)
+
+var ErrNotFound = errors.New("chat completion not found")
+
+// IDs end up as file names, anything else an upstream comes up with is replaced.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Record is what is kept for one stored completion.
+type Record struct {
+	Completion models.StoredChatCompletion `json:"completion"`
+	Messages   []models.ChatMessage        `json:"messages"`        // The messages the completion answered
+	Owner      string                      `json:"owner,omitempty"` // The identity that stored it, see the `auth` section
+}
+
+// ListOptions filter and page the stored completions. Metadata matches completions having every pair.
+type ListOptions struct {
+	Model    string
+	Metadata map[string]string
+	After    string
+	Limit    int
+	Order    string
+}
+
+// Store keeps stored completions as one JSON file each, with every record in memory for listing.
+type Store struct {
+	records *filestore.Store[Record]
+	logger  *zap.Logger
+}
+
+type Params struct {
+	fx.In
+	Config *config.Config
+	Logger *zap.Logger
+}
+
+type Result struct {
+	fx.Out
+	Store *Store
+}
+
+// NewStore loads the completions stored by earlier runs. A missing directory is created on the first save.
+func NewStore(p Params) (Result, error) {
+	dir := DefaultDir
+	if p.Config.Completions != nil && p.Config.Completions.Dir != "" {
+		dir = p.Config.Completions.Dir
+	}
+	records, err := filestore.Open(dir, func(r Record) string { return r.Completion.ID }, func(r Record) string { return r.Owner })
+	if err != nil {
+		return Result{}, err
+	}
+	store := &Store{records: records, logger: p.Logger.Named("CompletionStore")}
+	store.logger.Info("Stored completions loaded", zap.String("dir", dir), zap.Int("completions", records.Len()))
+	return Result{Store: store}, nil
+}
+
+// ValidateMetadata applies OpenAI's limits: 16 pairs, keys up to 64 characters and values up to 512.
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataPairs {
+		return fmt.Errorf("metadata can have at most %d pairs, got %d", maxMetadataPairs, len(metadata))
+	}
+	for key, value := range metadata {
+		if len(key) > maxMetadataKey {
+			return fmt.Errorf("metadata key %q is longer than %d characters", key, maxMetadataKey)
+		}
+		if len(value) > maxMetadataValue {
+			return fmt.Errorf("metadata value for %q is longer than %d characters", key, maxMetadataValue)
+		}
+	}
+	return nil
+}
+
+// Save stores the response to request on behalf of owner and returns what was stored. The upstream's ID is kept
+// when it's usable and not taken, so the client can look the completion up by the ID it was given.
+func (s *Store) Save(request *models.ChatCompletionRequest, response *models.ChatCompletionResponse, owner string) (models.StoredChatCompletion, error) {
+	completion := models.StoredChatCompletion{ChatCompletionResponse: *response, Metadata: maps.Clone(request.Metadata)}
+	completion.Choices = slices.Clone(response.Choices)
+	if completion.Metadata == nil {
+		completion.Metadata = map[string]string{}
+	}
+	if completion.Object == "" {
+		completion.Object = completionObject
+	}
+	if completion.Model == "" {
+		completion.Model = request.Model
+	}
+	if completion.Created == 0 {
+		completion.Created = time.Now().Unix()
+	}
+	record := Record{Completion: completion, Messages: slices.Clone(request.Messages), Owner: owner}
+	if !validID.MatchString(record.Completion.ID) {
+		record.Completion.ID = utils.NewID(generatedIDPrefix)
+	}
+	for {
+		err := s.records.Add(record)
+		if !errors.Is(err, filestore.ErrExists) {
+			return record.Completion, err
+		}
+		record.Completion.ID = utils.NewID(generatedIDPrefix)
+	}
+}
+
+// record returns the owner's completion. Someone else's completion is as not found as one that doesn't exist.
+func (s *Store) record(id string, owner string) (Record, error) {
+	record, ok := s.records.Get(id, owner)
+	if !ok {
+		return Record{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return record, nil
+}
+
+func (s *Store) Get(id string, owner string) (models.StoredChatCompletion, error) {
+	record, err := s.record(id, owner)
+	if err != nil {
+		return models.StoredChatCompletion{}, err
+	}
+	return record.Completion, nil
+}
+
+// UpdateMetadata replaces the completion's metadata, which is the only part of a stored completion that can change.
+func (s *Store) UpdateMetadata(id string, owner string, metadata map[string]string) (models.StoredChatCompletion, error) {
+	updated, ok, err := s.records.Update(id, owner, func(record Record) Record {
+		record.Completion.Metadata = maps.Clone(metadata)
+		if record.Completion.Metadata == nil {
+			record.Completion.Metadata = map[string]string{}
+		}
+		return record
+	})
+	if !ok {
+		return models.StoredChatCompletion{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return models.StoredChatCompletion{}, err
+	}
+	return updated.Completion, nil
+}
+
+func (s *Store) Delete(id string, owner string) (models.ChatCompletionDeleted, error) {
+	deleted, err := s.records.Delete(id, owner)
+	if !deleted {
+		return models.ChatCompletionDeleted{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return models.ChatCompletionDeleted{}, err
+	}
+	return models.ChatCompletionDeleted{Object: deletedObject, ID: id, Deleted: true}, nil
+}
+
+func (s *Store) List(owner string, options ListOptions) models.ListResponse[models.StoredChatCompletion] {
+	completions := []models.StoredChatCompletion{}
+	for _, record := range s.records.List(owner) {
+		if options.Model != "" && record.Completion.Model != options.Model {
+			continue
+		}
+		if !hasMetadata(record.Completion.Metadata, options.Metadata) {
+			continue
+		}
+		completions = append(completions, record.Completion)
+	}
+	slices.SortFunc(completions, func(a, b models.StoredChatCompletion) int {
+		return cmp.Or(cmp.Compare(a.Created, b.Created), strings.Compare(a.ID, b.ID))
+	})
+	return page(completions, func(c models.StoredChatCompletion) string { return c.ID }, options.After, options.Limit, options.Order)
+}
+
+// Messages lists the messages the completion was asked to complete.
+func (s *Store) Messages(id string, owner string, after string, limit int, order string) (models.ListResponse[models.StoredChatMessage], error) {
+	record, err := s.record(id, owner)
+	if err != nil {
+		return models.ListResponse[models.StoredChatMessage]{}, err
+	}
+	messages := make([]models.StoredChatMessage, 0, len(record.Messages))
+	for i, message := range record.Messages {
+		messages = append(messages, models.StoredChatMessage{ID: fmt.Sprintf("%s-%d", id, i), ChatMessage: message})
+	}
+	return page(messages, func(m models.StoredChatMessage) string { return m.ID }, after, limit, order), nil
+}
+
+func hasMetadata(metadata map[string]string, filter map[string]string) bool {
+	for key, value := range filter {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// page cuts one page out of items, which are in ascending order. Pages start after the item with ID after,
+// counting in the requested order; an unknown after gives an empty page.
+func page[T any](items []T, id func(T) string, after string, limit int, order string) models.ListResponse[T] {
+	if order == OrderDesc {
+		slices.Reverse(items)
+	}
+	if after != "" {
+		index := slices.IndexFunc(items, func(item T) bool { return id(item) == after })
+		if index < 0 {
+			items = nil
+		} else {
+			items = items[index+1:]
+		}
+	}
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	list := models.ListResponse[T]{Object: listObject, Data: []T{}}
+	if len(items) > limit {
+		items, list.HasMore = items[:limit], true
+	}
+	list.Data = append(list.Data, items...)
+	if len(items) > 0 {
+		list.FirstID, list.LastID = id(items[0]), id(items[len(items)-1])
+	}
+	return list
+}
@@ -0,0 +1,152 @@
+package completions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+func newStore(t *testing.T, dir string) *Store {
+	t.Helper()
+	result, err := NewStore(Params{Config: &config.Config{Completions: &config.CompletionsConfig{Dir: dir}}, Logger: zap.NewNop()})
+	require.NoError(t, err)
+	return result.Store
+}
+
+func save(t *testing.T, store *Store, id string, model string, created int64, metadata map[string]string, owner string) models.StoredChatCompletion {
+	t.Helper()
+	request := &models.ChatCompletionRequest{
+		Model:    model,
+		Metadata: metadata,
+		Messages: []models.ChatMessage{{Role: "system", Content: "be snide"}, {Role: "user", Content: "hi"}},
+	}
+	response := &models.ChatCompletionResponse{ID: id, Created: created, Choices: []models.ChatCompletionChoice{{Message: models.ChatMessage{Role: "assistant", Content: "what"}}}}
+	completion, err := store.Save(request, response, owner)
+	require.NoError(t, err)
+	return completion
+}
+
+func ids(list models.ListResponse[models.StoredChatCompletion]) []string {
+	result := []string{}
+	for _, completion := range list.Data {
+		result = append(result, completion.ID)
+	}
+	return result
+}
+
+func TestStore_SaveAndGet(t *testing.T) {
+	store := newStore(t, t.TempDir())
+	completion := save(t, store, "chatcmpl-1", "mistral", 100, map[string]string{"room": "kitchen"}, "")
+	assert.Equal(t, "chatcmpl-1", completion.ID)
+	assert.Equal(t, "chat.completion", completion.Object)
+	assert.Equal(t, "mistral", completion.Model)
+
+	got, err := store.Get("chatcmpl-1", "")
+	require.NoError(t, err)
+	assert.Equal(t, completion, got)
+
+	_, err = store.Get("chatcmpl-2", "")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStore_ReplacesUnusableIDs(t *testing.T) {
+	store := newStore(t, t.TempDir())
+	first := save(t, store, "chatcmpl-1", "mistral", 100, nil, "")
+	for _, id := range []string{"chatcmpl-1", "", "../../etc/passwd"} {
+		completion := save(t, store, id, "mistral", 100, nil, "")
+		assert.NotEqual(t, first.ID, completion.ID)
+		assert.Regexp(t, `^chatcmpl-[0-9a-f]{24}$`, completion.ID)
+	}
+}
+
+func TestStore_List(t *testing.T) {
+	store := newStore(t, t.TempDir())
+	save(t, store, "c", "mistral", 300, map[string]string{"room": "kitchen"}, "")
+	save(t, store, "a", "mistral", 100, map[string]string{"room": "kitchen", "mood": "bad"}, "")
+	save(t, store, "b", "llama", 200, nil, "")
+	save(t, store, "d", "mistral", 50, nil, "someone-else")
+
+	assert.Equal(t, []string{"a", "b", "c"}, ids(store.List("", ListOptions{})))
+	assert.Equal(t, []string{"c", "b", "a"}, ids(store.List("", ListOptions{Order: OrderDesc})))
+	assert.Equal(t, []string{"a", "c"}, ids(store.List("", ListOptions{Model: "mistral"})))
+	assert.Equal(t, []string{"a"}, ids(store.List("", ListOptions{Metadata: map[string]string{"room": "kitchen", "mood": "bad"}})))
+	assert.Equal(t, []string{"d"}, ids(store.List("someone-else", ListOptions{})))
+
+	first := store.List("", ListOptions{Limit: 2})
+	assert.Equal(t, []string{"a", "b"}, ids(first))
+	assert.True(t, first.HasMore)
+	assert.Equal(t, "a", first.FirstID)
+	assert.Equal(t, "b", first.LastID)
+	second := store.List("", ListOptions{Limit: 2, After: first.LastID})
+	assert.Equal(t, []string{"c"}, ids(second))
+	assert.False(t, second.HasMore)
+	assert.Empty(t, store.List("", ListOptions{After: "nope"}).Data)
+}
+
+func TestStore_UpdateDeleteAndReload(t *testing.T) {
+	dir := t.TempDir()
+	store := newStore(t, dir)
+	save(t, store, "chatcmpl-1", "mistral", 100, map[string]string{"room": "kitchen"}, "kitchen")
+	save(t, store, "chatcmpl-2", "mistral", 200, nil, "kitchen")
+
+	_, err := store.UpdateMetadata("chatcmpl-1", "garage", map[string]string{"room": "garage"})
+	assert.ErrorIs(t, err, ErrNotFound, "other identities can't see the completion")
+	updated, err := store.UpdateMetadata("chatcmpl-1", "kitchen", map[string]string{"room": "lounge"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"room": "lounge"}, updated.Metadata)
+
+	deleted, err := store.Delete("chatcmpl-2", "kitchen")
+	require.NoError(t, err)
+	assert.Equal(t, models.ChatCompletionDeleted{Object: "chat.completion.deleted", ID: "chatcmpl-2", Deleted: true}, deleted)
+	_, err = store.Delete("chatcmpl-2", "kitchen")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	reloaded := newStore(t, dir)
+	assert.Equal(t, []string{"chatcmpl-1"}, ids(reloaded.List("kitchen", ListOptions{})))
+	got, err := reloaded.Get("chatcmpl-1", "kitchen")
+	require.NoError(t, err)
+	assert.Equal(t, "lounge", got.Metadata["room"])
+}
+
+func TestStore_Messages(t *testing.T) {
+	store := newStore(t, t.TempDir())
+	save(t, store, "chatcmpl-1", "mistral", 100, nil, "")
+
+	messages, err := store.Messages("chatcmpl-1", "", "", 0, OrderAsc)
+	require.NoError(t, err)
+	require.Len(t, messages.Data, 2)
+	assert.Equal(t, "chatcmpl-1-0", messages.Data[0].ID)
+	assert.Equal(t, "be snide", messages.Data[0].Content)
+
+	messages, err = store.Messages("chatcmpl-1", "", "", 1, OrderDesc)
+	require.NoError(t, err)
+	assert.Equal(t, "chatcmpl-1-1", messages.FirstID)
+	assert.True(t, messages.HasMore)
+}
+
+func TestValidateMetadata(t *testing.T) {
+	assert.NoError(t, ValidateMetadata(map[string]string{"room": "kitchen"}))
+	tooMany := map[string]string{}
+	for i := range 17 {
+		tooMany[string(rune('a'+i))] = "x"
+	}
+	assert.Error(t, ValidateMetadata(tooMany))
+	assert.Error(t, ValidateMetadata(map[string]string{string(make([]byte, 65)): "x"}))
+}
+
+func TestShouldStore(t *testing.T) {
+	yes := true
+	response := &models.ChatCompletionResponse{}
+	assert.False(t, ShouldStore(&models.PipelineMessage{Request: &models.ChatCompletionRequest{}, Response: response}, &config.Config{}))
+	assert.True(t, ShouldStore(&models.PipelineMessage{Request: &models.ChatCompletionRequest{Store: &yes}, Response: response}, &config.Config{}))
+	assert.False(t, ShouldStore(&models.PipelineMessage{Request: &models.ChatCompletionRequest{Store: &yes}}, &config.Config{}), "nothing to store without a response")
+	assert.True(t, ShouldStore(&models.PipelineMessage{Request: &models.ChatCompletionRequest{}, Response: response}, &config.Config{Pipeline: &config.PipelineConfig{Store: true}}))
+
+	referenced := &models.PipelineMessage{Request: &models.ChatCompletionRequest{}, Response: response}
+	models.SetState(referenced, RequestedKey, true)
+	assert.True(t, ShouldStore(referenced, &config.Config{}))
+}
@@ -35,6 +35,10 @@ func LoadConfig(p Params) (Result, error) {
 		return result, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if err := ExpandTemplates(cfgRaw); err != nil {
+		return result, fmt.Errorf("failed to expand templates: %w", err)
+	}
+
 	rawBytes, err := json.Marshal(cfgRaw)
 	if err != nil {
 		return result, fmt.Errorf("failed to marshal config to raw JSON: %w", err)
@@ -48,6 +52,9 @@ func LoadConfig(p Params) (Result, error) {
 	if err := validate.Struct(cfg); err != nil {
 		return result, fmt.Errorf("validation error: %w", err)
 	}
+	if err := cfg.ValidatePipelineRefs(); err != nil {
+		return result, fmt.Errorf("validation error: %w", err)
+	}
 
 	if p.BindAddress != nil {
 		cfg.Server.Bind = p.BindAddress
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// StepConfigDefaults can be implemented by a step's config struct to fill in defaults before the section is decoded.
+type StepConfigDefaults interface {
+	SetDefaults()
+}
+
+// PipelineStepConfig holds the keys every step understands. Everything else in the step's node is kept in
+// Raw and decoded by the step's factory into its own config struct (see DecodeSection).
+type PipelineStepConfig struct {
+	Type     string         `json:"type" yaml:"type" validate:"required"`
+	Template string         `json:"template,omitempty" yaml:"template,omitempty" validate:"omitempty"`
+	When     *StepCondition `json:"when,omitempty" yaml:"when,omitempty" validate:"omitempty"`
+	Raw      map[string]any `json:"-" yaml:"-"`
+}
+
+type pipelineStepConfigFields PipelineStepConfig
+
+func (s *PipelineStepConfig) UnmarshalJSON(data []byte) error {
+	var fields pipelineStepConfigFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = PipelineStepConfig(fields)
+	s.Raw = raw
+	return nil
+}
+
+func (s PipelineStepConfig) MarshalJSON() ([]byte, error) {
+	node := maps.Clone(s.Raw)
+	if node == nil {
+		node = map[string]any{}
+	}
+	node["type"] = s.Type
+	if s.Template != "" {
+		node["template"] = s.Template
+	}
+	if s.When != nil {
+		node["when"] = s.When
+	}
+	return json.Marshal(node)
+}
+
+// Has reports whether the step's node contains the key.
+func (s PipelineStepConfig) Has(key string) bool {
+	_, ok := s.lookup(key)
+	return ok
+}
+
+// Viper lowercases keys, so sections are looked up case-insensitively.
+func (s PipelineStepConfig) lookup(key string) (any, bool) {
+	if value, ok := s.Raw[key]; ok {
+		return value, true
+	}
+	for name, value := range s.Raw {
+		if normalizeName(name) == normalizeName(key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// DecodeSection decodes the step's `key` section (or the whole node when key is "") into T, applying
+// T's defaults first and validating the result. Errors name the offending field, e.g. `llm.temperature`.
+func DecodeSection[T any](s PipelineStepConfig, key string) (T, error) {
+	var section T
+	if defaults, ok := any(&section).(StepConfigDefaults); ok {
+		defaults.SetDefaults()
+	}
+	var node any = s.Raw
+	if key != "" {
+		value, ok := s.lookup(key)
+		if !ok {
+			return section, fmt.Errorf("%s: section is required", key)
+		}
+		node = value
+	}
+	data, err := json.Marshal(node)
+	if err != nil {
+		return section, fmt.Errorf("%s: %w", sectionName(key), err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if key != "" {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&section); err != nil {
+		return section, decodeError(key, err)
+	}
+	if err := validateSection(key, section); err != nil {
+		return section, err
+	}
+	return section, nil
+}
+
+func sectionName(key string) string {
+	if key == "" {
+		return "step"
+	}
+	return key
+}
+
+func joinPath(key string, field string) string {
+	if key == "" || field == "" {
+		return key + field
+	}
+	return key + "." + field
+}
+
+func decodeError(key string, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("%s: expected %s, got %s", joinPath(key, typeErr.Field), typeErr.Type, typeErr.Value)
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return fmt.Errorf("%s: unknown field", joinPath(key, strings.Trim(field, `"`)))
+	}
+	return fmt.Errorf("%s: %w", sectionName(key), err)
+}
+
+var sectionValidator = func() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}()
+
+func validateSection(key string, section any) error {
+	value := reflect.ValueOf(section)
+	for value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	err := sectionValidator.Struct(section)
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+	messages := make([]string, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		// Drop the struct's type name from the namespace, it means nothing to whoever wrote the YAML.
+		_, field, _ := strings.Cut(fieldErr.Namespace(), ".")
+		message := fmt.Sprintf("%s: failed %q", joinPath(key, field), fieldErr.Tag())
+		if fieldErr.Param() != "" {
+			message += fmt.Sprintf(" (%s)", fieldErr.Param())
+		}
+		messages = append(messages, message)
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSection struct {
+	Model       string   `json:"model" validate:"required"`
+	Temperature *float64 `json:"temperature,omitempty" validate:"omitempty,min=0,max=1"`
+	Retries     int      `json:"retries,omitempty" validate:"min=0"`
+}
+
+func (t *testSection) SetDefaults() {
+	t.Retries = 3
+}
+
+func decodeStep(t *testing.T, data string) PipelineStepConfig {
+	var step PipelineStepConfig
+	require.NoError(t, json.Unmarshal([]byte(data), &step))
+	return step
+}
+
+func TestPipelineStepConfig_JSONRoundTrip(t *testing.T) {
+	step := decodeStep(t, `{"type":"custom","when":{"users":["alice"]},"custom":{"model":"m"}}`)
+	assert.Equal(t, "custom", step.Type)
+	require.NotNil(t, step.When)
+	assert.Equal(t, []string{"alice"}, step.When.Users)
+	assert.True(t, step.Has("custom"))
+	assert.True(t, step.Has("CUSTOM"))
+	assert.False(t, step.Has("llm"))
+
+	data, err := json.Marshal(step)
+	require.NoError(t, err)
+	assert.Equal(t, step, decodeStep(t, string(data)))
+}
+
+func TestDecodeSection(t *testing.T) {
+	section, err := DecodeSection[testSection](decodeStep(t, `{"type":"custom","custom":{"model":"m","temperature":0.5}}`), "custom")
+	require.NoError(t, err)
+	assert.Equal(t, "m", section.Model)
+	assert.Equal(t, 0.5, *section.Temperature)
+	assert.Equal(t, 3, section.Retries, "defaults should apply to fields the config leaves out")
+
+	section, err = DecodeSection[testSection](decodeStep(t, `{"type":"custom","custom":{"model":"m","retries":0}}`), "custom")
+	require.NoError(t, err)
+	assert.Equal(t, 0, section.Retries, "explicit values should override defaults")
+}
+
+func TestDecodeSection_RootNode(t *testing.T) {
+	type refSection struct {
+		Ref string `json:"ref" validate:"required"`
+	}
+	section, err := DecodeSection[refSection](decodeStep(t, `{"type":"pipeline","ref":"lookup"}`), "")
+	require.NoError(t, err)
+	assert.Equal(t, "lookup", section.Ref)
+
+	_, err = DecodeSection[refSection](decodeStep(t, `{"type":"pipeline"}`), "")
+	assert.EqualError(t, err, `ref: failed "required"`)
+}
+
+func TestDecodeSection_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    string
+		wantErr string
+	}{
+		{"MissingSection", `{"type":"custom"}`, "custom: section is required"},
+		{"MissingField", `{"type":"custom","custom":{}}`, `custom.model: failed "required"`},
+		{"OutOfRange", `{"type":"custom","custom":{"model":"m","temperature":2}}`, `custom.temperature: failed "max" (1)`},
+		{"WrongType", `{"type":"custom","custom":{"model":"m","temperature":"hot"}}`, "custom.temperature: expected float64, got string"},
+		{"UnknownField", `{"type":"custom","custom":{"model":"m","temprature":0.5}}`, "custom.temprature: unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeSection[testSection](decodeStep(t, tt.step), "custom")
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Viper lowercases every key it reads, so names of templates and pipelines are compared case-insensitively.
+func normalizeName(name string) string {
+	return strings.ToLower(name)
+}
+
+// mergeRaw deep merges override on top of base. Nested maps are merged, everything else is replaced.
+func mergeRaw(base map[string]any, override map[string]any) map[string]any {
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = map[string]any{}
+	}
+	for key, value := range override {
+		if overrideMap, ok := value.(map[string]any); ok {
+			if baseMap, ok := merged[key].(map[string]any); ok {
+				merged[key] = mergeRaw(baseMap, overrideMap)
+				continue
+			}
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+type templateResolver struct {
+	templates map[string]map[string]any
+	resolved  map[string]map[string]any
+}
+
+func (r *templateResolver) resolve(name string, chain []string) (map[string]any, error) {
+	key := normalizeName(name)
+	if slices.Contains(chain, key) {
+		return nil, fmt.Errorf("template cycle: %s -> %s", strings.Join(chain, " -> "), key)
+	}
+	if resolved, ok := r.resolved[key]; ok {
+		return resolved, nil
+	}
+	template, ok := r.templates[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q", name)
+	}
+	resolved := template
+	if parent, ok := template["template"].(string); ok && parent != "" {
+		base, err := r.resolve(parent, append(slices.Clone(chain), key))
+		if err != nil {
+			return nil, err
+		}
+		resolved = mergeRaw(base, template)
+	}
+	resolved = maps.Clone(resolved)
+	delete(resolved, "template")
+	r.resolved[key] = resolved
+	return resolved, nil
+}
+
+// expand walks the raw config and replaces every step that names a `template` with the template
+// merged with the step's own keys, which act as overrides.
+func (r *templateResolver) expand(node any, path string) (any, error) {
+	switch value := node.(type) {
+	case map[string]any:
+		if name, ok := value["template"].(string); ok && name != "" {
+			template, err := r.resolve(name, nil)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			overrides := maps.Clone(value)
+			delete(overrides, "template")
+			value = mergeRaw(template, overrides)
+			value["template"] = name
+		}
+		expanded := make(map[string]any, len(value))
+		for key, child := range value {
+			result, err := r.expand(child, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			expanded[key] = result
+		}
+		return expanded, nil
+	case []any:
+		expanded := make([]any, len(value))
+		for i, child := range value {
+			result, err := r.expand(child, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			expanded[i] = result
+		}
+		return expanded, nil
+	default:
+		return node, nil
+	}
+}
+
+// ExpandTemplates resolves `template` references in the pipeline and named pipelines of a raw config.
+func ExpandTemplates(raw map[string]any) error {
+	resolver := &templateResolver{
+		templates: map[string]map[string]any{},
+		resolved:  map[string]map[string]any{},
+	}
+	if templates, ok := raw["templates"].(map[string]any); ok {
+		for name, template := range templates {
+			templateMap, ok := template.(map[string]any)
+			if !ok {
+				return fmt.Errorf("templates.%s: template must be a mapping", name)
+			}
+			resolver.templates[normalizeName(name)] = templateMap
+		}
+	}
+	for _, key := range []string{"pipeline", "pipelines"} {
+		if node, ok := raw[key]; ok {
+			expanded, err := resolver.expand(node, key)
+			if err != nil {
+				return err
+			}
+			raw[key] = expanded
+		}
+	}
+	return nil
+}
+
+// collectRefs finds every `pipeline` step, however deeply it is nested inside other steps' sections.
+// Step sections are opaque to the config package, so the pipeline is walked as plain JSON.
+func collectRefs(pipeline PipelineConfig) []string {
+	data, err := json.Marshal(pipeline)
+	if err != nil {
+		return nil
+	}
+	var node any
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil
+	}
+	return findRefs(node)
+}
+
+func findRefs(node any) []string {
+	refs := []string{}
+	switch value := node.(type) {
+	case map[string]any:
+		if value["type"] == "pipeline" {
+			if ref, ok := value["ref"].(string); ok && ref != "" {
+				refs = append(refs, ref)
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(value)) {
+			refs = append(refs, findRefs(value[key])...)
+		}
+	case []any:
+		for _, child := range value {
+			refs = append(refs, findRefs(child)...)
+		}
+	}
+	return refs
+}
+
+// GetPipeline looks up a named pipeline.
+func (c *Config) GetPipeline(name string) (PipelineConfig, bool) {
+	for key, pipeline := range c.Pipelines {
+		if normalizeName(key) == normalizeName(name) {
+			return pipeline, true
+		}
+	}
+	return PipelineConfig{}, false
+}
+
+func (c *Config) checkRefs(name string, pipeline PipelineConfig, chain []string) error {
+	for _, ref := range collectRefs(pipeline) {
+		key := normalizeName(ref)
+		if slices.Contains(chain, key) {
+			return fmt.Errorf("pipeline reference cycle: %s -> %s", strings.Join(chain, " -> "), key)
+		}
+		referenced, ok := c.GetPipeline(ref)
+		if !ok {
+			return fmt.Errorf("%s references unknown pipeline %q", name, ref)
+		}
+		if err := c.checkRefs(key, referenced, append(slices.Clone(chain), key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidatePipelineRefs checks every `ref` points at a declared pipeline and that no pipeline references itself.
+func (c *Config) ValidatePipelineRefs() error {
+	if c.Pipeline != nil {
+		if err := c.checkRefs("pipeline", *c.Pipeline, []string{}); err != nil {
+			return err
+		}
+	}
+	for name, pipeline := range c.Pipelines {
+		key := normalizeName(name)
+		if err := c.checkRefs("pipelines."+key, pipeline, []string{key}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTempYAMLConfig(t *testing.T, content string) string {
+	t.Helper()
+	cfgPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(cfgPath, []byte(content), 0644))
+	return cfgPath
+}
+
+func TestExpandTemplates_MergesOverrides(t *testing.T) {
+	raw := map[string]any{
+		"templates": map[string]any{
+			"base": map[string]any{
+				"type": "llm",
+				"llm":  map[string]any{"model": "mistral", "base_url": "http://localhost"},
+			},
+			"fast": map[string]any{
+				"template": "base",
+				"llm":      map[string]any{"model": "qwen"},
+			},
+		},
+		"pipeline": map[string]any{
+			"steps": []any{
+				map[string]any{"template": "fast", "llm": map[string]any{"temperature": 0.2}},
+			},
+		},
+	}
+
+	require.NoError(t, ExpandTemplates(raw))
+
+	step := raw["pipeline"].(map[string]any)["steps"].([]any)[0].(map[string]any)
+	require.Equal(t, "llm", step["type"])
+	require.Equal(t, map[string]any{"model": "qwen", "base_url": "http://localhost", "temperature": 0.2}, step["llm"])
+}
+
+func TestExpandTemplates_NestedSteps(t *testing.T) {
+	raw := map[string]any{
+		"templates": map[string]any{
+			"memory": map[string]any{"type": "retrieveMemory"},
+		},
+		"pipelines": map[string]any{
+			"lookup": map[string]any{
+				"steps": []any{
+					map[string]any{
+						"type": "fork",
+						"fork": []any{
+							map[string]any{"steps": []any{map[string]any{"template": "memory"}}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	require.NoError(t, ExpandTemplates(raw))
+
+	fork := raw["pipelines"].(map[string]any)["lookup"].(map[string]any)["steps"].([]any)[0].(map[string]any)["fork"].([]any)
+	nested := fork[0].(map[string]any)["steps"].([]any)[0].(map[string]any)
+	require.Equal(t, "retrieveMemory", nested["type"])
+}
+
+func TestExpandTemplates_UnknownTemplate(t *testing.T) {
+	raw := map[string]any{
+		"pipeline": map[string]any{"steps": []any{map[string]any{"template": "missing"}}},
+	}
+	err := ExpandTemplates(raw)
+	require.EqualError(t, err, `pipeline.steps[0]: unknown template "missing"`)
+}
+
+func TestExpandTemplates_Cycle(t *testing.T) {
+	raw := map[string]any{
+		"templates": map[string]any{
+			"a": map[string]any{"template": "b"},
+			"b": map[string]any{"template": "a"},
+		},
+		"pipeline": map[string]any{"steps": []any{map[string]any{"template": "a"}}},
+	}
+	err := ExpandTemplates(raw)
+	require.EqualError(t, err, "pipeline.steps[0]: template cycle: a -> b -> a")
+}
+
+func TestValidatePipelineRefs(t *testing.T) {
+	cfg := Config{
+		Pipeline: &PipelineConfig{Steps: []PipelineStepConfig{{Type: "pipeline", Raw: map[string]any{"ref": "outer"}}}},
+		Pipelines: map[string]PipelineConfig{
+			"outer": {Steps: []PipelineStepConfig{{Type: "pipeline", Raw: map[string]any{"ref": "inner"}}}},
+			"inner": {Steps: []PipelineStepConfig{{Type: "storeMemory"}}},
+		},
+	}
+	require.NoError(t, cfg.ValidatePipelineRefs())
+
+	cfg.Pipelines["inner"] = PipelineConfig{Steps: []PipelineStepConfig{{
+		Type: "fork",
+		Raw: map[string]any{
+			"fork": []PipelineConfig{{Steps: []PipelineStepConfig{{Type: "pipeline", Raw: map[string]any{"ref": "outer"}}}}},
+		},
+	}}}
+	require.ErrorContains(t, cfg.ValidatePipelineRefs(), "pipeline reference cycle")
+
+	cfg.Pipelines["inner"] = PipelineConfig{Steps: []PipelineStepConfig{{Type: "pipeline", Raw: map[string]any{"ref": "nope"}}}}
+	require.EqualError(t, cfg.ValidatePipelineRefs(), `inner references unknown pipeline "nope"`)
+}
+
+func TestLoadConfig_TemplatesAndRefs(t *testing.T) {
+	cfgPath := writeTempYAMLConfig(t, `
+server:
+  port: 8080
+templates:
+  localLLM:
+    type: llm
+    llm:
+      model: mistral
+      base_url: http://localhost:11434/v1
+pipelines:
+  memoryLookup:
+    steps:
+      - type: retrieveMemory
+pipeline:
+  steps:
+    - type: pipeline
+      ref: memoryLookup
+    - template: localLLM
+      llm:
+        model: qwen
+`)
+	res, err := LoadConfig(Params{ConfigPath: cfgPath})
+	require.NoError(t, err)
+	steps := res.Config.Pipeline.Steps
+	require.Len(t, steps, 2)
+	require.Equal(t, "memoryLookup", steps[0].Raw["ref"])
+	require.Equal(t, "llm", steps[1].Type)
+	require.Equal(t, map[string]any{"model": "qwen", "base_url": "http://localhost:11434/v1"}, steps[1].Raw["llm"])
+	lookup, ok := res.Config.GetPipeline("memoryLookup")
+	require.True(t, ok)
+	require.Equal(t, "retrieveMemory", lookup.Steps[0].Type)
+}
+
+func TestLoadConfig_RefCycle(t *testing.T) {
+	cfgPath := writeTempYAMLConfig(t, `
+server:
+  port: 8080
+pipelines:
+  a:
+    steps:
+      - type: pipeline
+        ref: b
+  b:
+    steps:
+      - type: pipeline
+        ref: a
+`)
+	res, err := LoadConfig(Params{ConfigPath: cfgPath})
+	require.ErrorContains(t, err, "pipeline reference cycle")
+	require.Nil(t, res.Config)
+}
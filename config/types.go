@@ -83,44 +83,49 @@ func (r RegexList) MarshalYAML() (interface{}, error) {
 }
 
 type Config struct {
-	Server     ServerConfig       `json:"server" yaml:"server" validate:"required"`
-	MCPServers *[]MCPServerConfig `json:"mcp_servers" yaml:"mcp_servers" validate:"omitempty,dive"`
-	Pipeline   *PipelineConfig    `json:"pipeline,omitempty" yaml:"pipeline,omitempty" validate:"omitempty,dive"`
-}
-
-type LLMConfig struct {
-	Model             *string           `json:"model,omitempty" yaml:"model,omitempty" validate:"omitempty,required"`
-	APIKey            *string           `json:"api_key,omitempty" yaml:"api_key,omitempty" validate:"omitempty,required"`
-	APIKeyHeader      *string           `json:"api_key_header,omitempty" yaml:"api_key,omitempty" validate:"omitempty,required=api_key"`
-	BaseURL           string            `json:"base_url,omitempty" yaml:"base_url,omitempty" validate:"omitempty,url"`
-	Timeout           *int              `json:"timeout,omitempty" yaml:"timeout,omitempty" validate:"omitempty,min=1"`
-	Headers           map[string]string `json:"headers,omitempty" yaml:"headers,omitempty" validate:"omitempty,dive,keys,required"`
-	Temperature       *float64          `json:"temperature,omitempty" yaml:"temperature,omitempty" validate:"omitempty,min=0,max=1"`
-	MaxTokens         *int64            `json:"max_tokens,omitempty" yaml:"max_tokens,omitempty" validate:"omitempty,min=1"`
-	TopP              *float64          `json:"top_p,omitempty" yaml:"top_p,omitempty" validate:"omitempty,min=0,max=1"`
-	FrequencyPenalty  *float64          `json:"frequency_penalty,omitempty" yaml:"frequency_penalty,omitempty" validate:"omitempty,min=0,max=1"`
-	PresencePenalty   *float64          `json:"presence_penalty,omitempty" yaml:"presence_penalty,omitempty" validate:"omitempty,min=0,max=1"`
-	N                 *int              `json:"n,omitempty" yaml:"n,omitempty" validate:"omitempty,min=1"`
-	Stream            *bool             `json:"stream,omitempty" yaml:"stream,omitempty" validate:"omitempty"`
-	ParallelToolCalls *bool             `json:"parallel_tool_calls,omitempty" yaml:"parallel_tool_calls,omitempty" validate:"omitempty"`
-}
-
-type EmbedderConfig struct {
-	Model        string  `json:"model,omitempty" yaml:"model,omitempty" validate:"omitempty,required"`
-	APIKey       *string `json:"api_key,omitempty" yaml:"api_key,omitempty" validate:"omitempty,required"`
-	APIKeyHeader *string `json:"api_key_header,omitempty" yaml:"api_key,omitempty" validate:"omitempty,required"`
-	URL          string  `json:"base_url,omitempty" yaml:"base_url,omitempty" validate:"omitempty,url"`
-}
-
-type PipelineStepConfig struct {
-	Type     string            `json:"type" yaml:"type" validate:"required,oneof=extractTags fork llm reduceTools retrieveMemory storeMemory"`
-	LLM      *LLMConfig        `json:"llm,omitempty" yaml:"llm,omitempty" validate:"omitempty"`
-	Fork     *[]PipelineConfig `json:"fork,omitempty" yaml:"fork,omitempty" validate:"omitempty"`
-	Embedder *EmbedderConfig   `json:"embedder,omitempty" yaml:"embedder,omitempty" validate:"omitempty"`
+	Server      ServerConfig              `json:"server" yaml:"server" validate:"required"`
+	MCPServers  *[]MCPServerConfig        `json:"mcp_servers" yaml:"mcp_servers" validate:"omitempty,dive"`
+	Pipeline    *PipelineConfig           `json:"pipeline,omitempty" yaml:"pipeline,omitempty" validate:"omitempty"`
+	Pipelines   map[string]PipelineConfig `json:"pipelines,omitempty" yaml:"pipelines,omitempty" validate:"omitempty,dive"`
+	Templates   map[string]map[string]any `json:"templates,omitempty" yaml:"templates,omitempty" validate:"omitempty"`
+	Telemetry   *TelemetryConfig          `json:"telemetry,omitempty" yaml:"telemetry,omitempty" validate:"omitempty"`
+	Limits      *LimitsConfig             `json:"limits,omitempty" yaml:"limits,omitempty" validate:"omitempty"`
+	Auth        *AuthConfig               `json:"auth,omitempty" yaml:"auth,omitempty" validate:"omitempty"`
+	Completions *CompletionsConfig        `json:"completions,omitempty" yaml:"completions,omitempty" validate:"omitempty"`
+	Responses   *ResponsesConfig          `json:"responses,omitempty" yaml:"responses,omitempty" validate:"omitempty"`
+	Memory      *MemoryConfig             `json:"memory,omitempty" yaml:"memory,omitempty" validate:"omitempty"`
+	MCP         *MCPConfig                `json:"mcp,omitempty" yaml:"mcp,omitempty" validate:"omitempty"`
+	Models      []string                  `json:"models,omitempty" yaml:"models,omitempty" validate:"omitempty,dive,required"` // Offered to clients that pick from a list, e.g. on /api/tags
+}
+
+// DefaultModel is the one model offered when `models` names none.
+const DefaultModel = "snidemind"
+
+// ModelNames lists the models clients are offered. They're virtual: the pipeline gets the name as the
+// request's `model` to route on with `when.models`, while llm steps ask their upstream for their own model.
+func (c *Config) ModelNames() []string {
+	if len(c.Models) == 0 {
+		return []string{DefaultModel}
+	}
+	return c.Models
+}
+
+type StepCondition struct {
+	Tags             []string   `json:"tags,omitempty" yaml:"tags,omitempty" validate:"omitempty,dive,required"`
+	MinTagScore      *float64   `json:"min_tag_score,omitempty" yaml:"min_tag_score,omitempty" validate:"omitempty,min=0,max=1"`
+	Users            []string   `json:"users,omitempty" yaml:"users,omitempty" validate:"omitempty,dive,required"`
+	Models           []string   `json:"models,omitempty" yaml:"models,omitempty" validate:"omitempty,dive,required"`
+	MinMessageLength *int       `json:"min_message_length,omitempty" yaml:"min_message_length,omitempty" validate:"omitempty,min=0"`
+	MaxMessageLength *int       `json:"max_message_length,omitempty" yaml:"max_message_length,omitempty" validate:"omitempty,min=0"`
+	MinTools         *int       `json:"min_tools,omitempty" yaml:"min_tools,omitempty" validate:"omitempty,min=0"`
+	MaxTools         *int       `json:"max_tools,omitempty" yaml:"max_tools,omitempty" validate:"omitempty,min=0"`
+	Message          *RegexList `json:"message,omitempty" yaml:"message,omitempty" validate:"omitempty"`
 }
 
 type PipelineConfig struct {
-	Steps []PipelineStepConfig `json:"steps,omitempty" yaml:"steps,omitempty" validate:"omitempty,dive"`
+	Description string               `json:"description,omitempty" yaml:"description,omitempty"` // What the pipeline is for, told to MCP clients offered it as a tool
+	Steps       []PipelineStepConfig `json:"steps,omitempty" yaml:"steps,omitempty" validate:"omitempty,dive"`
+	Store       bool                 `json:"store,omitempty" yaml:"store,omitempty"` // Store every completion that runs through the pipeline, as if the request set `store: true`
 }
 
 type MCPBlacklist struct {
@@ -129,11 +134,108 @@ type MCPBlacklist struct {
 	Resources *RegexList `json:"resources,omitempty" yaml:"resources,omitempty" validate:"omitempty,dive,required"`
 }
 
+// MCPServerConfig is an MCP server SnideMind is a client of. `sse` and `http` servers are reached at URL, `stdio`
+// ones are launched from Command and kept running.
 type MCPServerConfig struct {
-	Name      string        `json:"name" yaml:"name" validate:"required"`
-	URL       string        `json:"url" yaml:"url" validate:"required,url"`
-	Type      string        `json:"type" yaml:"type" validate:"required,oneof=sse http"`
-	Blacklist *MCPBlacklist `json:"blacklist,omitempty" yaml:"blacklist,omitempty" validate:"omitempty,dive"`
+	Name      string            `json:"name" yaml:"name" validate:"required"`
+	URL       string            `json:"url,omitempty" yaml:"url,omitempty" validate:"required_unless=Type stdio,omitempty,url"`
+	Type      string            `json:"type" yaml:"type" validate:"required,oneof=sse http stdio"`
+	Command   string            `json:"command,omitempty" yaml:"command,omitempty" validate:"required_if=Type stdio"`
+	Args      []string          `json:"args,omitempty" yaml:"args,omitempty" validate:"omitempty"`
+	Env       []string          `json:"env,omitempty" yaml:"env,omitempty" validate:"omitempty,dive,contains=="` // KEY=value, on top of SnideMind's own environment. A list because config keys are lowercased
+	Cwd       string            `json:"cwd,omitempty" yaml:"cwd,omitempty" validate:"omitempty"`
+	Headers   map[string]string `json:"headers,omitempty" yaml:"headers,omitempty" validate:"omitempty,dive,keys,required"` // Sent with every request to sse and http servers, ${VAR}s taken from the environment
+	OAuth     *MCPOAuthConfig   `json:"oauth,omitempty" yaml:"oauth,omitempty" validate:"omitempty"`
+	Blacklist *MCPBlacklist     `json:"blacklist,omitempty" yaml:"blacklist,omitempty" validate:"omitempty,dive"`
+}
+
+// MCPOAuthConfig gets sse and http servers their bearer tokens from an OAuth 2.1 authorization server, with the
+// client_credentials grant, or the refresh_token grant when there's a refresh token. ${VAR}s in the secret and
+// the refresh token are taken from the environment.
+type MCPOAuthConfig struct {
+	TokenURL     string   `json:"token_url" yaml:"token_url" validate:"required,url"`
+	ClientID     string   `json:"client_id" yaml:"client_id" validate:"required"`
+	ClientSecret string   `json:"client_secret,omitempty" yaml:"client_secret,omitempty"`
+	RefreshToken string   `json:"refresh_token,omitempty" yaml:"refresh_token,omitempty"`
+	Scopes       []string `json:"scopes,omitempty" yaml:"scopes,omitempty" validate:"omitempty,dive,required"`
+	Resource     string   `json:"resource,omitempty" yaml:"resource,omitempty" validate:"omitempty,url"`                    // RFC 8707 resource indicator, for servers that want one
+	ClientAuth   string   `json:"client_auth,omitempty" yaml:"client_auth,omitempty" validate:"omitempty,oneof=basic post"` // How the client authenticates, HTTP Basic (the default) or in the form
+}
+
+// PromptArgumentConfig is an argument a prompt template takes.
+type PromptArgumentConfig struct {
+	Name        string `json:"name" yaml:"name" validate:"required"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
+}
+
+// PromptConfig is a prompt template offered to MCP clients. Template is a Go text/template given the arguments
+// by name, e.g. `Roast {{.target}}.`
+type PromptConfig struct {
+	Name        string                 `json:"name" yaml:"name" validate:"required"`
+	Description string                 `json:"description,omitempty" yaml:"description,omitempty"`
+	Arguments   []PromptArgumentConfig `json:"arguments,omitempty" yaml:"arguments,omitempty" validate:"omitempty,dive"`
+	Template    string                 `json:"template" yaml:"template" validate:"required"`
+}
+
+// MCPConfig configures SnideMind's own MCP server, the one on /mcp. Not to be confused with `mcp_servers`,
+// the servers SnideMind is a client of.
+type MCPConfig struct {
+	Name    string         `json:"name,omitempty" yaml:"name,omitempty"` // What the server calls itself, defaults to snidemind
+	Prompts []PromptConfig `json:"prompts,omitempty" yaml:"prompts,omitempty" validate:"omitempty,dive"`
+}
+
+// TelemetryConfig selects where OpenTelemetry spans are sent. Without it spans are dropped,
+// but incoming trace context is still passed on to upstream servers.
+type TelemetryConfig struct {
+	Exporter    string   `json:"exporter" yaml:"exporter" validate:"required,oneof=none stdout otlp"`
+	Endpoint    string   `json:"endpoint,omitempty" yaml:"endpoint,omitempty" validate:"omitempty,url"` // OTLP/HTTP collector, e.g. http://localhost:4318
+	ServiceName string   `json:"service_name,omitempty" yaml:"service_name,omitempty" validate:"omitempty"`
+	SampleRatio *float64 `json:"sample_ratio,omitempty" yaml:"sample_ratio,omitempty" validate:"omitempty,min=0,max=1"`
+}
+
+// LimitConfig caps what one consumer can do. Leaving a limit out means no limit.
+type LimitConfig struct {
+	RequestsPerMinute *int `json:"requests_per_minute,omitempty" yaml:"requests_per_minute,omitempty" validate:"omitempty,min=0"`
+	TokensPerDay      *int `json:"tokens_per_day,omitempty" yaml:"tokens_per_day,omitempty" validate:"omitempty,min=0"`
+}
+
+// LimitsConfig sets the limits for every consumer, identified by its API key's identity, the API key or the request's `user`.
+// Consumers listed in Consumers override the default per limit.
+type LimitsConfig struct {
+	StateFile string                 `json:"state_file,omitempty" yaml:"state_file,omitempty" validate:"omitempty"` // Where counters are kept across restarts
+	Default   LimitConfig            `json:"default" yaml:"default" validate:"omitempty"`
+	Consumers map[string]LimitConfig `json:"consumers,omitempty" yaml:"consumers,omitempty" validate:"omitempty,dive"` // Keyed by identity, API key or user, case-insensitive
+}
+
+// APIKeyConfig is one accepted bearer token and who it belongs to. Only the key's SHA-256 is kept in config.
+// Empty allow lists allow everything.
+type APIKeyConfig struct {
+	Name       string   `json:"name" yaml:"name" validate:"required"`
+	KeyHash    string   `json:"key_hash" yaml:"key_hash" validate:"required,len=64,hexadecimal"` // Hex SHA-256 of the key
+	Pipelines  []string `json:"pipelines,omitempty" yaml:"pipelines,omitempty" validate:"omitempty,dive,required"`
+	Models     []string `json:"models,omitempty" yaml:"models,omitempty" validate:"omitempty,dive,required"`
+	MCPServers []string `json:"mcp_servers,omitempty" yaml:"mcp_servers,omitempty" validate:"omitempty,dive,required"`
+}
+
+// AuthConfig turns on API key authentication for the /v1 API. Without it every request is let in.
+type AuthConfig struct {
+	Keys []APIKeyConfig `json:"keys" yaml:"keys" validate:"required,min=1,dive"`
+}
+
+// CompletionsConfig says where completions stored with `store: true` are kept.
+type CompletionsConfig struct {
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty" validate:"omitempty"` // One JSON file per completion, defaults to ./completions
+}
+
+// ResponsesConfig says where responses from /v1/responses are kept, so later requests can continue them.
+type ResponsesConfig struct {
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty" validate:"omitempty"` // One JSON file per response, defaults to ./responses
+}
+
+// MemoryConfig says where the storeMemory step keeps what it remembers.
+type MemoryConfig struct {
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty" validate:"omitempty"` // One JSON file per memory, defaults to ./memories
 }
 
 type ServerConfig struct {
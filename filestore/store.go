@@ -0,0 +1,165 @@
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrExists = errors.New("record already exists")
+
+// Store keeps records as one JSON file each, named after the record's ID, with every record in memory too so
+// they can be listed. Each record belongs to an owner, the identity that stored it, and only ever shows up for
+// that owner.
+type Store[T any] struct {
+	dir     string
+	id      func(T) string
+	owner   func(T) string
+	records map[string]T
+	lock    sync.RWMutex
+}
+
+// Open loads the records stored by earlier runs. A missing directory is created on the first save.
+func Open[T any](dir string, id func(T) string, owner func(T) string) (*Store[T], error) {
+	store := &Store[T]{dir: dir, id: id, owner: owner, records: map[string]T{}}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var record T
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		store.records[id(record)] = record
+	}
+	return store, nil
+}
+
+func (s *Store[T]) Dir() string {
+	return s.dir
+}
+
+// Len is how many records there are, whoever they belong to.
+func (s *Store[T]) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.records)
+}
+
+func (s *Store[T]) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// write saves the record through a temporary file, so a crash mid-write can't leave half a record behind.
+func (s *Store[T]) write(record T) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", s.dir, err)
+	}
+	path := s.path(s.id(record))
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", temp, err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// Add stores a new record, failing with ErrExists when its ID is taken.
+func (s *Store[T]) Add(record T) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, taken := s.records[s.id(record)]; taken {
+		return fmt.Errorf("%w: %s", ErrExists, s.id(record))
+	}
+	if err := s.write(record); err != nil {
+		return err
+	}
+	s.records[s.id(record)] = record
+	return nil
+}
+
+// Put stores the record, replacing any earlier one with the same ID.
+func (s *Store[T]) Put(record T) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.write(record); err != nil {
+		return err
+	}
+	s.records[s.id(record)] = record
+	return nil
+}
+
+// Get returns the owner's record. Someone else's record is as missing as one that doesn't exist.
+func (s *Store[T]) Get(id string, owner string) (T, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	record, ok := s.records[id]
+	if !ok || s.owner(record) != owner {
+		var none T
+		return none, false
+	}
+	return record, true
+}
+
+// Update replaces the owner's record with what change makes of it, reporting false when there's no such record.
+func (s *Store[T]) Update(id string, owner string, change func(T) T) (T, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record, ok := s.records[id]
+	if !ok || s.owner(record) != owner {
+		return record, false, nil
+	}
+	updated := change(record)
+	if err := s.write(updated); err != nil {
+		return record, true, err
+	}
+	s.records[id] = updated
+	return updated, true, nil
+}
+
+// Delete removes the owner's record, reporting false when there's no such record.
+func (s *Store[T]) Delete(id string, owner string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record, ok := s.records[id]
+	if !ok || s.owner(record) != owner {
+		return false, nil
+	}
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return true, fmt.Errorf("failed to delete %s: %w", s.path(id), err)
+	}
+	delete(s.records, id)
+	return true, nil
+}
+
+// List returns the owner's records, in no particular order.
+func (s *Store[T]) List(owner string) []T {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	records := []T{}
+	for _, record := range s.records {
+		if s.owner(record) == owner {
+			records = append(records, record)
+		}
+	}
+	return records
+}
@@ -0,0 +1,68 @@
+package filestore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type note struct {
+	ID    string `json:"id"`
+	Text  string `json:"text"`
+	Owner string `json:"owner"`
+}
+
+func open(t *testing.T, dir string) *Store[note] {
+	t.Helper()
+	store, err := Open(dir, func(n note) string { return n.ID }, func(n note) string { return n.Owner })
+	require.NoError(t, err)
+	return store
+}
+
+func TestStore_KeepsRecordsAcrossRuns(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "notes")
+	store := open(t, dir)
+	require.NoError(t, store.Add(note{ID: "a", Text: "one", Owner: "kitchen"}))
+	assert.ErrorIs(t, store.Add(note{ID: "a", Owner: "garage"}), ErrExists)
+	require.NoError(t, store.Put(note{ID: "b", Text: "two", Owner: "kitchen"}))
+	require.NoError(t, store.Put(note{ID: "b", Text: "three", Owner: "kitchen"}))
+
+	reloaded := open(t, dir)
+	assert.Equal(t, 2, reloaded.Len())
+	got, ok := reloaded.Get("b", "kitchen")
+	require.True(t, ok)
+	assert.Equal(t, "three", got.Text)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files left behind")
+}
+
+func TestStore_ScopesRecordsToTheirOwner(t *testing.T) {
+	store := open(t, t.TempDir())
+	require.NoError(t, store.Add(note{ID: "a", Text: "one", Owner: "kitchen"}))
+
+	_, ok := store.Get("a", "garage")
+	assert.False(t, ok)
+	assert.Empty(t, store.List("garage"))
+	_, ok, err := store.Update("a", "garage", func(n note) note { n.Text = "stolen"; return n })
+	require.NoError(t, err)
+	assert.False(t, ok)
+	deleted, err := store.Delete("a", "garage")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	updated, ok, err := store.Update("a", "kitchen", func(n note) note { n.Text = "changed"; return n })
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "changed", updated.Text)
+	assert.Equal(t, []note{updated}, store.List("kitchen"))
+
+	deleted, err = store.Delete("a", "kitchen")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	assert.Equal(t, 0, store.Len())
+	assert.Empty(t, open(t, store.Dir()).List("kitchen"))
+}
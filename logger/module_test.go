@@ -64,11 +64,17 @@ func TestMcpModule_IsNotNil(t *testing.T) {
 	}
 }
 
-func TestMcpModule_ProvidesNoConstructors(t *testing.T) {
-	// This test ensures that the mcp.Module does not provide any constructors.
+func TestMcpModule_ProvidesClients(t *testing.T) {
+	// This test ensures that the mcp.Module provides the MCP clients, without servers to connect to here.
 	app := fxtest.New(
 		t,
+		fx.Supply(zap.NewNop(), &config.Config{}),
 		mcp.Module,
+		fx.Invoke(func(clients *mcp.Clients) {
+			if clients == nil {
+				t.Error("Expected MCP clients to be provided, got nil")
+			}
+		}),
 	)
 	app.RequireStart()
 	app.RequireStop()
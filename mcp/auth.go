@@ -0,0 +1,252 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/telemetry"
+)
+
+const tokenExpiryMargin = 30 * time.Second // Tokens are renewed this long before they expire, so none expires mid-call
+
+var variable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expand replaces the ${VAR}s in a config value with the environment's. A variable that isn't set is an error,
+// not an empty string: an empty bearer token fails later and less clearly.
+func expand(value string) (string, error) {
+	var missing []string
+	expanded := variable.ReplaceAllStringFunc(value, func(match string) string {
+		name := variable.FindStringSubmatch(match)[1]
+		found, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return found
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// token is an access token and when it has to be renewed.
+type token struct {
+	access  string
+	renewAt time.Time // Zero when it doesn't expire
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type tokenError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// tokenSource gets access tokens from the authorization server and keeps the current one. With a refresh
+// token it uses the refresh_token grant, keeping whichever refresh token the server rotates to; without one,
+// client_credentials.
+type tokenSource struct {
+	config       config.MCPOAuthConfig
+	clientSecret string
+	client       *http.Client
+
+	lock         sync.Mutex
+	current      *token
+	refreshToken string
+}
+
+func newTokenSource(cfg config.MCPOAuthConfig) (*tokenSource, error) {
+	clientSecret, err := expand(cfg.ClientSecret)
+	if err != nil {
+		return nil, fmt.Errorf("oauth.client_secret: %w", err)
+	}
+	refreshToken, err := expand(cfg.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("oauth.refresh_token: %w", err)
+	}
+	return &tokenSource{
+		config:       cfg,
+		clientSecret: clientSecret,
+		client:       telemetry.NewHTTPClient("oauth"),
+		refreshToken: refreshToken,
+	}, nil
+}
+
+// Token is the current access token, renewed first if it's about to expire.
+func (t *tokenSource) Token(ctx context.Context) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.current != nil && (t.current.renewAt.IsZero() || time.Now().Before(t.current.renewAt)) {
+		return t.current.access, nil
+	}
+	fresh, err := t.fetch(ctx)
+	if err != nil && t.config.RefreshToken == "" && t.refreshToken != "" {
+		// The refresh token came with a client_credentials token, those can always be had again
+		t.refreshToken = ""
+		fresh, err = t.fetch(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+	t.current = fresh
+	return fresh.access, nil
+}
+
+// invalidate drops the access token the server rejected, unless it's already been replaced.
+func (t *tokenSource) invalidate(access string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.current != nil && t.current.access == access {
+		t.current = nil
+	}
+}
+
+// fetch asks the authorization server for a new access token; the caller must hold the lock.
+func (t *tokenSource) fetch(ctx context.Context) (*token, error) {
+	form := url.Values{}
+	if t.refreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", t.refreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(t.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(t.config.Scopes, " "))
+	}
+	if t.config.Resource != "" {
+		form.Set("resource", t.config.Resource)
+	}
+	if t.config.ClientAuth == "post" || t.clientSecret == "" {
+		form.Set("client_id", t.config.ClientID)
+		if t.clientSecret != "" {
+			form.Set("client_secret", t.clientSecret)
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if t.config.ClientAuth != "post" && t.clientSecret != "" {
+		// RFC 6749 2.3.1: both are form encoded before going into the header
+		request.SetBasicAuth(url.QueryEscape(t.config.ClientID), url.QueryEscape(t.clientSecret))
+	}
+	start := time.Now()
+	response, err := t.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		var failure tokenError
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return nil, fmt.Errorf("%s grant refused: %s %s", form.Get("grant_type"), failure.Error, failure.Description)
+		}
+		return nil, fmt.Errorf("%s grant refused with status %d", form.Get("grant_type"), response.StatusCode)
+	}
+	var answer tokenResponse
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return nil, fmt.Errorf("unparseable token response: %w", err)
+	}
+	if answer.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+	if answer.TokenType != "" && !strings.EqualFold(answer.TokenType, "bearer") {
+		return nil, fmt.Errorf("token type %s is not supported", answer.TokenType)
+	}
+	if answer.RefreshToken != "" {
+		t.refreshToken = answer.RefreshToken
+	}
+	fresh := &token{access: answer.AccessToken}
+	if answer.ExpiresIn > 0 {
+		lifetime := time.Duration(answer.ExpiresIn) * time.Second
+		fresh.renewAt = start.Add(lifetime - min(tokenExpiryMargin, lifetime/2))
+	}
+	return fresh, nil
+}
+
+// authTransport sends the server's headers and bearer token with every request. When the server turns a token
+// down, it's dropped and the request sent again with a new one, once, so a token revoked early doesn't fail
+// the tool call it was used for.
+type authTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+	tokens  *tokenSource // nil without OAuth
+}
+
+// newAuthTransport wraps base with the server's headers and tokens, or returns it as is when there are none.
+func newAuthTransport(headers map[string]string, tokens *tokenSource, base http.RoundTripper) (http.RoundTripper, error) {
+	if len(headers) == 0 && tokens == nil {
+		return base, nil
+	}
+	auth := &authTransport{base: base, headers: make(map[string]string, len(headers)), tokens: tokens}
+	for name, value := range headers {
+		expanded, err := expand(value)
+		if err != nil {
+			return nil, fmt.Errorf("headers.%s: %w", name, err)
+		}
+		auth.headers[name] = expanded
+	}
+	return auth, nil
+}
+
+func (a *authTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, access, err := a.send(request)
+	if err != nil || a.tokens == nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+	if request.Body != nil && request.GetBody == nil {
+		return response, nil // The body's been read and can't be sent again
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	a.tokens.invalidate(access)
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		request = request.Clone(request.Context())
+		request.Body = body
+	}
+	response, _, err = a.send(request)
+	return response, err
+}
+
+// send sends the request with the credentials, returning the access token it used.
+func (a *authTransport) send(request *http.Request) (*http.Response, string, error) {
+	authorized := request.Clone(request.Context())
+	for name, value := range a.headers {
+		authorized.Header.Set(name, value)
+	}
+	access := ""
+	if a.tokens != nil {
+		var err error
+		if access, err = a.tokens.Token(request.Context()); err != nil {
+			return nil, "", fmt.Errorf("failed to get an access token: %w", err)
+		}
+		authorized.Header.Set("Authorization", "Bearer "+access)
+	}
+	response, err := a.base.RoundTrip(authorized)
+	return response, access, err
+}
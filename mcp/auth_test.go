@@ -0,0 +1,208 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpServer "github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+// authorizationServer is a stub OAuth authorization server. It hands out numbered tokens to the client
+// snidemind/s3cret and rotates the refresh token on every use.
+type authorizationServer struct {
+	lock      sync.Mutex
+	expiresIn int64
+	issued    map[string]bool
+	refresh   string
+	grants    []url.Values
+}
+
+func newAuthorizationServer(expiresIn int64) *authorizationServer {
+	return &authorizationServer{expiresIn: expiresIn, issued: map[string]bool{}, refresh: "refresh-0"}
+}
+
+func (a *authorizationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.grants = append(a.grants, r.PostForm)
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	refuse := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	if id != "snidemind" || secret != "s3cret" {
+		refuse("invalid_client")
+		return
+	}
+	answer := map[string]any{"access_token": fmt.Sprintf("token-%d", len(a.grants)), "token_type": "Bearer", "expires_in": a.expiresIn}
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") != a.refresh {
+			refuse("invalid_grant")
+			return
+		}
+		a.refresh = fmt.Sprintf("refresh-%d", len(a.grants))
+		answer["refresh_token"] = a.refresh
+	default:
+		refuse("unsupported_grant_type")
+		return
+	}
+	a.issued[answer["access_token"].(string)] = true
+	json.NewEncoder(w).Encode(answer)
+}
+
+func (a *authorizationServer) revokeAll() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.issued = map[string]bool{}
+}
+
+func (a *authorizationServer) grantCount() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.grants)
+}
+
+// protect lets through only the requests with a token the authorization server issued.
+func (a *authorizationServer) protect(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		a.lock.Lock()
+		valid := a.issued[token]
+		a.lock.Unlock()
+		if !valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestMCPClient_ClientCredentials(t *testing.T) {
+	authorization := newAuthorizationServer(1)
+	tokens := httptest.NewServer(authorization)
+	defer tokens.Close()
+	server := mcpServer.NewMCPServer("protected", "1.0.0", mcpServer.WithToolCapabilities(true))
+	server.AddTool(mcp.NewTool("secret"), func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("42"), nil
+	})
+	upstream := httptest.NewServer(authorization.protect(mcpServer.NewStreamableHTTPServer(server)))
+	defer upstream.Close()
+
+	client := NewMCPClient(config.MCPServerConfig{
+		Name: "protected",
+		Type: "http",
+		URL:  upstream.URL,
+		OAuth: &config.MCPOAuthConfig{
+			TokenURL:     tokens.URL,
+			ClientID:     "snidemind",
+			ClientSecret: "s3cret",
+			Scopes:       []string{"tools", "prompts"},
+		},
+	}, zap.NewNop())
+	client.checkInterval = time.Hour
+	client.Start()
+	defer client.Close()
+	require.Eventually(t, state(client, models.MCPServerReady), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "client_credentials", authorization.grants[0].Get("grant_type"))
+	assert.Equal(t, "tools prompts", authorization.grants[0].Get("scope"))
+	assert.Empty(t, authorization.grants[0].Get("client_secret"), "the secret goes in the Authorization header")
+
+	call := func() {
+		t.Helper()
+		result, err := client.CallTool(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Name: "secret"}})
+		require.NoError(t, err)
+		assert.False(t, result.IsError)
+	}
+	call()
+	granted := authorization.grantCount()
+
+	time.Sleep(600 * time.Millisecond) // The token is renewed half way through its second
+	call()
+	assert.Greater(t, authorization.grantCount(), granted, "an expiring token is renewed before the call")
+	granted = authorization.grantCount()
+
+	authorization.revokeAll()
+	call()
+	assert.Greater(t, authorization.grantCount(), granted, "a rejected token is replaced and the call sent again")
+}
+
+func TestTokenSource_RotatesRefreshTokens(t *testing.T) {
+	authorization := newAuthorizationServer(0)
+	server := httptest.NewServer(authorization)
+	defer server.Close()
+	t.Setenv("MCP_CLIENT_SECRET", "s3cret")
+	t.Setenv("MCP_REFRESH_TOKEN", "refresh-0")
+
+	tokens, err := newTokenSource(config.MCPOAuthConfig{
+		TokenURL:     server.URL,
+		ClientID:     "snidemind",
+		ClientSecret: "${MCP_CLIENT_SECRET}",
+		RefreshToken: "${MCP_REFRESH_TOKEN}",
+		Resource:     "https://mcp.example.com",
+		ClientAuth:   "post",
+	})
+	require.NoError(t, err)
+
+	first, err := tokens.Token(context.Background())
+	require.NoError(t, err)
+	again, err := tokens.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, first, again, "a token that doesn't expire is kept")
+
+	tokens.invalidate(first)
+	second, err := tokens.Token(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	require.Len(t, authorization.grants, 2)
+	assert.Equal(t, "refresh-0", authorization.grants[0].Get("refresh_token"))
+	assert.Equal(t, "refresh-1", authorization.grants[1].Get("refresh_token"), "the rotated refresh token is used")
+	assert.Equal(t, "s3cret", authorization.grants[1].Get("client_secret"))
+	assert.Equal(t, "https://mcp.example.com", authorization.grants[1].Get("resource"))
+
+	tokens.refreshToken = "stale"
+	tokens.invalidate(second)
+	_, err = tokens.Token(context.Background())
+	assert.ErrorContains(t, err, "refresh_token grant refused: invalid_grant")
+}
+
+func TestAuthTransport_Headers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	t.Setenv("MCP_TOKEN", "abc")
+	roundTripper, err := newAuthTransport(map[string]string{"authorization": "Bearer ${MCP_TOKEN}"}, nil, http.DefaultTransport)
+	require.NoError(t, err)
+	response, err := (&http.Client{Transport: roundTripper}).Get(server.URL)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer abc", string(body))
+
+	_, err = newAuthTransport(map[string]string{"authorization": "Bearer ${MCP_UNSET_TOKEN}"}, nil, http.DefaultTransport)
+	assert.ErrorContains(t, err, "environment variable MCP_UNSET_TOKEN is not set")
+}
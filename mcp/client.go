@@ -3,93 +3,355 @@ package mcp
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	mcpClient "github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/teagan42/snidemind/config"
-	"go.uber.org/fx"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/telemetry"
 	"go.uber.org/zap"
 )
 
-type MCPClient struct {
-	Client *mcpClient.Client
-	Config config.MCPServerConfig
+const (
+	clientName        = "snidemind"
+	clientVersion     = "1.0.0"
+	reconnectDelay    = time.Second
+	maxReconnectDelay = time.Minute
+	checkInterval     = 30 * time.Second
+	checkTimeout      = 10 * time.Second
+	connectTimeout    = 30 * time.Second
+	maxFailedChecks   = 3 // Health checks failed in a row before the connection is given up on and made again
+)
+
+// catalog is what the server offers, as of the last refresh, without what's blacklisted.
+type catalog struct {
+	tools     []mcp.Tool
+	prompts   []mcp.Prompt
+	resources []mcp.Resource
 }
 
-type Params struct {
-	fx.In
-	Log *zap.Logger
-	Cfg config.MCPServerConfig
-	Lc  fx.Lifecycle
+// MCPClient is the connection to one MCP server. It connects in the background and keeps at it: the server is
+// pinged every checkInterval, a failed check makes it degraded and maxFailedChecks of them in a row have the
+// connection made again, with a delay that doubles up to maxReconnectDelay while that fails. Every (re)connect
+// initializes the session and refreshes the catalog.
+type MCPClient struct {
+	Config         config.MCPServerConfig
+	logger         *zap.Logger
+	checkInterval  time.Duration
+	reconnectDelay time.Duration
+	tokens         *tokenSource // Kept across connections, so is the refresh token the server last rotated to
+
+	lock    sync.RWMutex
+	client  *mcpClient.Client // nil while not connected
+	status  models.MCPServerStatus
+	catalog catalog
+
+	ctx    context.Context // Cancelled by Close. An SSE stream lasts as long as the context it's started with
+	cancel context.CancelFunc
+	check  chan struct{} // Asks for a health check now, e.g. after a call failed
+	start  sync.Once
+	done   chan struct{} // Closed when the connection loop has stopped, nil until started
 }
 
-type Result struct {
-	fx.Out
-	Client *MCPClient `name:"client"`
+func NewMCPClient(cfg config.MCPServerConfig, logger *zap.Logger) *MCPClient {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MCPClient{
+		Config:         cfg,
+		logger:         logger,
+		checkInterval:  checkInterval,
+		reconnectDelay: reconnectDelay,
+		status:         models.MCPServerStatus{Name: cfg.Name, Type: cfg.Type, State: models.MCPServerConnecting, Since: time.Now()},
+		ctx:            ctx,
+		cancel:         cancel,
+		check:          make(chan struct{}, 1),
+	}
 }
 
-func NewMCPClient(p Params) (*Result, error) {
-	var clientTransport transport.Interface
-	switch p.Cfg.Type {
-	case "sse":
-		if sseTransport, err := transport.NewSSE(p.Cfg.URL); err != nil {
+// transport makes a new transport for every connection, a closed one can't be started again.
+func (c *MCPClient) transport() (transport.Interface, error) {
+	if c.Config.Type == "stdio" {
+		return NewStdio(c.Config, c.logger), nil
+	}
+	if c.Config.OAuth != nil && c.tokens == nil {
+		tokens, err := newTokenSource(*c.Config.OAuth)
+		if err != nil {
 			return nil, err
-		} else {
-			clientTransport = sseTransport
 		}
+		c.tokens = tokens
+	}
+	httpClient := telemetry.NewHTTPClient("mcp")
+	roundTripper, err := newAuthTransport(c.Config.Headers, c.tokens, httpClient.Transport)
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Transport = roundTripper
+	switch c.Config.Type {
+	case "sse":
+		return transport.NewSSE(c.Config.URL, transport.WithHTTPClient(httpClient))
 	case "http":
-		if httpTransport, err := transport.NewStreamableHTTP(p.Cfg.URL); err != nil {
-			return nil, err
-		} else {
-			clientTransport = httpTransport
-		}
+		return transport.NewStreamableHTTP(c.Config.URL, transport.WithHTTPBasicClient(httpClient))
 	}
+	return nil, fmt.Errorf("unknown MCP server type %q", c.Config.Type)
+}
 
-	return &Result{
-		Client: &MCPClient{
-			Client: mcpClient.NewClient(
-				clientTransport,
-			),
-			Config: p.Cfg,
-		},
-	}, nil
+// Start connects to the server in the background and returns at once.
+func (c *MCPClient) Start() {
+	c.start.Do(func() {
+		c.logger.Info("Starting MCP client", zap.String("type", c.Config.Type), zap.String("address", c.address()))
+		c.done = make(chan struct{})
+		go c.run()
+	})
+}
 
+// address is where the server is, for the logs.
+func (c *MCPClient) address() string {
+	if c.Config.Type == "stdio" {
+		return strings.Join(append([]string{c.Config.Command}, c.Config.Args...), " ")
+	}
+	return c.Config.URL
 }
 
-func (c *MCPClient) Start(context context.Context) {
-	fmt.Printf("Starting MCP client for %s at %s\n", c.Config.Name, c.Config.URL)
-	if err := c.Client.Start(context); err != nil {
-		fmt.Printf("Failed to start MCP client for %s: %v\n", c.Config.Name, err)
-		panic(err)
+func (c *MCPClient) run() {
+	defer close(c.done)
+	delay := c.reconnectDelay
+	for {
+		client, err := c.connect()
+		if err == nil {
+			delay = c.reconnectDelay
+			err = c.watch(client)
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.setState(models.MCPServerDown, err)
+		c.logger.Warn("MCP server is down", zap.Error(err), zap.Duration("retryIn", delay))
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (c *MCPClient) connect() (*mcpClient.Client, error) {
+	c.setState(models.MCPServerConnecting, nil)
+	clientTransport, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	client := mcpClient.NewClient(clientTransport)
+	if err := client.Start(c.ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to start: %w", err)
+	}
+	client.OnNotification(c.HandleNotification)
+	ctx, cancel := context.WithTimeout(c.ctx, connectTimeout)
+	defer cancel()
+	request := mcp.InitializeRequest{}
+	request.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	request.Params.ClientInfo = mcp.Implementation{Name: clientName, Version: clientVersion}
+	result, err := client.Initialize(ctx, request)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to initialize: %w", err)
+	}
+	c.logger.Info("Connected to MCP server", zap.String("serverName", result.ServerInfo.Name), zap.String("serverVersion", result.ServerInfo.Version))
+	c.lock.Lock()
+	c.client = client
+	c.lock.Unlock()
+	if err := c.refresh(ctx, client); err != nil {
+		c.setState(models.MCPServerDegraded, err)
 	} else {
-		fmt.Printf("MCP client for %s started successfully\n", c.Config.Name)
-		c.Client.OnNotification(c.HandleNotification)
-		if result, err := c.Client.Initialize(context, mcp.InitializeRequest{}); err != nil {
-			fmt.Printf("Failed to initialize MCP client for %s: %v\n", c.Config.Name, err)
-			panic(err)
-		} else {
-			fmt.Printf("MCP client for %s initialized successfully with capabilities: %v\n", c.Config.Name, result.Capabilities)
+		c.setState(models.MCPServerReady, nil)
+	}
+	return client, nil
+}
+
+// watch checks on the connection until it's lost or the client is closed, then closes it.
+func (c *MCPClient) watch(client *mcpClient.Client) error {
+	defer func() {
+		c.lock.Lock()
+		c.client = nil
+		c.lock.Unlock()
+		client.Close()
+	}()
+	ticker := time.NewTicker(c.checkInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-ticker.C:
+		case <-c.check:
+		}
+		ctx, cancel := context.WithTimeout(c.ctx, checkTimeout)
+		err := client.Ping(ctx)
+		if err == nil && c.Status().State != models.MCPServerReady {
+			// Back from a failed check, e.g. a restarted stdio server; what it offers may have changed
+			err = c.refresh(ctx, client)
+		}
+		cancel()
+		if err == nil {
+			failures = 0
+			c.setState(models.MCPServerReady, nil)
+			continue
+		}
+		failures++
+		if failures >= maxFailedChecks {
+			return fmt.Errorf("%d health checks failed: %w", failures, err)
+		}
+		c.setState(models.MCPServerDegraded, err)
+	}
+}
+
+// checkNow asks for a health check without waiting for the next one.
+func (c *MCPClient) checkNow() {
+	select {
+	case c.check <- struct{}{}:
+	default:
+	}
+}
+
+// refresh fetches what the server offers, asking only for what it says it has.
+func (c *MCPClient) refresh(ctx context.Context, client *mcpClient.Client) error {
+	capabilities := client.GetServerCapabilities()
+	fresh := catalog{}
+	if capabilities.Tools != nil {
+		tools, err := client.ListTools(ctx, mcp.ListToolsRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to list tools: %w", err)
+		}
+		for _, tool := range tools.Tools {
+			if !c.Config.Blacklist.IsToolBlacklisted(tool.Name) {
+				fresh.tools = append(fresh.tools, tool)
+			}
 		}
 	}
+	if capabilities.Prompts != nil {
+		prompts, err := client.ListPrompts(ctx, mcp.ListPromptsRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to list prompts: %w", err)
+		}
+		for _, prompt := range prompts.Prompts {
+			if !c.Config.Blacklist.IsPromptBlacklisted(prompt.Name) {
+				fresh.prompts = append(fresh.prompts, prompt)
+			}
+		}
+	}
+	if capabilities.Resources != nil {
+		resources, err := client.ListResources(ctx, mcp.ListResourcesRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to list resources: %w", err)
+		}
+		for _, resource := range resources.Resources {
+			if !c.Config.Blacklist.IsResourceBlacklisted(resource.Name) {
+				fresh.resources = append(fresh.resources, resource)
+			}
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.catalog = fresh
+	c.status.Tools, c.status.Prompts, c.status.Resources = len(fresh.tools), len(fresh.prompts), len(fresh.resources)
+	return nil
+}
+
+func (c *MCPClient) setState(state models.MCPServerState, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.status.State != state {
+		c.logger.Info("MCP server state changed", zap.String("from", string(c.status.State)), zap.String("to", string(state)), zap.Error(err))
+		c.status.State = state
+		c.status.Since = time.Now()
+	}
+	c.status.Error = ""
+	if err != nil {
+		c.status.Error = err.Error()
+	}
+}
+
+// Status is how the connection is doing.
+func (c *MCPClient) Status() models.MCPServerStatus {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.status
+}
+
+// Tools are the server's tools as of the last refresh, or none while it's down. Each is tagged with the
+// server's name and its own, so a tag naming either picks it.
+func (c *MCPClient) Tools() []models.MCPTool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if !c.status.State.Up() {
+		return nil
+	}
+	tools := make([]models.MCPTool, 0, len(c.catalog.tools))
+	for _, tool := range c.catalog.tools {
+		tools = append(tools, models.MCPTool{
+			ToolMetadata: models.ToolMetadata{Name: tool.Name, Description: tool.Description, Tags: &[]string{c.Config.Name, tool.Name}},
+			Server:       c.Config.Name,
+		})
+	}
+	return tools
 }
 
+// HandleNotification refreshes the catalog when the server says it changed.
 func (c *MCPClient) HandleNotification(notification mcp.JSONRPCNotification) {
-	fmt.Printf("Received notification: %s with %+v\n", notification.Method, notification.Params)
+	c.logger.Debug("Received notification", zap.String("method", notification.Method))
+	switch notification.Method {
+	case mcp.MethodNotificationToolsListChanged, mcp.MethodNotificationPromptsListChanged, mcp.MethodNotificationResourcesListChanged:
+	default:
+		return
+	}
+	go func() {
+		client, err := c.current()
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.ctx, checkTimeout)
+		defer cancel()
+		if err := c.refresh(ctx, client); err != nil {
+			c.logger.Warn("Failed to refresh MCP server catalog", zap.Error(err))
+			c.checkNow()
+		}
+	}()
+}
+
+// current is the connected client, or an error saying why there's none.
+func (c *MCPClient) current() (*mcpClient.Client, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.client == nil {
+		return nil, fmt.Errorf("MCP server %s is %s", c.Config.Name, c.status.State)
+	}
+	return c.client, nil
 }
 
+// Close disconnects and stops reconnecting.
 func (c *MCPClient) Close() error {
-	if err := c.Client.Close(); err != nil {
-		return err
+	c.cancel()
+	if c.done != nil {
+		<-c.done
 	}
 	return nil
 }
 
 func (c *MCPClient) ListTools(context context.Context, request mcp.ListToolsRequest) (*[]mcp.Tool, error) {
-	tools, err := c.Client.ListTools(context, request)
+	client, err := c.current()
 	if err != nil {
 		return nil, err
 	}
+	tools, err := client.ListTools(context, request)
+	if err != nil {
+		c.checkNow()
+		return nil, err
+	}
 	if tools == nil || len(tools.Tools) == 0 {
 		// No tools available
 		return nil, nil
@@ -111,8 +373,13 @@ func (c *MCPClient) ListTools(context context.Context, request mcp.ListToolsRequ
 }
 
 func (c *MCPClient) ListPrompts(context context.Context, request mcp.ListPromptsRequest) (*[]mcp.Prompt, error) {
-	prompts, err := c.Client.ListPrompts(context, request)
+	client, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	prompts, err := client.ListPrompts(context, request)
 	if err != nil {
+		c.checkNow()
 		return nil, err
 	}
 	if prompts == nil || len(prompts.Prompts) == 0 {
@@ -136,10 +403,15 @@ func (c *MCPClient) ListPrompts(context context.Context, request mcp.ListPrompts
 }
 
 func (c *MCPClient) ListResources(context context.Context, request mcp.ListResourcesRequest) (*[]mcp.Resource, error) {
-	resources, err := c.Client.ListResources(context, request)
+	client, err := c.current()
 	if err != nil {
 		return nil, err
 	}
+	resources, err := client.ListResources(context, request)
+	if err != nil {
+		c.checkNow()
+		return nil, err
+	}
 	if resources == nil || len(resources.Resources) == 0 {
 		// No resources available
 		return nil, nil
@@ -159,3 +431,23 @@ func (c *MCPClient) ListResources(context context.Context, request mcp.ListResou
 	}
 	return &filteredResources, nil
 }
+
+// CallTool calls one of the server's tools. A result flagged as an error is counted as a failed call but
+// returned as is, since the error text is meant for the model.
+func (c *MCPClient) CallTool(context context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	client, err := c.current()
+	if err != nil {
+		telemetry.ObserveToolCall(c.Config.Name, request.Params.Name, err)
+		return nil, err
+	}
+	result, err := client.CallTool(context, request)
+	if err != nil {
+		c.checkNow()
+	}
+	outcome := err
+	if err == nil && result != nil && result.IsError {
+		outcome = fmt.Errorf("tool %s returned an error", request.Params.Name)
+	}
+	telemetry.ObserveToolCall(c.Config.Name, request.Params.Name, outcome)
+	return result, err
+}
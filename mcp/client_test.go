@@ -0,0 +1,124 @@
+package mcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpServer "github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+// flaky is an MCP server that can be taken down and brought back as a new one, sessions lost.
+type flaky struct {
+	lock    sync.Mutex
+	handler http.Handler
+}
+
+func (f *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	handler := f.handler
+	f.lock.Unlock()
+	if handler == nil {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// serve replaces the server with a new one offering the named tools, or takes it down without any.
+func (f *flaky) serve(tools ...string) {
+	var handler http.Handler
+	if len(tools) > 0 {
+		server := mcpServer.NewMCPServer("flaky", "1.0.0", mcpServer.WithToolCapabilities(true))
+		for _, name := range tools {
+			server.AddTool(mcp.NewTool(name, mcp.WithDescription("Does "+name)), func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return mcp.NewToolResultText(name + " done"), nil
+			})
+		}
+		handler = mcpServer.NewStreamableHTTPServer(server)
+	}
+	f.lock.Lock()
+	f.handler = handler
+	f.lock.Unlock()
+}
+
+func state(client *MCPClient, want models.MCPServerState) func() bool {
+	return func() bool { return client.Status().State == want }
+}
+
+func TestMCPClient_Reconnects(t *testing.T) {
+	server := &flaky{}
+	server.serve("one")
+	upstream := httptest.NewServer(server)
+	defer upstream.Close()
+
+	client := NewMCPClient(config.MCPServerConfig{
+		Name:      "flaky",
+		Type:      "http",
+		URL:       upstream.URL,
+		Blacklist: &config.MCPBlacklist{Tools: &config.RegexList{regexp.MustCompile("^secret$")}},
+	}, zap.NewNop())
+	client.checkInterval = 20 * time.Millisecond
+	client.reconnectDelay = 10 * time.Millisecond
+	client.Start()
+	defer client.Close()
+
+	require.Eventually(t, state(client, models.MCPServerReady), 5*time.Second, 10*time.Millisecond)
+	tools := client.Tools()
+	require.Len(t, tools, 1)
+	assert.Equal(t, models.MCPTool{ToolMetadata: models.ToolMetadata{Name: "one", Description: "Does one", Tags: &[]string{"flaky", "one"}}, Server: "flaky"}, tools[0])
+
+	server.serve()
+	require.Eventually(t, state(client, models.MCPServerDegraded), 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, client.Tools(), 1, "a degraded server's tools are still offered")
+	require.Eventually(t, state(client, models.MCPServerDown), 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, client.Tools(), "a server that's down takes its tools with it")
+	assert.NotEmpty(t, client.Status().Error)
+	_, err := client.CallTool(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Name: "one"}})
+	assert.ErrorContains(t, err, "MCP server flaky is")
+
+	server.serve("one", "two", "secret")
+	require.Eventually(t, state(client, models.MCPServerReady), 5*time.Second, 10*time.Millisecond)
+	status := client.Status()
+	assert.Empty(t, status.Error)
+	assert.Equal(t, 2, status.Tools, "the catalog is refreshed after reconnecting, without what's blacklisted")
+	result, err := client.CallTool(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Name: "two"}})
+	require.NoError(t, err)
+	assert.Equal(t, "two done", result.Content[0].(mcp.TextContent).Text)
+
+	require.NoError(t, client.Close())
+}
+
+func TestClients_Health(t *testing.T) {
+	server := &flaky{}
+	server.serve("one")
+	upstream := httptest.NewServer(server)
+	defer upstream.Close()
+
+	up := NewMCPClient(config.MCPServerConfig{Name: "up", Type: "http", URL: upstream.URL}, zap.NewNop())
+	down := NewMCPClient(config.MCPServerConfig{Name: "down", Type: "stdio", Command: "/nonexistent/mcp-server"}, zap.NewNop())
+	clients := &Clients{clients: []*MCPClient{up, down}}
+	for _, client := range clients.clients {
+		client.Start()
+		defer client.Close()
+	}
+
+	require.Eventually(t, state(up, models.MCPServerReady), 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, state(down, models.MCPServerDown), 5*time.Second, 10*time.Millisecond)
+	health := clients.Health()
+	assert.Equal(t, "degraded", health.Status)
+	require.Len(t, health.MCPServers, 2)
+	assert.Equal(t, models.MCPServerReady, health.MCPServers[0].State)
+	assert.Contains(t, health.MCPServers[1].Error, "failed to start")
+	assert.Len(t, clients.Tools(), 1, "only the tools of servers that are up")
+}
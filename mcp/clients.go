@@ -0,0 +1,90 @@
+package mcp
+
+import (
+	"context"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type Params struct {
+	fx.In
+	Log    *zap.Logger
+	Config *config.Config
+	Lc     fx.Lifecycle
+}
+
+type Result struct {
+	fx.Out
+	Clients *Clients
+	Tools   models.ToolSource
+}
+
+// Clients are the connections to the servers under `mcp_servers`. They connect in the background once the app
+// starts, so a server that's down doesn't keep SnideMind from starting.
+type Clients struct {
+	clients []*MCPClient
+}
+
+func NewClients(p Params) Result {
+	logger := p.Log.Named("MCPClient")
+	clients := &Clients{}
+	if p.Config.MCPServers != nil {
+		for _, server := range *p.Config.MCPServers {
+			clients.clients = append(clients.clients, NewMCPClient(server, logger.With(zap.String("server", server.Name))))
+		}
+	}
+	p.Lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			for _, client := range clients.clients {
+				client.Start()
+			}
+			return nil
+		},
+		// Closing ends the sessions, and stops the stdio servers' processes
+		OnStop: func(ctx context.Context) error {
+			for _, client := range clients.clients {
+				client.Close()
+			}
+			return nil
+		},
+	})
+	return Result{
+		Clients: clients,
+		Tools:   clients,
+	}
+}
+
+// Get finds the client for the named server.
+func (c *Clients) Get(name string) (*MCPClient, bool) {
+	for _, client := range c.clients {
+		if client.Config.Name == name {
+			return client, true
+		}
+	}
+	return nil, false
+}
+
+// Tools are the tools of every server that's up.
+func (c *Clients) Tools() []models.MCPTool {
+	tools := []models.MCPTool{}
+	for _, client := range c.clients {
+		tools = append(tools, client.Tools()...)
+	}
+	return tools
+}
+
+// Health reports every server's state.
+func (c *Clients) Health() models.Health {
+	health := models.Health{Status: "ok", MCPServers: make([]models.MCPServerStatus, 0, len(c.clients))}
+	for _, client := range c.clients {
+		status := client.Status()
+		if status.State != models.MCPServerReady {
+			health.Status = "degraded"
+		}
+		health.MCPServers = append(health.MCPServers, status)
+	}
+	return health
+}
@@ -4,5 +4,7 @@ import "go.uber.org/fx"
 
 var Module = fx.Module(
 	"mcp",
-	fx.Provide(),
+	fx.Provide(
+		NewClients,
+	),
 )
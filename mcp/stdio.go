@@ -0,0 +1,305 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/teagan42/snidemind/config"
+	"go.uber.org/zap"
+)
+
+const (
+	restartDelay    = time.Second
+	maxRestartDelay = 30 * time.Second
+	stableAfter     = time.Minute      // A process that ran this long starts over from the shortest delay when it dies
+	stopGrace       = 5 * time.Second  // How long a process gets to exit after its stdin closes before it's killed
+	replayTimeout   = 30 * time.Second // How long a restarted process gets to answer the replayed initialize
+)
+
+var errExited = errors.New("the server process exited")
+
+// process is one run of a stdio server's command. ctx is cancelled when it exits.
+type process struct {
+	cmd     *osexec.Cmd
+	io      *transport.Stdio
+	ctx     context.Context
+	started time.Time
+	err     error // Why it exited, once ctx is done
+}
+
+// Stdio is the transport to an MCP server launched as a local command, speaking JSON-RPC on its stdin and
+// stdout. The process is supervised: when it dies it's started again, with a delay that doubles up to
+// maxRestartDelay, and the client's initialize handshake is replayed to it, so only the calls in flight at the
+// time fail. Whatever the server writes to stderr is logged.
+type Stdio struct {
+	config       config.MCPServerConfig
+	logger       *zap.Logger
+	restartDelay time.Duration
+
+	lock           sync.Mutex
+	current        *process // nil while the process is being restarted
+	initialize     *transport.JSONRPCRequest
+	initialized    *mcp.JSONRPCNotification
+	onNotification func(mcp.JSONRPCNotification)
+	stop           chan struct{}
+	stopOnce       sync.Once
+	done           chan struct{} // Closed when the supervisor has stopped, nil until started
+}
+
+func NewStdio(cfg config.MCPServerConfig, logger *zap.Logger) *Stdio {
+	return &Stdio{
+		config:       cfg,
+		logger:       logger,
+		restartDelay: restartDelay,
+		stop:         make(chan struct{}),
+	}
+}
+
+// Start launches the command. Only a process that started and then died is restarted here; one that can't be
+// started at all is the client's to retry.
+func (s *Stdio) Start(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.done != nil {
+		return fmt.Errorf("MCP server %s is already started", s.config.Name)
+	}
+	p, err := s.spawn()
+	if err != nil {
+		return err
+	}
+	s.current = p
+	s.done = make(chan struct{})
+	go s.supervise(p)
+	return nil
+}
+
+func (s *Stdio) command() *osexec.Cmd {
+	cmd := osexec.Command(s.config.Command, s.config.Args...)
+	cmd.Dir = s.config.Cwd
+	if len(s.config.Env) > 0 {
+		cmd.Env = append(os.Environ(), s.config.Env...)
+	}
+	return cmd
+}
+
+func (s *Stdio) spawn() (*process, error) {
+	cmd := s.command()
+	var pipes []io.Closer
+	closePipes := func() {
+		for _, pipe := range pipes {
+			pipe.Close()
+		}
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	pipes = append(pipes, stdin)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		closePipes()
+		return nil, err
+	}
+	pipes = append(pipes, stdout)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		closePipes()
+		return nil, err
+	}
+	pipes = append(pipes, stderr)
+	if err := cmd.Start(); err != nil {
+		// os/exec only promises that Wait closes the pipes, and there's nothing to wait for
+		closePipes()
+		return nil, fmt.Errorf("failed to start MCP server %s: %w", s.config.Name, err)
+	}
+	s.logger.Info("Started MCP server process", zap.String("command", cmd.Path), zap.Int("pid", cmd.Process.Pid))
+
+	ctx, exited := context.WithCancel(context.Background())
+	p := &process{cmd: cmd, io: transport.NewIO(stdout, stdin, stderr), ctx: ctx, started: time.Now()}
+	p.io.SetNotificationHandler(s.notify)
+	if err := p.io.Start(ctx); err != nil {
+		cmd.Process.Kill()
+		closePipes()
+		cmd.Wait()
+		exited()
+		return nil, err
+	}
+	logged := make(chan struct{})
+	go s.logStderr(stderr, logged)
+	go func() {
+		<-logged // Wait closes the pipes, the last of stderr would be lost
+		p.err = cmd.Wait()
+		exited()
+	}()
+	return p, nil
+}
+
+func (s *Stdio) logStderr(stderr io.Reader, done chan struct{}) {
+	defer close(done)
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		s.logger.Info("MCP server stderr", zap.String("line", scanner.Text()))
+	}
+}
+
+// supervise restarts the process whenever it exits, until the transport is closed.
+func (s *Stdio) supervise(p *process) {
+	defer close(s.done)
+	delay := s.restartDelay
+	for {
+		select {
+		case <-s.stop:
+			s.kill(p)
+			return
+		case <-p.ctx.Done():
+		}
+		s.lock.Lock()
+		s.current = nil
+		s.lock.Unlock()
+		p.io.Close()
+		if time.Since(p.started) >= stableAfter {
+			delay = s.restartDelay
+		}
+		s.logger.Warn("MCP server process exited", zap.Error(p.err), zap.Duration("restartIn", delay))
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxRestartDelay)
+			next, err := s.restart()
+			if err == nil {
+				p = next
+				break
+			}
+			s.logger.Error("Failed to restart MCP server process", zap.Error(err), zap.Duration("restartIn", delay))
+		}
+	}
+}
+
+// restart starts a new process and replays the client's initialize handshake to it, if the client got that far.
+// Calls go to the new process once it's initialized.
+func (s *Stdio) restart() (*process, error) {
+	p, err := s.spawn()
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	initialize, initialized := s.initialize, s.initialized
+	s.lock.Unlock()
+	if initialize != nil {
+		ctx, cancel := context.WithTimeout(p.ctx, replayTimeout)
+		defer cancel()
+		response, err := p.io.SendRequest(ctx, *initialize)
+		if err == nil && response.Error != nil {
+			err = errors.New(response.Error.Message)
+		}
+		if err == nil && initialized != nil {
+			err = p.io.SendNotification(ctx, *initialized)
+		}
+		if err != nil {
+			s.kill(p)
+			return nil, fmt.Errorf("failed to initialize restarted MCP server %s: %w", s.config.Name, err)
+		}
+	}
+	s.lock.Lock()
+	s.current = p
+	s.lock.Unlock()
+	s.logger.Info("Restarted MCP server process", zap.Int("pid", p.cmd.Process.Pid))
+	return p, nil
+}
+
+// kill closes the process's stdin, which is how MCP servers are asked to stop, and kills it if it doesn't.
+func (s *Stdio) kill(p *process) {
+	p.io.Close()
+	select {
+	case <-p.ctx.Done():
+	case <-time.After(stopGrace):
+		p.cmd.Process.Kill()
+		<-p.ctx.Done()
+	}
+}
+
+func (s *Stdio) process() (*process, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current == nil {
+		return nil, fmt.Errorf("MCP server %s is not running", s.config.Name)
+	}
+	return s.current, nil
+}
+
+// SendRequest sends the request to the running process. A call the process dies during fails at once instead
+// of waiting out its context.
+func (s *Stdio) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	if request.Method == string(mcp.MethodInitialize) {
+		s.lock.Lock()
+		s.initialize = &request
+		s.lock.Unlock()
+	}
+	p, err := s.process()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(p.ctx, func() { cancel(errExited) })
+	defer stop()
+	response, err := p.io.SendRequest(ctx, request)
+	if err != nil && errors.Is(context.Cause(ctx), errExited) {
+		return nil, fmt.Errorf("MCP server %s: %w", s.config.Name, errExited)
+	}
+	return response, err
+}
+
+func (s *Stdio) SendNotification(ctx context.Context, notification mcp.JSONRPCNotification) error {
+	if notification.Method == "notifications/initialized" {
+		s.lock.Lock()
+		s.initialized = &notification
+		s.lock.Unlock()
+	}
+	p, err := s.process()
+	if err != nil {
+		return err
+	}
+	return p.io.SendNotification(ctx, notification)
+}
+
+// SetNotificationHandler sets the handler for notifications from this and every later process.
+func (s *Stdio) SetNotificationHandler(handler func(notification mcp.JSONRPCNotification)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onNotification = handler
+}
+
+func (s *Stdio) notify(notification mcp.JSONRPCNotification) {
+	s.lock.Lock()
+	handler := s.onNotification
+	s.lock.Unlock()
+	if handler != nil {
+		handler(notification)
+	}
+}
+
+// Close stops the process and its supervision.
+func (s *Stdio) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.lock.Lock()
+	done := s.done
+	s.lock.Unlock()
+	if done != nil {
+		<-done
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	mcpClient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpServer "github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// TestStdioServer isn't a test: run with SNIDEMIND_STDIO_SERVER set, the test binary is the MCP server the
+// stdio tests launch. Its `pid` tool says which process answered, `crash` kills it.
+func TestStdioServer(t *testing.T) {
+	if os.Getenv("SNIDEMIND_STDIO_SERVER") == "" {
+		t.Skip("only run as the server of the stdio tests")
+	}
+	server := mcpServer.NewMCPServer("stdio-test", "1.0.0")
+	server.AddTool(mcp.NewTool("pid"), func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(fmt.Sprint(os.Getpid())), nil
+	})
+	server.AddTool(mcp.NewTool("crash"), func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		fmt.Fprintln(os.Stderr, "crashing as asked")
+		os.Exit(3)
+		return nil, nil
+	})
+	wd, _ := os.Getwd()
+	fmt.Fprintln(os.Stderr, "listening in", wd)
+	mcpServer.NewStdioServer(server).Listen(context.Background(), os.Stdin, os.Stdout)
+	os.Exit(0)
+}
+
+func pid(t *testing.T, client *mcpClient.Client) (string, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := client.CallTool(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Name: "pid"}})
+	if err != nil {
+		return "", err
+	}
+	return result.Content[0].(mcp.TextContent).Text, nil
+}
+
+func TestStdio_RestartsTheServer(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	stdio := NewStdio(config.MCPServerConfig{
+		Name:    "test",
+		Type:    "stdio",
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestStdioServer$"},
+		Env:     []string{"SNIDEMIND_STDIO_SERVER=1"},
+		Cwd:     "/",
+	}, zap.New(core))
+	stdio.restartDelay = 10 * time.Millisecond
+	client := mcpClient.NewClient(stdio)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.Start(ctx))
+	_, err := client.Initialize(ctx, mcp.InitializeRequest{})
+	require.NoError(t, err)
+	first, err := pid(t, client)
+	require.NoError(t, err)
+
+	_, err = client.CallTool(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Name: "crash"}})
+	require.ErrorIs(t, err, errExited, "a call the process dies during fails at once")
+
+	var second string
+	require.Eventually(t, func() bool {
+		second, err = pid(t, client)
+		return err == nil
+	}, 5*time.Second, 20*time.Millisecond, "the restarted process is initialized for the client")
+	assert.NotEqual(t, first, second)
+
+	assert.NotEmpty(t, logs.FilterField(zap.String("line", "crashing as asked")).All(), "stderr is logged")
+	assert.NotEmpty(t, logs.FilterField(zap.String("line", "listening in /")).All(), "the server runs in cwd")
+
+	require.NoError(t, client.Close())
+	_, err = pid(t, client)
+	assert.Error(t, err)
+}
+
+func TestStdio_FailsToStartMissingCommands(t *testing.T) {
+	stdio := NewStdio(config.MCPServerConfig{Name: "test", Type: "stdio", Command: "/nonexistent/mcp-server"}, zap.NewNop())
+	assert.Error(t, stdio.Start(context.Background()))
+	assert.NoError(t, stdio.Close())
+}
+
+func TestStdio_ClosesThePipesWhenTheCommandWontStart(t *testing.T) {
+	descriptors := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("needs /proc to count open files")
+		}
+		return len(entries)
+	}
+	stdio := NewStdio(config.MCPServerConfig{Name: "test", Type: "stdio", Command: "/nonexistent/mcp-server"}, zap.NewNop())
+	before := descriptors()
+	for range 10 {
+		_, err := stdio.spawn()
+		require.Error(t, err)
+	}
+	assert.Equal(t, before, descriptors())
+}
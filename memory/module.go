@@ -0,0 +1,10 @@
+package memory
+
+import "go.uber.org/fx"
+
+var Module = fx.Module(
+	"memory",
+	fx.Provide(
+		NewStore,
+	),
+)
@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/filestore"
+	"github.com/teagan42/snidemind/telemetry"
+	"github.com/teagan42/snidemind/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultDir = "memories"
+	idPrefix   = "mem-"
+)
+
+var ErrNotFound = errors.New("memory not found")
+
+// Memory is one thing the storeMemory step remembered.
+type Memory struct {
+	ID      string   `json:"id"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags,omitempty"` // IDs of the tags the message had when it was remembered
+	Created int64    `json:"created"`
+	Owner   string   `json:"owner,omitempty"` // The identity that stored it, see the `auth` section
+}
+
+// Store keeps memories as one JSON file each, with every memory loaded for listing.
+type Store struct {
+	records *filestore.Store[Memory]
+	logger  *zap.Logger
+}
+
+type Params struct {
+	fx.In
+	Config *config.Config
+	Logger *zap.Logger
+}
+
+type Result struct {
+	fx.Out
+	Store *Store
+}
+
+// NewStore loads the memories stored by earlier runs. A missing directory is created on the first save.
+func NewStore(p Params) (Result, error) {
+	dir := DefaultDir
+	if p.Config.Memory != nil && p.Config.Memory.Dir != "" {
+		dir = p.Config.Memory.Dir
+	}
+	records, err := filestore.Open(dir, func(m Memory) string { return m.ID }, func(m Memory) string { return m.Owner })
+	if err != nil {
+		return Result{}, err
+	}
+	store := &Store{records: records, logger: p.Logger.Named("MemoryStore")}
+	telemetry.ObserveMemoryStoreSize(records.Len())
+	store.logger.Info("Memories loaded", zap.String("dir", dir), zap.Int("memories", records.Len()))
+	return Result{Store: store}, nil
+}
+
+// Add remembers text on behalf of owner, along with the tags it was said under.
+func (s *Store) Add(owner string, text string, tags []string) (Memory, error) {
+	memory := Memory{ID: utils.NewID(idPrefix), Text: text, Tags: tags, Created: time.Now().Unix(), Owner: owner}
+	if err := s.records.Add(memory); err != nil {
+		return Memory{}, err
+	}
+	telemetry.ObserveMemoryStoreSize(s.records.Len())
+	return memory, nil
+}
+
+// Get returns the owner's memory. Someone else's memory is as not found as one that doesn't exist.
+func (s *Store) Get(id string, owner string) (Memory, error) {
+	memory, ok := s.records.Get(id, owner)
+	if !ok {
+		return Memory{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return memory, nil
+}
+
+// List returns the owner's memories, oldest first.
+func (s *Store) List(owner string) []Memory {
+	memories := s.records.List(owner)
+	slices.SortFunc(memories, func(a, b Memory) int {
+		return cmp.Or(cmp.Compare(a.Created, b.Created), strings.Compare(a.ID, b.ID))
+	})
+	return memories
+}
@@ -0,0 +1,56 @@
+package memory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/telemetry"
+	"go.uber.org/zap"
+)
+
+func newStore(t *testing.T, dir string) *Store {
+	t.Helper()
+	result, err := NewStore(Params{Config: &config.Config{Memory: &config.MemoryConfig{Dir: dir}}, Logger: zap.NewNop()})
+	require.NoError(t, err)
+	return result.Store
+}
+
+func TestStore_AddGetAndReload(t *testing.T) {
+	dir := t.TempDir()
+	store := newStore(t, dir)
+	first, err := store.Add("kitchen", "the cat is called Biscuit", []string{"pets"})
+	require.NoError(t, err)
+	second, err := store.Add("kitchen", "never play jazz", nil)
+	require.NoError(t, err)
+	_, err = store.Add("garage", "the car is blue", nil)
+	require.NoError(t, err)
+
+	got, err := store.Get(first.ID, "kitchen")
+	require.NoError(t, err)
+	assert.Equal(t, first, got)
+	_, err = store.Get(first.ID, "garage")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	reloaded := newStore(t, dir)
+	assert.ElementsMatch(t, []Memory{first, second}, reloaded.List("kitchen"))
+	assert.Len(t, reloaded.List("garage"), 1)
+}
+
+func TestStore_ReportsItsSize(t *testing.T) {
+	store := newStore(t, t.TempDir())
+	_, err := store.Add("", "one", nil)
+	require.NoError(t, err)
+	_, err = store.Add("kitchen", "two", nil)
+	require.NoError(t, err)
+
+	expected := `
+# HELP snidemind_memory_store_entries Memories kept by storeMemory, whoever they belong to.
+# TYPE snidemind_memory_store_entries gauge
+snidemind_memory_store_entries 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(telemetry.Registry, strings.NewReader(expected), "snidemind_memory_store_entries"))
+}
@@ -13,10 +13,11 @@ type ModelListResponse struct {
 }
 
 type ChatMessage struct {
-	Role      string                            `json:"role"`
-	Content   string                            `json:"content"`
-	Name      string                            `json:"name,omitempty"`
-	ToolCalls *[]ChatCompletionsMessageToolCall `json:"tool_calls,omitempty"`
+	Role       string                            `json:"role"`
+	Content    string                            `json:"content"`
+	Name       string                            `json:"name,omitempty"`
+	ToolCalls  *[]ChatCompletionsMessageToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string                            `json:"tool_call_id,omitempty"` // The call a `tool` message is the result of
 }
 
 type FunctionCall struct {
@@ -58,6 +59,10 @@ type WebSearchOptions struct {
 	UserLocation      *WebSearchUserLocation `json:"user_location,omitempty"`
 }
 
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
 type ChatCompletionRequest struct {
 	Messages            []ChatMessage     `json:"messages"`
 	Model               string            `json:"model"`
@@ -71,12 +76,15 @@ type ChatCompletionRequest struct {
 	ReasoningEffort     string            `json:"reasoning_effort,omitempty"`
 	ResponseFormat      string            `json:"response_format,omitempty"`
 	Stream              *bool             `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions    `json:"stream_options,omitempty"`
 	Temperature         *float64          `json:"temperature,omitempty"`
 	Tools               *[]Tool           `json:"tools,omitempty"`
 	TopLogProbs         int               `json:"top_logprobs,omitempty"`
 	TopP                *float64          `json:"top_p,omitempty"`
 	User                string            `json:"user,omitempty"`
 	WebSearchOptions    *WebSearchOptions `json:"web_search_options,omitempty"`
+	Store               *bool             `json:"store,omitempty"`
+	Metadata            map[string]string `json:"metadata,omitempty"`
 }
 
 type TokenLogProb struct {
@@ -137,6 +145,35 @@ type ChatCompletionResponse struct {
 	Created int64                  `json:"created"`
 	Model   string                 `json:"model"`
 	Object  string                 `json:"object"`
+	Usage   *Usage                 `json:"usage,omitempty"`
+}
+
+// ChatCompletionChunk is one `data:` event of a streamed chat completion. The answer arrives as deltas,
+// tool calls in pieces that ChatCompletionToolCallDelta.Index says belong together.
+type ChatCompletionChunk struct {
+	ID      string                      `json:"id"`
+	Object  string                      `json:"object,omitempty"`
+	Created int64                       `json:"created"`
+	Model   string                      `json:"model"`
+	Choices []ChatCompletionChunkChoice `json:"choices"`
+	Usage   *Usage                      `json:"usage,omitempty"`
+}
+
+type ChatCompletionChunkChoice struct {
+	Index        int                      `json:"index"`
+	Delta        ChatCompletionChunkDelta `json:"delta"`
+	FinishReason *string                  `json:"finish_reason"`
+}
+
+type ChatCompletionChunkDelta struct {
+	Role      string                        `json:"role,omitempty"`
+	Content   string                        `json:"content,omitempty"`
+	ToolCalls []ChatCompletionToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+type ChatCompletionToolCallDelta struct {
+	Index int `json:"index"`
+	ChatCompletionsMessageToolCall
 }
 
 type EmbeddingData struct {
@@ -149,4 +186,32 @@ type EmbeddingResponse struct {
 	Data   []EmbeddingData `json:"data" validate:"required,dive"`
 	Model  string          `json:"model" validate:"required"`
 	Object string          `json:"object" validate:"required,oneof=list"`
+	Usage  *Usage          `json:"usage,omitempty"`
+}
+
+// StoredChatCompletion is a completion kept because its request set `store: true`, with the metadata it was stored with.
+type StoredChatCompletion struct {
+	ChatCompletionResponse
+	Metadata map[string]string `json:"metadata"`
+}
+
+// StoredChatMessage is one of the messages a stored completion was asked to complete. IDs are `<completion id>-<index>`.
+type StoredChatMessage struct {
+	ID string `json:"id"`
+	ChatMessage
+}
+
+// ListResponse is a page of a paginated list, `after` the last ID of the previous page.
+type ListResponse[T any] struct {
+	Object  string `json:"object"`
+	Data    []T    `json:"data"`
+	FirstID string `json:"first_id"`
+	LastID  string `json:"last_id"`
+	HasMore bool   `json:"has_more"`
+}
+
+type ChatCompletionDeleted struct {
+	Object  string `json:"object"`
+	ID      string `json:"id"`
+	Deleted bool   `json:"deleted"`
 }
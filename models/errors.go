@@ -0,0 +1,103 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error types used in APIErrorDetail.Type, the same ones OpenAI uses.
+const (
+	ErrorTypeInvalidRequest = "invalid_request_error"
+	ErrorTypePermission     = "permission_error"
+	ErrorTypeNotFound       = "not_found_error"
+	ErrorTypeServer         = "server_error"
+)
+
+// ErrForbidden is wrapped by errors for things the request's identity isn't allowed to use,
+// so they can be answered with a 403 rather than a 500.
+var ErrForbidden = errors.New("forbidden")
+
+// APIError is the body of an error response, in the shape OpenAI clients expect:
+// `{"error":{"message":...,"type":...,"param":...,"code":...}}`.
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+type APIErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// StatusError is an error that knows what the client should be told about it: the status and the error body.
+// Steps return one when the failure belongs to the client (a bad request upstream, say) rather than to SnideMind.
+type StatusError struct {
+	Status int
+	Detail APIErrorDetail
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Detail.Type, e.Detail.Message)
+}
+
+// NewStatusError builds a StatusError without a param or code.
+func NewStatusError(status int, errorType string, message string) *StatusError {
+	return &StatusError{Status: status, Detail: APIErrorDetail{Message: message, Type: errorType}}
+}
+
+// UpstreamError turns an error response from an upstream model server into a StatusError, keeping the upstream's
+// own message, type, param and code when it sent an OpenAI style body (or Ollama's `{"error":"..."}`).
+// The upstream refusing SnideMind's credentials is SnideMind's problem, not the client's, so 401 and 403
+// are reported as 502.
+func UpstreamError(status int, body []byte) *StatusError {
+	detail := APIErrorDetail{Type: upstreamErrorType(status)}
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && len(envelope.Error) > 0 {
+		var parsed APIErrorDetail
+		var message string
+		if json.Unmarshal(envelope.Error, &parsed) == nil && parsed.Message != "" {
+			detail.Message, detail.Param, detail.Code = parsed.Message, parsed.Param, parsed.Code
+			if parsed.Type != "" {
+				detail.Type = parsed.Type
+			}
+		} else if json.Unmarshal(envelope.Error, &message) == nil {
+			detail.Message = message
+		}
+	}
+	if detail.Message == "" {
+		detail.Message = strings.TrimSpace(fmt.Sprintf("upstream returned %d %s %s", status, http.StatusText(status), body))
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		status = http.StatusBadGateway
+	}
+	return &StatusError{Status: status, Detail: detail}
+}
+
+func upstreamErrorType(status int) string {
+	switch {
+	case status == http.StatusNotFound:
+		return ErrorTypeNotFound
+	case status >= 400 && status < 500:
+		return ErrorTypeInvalidRequest
+	}
+	return ErrorTypeServer
+}
+
+// ErrorResponse works out the status and body to send for an error returned while handling a request.
+// Anything that isn't a StatusError or ErrForbidden is a 500.
+func ErrorResponse(err error) (int, APIErrorDetail) {
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return statusErr.Status, statusErr.Detail
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden, APIErrorDetail{Message: err.Error(), Type: ErrorTypePermission}
+	}
+	return http.StatusInternalServerError, APIErrorDetail{Message: "The server had an error while processing your request: " + err.Error(), Type: ErrorTypeServer}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamError(t *testing.T) {
+	err := UpstreamError(http.StatusBadRequest, []byte(`{"error":{"message":"This model's maximum context length is 8192 tokens","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`))
+	assert.Equal(t, http.StatusBadRequest, err.Status)
+	assert.Equal(t, "This model's maximum context length is 8192 tokens", err.Detail.Message)
+	assert.Equal(t, "messages", *err.Detail.Param)
+	assert.Equal(t, "context_length_exceeded", *err.Detail.Code)
+
+	err = UpstreamError(http.StatusNotFound, []byte(`{"error":"model \"mistral\" not found, try pulling it first"}`))
+	assert.Equal(t, http.StatusNotFound, err.Status)
+	assert.Equal(t, ErrorTypeNotFound, err.Detail.Type)
+	assert.Equal(t, `model "mistral" not found, try pulling it first`, err.Detail.Message)
+
+	err = UpstreamError(http.StatusServiceUnavailable, []byte("<html>nope</html>"))
+	assert.Equal(t, http.StatusServiceUnavailable, err.Status)
+	assert.Equal(t, ErrorTypeServer, err.Detail.Type)
+	assert.Equal(t, "upstream returned 503 Service Unavailable <html>nope</html>", err.Detail.Message)
+
+	err = UpstreamError(http.StatusUnauthorized, []byte(`{"error":{"message":"Incorrect API key provided","type":"invalid_request_error"}}`))
+	assert.Equal(t, http.StatusBadGateway, err.Status)
+}
+
+func TestErrorResponse(t *testing.T) {
+	status, detail := ErrorResponse(fmt.Errorf("pipeline chat: %w", NewStatusError(http.StatusTooManyRequests, "requests", "slow down")))
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, APIErrorDetail{Message: "slow down", Type: "requests"}, detail)
+
+	status, detail = ErrorResponse(fmt.Errorf("%w: kitchen is not allowed to run pipeline admin", ErrForbidden))
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, ErrorTypePermission, detail.Type)
+
+	status, detail = ErrorResponse(errors.New("boom"))
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, ErrorTypeServer, detail.Type)
+	assert.Contains(t, detail.Message, "boom")
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// Identity is who a request was authenticated as, and what they may use. Empty lists allow everything.
+type Identity struct {
+	Name       string   `json:"name"`
+	Pipelines  []string `json:"pipelines,omitempty"`
+	Models     []string `json:"models,omitempty"`
+	MCPServers []string `json:"mcp_servers,omitempty"`
+}
+
+// AllowsPipeline reports whether the identity may run the named pipeline. Pipeline names are case-insensitive,
+// like the `pipelines` section they come from.
+func (i *Identity) AllowsPipeline(name string) bool {
+	if i == nil || len(i.Pipelines) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(i.Pipelines, func(allowed string) bool {
+		return strings.EqualFold(allowed, name)
+	})
+}
+
+func (i *Identity) AllowsModel(model string) bool {
+	return i == nil || len(i.Models) == 0 || slices.Contains(i.Models, model)
+}
+
+// AllowsMCPServer reports whether tools from the named MCP server may be offered or called on the identity's behalf.
+func (i *Identity) AllowsMCPServer(name string) bool {
+	return i == nil || len(i.MCPServers) == 0 || slices.Contains(i.MCPServers, name)
+}
+
+type identityKey struct{}
+
+// WithIdentity attaches the authenticated identity to a request's context, the pipeline copies it onto the message.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// OwnerFromContext names who stored things belong to when created by the request: its identity, or "" for
+// everyone when authentication is off.
+func OwnerFromContext(ctx context.Context) string {
+	if identity := IdentityFromContext(ctx); identity != nil {
+		return identity.Name
+	}
+	return ""
+}
+
+// IdentityFromContext returns the request's identity, or nil when authentication is off.
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type ToolMetadata struct {
 	Name        string    `json:"name" validate:"required"`
 	Description string    `json:"description,omitempty"`
@@ -8,4 +10,43 @@ type ToolMetadata struct {
 
 type MCPTool struct {
 	ToolMetadata ToolMetadata `json:"metadata" validate:"required,dive"`
+	Server       string       `json:"server,omitempty"` // The MCP server the tool is from, empty for tools that aren't
+}
+
+// ToolSource is where steps find the tools MCP servers offer right now. Servers that are down have none.
+type ToolSource interface {
+	Tools() []MCPTool
+}
+
+// MCPServerState is how the connection to an MCP server is doing.
+type MCPServerState string
+
+const (
+	MCPServerConnecting MCPServerState = "connecting" // Connecting, or reconnecting after it was lost
+	MCPServerReady      MCPServerState = "ready"
+	MCPServerDegraded   MCPServerState = "degraded" // Connected, but health checks are failing
+	MCPServerDown       MCPServerState = "down"     // Not connected, waiting to try again
+)
+
+// Up reports whether the server's tools can be offered.
+func (s MCPServerState) Up() bool {
+	return s == MCPServerReady || s == MCPServerDegraded
+}
+
+// MCPServerStatus is an MCP server's connection state, as reported on /health.
+type MCPServerStatus struct {
+	Name      string         `json:"name"`
+	Type      string         `json:"type"`
+	State     MCPServerState `json:"state"`
+	Since     time.Time      `json:"since"`           // When it entered the state
+	Error     string         `json:"error,omitempty"` // Why it's degraded or down
+	Tools     int            `json:"tools"`
+	Prompts   int            `json:"prompts"`
+	Resources int            `json:"resources"`
+}
+
+// Health is the answer to /health. SnideMind is "ok" while every MCP server is ready, "degraded" otherwise.
+type Health struct {
+	Status     string            `json:"status"`
+	MCPServers []MCPServerStatus `json:"mcp_servers"`
 }
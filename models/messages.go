@@ -0,0 +1,154 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Anthropic's Messages API (/v1/messages) is served next to chat completions for clients that only speak it.
+// Requests are turned into a ChatCompletionRequest for the pipeline and its answer back into a Message.
+
+// MessagesContent is a message's content: a string, or a list of content blocks.
+type MessagesContent struct {
+	Text   string
+	Blocks []MessagesContentBlock
+}
+
+func (c *MessagesContent) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &c.Text)
+	}
+	return json.Unmarshal(data, &c.Blocks)
+}
+
+func (c MessagesContent) MarshalJSON() ([]byte, error) {
+	if c.Blocks != nil {
+		return json.Marshal(c.Blocks)
+	}
+	return json.Marshal(c.Text)
+}
+
+// Join joins the text of the content, failing on blocks a chat model can't be given as text, like images.
+func (c *MessagesContent) Join() (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	if c.Blocks == nil {
+		return c.Text, nil
+	}
+	text := ""
+	for _, block := range c.Blocks {
+		if block.Type != "text" {
+			return "", fmt.Errorf("content blocks of type %s are not supported here", block.Type)
+		}
+		text += block.Text
+	}
+	return text, nil
+}
+
+// MessagesContentBlock is a text, tool_use or tool_result block. Which fields mean something depends on Type.
+type MessagesContentBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   *MessagesContent `json:"content,omitempty"`
+	IsError   bool             `json:"is_error,omitempty"`
+}
+
+// MarshalJSON writes only the block's own fields, all of them, so an empty text block still has its `text`.
+func (b MessagesContentBlock) MarshalJSON() ([]byte, error) {
+	switch b.Type {
+	case "text":
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}{b.Type, b.Text})
+	case "tool_use":
+		input := b.Input
+		if len(input) == 0 {
+			input = json.RawMessage(`{}`)
+		}
+		return json.Marshal(struct {
+			Type  string          `json:"type"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		}{b.Type, b.ID, b.Name, input})
+	}
+	type block MessagesContentBlock
+	return json.Marshal(block(b))
+}
+
+type MessagesMessage struct {
+	Role    string          `json:"role"`
+	Content MessagesContent `json:"content"`
+}
+
+type MessagesTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema ToolFunctionParameters `json:"input_schema"`
+}
+
+type MessagesMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+type MessagesRequest struct {
+	Model         string            `json:"model"`
+	Messages      []MessagesMessage `json:"messages"`
+	MaxTokens     int64             `json:"max_tokens"`
+	System        *MessagesContent  `json:"system,omitempty"`
+	Metadata      *MessagesMetadata `json:"metadata,omitempty"`
+	StopSequences []string          `json:"stop_sequences,omitempty"`
+	Stream        *bool             `json:"stream,omitempty"`
+	Temperature   *float64          `json:"temperature,omitempty"`
+	Tools         []MessagesTool    `json:"tools,omitempty"`
+	TopP          *float64          `json:"top_p,omitempty"`
+}
+
+type MessagesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Message is the answer, the Messages API's counterpart of a chat completion.
+type Message struct {
+	ID           string                 `json:"id"`
+	Type         string                 `json:"type"`
+	Role         string                 `json:"role"`
+	Model        string                 `json:"model"`
+	Content      []MessagesContentBlock `json:"content"`
+	StopReason   *string                `json:"stop_reason"`
+	StopSequence *string                `json:"stop_sequence"`
+	Usage        MessagesUsage          `json:"usage"`
+}
+
+// MessagesDelta is a content_block_delta's `delta` (text or partial tool input) or a message_delta's (the stop reason).
+type MessagesDelta struct {
+	Type        string  `json:"type,omitempty"`
+	Text        string  `json:"text,omitempty"`
+	PartialJSON string  `json:"partial_json,omitempty"`
+	StopReason  *string `json:"stop_reason,omitempty"`
+}
+
+// MessagesError is the `error` of an error body or event, `{"type":"error","error":{"type":...,"message":...}}`.
+type MessagesError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// MessagesStreamEvent is one server-sent event of a streamed message. Which fields are set depends on Type.
+type MessagesStreamEvent struct {
+	Type         string                `json:"type"`
+	Message      *Message              `json:"message,omitempty"`
+	Index        *int                  `json:"index,omitempty"`
+	ContentBlock *MessagesContentBlock `json:"content_block,omitempty"`
+	Delta        *MessagesDelta        `json:"delta,omitempty"`
+	Usage        *MessagesUsage        `json:"usage,omitempty"`
+	Error        *MessagesError        `json:"error,omitempty"`
+}
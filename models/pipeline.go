@@ -1,22 +1,103 @@
 package models
 
 import (
-	"net/http"
-
+	"context"
 	"maps"
+	"net/http"
+	"slices"
 
 	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/utils"
 )
 
 type PipelineMessage struct {
 	Request        *ChatCompletionRequest
 	Tags           *map[string]string      // Tags associated with the message
+	TagScores      *map[string]float64     // Confidence scores for the tags, keyed by tag ID
 	Tools          *[]MCPTool              // Tools associated with the message
 	Prompts        *[]string               // Prompts associated with the message
 	Memories       *[]string               // Memories associated with the message
 	Knowledge      *[]string               // Knowledge associated with the message
 	ResponseWriter http.ResponseWriter     // Content of the message
 	Response       *ChatCompletionResponse // Response from the message
+	Usage          *UsageMeter             // Tokens spent on the message, shared with its clones
+	Identity       *Identity               // Who sent the request, nil when authentication is off
+	state          map[string]any          // Typed step state, see StateKey
+	ctx            context.Context         // Context of the request, see Context
+}
+
+// Context returns the context of the request being processed, carrying its cancellation and the current span.
+func (p *PipelineMessage) Context() context.Context {
+	if p == nil || p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// SetContext replaces the message's context, e.g. to make a step's span the parent of whatever the step calls.
+func (p *PipelineMessage) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+func cloneSlice[T any](s *[]T) *[]T {
+	if s == nil {
+		return nil
+	}
+	clone := slices.Clone(*s)
+	return &clone
+}
+
+func cloneMap[K comparable, V any](m *map[K]V) *map[K]V {
+	if m == nil {
+		return nil
+	}
+	clone := maps.Clone(*m)
+	return &clone
+}
+
+// Clone returns a deep copy of the message so concurrent branches can mutate it independently.
+// The ResponseWriter and context are shared; a fork gives each branch its own, see fork.ForkPipelineStage.
+func (p *PipelineMessage) Clone() *PipelineMessage {
+	if p == nil {
+		return nil
+	}
+	clone := &PipelineMessage{
+		Tags:           cloneMap(p.Tags),
+		TagScores:      cloneMap(p.TagScores),
+		Tools:          cloneSlice(p.Tools),
+		Prompts:        cloneSlice(p.Prompts),
+		Memories:       cloneSlice(p.Memories),
+		Knowledge:      cloneSlice(p.Knowledge),
+		ResponseWriter: p.ResponseWriter,
+		Usage:          p.Usage,
+		Identity:       p.Identity,
+		state:          cloneState(p.state),
+		ctx:            p.ctx,
+	}
+	if p.Request != nil {
+		request := *p.Request
+		request.Messages = cloneMessages(p.Request.Messages)
+		request.Tools = cloneSlice(p.Request.Tools)
+		clone.Request = &request
+	}
+	if p.Response != nil {
+		response := *p.Response
+		response.Choices = slices.Clone(p.Response.Choices)
+		for i := range response.Choices {
+			response.Choices[i].Message.ToolCalls = cloneSlice(response.Choices[i].Message.ToolCalls)
+		}
+		clone.Response = &response
+	}
+	return clone
+}
+
+// cloneMessages copies the conversation down to each message's tool calls, which a step may append to.
+func cloneMessages(messages []ChatMessage) []ChatMessage {
+	clone := slices.Clone(messages)
+	for i := range clone {
+		clone[i].ToolCalls = cloneSlice(clone[i].ToolCalls)
+	}
+	return clone
 }
 
 func (p *PipelineMessage) Combine(message *PipelineMessage) {
@@ -26,30 +107,47 @@ func (p *PipelineMessage) Combine(message *PipelineMessage) {
 		}
 		maps.Copy((*p.Tags), *message.Tags)
 	}
+	if message.TagScores != nil {
+		if p.TagScores == nil {
+			p.TagScores = &map[string]float64{}
+		}
+		maps.Copy((*p.TagScores), *message.TagScores)
+	}
 	if message.Tools != nil {
 		if p.Tools == nil {
 			p.Tools = &[]MCPTool{}
 		}
-		*p.Tools = append(*p.Tools, (*message.Tools)...)
+		*p.Tools = utils.UnionFunc(*p.Tools, *message.Tools, func(tool MCPTool) string { return tool.ToolMetadata.Name })
 	}
 	if message.Prompts != nil {
 		if p.Prompts == nil {
 			p.Prompts = &[]string{}
 		}
-		*p.Prompts = append(*p.Prompts, (*message.Prompts)...)
+		*p.Prompts = utils.Union(*p.Prompts, *message.Prompts)
 	}
 	if message.Memories != nil {
 		if p.Memories == nil {
 			p.Memories = &[]string{}
 		}
-		*p.Memories = append(*p.Memories, (*message.Memories)...)
+		*p.Memories = utils.Union(*p.Memories, *message.Memories)
 	}
 	if message.Knowledge != nil {
 		if p.Knowledge == nil {
 			p.Knowledge = &[]string{}
 		}
-		*p.Knowledge = append(*p.Knowledge, (*message.Knowledge)...)
+		*p.Knowledge = utils.Union(*p.Knowledge, *message.Knowledge)
 	}
+	p.state = mergeState(p.state, message.state)
+}
+
+// MergeBranchState replaces the message's state with parent, the snapshot taken before the branches were
+// cloned from it, plus what each branch changed since, merged in branch order.
+func (p *PipelineMessage) MergeBranchState(parent map[string]any, branches ...*PipelineMessage) {
+	states := make([]map[string]any, len(branches))
+	for i, branch := range branches {
+		states[i] = branch.state
+	}
+	p.state = mergeBranchState(parent, states)
 }
 
 type PipelineStep interface {
@@ -63,3 +161,18 @@ type PipelineStepFactory interface {
 	Name() string                                                                                               // Name of the factory, used for identification and logging
 	Build(config config.PipelineStepConfig, stepFactories map[string]PipelineStepFactory) (PipelineStep, error) // Build a new pipeline step from the factory
 }
+
+// PipelineStepConfigValidator is implemented by factories that check their own config section before anything is built.
+type PipelineStepConfigValidator interface {
+	ValidateConfig(config config.PipelineStepConfig) error
+}
+
+type NestedPipeline struct {
+	Path   string                // Path of the sub-pipeline relative to the step, e.g. fork[1]
+	Config config.PipelineConfig // Config of the sub-pipeline
+}
+
+// NestedPipelineProvider is implemented by factories whose steps embed sub-pipelines, so validation can walk into them.
+type NestedPipelineProvider interface {
+	NestedPipelines(config config.PipelineStepConfig) []NestedPipeline
+}
@@ -11,7 +11,7 @@ func TestPipelineMessage_Combine_AllFields(t *testing.T) {
 	pm1 := &PipelineMessage{
 		Tags: &map[string]string{"a": "1"},
 		Tools: &[]MCPTool{{
-			ToolMetadata{
+			ToolMetadata: ToolMetadata{
 				Name:        "tool1",
 				Description: "description1",
 				Tags:        &[]string{"tag1", "tag2"},
@@ -26,7 +26,7 @@ func TestPipelineMessage_Combine_AllFields(t *testing.T) {
 	pm2 := &PipelineMessage{
 		Tags: &map[string]string{"b": "2"},
 		Tools: &[]MCPTool{{
-			ToolMetadata{
+			ToolMetadata: ToolMetadata{
 				Name:        "tool2",
 				Description: "description2",
 				Tags:        &[]string{"tag3", "tag4"},
@@ -48,14 +48,14 @@ func TestPipelineMessage_Combine_AllFields(t *testing.T) {
 	// Check Tools
 	wantTools := []MCPTool{
 		{
-			ToolMetadata{
+			ToolMetadata: ToolMetadata{
 				Name:        "tool1",
 				Description: "description1",
 				Tags:        &[]string{"tag1", "tag2"},
 			},
 		},
 		{
-			ToolMetadata{
+			ToolMetadata: ToolMetadata{
 				Name:        "tool2",
 				Description: "description2",
 				Tags:        &[]string{"tag3", "tag4"},
@@ -90,7 +90,7 @@ func TestPipelineMessage_Combine_NilFields(t *testing.T) {
 	pm2 := &PipelineMessage{
 		Tags: &map[string]string{"x": "y"},
 		Tools: &[]MCPTool{{
-			ToolMetadata{
+			ToolMetadata: ToolMetadata{
 				Name:        "t",
 				Description: "d",
 				Tags:        &[]string{"tag1", "tag2"},
@@ -124,7 +124,7 @@ func TestPipelineMessage_Combine_NilInputFields(t *testing.T) {
 	pm1 := &PipelineMessage{
 		Tags: &map[string]string{"a": "1"},
 		Tools: &[]MCPTool{{
-			ToolMetadata{
+			ToolMetadata: ToolMetadata{
 				Name:        "tool1",
 				Description: "description1",
 				Tags:        &[]string{"tag1", "tag2"},
@@ -157,3 +157,64 @@ func TestPipelineMessage_Combine_NilInputFields(t *testing.T) {
 		t.Errorf("Knowledge changed unexpectedly: got %v, want %v", pm1.Knowledge, orig.Knowledge)
 	}
 }
+
+func TestPipelineMessage_Combine_TagScores(t *testing.T) {
+	pm1 := &PipelineMessage{}
+	pm2 := &PipelineMessage{
+		TagScores: &map[string]float64{"memory": 0.7},
+	}
+
+	pm1.Combine(pm2)
+
+	if pm1.TagScores == nil || (*pm1.TagScores)["memory"] != 0.7 {
+		t.Errorf("TagScores not combined correctly: got %v", pm1.TagScores)
+	}
+}
+
+func TestPipelineMessage_Combine_DedupesTools(t *testing.T) {
+	tool := MCPTool{ToolMetadata: ToolMetadata{Name: "tool1"}}
+	pm1 := &PipelineMessage{Tools: &[]MCPTool{tool}, Memories: &[]string{"m"}}
+	pm2 := &PipelineMessage{Tools: &[]MCPTool{tool}, Memories: &[]string{"m", "n"}}
+
+	pm1.Combine(pm2)
+
+	if len(*pm1.Tools) != 1 {
+		t.Errorf("expected duplicate tools to be dropped, got %v", *pm1.Tools)
+	}
+	if !reflect.DeepEqual(*pm1.Memories, []string{"m", "n"}) {
+		t.Errorf("expected duplicate memories to be dropped, got %v", *pm1.Memories)
+	}
+}
+
+func TestPipelineMessage_Clone_IsIndependent(t *testing.T) {
+	original := &PipelineMessage{
+		Request: &ChatCompletionRequest{
+			Messages: []ChatMessage{{Role: "user", Content: "hi", ToolCalls: &[]ChatCompletionsMessageToolCall{{ID: "call_1"}}}},
+		},
+		Tags:      &map[string]string{"a": "1"},
+		TagScores: &map[string]float64{"a": 0.5},
+		Tools:     &[]MCPTool{{ToolMetadata: ToolMetadata{Name: "tool1"}}},
+		Memories:  &[]string{"m"},
+	}
+
+	clone := original.Clone()
+	(*clone.Tags)["b"] = "2"
+	(*clone.TagScores)["a"] = 0.9
+	*clone.Tools = append(*clone.Tools, MCPTool{ToolMetadata: ToolMetadata{Name: "tool2"}})
+	(*clone.Memories)[0] = "changed"
+	clone.Request.Messages[0].Content = "changed"
+	(*clone.Request.Messages[0].ToolCalls)[0].ID = "changed"
+
+	if len(*original.Tags) != 1 || (*original.TagScores)["a"] != 0.5 {
+		t.Errorf("clone mutated original tags: %v %v", *original.Tags, *original.TagScores)
+	}
+	if len(*original.Tools) != 1 || (*original.Memories)[0] != "m" {
+		t.Errorf("clone mutated original slices: %v %v", *original.Tools, *original.Memories)
+	}
+	if original.Request.Messages[0].Content != "hi" || (*original.Request.Messages[0].ToolCalls)[0].ID != "call_1" {
+		t.Errorf("clone mutated original request: %v", original.Request.Messages)
+	}
+	if (*PipelineMessage)(nil).Clone() != nil {
+		t.Error("expected nil clone of nil message")
+	}
+}
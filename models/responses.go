@@ -0,0 +1,191 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// The Responses API (/v1/responses) is OpenAI's successor to chat completions. Requests are turned into a
+// ChatCompletionRequest for the pipeline and the pipeline's answer back into a Response.
+
+// ResponseContent is an input message's content: a string, or a list of parts of which only the text ones
+// mean anything to a chat model.
+type ResponseContent struct {
+	Text  string
+	Parts []ResponseContentPart
+}
+
+type ResponseContentPart struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+}
+
+func (c *ResponseContent) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &c.Text)
+	}
+	return json.Unmarshal(data, &c.Parts)
+}
+
+func (c ResponseContent) MarshalJSON() ([]byte, error) {
+	if c.Parts != nil {
+		return json.Marshal(c.Parts)
+	}
+	return json.Marshal(c.Text)
+}
+
+// ResponseInputItem is one item of a request's `input`: a message, a function call the model made earlier or
+// the output of one.
+type ResponseInputItem struct {
+	Type      string           `json:"type,omitempty"` // message (the default), function_call or function_call_output
+	ID        string           `json:"id,omitempty"`
+	Role      string           `json:"role,omitempty"`
+	Content   *ResponseContent `json:"content,omitempty"`
+	CallID    string           `json:"call_id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Arguments string           `json:"arguments,omitempty"`
+	Output    string           `json:"output,omitempty"`
+}
+
+// ResponseInput is a request's `input`, either a string (one user message) or a list of items.
+type ResponseInput struct {
+	Text  string
+	Items []ResponseInputItem
+}
+
+func (i *ResponseInput) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &i.Text)
+	}
+	return json.Unmarshal(data, &i.Items)
+}
+
+func (i ResponseInput) MarshalJSON() ([]byte, error) {
+	if i.Items != nil {
+		return json.Marshal(i.Items)
+	}
+	return json.Marshal(i.Text)
+}
+
+// ResponseTool is a tool in the Responses shape, which is a chat completion tool with the function flattened in.
+type ResponseTool struct {
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  ToolFunctionParameters `json:"parameters,omitempty"`
+	Strict      *bool                  `json:"strict,omitempty"`
+}
+
+type ResponseRequest struct {
+	Model              string            `json:"model"`
+	Input              ResponseInput     `json:"input"`
+	Instructions       string            `json:"instructions,omitempty"`
+	PreviousResponseID string            `json:"previous_response_id,omitempty"`
+	MaxOutputTokens    *int64            `json:"max_output_tokens,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	ParallelToolCalls  *bool             `json:"parallel_tool_calls,omitempty"`
+	Store              *bool             `json:"store,omitempty"`
+	Stream             *bool             `json:"stream,omitempty"`
+	Temperature        *float64          `json:"temperature,omitempty"`
+	Tools              []ResponseTool    `json:"tools,omitempty"`
+	TopP               *float64          `json:"top_p,omitempty"`
+	User               string            `json:"user,omitempty"`
+}
+
+type ResponseOutputContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+// ResponseOutputItem is a message or a function call in a response's `output`.
+type ResponseOutputItem struct {
+	Type      string                  `json:"type"`
+	ID        string                  `json:"id"`
+	Status    string                  `json:"status"`
+	Role      string                  `json:"role,omitempty"`
+	Content   []ResponseOutputContent `json:"content,omitempty"`
+	CallID    string                  `json:"call_id,omitempty"`
+	Name      string                  `json:"name,omitempty"`
+	Arguments string                  `json:"arguments,omitempty"`
+}
+
+type ResponseUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+func NewResponseUsage(usage Usage) *ResponseUsage {
+	return &ResponseUsage{InputTokens: usage.PromptTokens, OutputTokens: usage.CompletionTokens, TotalTokens: usage.TotalTokens}
+}
+
+type ResponseIncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+type ResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Response struct {
+	ID                 string                     `json:"id"`
+	Object             string                     `json:"object"`
+	CreatedAt          int64                      `json:"created_at"`
+	Status             string                     `json:"status"`
+	Model              string                     `json:"model"`
+	Output             []ResponseOutputItem       `json:"output"`
+	Instructions       *string                    `json:"instructions"`
+	PreviousResponseID *string                    `json:"previous_response_id"`
+	Error              *ResponseError             `json:"error"`
+	IncompleteDetails  *ResponseIncompleteDetails `json:"incomplete_details"`
+	Metadata           map[string]string          `json:"metadata"`
+	ParallelToolCalls  bool                       `json:"parallel_tool_calls"`
+	Store              bool                       `json:"store"`
+	Temperature        *float64                   `json:"temperature"`
+	TopP               *float64                   `json:"top_p"`
+	Tools              []ResponseTool             `json:"tools"`
+	Usage              *ResponseUsage             `json:"usage"`
+	User               string                     `json:"user,omitempty"`
+}
+
+// ResponseStreamEvent is one server-sent event of a streamed response. Which fields are set depends on Type,
+// e.g. `response.output_text.delta` carries the item, its indexes and the Delta.
+type ResponseStreamEvent struct {
+	Type           string                 `json:"type"`
+	SequenceNumber int                    `json:"sequence_number"`
+	Response       *Response              `json:"response,omitempty"`
+	OutputIndex    *int                   `json:"output_index,omitempty"`
+	ContentIndex   *int                   `json:"content_index,omitempty"`
+	ItemID         string                 `json:"item_id,omitempty"`
+	Item           *ResponseOutputItem    `json:"item,omitempty"`
+	Part           *ResponseOutputContent `json:"part,omitempty"`
+	Delta          string                 `json:"delta,omitempty"`
+	Text           *string                `json:"text,omitempty"`
+	Arguments      *string                `json:"arguments,omitempty"`
+}
+
+// Join joins the text parts of the content, failing on parts a chat model can't be given, like images.
+func (c *ResponseContent) Join() (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	if c.Parts == nil {
+		return c.Text, nil
+	}
+	text := ""
+	for _, part := range c.Parts {
+		switch part.Type {
+		case "input_text", "output_text", "text":
+			if text != "" {
+				text += "\n"
+			}
+			text += part.Text
+		default:
+			return "", fmt.Errorf("content of type %s is not supported", part.Type)
+		}
+	}
+	return text, nil
+}
@@ -0,0 +1,202 @@
+package models
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// StateKey is a typed handle on a slot in PipelineMessage's state area.
+// Steps declare keys once (usually as package variables) and use GetState/SetState to exchange data
+// without adding fields to PipelineMessage.
+type StateKey[T any] struct {
+	name string
+}
+
+// StateCloner can be implemented by state values that hold references (slices, maps, pointers),
+// so that cloning a message for a fork branch gives the branch its own copy.
+type StateCloner interface {
+	CloneState() any
+}
+
+// StateDiffer can be implemented by state values of mergeable keys that accumulate (e.g. append to a slice),
+// so that merging fork branches combines only what each branch added rather than the parent's value again.
+type StateDiffer interface {
+	DiffState(since any) any
+}
+
+type stateKeyInfo struct {
+	typeName string
+	merge    func(current any, incoming any) any
+}
+
+var (
+	stateRegistryLock sync.RWMutex
+	stateRegistry     = map[string]stateKeyInfo{}
+)
+
+func registerStateKey[T any](name string, merge func(current any, incoming any) any) StateKey[T] {
+	stateRegistryLock.Lock()
+	defer stateRegistryLock.Unlock()
+	typeName := fmt.Sprintf("%T", *new(T))
+	if existing, ok := stateRegistry[name]; ok && existing.typeName != typeName {
+		panic(fmt.Sprintf("state key %q already registered with type %s", name, existing.typeName))
+	}
+	stateRegistry[name] = stateKeyInfo{typeName: typeName, merge: merge}
+	return StateKey[T]{name: name}
+}
+
+// NewStateKey registers a state key. When fork branches are merged the later branch's value wins.
+// Registering the same name twice with a different type panics.
+func NewStateKey[T any](name string) StateKey[T] {
+	return registerStateKey[T](name, nil)
+}
+
+// NewMergeableStateKey registers a state key whose values are combined with merge when fork branches are merged.
+func NewMergeableStateKey[T any](name string, merge func(current T, incoming T) T) StateKey[T] {
+	return registerStateKey[T](name, func(current any, incoming any) any {
+		return merge(current.(T), incoming.(T))
+	})
+}
+
+func (k StateKey[T]) Name() string {
+	return k.name
+}
+
+// RegisteredStateKeys lists the names of every registered state key.
+func RegisteredStateKeys() []string {
+	stateRegistryLock.RLock()
+	defer stateRegistryLock.RUnlock()
+	names := make([]string, 0, len(stateRegistry))
+	for name := range stateRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetState[T any](m *PipelineMessage, key StateKey[T]) (T, bool) {
+	var zero T
+	if m == nil || m.state == nil {
+		return zero, false
+	}
+	value, ok := m.state[key.name]
+	if !ok {
+		return zero, false
+	}
+	typed, ok := value.(T)
+	return typed, ok
+}
+
+// SetState stores a value under the key. Values are replaced, never mutated in place,
+// so a message's state can be shared with its clones until one of them writes.
+func SetState[T any](m *PipelineMessage, key StateKey[T], value T) {
+	if m.state == nil {
+		m.state = map[string]any{}
+	}
+	m.state[key.name] = value
+}
+
+func DeleteState[T any](m *PipelineMessage, key StateKey[T]) {
+	if m.state != nil {
+		delete(m.state, key.name)
+	}
+}
+
+func cloneState(state map[string]any) map[string]any {
+	if state == nil {
+		return nil
+	}
+	clone := make(map[string]any, len(state))
+	for name, value := range state {
+		if cloner, ok := value.(StateCloner); ok {
+			clone[name] = cloner.CloneState()
+		} else {
+			clone[name] = value
+		}
+	}
+	return clone
+}
+
+func mergeState(current map[string]any, incoming map[string]any) map[string]any {
+	if incoming == nil {
+		return current
+	}
+	if current == nil {
+		current = map[string]any{}
+	}
+	stateRegistryLock.RLock()
+	defer stateRegistryLock.RUnlock()
+	for name, value := range incoming {
+		existing, exists := current[name]
+		if info, ok := stateRegistry[name]; ok && exists && info.merge != nil {
+			current[name] = info.merge(existing, value)
+		} else {
+			current[name] = value
+		}
+	}
+	return current
+}
+
+// mergeBranchState starts from the parent's state and applies the keys each branch set, changed or deleted.
+// A key changed by more than one branch, or whose value can report its change with StateDiffer, is combined
+// with the key's merge function; otherwise the later branch wins.
+func mergeBranchState(parent map[string]any, branches []map[string]any) map[string]any {
+	merged := cloneState(parent)
+	if merged == nil {
+		merged = map[string]any{}
+	}
+	changed := map[string]bool{}
+	stateRegistryLock.RLock()
+	defer stateRegistryLock.RUnlock()
+	for _, branch := range branches {
+		for name := range parent {
+			if _, kept := branch[name]; !kept {
+				delete(merged, name)
+			}
+		}
+		for name, value := range branch {
+			before, existed := parent[name]
+			if existed && reflect.DeepEqual(before, value) {
+				continue
+			}
+			info := stateRegistry[name]
+			current, present := merged[name]
+			if info.merge != nil && present {
+				if differ, ok := value.(StateDiffer); ok && existed {
+					merged[name] = info.merge(current, differ.DiffState(before))
+				} else if changed[name] {
+					merged[name] = info.merge(current, value)
+				} else {
+					merged[name] = value
+				}
+			} else {
+				merged[name] = value
+			}
+			changed[name] = true
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// StateSnapshot returns a shallow copy of every state value, for serialising the message.
+func (p *PipelineMessage) StateSnapshot() map[string]any {
+	if p == nil || p.state == nil {
+		return nil
+	}
+	return maps.Clone(p.state)
+}
+
+// SetRawState stores an untyped value, e.g. one decoded from JSON by an external step.
+// Typed reads of the key only succeed if the value has the key's type.
+func (p *PipelineMessage) SetRawState(name string, value any) {
+	if p.state == nil {
+		p.state = map[string]any{}
+	}
+	p.state[name] = value
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+type testHits []string
+
+func (h testHits) CloneState() any { return slices.Clone(h) }
+
+var (
+	testCounterKey = NewStateKey[int]("test.counter")
+	testHitsKey    = NewMergeableStateKey("test.hits", func(current testHits, incoming testHits) testHits {
+		merged := slices.Clone(current)
+		for _, hit := range incoming {
+			if !slices.Contains(merged, hit) {
+				merged = append(merged, hit)
+			}
+		}
+		return merged
+	})
+)
+
+func TestState_GetSet(t *testing.T) {
+	msg := &PipelineMessage{}
+	if _, ok := GetState(msg, testCounterKey); ok {
+		t.Error("expected no value before SetState")
+	}
+	SetState(msg, testCounterKey, 3)
+	if value, ok := GetState(msg, testCounterKey); !ok || value != 3 {
+		t.Errorf("expected 3, got %v (%v)", value, ok)
+	}
+	DeleteState(msg, testCounterKey)
+	if _, ok := GetState(msg, testCounterKey); ok {
+		t.Error("expected value to be deleted")
+	}
+	if _, ok := GetState((*PipelineMessage)(nil), testCounterKey); ok {
+		t.Error("expected no value on nil message")
+	}
+}
+
+func TestState_RegisteredKeys(t *testing.T) {
+	keys := RegisteredStateKeys()
+	if !slices.Contains(keys, "test.counter") || !slices.Contains(keys, "test.hits") {
+		t.Errorf("expected test keys to be registered, got %v", keys)
+	}
+	if testCounterKey.Name() != "test.counter" {
+		t.Errorf("unexpected key name %q", testCounterKey.Name())
+	}
+}
+
+func TestState_ReRegisterSameTypeIsAllowed(t *testing.T) {
+	key := NewStateKey[int]("test.counter")
+	msg := &PipelineMessage{}
+	SetState(msg, testCounterKey, 5)
+	if value, _ := GetState(msg, key); value != 5 {
+		t.Errorf("expected keys with the same name to share state, got %v", value)
+	}
+}
+
+func TestState_ReRegisterDifferentTypePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic when re-registering a key with a different type")
+		}
+	}()
+	NewStateKey[string]("test.counter")
+}
+
+func TestState_CloneIsIndependent(t *testing.T) {
+	msg := &PipelineMessage{}
+	SetState(msg, testCounterKey, 1)
+	SetState(msg, testHitsKey, testHits{"a"})
+
+	clone := msg.Clone()
+	SetState(clone, testCounterKey, 2)
+	hits, _ := GetState(clone, testHitsKey)
+	hits[0] = "changed"
+
+	if value, _ := GetState(msg, testCounterKey); value != 1 {
+		t.Errorf("clone overwrote original counter: %v", value)
+	}
+	if value, _ := GetState(msg, testHitsKey); !reflect.DeepEqual(value, testHits{"a"}) {
+		t.Errorf("clone mutated original hits: %v", value)
+	}
+}
+
+func TestState_MergeBranchState(t *testing.T) {
+	msg := &PipelineMessage{}
+	SetState(msg, testHitsKey, testHits{"a"})
+	SetState(msg, testCounterKey, 1)
+
+	left := msg.Clone()
+	SetState(left, testHitsKey, testHits{"a", "b"})
+	SetState(left, testCounterKey, 2)
+	right := msg.Clone()
+	SetState(right, testHitsKey, testHits{"a", "c"})
+	SetState(right, testCounterKey, 3)
+
+	msg.MergeBranchState(msg.StateSnapshot(), left, right)
+
+	if value, _ := GetState(msg, testHitsKey); !reflect.DeepEqual(value, testHits{"a", "b", "c"}) {
+		t.Errorf("expected merged hits, got %v", value)
+	}
+	if value, _ := GetState(msg, testCounterKey); value != 3 {
+		t.Errorf("expected last branch to win for unmergeable key, got %v", value)
+	}
+}
+
+func TestState_Combine(t *testing.T) {
+	msg := &PipelineMessage{}
+	other := &PipelineMessage{}
+	SetState(other, testCounterKey, 7)
+
+	msg.Combine(other)
+
+	if value, ok := GetState(msg, testCounterKey); !ok || value != 7 {
+		t.Errorf("expected combined state, got %v (%v)", value, ok)
+	}
+}
+
+func TestState_RawAccess(t *testing.T) {
+	msg := &PipelineMessage{}
+	if msg.StateSnapshot() != nil {
+		t.Error("expected nil snapshot for empty state")
+	}
+	msg.SetRawState("test.counter", 4)
+	msg.SetRawState("external", map[string]any{"a": 1.0})
+
+	snapshot := msg.StateSnapshot()
+	snapshot["test.counter"] = 5
+	if value, _ := GetState(msg, testCounterKey); value != 4 {
+		t.Errorf("expected snapshot to be a copy, got %v", value)
+	}
+	if !reflect.DeepEqual(msg.StateSnapshot()["external"], map[string]any{"a": 1.0}) {
+		t.Errorf("unexpected raw state %v", msg.StateSnapshot())
+	}
+}
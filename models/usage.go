@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+// Usage is the token count reported by a model, in the OpenAI `usage` shape.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
+}
+
+// UsageMeter adds up the tokens spent on every model call made for one request: the answer itself, every
+// round of a tool loop and auxiliary calls like embeddings. Clones of a message share their meter, so
+// calls made in fork branches count exactly once.
+type UsageMeter struct {
+	lock    sync.Mutex
+	byModel map[string]Usage
+}
+
+func NewUsageMeter() *UsageMeter {
+	return &UsageMeter{byModel: map[string]Usage{}}
+}
+
+// Add records a call's usage. Calls that report nothing are left out, so they don't show up in ByModel.
+func (m *UsageMeter) Add(model string, usage Usage) {
+	if usage == (Usage{}) {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.byModel[model] = m.byModel[model].Add(usage)
+}
+
+// Total is the usage of every call recorded so far.
+func (m *UsageMeter) Total() Usage {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	total := Usage{}
+	for _, usage := range m.byModel {
+		total = total.Add(usage)
+	}
+	return total
+}
+
+func (m *UsageMeter) ByModel() map[string]Usage {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return maps.Clone(m.byModel)
+}
+
+// AddUsage records the tokens a model call made for this message spent.
+func (p *PipelineMessage) AddUsage(model string, usage Usage) {
+	if p.Usage == nil {
+		p.Usage = NewUsageMeter()
+	}
+	p.Usage.Add(model, usage)
+}
+
+// TotalUsage is the usage of every model call made for the message, including those made in its clones.
+func (p *PipelineMessage) TotalUsage() Usage {
+	if p.Usage == nil {
+		return Usage{}
+	}
+	return p.Usage.Total()
+}
+
+type usageMeterKey struct{}
+
+// WithUsageMeter attaches a meter to a request's context, so whoever handles the request
+// (the rate limiter, say) can find out what it cost once the pipeline is done.
+func WithUsageMeter(ctx context.Context, meter *UsageMeter) context.Context {
+	return context.WithValue(ctx, usageMeterKey{}, meter)
+}
+
+func UsageMeterFromContext(ctx context.Context) *UsageMeter {
+	meter, _ := ctx.Value(usageMeterKey{}).(*UsageMeter)
+	return meter
+}
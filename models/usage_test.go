@@ -0,0 +1,56 @@
+package models
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestUsageMeter_SumsPerModel(t *testing.T) {
+	meter := NewUsageMeter()
+	meter.Add("mistral", Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15})
+	meter.Add("mistral", Usage{PromptTokens: 20, CompletionTokens: 7, TotalTokens: 27})
+	meter.Add("nomic-embed-text", Usage{PromptTokens: 4, TotalTokens: 4})
+	meter.Add("silent", Usage{})
+
+	if got, want := meter.Total(), (Usage{PromptTokens: 34, CompletionTokens: 12, TotalTokens: 46}); got != want {
+		t.Errorf("Total() = %+v, want %+v", got, want)
+	}
+	byModel := meter.ByModel()
+	if len(byModel) != 2 {
+		t.Errorf("ByModel() = %+v, want only the models that reported usage", byModel)
+	}
+	if got, want := byModel["mistral"], (Usage{PromptTokens: 30, CompletionTokens: 12, TotalTokens: 42}); got != want {
+		t.Errorf("ByModel()[mistral] = %+v, want %+v", got, want)
+	}
+}
+
+func TestPipelineMessage_ClonesShareUsage(t *testing.T) {
+	original := &PipelineMessage{Usage: NewUsageMeter()}
+	original.AddUsage("mistral", Usage{PromptTokens: 1, TotalTokens: 1})
+
+	var wg sync.WaitGroup
+	for range 2 {
+		branch := original.Clone()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			branch.AddUsage("mistral", Usage{CompletionTokens: 2, TotalTokens: 2})
+		}()
+	}
+	wg.Wait()
+
+	if got, want := original.TotalUsage(), (Usage{PromptTokens: 1, CompletionTokens: 4, TotalTokens: 5}); got != want {
+		t.Errorf("TotalUsage() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPipelineMessage_AddUsageWithoutMeter(t *testing.T) {
+	message := &PipelineMessage{}
+	if got := message.TotalUsage(); got != (Usage{}) {
+		t.Errorf("TotalUsage() = %+v, want zero", got)
+	}
+	message.AddUsage("mistral", Usage{PromptTokens: 3, TotalTokens: 3})
+	if got := message.TotalUsage().TotalTokens; got != 3 {
+		t.Errorf("TotalUsage().TotalTokens = %d, want 3", got)
+	}
+}
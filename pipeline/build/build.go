@@ -0,0 +1,50 @@
+package build
+
+import (
+	"fmt"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/condition"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	"go.uber.org/zap"
+)
+
+// StepError is a step whose factory failed to build it, and where in its list of steps it was.
+type StepError struct {
+	Index int
+	Type  string
+	Err   error
+}
+
+func (e *StepError) Error() string {
+	return "failed to build pipeline step: " + e.Err.Error()
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Steps builds a list of steps with their registered factories, each guarded by its `when` and traced. in names
+// what the list belongs to, e.g. fork or router, for the errors and logs.
+func Steps(in string, stepConfigs []config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory, logger *zap.Logger) ([]models.PipelineStep, error) {
+	steps := []models.PipelineStep{}
+	for j, step := range stepConfigs {
+		if step.Type == "" {
+			logger.Error("Step type is empty", zap.String("in", in), zap.Int("index", j))
+			return nil, fmt.Errorf("step type is empty in %s config at index %d", in, j)
+		}
+		factory, ok := stepFactories[step.Type]
+		if !ok {
+			logger.Error("Unknown pipeline step type", zap.String("in", in), zap.String("type", step.Type))
+			return nil, fmt.Errorf("unknown pipeline step type: %s", step.Type)
+		}
+		stage, err := factory.Build(step, stepFactories)
+		if err != nil {
+			logger.Error("Error building pipeline step", zap.String("in", in), zap.Int("index", j), zap.String("type", step.Type), zap.Error(err))
+			return nil, &StepError{Index: j, Type: step.Type, Err: err}
+		}
+		steps = append(steps, trace.Wrap(condition.Wrap(stage, step.When, logger)))
+	}
+	return steps, nil
+}
@@ -0,0 +1,64 @@
+package build
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/condition"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	"go.uber.org/zap"
+)
+
+type namedStep struct{ name string }
+
+func (s namedStep) Name() string { return s.name }
+func (s namedStep) Process(_ *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	return input, nil
+}
+
+type namedFactory struct {
+	models.PipelineStepFactory
+	err error
+}
+
+func (f namedFactory) Build(step config.PipelineStepConfig, _ map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	return namedStep{name: step.Type}, f.err
+}
+
+var factories = map[string]models.PipelineStepFactory{
+	"llm":    namedFactory{},
+	"broken": namedFactory{err: errors.New("boom")},
+}
+
+func TestSteps_TracesAndGuardsEachStep(t *testing.T) {
+	steps, err := Steps("fork", []config.PipelineStepConfig{
+		{Type: "llm"},
+		{Type: "llm", When: &config.StepCondition{Tags: []string{"home"}}},
+	}, factories, zap.NewNop())
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	assert.Equal(t, []string{"llm", "llm"}, []string{steps[0].Name(), steps[1].Name()})
+	require.IsType(t, &trace.TracedStep{}, steps[0])
+	assert.IsType(t, namedStep{}, steps[0].(*trace.TracedStep).Step)
+	require.IsType(t, &trace.TracedStep{}, steps[1])
+	assert.IsType(t, &condition.ConditionalStep{}, steps[1].(*trace.TracedStep).Step)
+}
+
+func TestSteps_Errors(t *testing.T) {
+	_, err := Steps("router", []config.PipelineStepConfig{{Type: "llm"}, {}}, factories, zap.NewNop())
+	assert.EqualError(t, err, "step type is empty in router config at index 1")
+
+	_, err = Steps("router", []config.PipelineStepConfig{{Type: "typo"}}, factories, zap.NewNop())
+	assert.EqualError(t, err, "unknown pipeline step type: typo")
+
+	_, err = Steps("router", []config.PipelineStepConfig{{Type: "llm"}, {Type: "broken"}}, factories, zap.NewNop())
+	assert.EqualError(t, err, "failed to build pipeline step: boom")
+	var stepErr *StepError
+	require.ErrorAs(t, err, &stepErr)
+	assert.Equal(t, 1, stepErr.Index)
+	assert.Equal(t, "broken", stepErr.Type)
+}
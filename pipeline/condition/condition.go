@@ -0,0 +1,133 @@
+package condition
+
+import (
+	"path"
+	"regexp"
+	"slices"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+// ConditionalStep wraps a pipeline step and only runs it when the message
+// satisfies the configured `when` clause. Otherwise the message passes through untouched.
+type ConditionalStep struct {
+	Step      models.PipelineStep
+	Condition config.StepCondition
+	Logger    *zap.Logger
+}
+
+// Wrap returns the step unchanged when there is no condition, or a ConditionalStep guarding it.
+func Wrap(step models.PipelineStep, when *config.StepCondition, logger *zap.Logger) models.PipelineStep {
+	if when == nil {
+		return step
+	}
+	return &ConditionalStep{
+		Step:      step,
+		Condition: *when,
+		Logger:    logger.Named("ConditionalStep"),
+	}
+}
+
+func (c ConditionalStep) Name() string {
+	return c.Step.Name()
+}
+
+func (c ConditionalStep) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	if !Matches(&c.Condition, input) {
+		c.Logger.Info("Condition not met, skipping step", zap.String("step", c.Step.Name()))
+		return input, nil
+	}
+	return c.Step.Process(previous, input)
+}
+
+// Matches reports whether the message satisfies every criterion set on the condition.
+// A nil condition always matches.
+func Matches(cond *config.StepCondition, msg *models.PipelineMessage) bool {
+	if cond == nil {
+		return true
+	}
+	if msg == nil {
+		return false
+	}
+	if len(cond.Tags) > 0 || cond.MinTagScore != nil {
+		if !matchesTags(cond, msg) {
+			return false
+		}
+	}
+	if len(cond.Users) > 0 {
+		if msg.Request == nil || !slices.Contains(cond.Users, msg.Request.User) {
+			return false
+		}
+	}
+	if len(cond.Models) > 0 {
+		if msg.Request == nil || !slices.Contains(cond.Models, msg.Request.Model) {
+			return false
+		}
+	}
+	if cond.MinMessageLength != nil || cond.MaxMessageLength != nil {
+		length := len([]rune(LastMessage(msg)))
+		if cond.MinMessageLength != nil && length < *cond.MinMessageLength {
+			return false
+		}
+		if cond.MaxMessageLength != nil && length > *cond.MaxMessageLength {
+			return false
+		}
+	}
+	if cond.Message != nil && len(*cond.Message) > 0 {
+		content := LastMessage(msg)
+		if !slices.ContainsFunc(*cond.Message, func(re *regexp.Regexp) bool {
+			return re.MatchString(content)
+		}) {
+			return false
+		}
+	}
+	if cond.MinTools != nil || cond.MaxTools != nil {
+		count := 0
+		if msg.Tools != nil {
+			count = len(*msg.Tools)
+		}
+		if cond.MinTools != nil && count < *cond.MinTools {
+			return false
+		}
+		if cond.MaxTools != nil && count > *cond.MaxTools {
+			return false
+		}
+	}
+	return true
+}
+
+// LastMessage returns the content of the most recent message in the request, or "" if there is none.
+func LastMessage(msg *models.PipelineMessage) string {
+	if msg == nil || msg.Request == nil || len(msg.Request.Messages) == 0 {
+		return ""
+	}
+	return msg.Request.Messages[len(msg.Request.Messages)-1].Content
+}
+
+// matchesTags checks that at least one detected tag matches one of the patterns
+// (shell-style, so `memory.*` matches `memory.user`) with a score at or above MinTagScore.
+func matchesTags(cond *config.StepCondition, msg *models.PipelineMessage) bool {
+	if msg.Tags == nil {
+		return false
+	}
+	for tag := range *msg.Tags {
+		if len(cond.Tags) > 0 && !slices.ContainsFunc(cond.Tags, func(pattern string) bool {
+			matched, err := path.Match(pattern, tag)
+			return err == nil && matched
+		}) {
+			continue
+		}
+		if cond.MinTagScore != nil {
+			if msg.TagScores == nil {
+				continue
+			}
+			if score, ok := (*msg.TagScores)[tag]; !ok || score < *cond.MinTagScore {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
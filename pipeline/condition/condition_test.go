@@ -0,0 +1,99 @@
+package condition
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+type countingStep struct {
+	calls int
+}
+
+func (s *countingStep) Name() string { return "counting" }
+func (s *countingStep) Process(_ *[]models.PipelineStep, msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+	s.calls++
+	return msg, nil
+}
+
+func intPtr(i int) *int           { return &i }
+func floatPtr(f float64) *float64 { return &f }
+
+func newMessage(content string) *models.PipelineMessage {
+	return &models.PipelineMessage{
+		Request: &models.ChatCompletionRequest{
+			Messages: []models.ChatMessage{{Role: "user", Content: content}},
+			Model:    "mistral",
+			User:     "alice",
+		},
+		Tags:      &map[string]string{"memory.user": "memory.user", "web": "web"},
+		TagScores: &map[string]float64{"memory.user": 0.9, "web": 0.61},
+		Tools:     &[]models.MCPTool{{ToolMetadata: models.ToolMetadata{Name: "tool"}}},
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		cond *config.StepCondition
+		want bool
+	}{
+		{"NilCondition", nil, true},
+		{"EmptyCondition", &config.StepCondition{}, true},
+		{"TagWildcard", &config.StepCondition{Tags: []string{"memory.*"}}, true},
+		{"TagExact", &config.StepCondition{Tags: []string{"web.search"}}, false},
+		{"TagScoreAbove", &config.StepCondition{Tags: []string{"memory.*"}, MinTagScore: floatPtr(0.8)}, true},
+		{"TagScoreBelow", &config.StepCondition{Tags: []string{"web"}, MinTagScore: floatPtr(0.8)}, false},
+		{"AnyTagScore", &config.StepCondition{MinTagScore: floatPtr(0.95)}, false},
+		{"UserMatch", &config.StepCondition{Users: []string{"bob", "alice"}}, true},
+		{"UserMismatch", &config.StepCondition{Users: []string{"bob"}}, false},
+		{"ModelMatch", &config.StepCondition{Models: []string{"mistral"}}, true},
+		{"ModelMismatch", &config.StepCondition{Models: []string{"llama"}}, false},
+		{"MessageLengthInRange", &config.StepCondition{MinMessageLength: intPtr(3), MaxMessageLength: intPtr(10)}, true},
+		{"MessageTooShort", &config.StepCondition{MinMessageLength: intPtr(50)}, false},
+		{"MessageTooLong", &config.StepCondition{MaxMessageLength: intPtr(2)}, false},
+		{"ToolCountInRange", &config.StepCondition{MinTools: intPtr(1), MaxTools: intPtr(1)}, true},
+		{"TooFewTools", &config.StepCondition{MinTools: intPtr(2)}, false},
+		{"MessageRegexMatch", &config.StepCondition{Message: &config.RegexList{regexp.MustCompile(`^hel+o$`)}}, true},
+		{"MessageRegexMismatch", &config.StepCondition{Message: &config.RegexList{regexp.MustCompile(`research`)}}, false},
+		{"AllCriteria", &config.StepCondition{Tags: []string{"web"}, Users: []string{"alice"}, Models: []string{"mistral"}}, true},
+		{"OneCriterionFails", &config.StepCondition{Tags: []string{"web"}, Users: []string{"bob"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Matches(tt.cond, newMessage("hello")))
+		})
+	}
+}
+
+func TestMatches_NilMessage(t *testing.T) {
+	assert.False(t, Matches(&config.StepCondition{}, nil))
+}
+
+func TestWrap_NoCondition(t *testing.T) {
+	step := &countingStep{}
+	assert.Same(t, step, Wrap(step, nil, zap.NewNop()))
+}
+
+func TestConditionalStep_Process(t *testing.T) {
+	step := &countingStep{}
+	wrapped := Wrap(step, &config.StepCondition{Tags: []string{"memory.*"}}, zap.NewNop())
+	assert.Equal(t, "counting", wrapped.Name())
+
+	msg := newMessage("hello")
+	out, err := wrapped.Process(nil, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, out)
+	assert.Equal(t, 1, step.calls)
+
+	msg.Tags = &map[string]string{"home": "home"}
+	out, err = wrapped.Process(nil, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, out)
+	assert.Equal(t, 1, step.calls, "step should be skipped when condition is not met")
+}
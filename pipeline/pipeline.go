@@ -1,18 +1,29 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/teagan42/snidemind/config"
 	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/build"
+	"github.com/teagan42/snidemind/pipeline/trace"
 	"github.com/teagan42/snidemind/server/middleware"
+	"github.com/teagan42/snidemind/telemetry"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
+// MainPipeline is how the top-level `pipeline` is labelled in metrics, next to the names of referenced pipelines.
+const MainPipeline = "main"
+
 type Pipeline struct {
-	Steps  []models.PipelineStep // All Steps in the pipeline
+	Steps  []models.PipelineStep          // All Steps in the pipeline
+	Named  map[string]models.PipelineStep // The named `pipelines`, each built as a `pipeline` step so it can run on its own
+	Models []string                       // The configured model names, the only ones used as metric labels
 	Logger *zap.Logger
 }
 
@@ -23,25 +34,42 @@ type Params struct {
 	StepFactories map[string]models.PipelineStepFactory `name:"pipelineStepFactoryMap"`
 }
 
-func NewPipeline(p Params) *Pipeline {
+func NewPipeline(p Params) (*Pipeline, error) {
+	if err := ValidateConfig(p.Config, p.StepFactories); err != nil {
+		p.Logger.Error("Invalid pipeline config", zap.Error(err))
+		return nil, fmt.Errorf("invalid pipeline config: %w", err)
+	}
 	steps := []models.PipelineStep{}
-	for _, step := range p.Config.Pipeline.Steps {
-		if factory, ok := p.StepFactories[step.Type]; ok {
-			if s, err := factory.Build(step, p.StepFactories); err != nil {
-				p.Logger.Error("Error building pipeline step", zap.Error(err))
-				continue
-			} else {
-				steps = append(steps, s)
+	if p.Config.Pipeline != nil {
+		built, err := build.Steps("pipeline", p.Config.Pipeline.Steps, p.StepFactories, p.Logger)
+		var stepErr *build.StepError
+		if errors.As(err, &stepErr) {
+			return nil, fmt.Errorf("pipeline.steps[%d] (%s): failed to build: %w", stepErr.Index, stepErr.Type, stepErr.Err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pipeline.steps: %w", err)
+		}
+		steps = built
+	}
+	named := map[string]models.PipelineStep{}
+	if factory, ok := p.StepFactories["pipeline"]; ok {
+		for name := range p.Config.Pipelines {
+			ref := config.PipelineStepConfig{Type: "pipeline", Raw: map[string]any{"type": "pipeline", "ref": name}}
+			s, err := factory.Build(ref, p.StepFactories)
+			if err != nil {
+				p.Logger.Error("Error building named pipeline", zap.String("name", name), zap.Error(err))
+				return nil, fmt.Errorf("pipelines.%s: failed to build: %w", name, err)
 			}
-		} else {
-			p.Logger.Error("Unknown pipeline step type", zap.String("type", step.Type))
+			named[name] = trace.Wrap(s)
 		}
 	}
-	p.Logger.Info("Pipeline initialized", zap.Int("steps", len(steps)))
+	p.Logger.Info("Pipeline initialized", zap.Int("steps", len(steps)), zap.Int("named", len(named)))
 	return &Pipeline{
 		Steps:  steps,
+		Named:  named,
+		Models: p.Config.ModelNames(),
 		Logger: p.Logger.Named("Pipeline"),
-	}
+	}, nil
 }
 
 func (p *Pipeline) AddStep(stage models.PipelineStep, index *int) {
@@ -52,39 +80,100 @@ func (p *Pipeline) AddStep(stage models.PipelineStep, index *int) {
 	}
 }
 
-func (p *Pipeline) Process(r *http.Request, w http.ResponseWriter) (models.PipelineMessage, error) {
-	fmt.Printf("Processing pipeline for request: %s %s\n", r.Method, r.URL.String())
-	p.Logger.Info("Processing pipeline for request", zap.String("method", r.Method), zap.String("url", r.URL.String()))
-	body, err := middleware.GetValidatedBody[models.ChatCompletionRequest](r)
-	if err != nil {
-		fmt.Printf("Error getting validated body: %v\n", err)
-		p.Logger.Error("Error getting validated body", zap.Error(err))
-		return *new(models.PipelineMessage), err // Return zero value of OUT and the error
+func newMessage(ctx context.Context, request *models.ChatCompletionRequest, w http.ResponseWriter) *models.PipelineMessage {
+	usage := models.UsageMeterFromContext(ctx)
+	if usage == nil {
+		usage = models.NewUsageMeter()
 	}
-	fmt.Printf("Validated body: %v\n", body)
-	p.Logger.Info("Validated body", zap.Any("body", body))
-	input := &models.PipelineMessage{
-		Request:        &body,
+	message := &models.PipelineMessage{
+		Request:        request,
 		Tags:           &map[string]string{},
+		TagScores:      &map[string]float64{},
 		Tools:          &[]models.MCPTool{},
 		Prompts:        &[]string{},
 		Memories:       &[]string{},
 		Knowledge:      &[]string{},
 		ResponseWriter: w,
+		Usage:          usage,
+		Identity:       models.IdentityFromContext(ctx),
 	}
+	message.SetContext(ctx)
+	return message
+}
+
+func (p *Pipeline) run(input *models.PipelineMessage) (*models.PipelineMessage, error) {
 	var previous *[]models.PipelineStep
 	for _, stage := range p.Steps {
 		fmt.Printf("Processing stage: %s\n", stage.Name())
 		p.Logger.Info("Processing stage", zap.String("stage", stage.Name()))
-		if input, err = stage.Process(previous, input); err != nil {
+		output, err := stage.Process(previous, input)
+		if err != nil {
 			fmt.Printf("Error processing stage: %s, error: %v\n", stage.Name(), err)
 			p.Logger.Error("Error processing stage", zap.String("stage", stage.Name()), zap.Error(err))
-			return *new(models.PipelineMessage), err // Return zero value of OUT and the error
+			return input, err
 		}
+		input = output
 		fmt.Printf("Stage processed successfully: %s\n", stage.Name())
 		p.Logger.Info("Stage processed successfully", zap.String("stage", stage.Name()))
 		previous = &[]models.PipelineStep{stage} // Update previous to the current stage
 	}
+	return input, nil
+}
+
+func (p *Pipeline) Process(r *http.Request, w http.ResponseWriter) (models.PipelineMessage, error) {
+	fmt.Printf("Processing pipeline for request: %s %s\n", r.Method, r.URL.String())
+	p.Logger.Info("Processing pipeline for request", zap.String("method", r.Method), zap.String("url", r.URL.String()))
+	body, err := middleware.GetValidatedBody[models.ChatCompletionRequest](r)
+	if err != nil {
+		fmt.Printf("Error getting validated body: %v\n", err)
+		p.Logger.Error("Error getting validated body", zap.Error(err))
+		return *new(models.PipelineMessage), err // Return zero value of OUT and the error
+	}
+	fmt.Printf("Validated body: %v\n", body)
+	p.Logger.Info("Validated body", zap.Any("body", body))
+	return p.ProcessRequest(r.Context(), body, w)
+}
+
+// ProcessRequest runs a request that didn't arrive as a chat completion, e.g. one translated from the Responses
+// API, through the pipeline. Whatever the steps write goes to w.
+func (p *Pipeline) ProcessRequest(ctx context.Context, request models.ChatCompletionRequest, w http.ResponseWriter) (models.PipelineMessage, error) {
+	start := time.Now()
+	input := newMessage(ctx, &request, w)
+	output, err := p.run(input)
+	telemetry.ObservePipeline(MainPipeline, request.Model, p.Models, err, time.Since(start))
+	telemetry.ObserveUsage(MainPipeline, input.Identity, input.Usage.ByModel())
+	if err != nil {
+		return *new(models.PipelineMessage), err // Return zero value of OUT and the error
+	}
+	return *output, nil // Return the processed output
+}
+
+// ProcessPipeline runs one of the named `pipelines` instead of the main one, e.g. for an MCP tool call. The
+// pipeline step checks the identity may run it and reports its own metrics.
+func (p *Pipeline) ProcessPipeline(ctx context.Context, name string, request models.ChatCompletionRequest, w http.ResponseWriter) (models.PipelineMessage, error) {
+	step, ok := p.Named[name]
+	if !ok {
+		return *new(models.PipelineMessage), models.NewStatusError(http.StatusNotFound, models.ErrorTypeNotFound, fmt.Sprintf("The pipeline %s does not exist.", name))
+	}
+	input := newMessage(ctx, &request, w)
+	output, err := step.Process(nil, input)
+	telemetry.ObserveUsage(name, input.Identity, input.Usage.ByModel())
+	if err != nil {
+		return *new(models.PipelineMessage), err
+	}
+	return *output, nil
+}
 
-	return *input, nil // Return the processed output
+// Trace runs the request through the pipeline and records what every step did to the message.
+// Nothing is sent to the client; whatever the steps write ends up in the trace's output.
+// A failing step doesn't fail the trace, it's recorded on the step that failed.
+func (p *Pipeline) Trace(ctx context.Context, request models.ChatCompletionRequest, options trace.Options) *trace.Trace {
+	p.Logger.Info("Tracing pipeline", zap.String("model", request.Model), zap.Bool("stubLLM", options.StubLLM))
+	writer := trace.NewResponseBuffer()
+	input := newMessage(ctx, &request, writer)
+	t := trace.Start(input, options)
+	start := time.Now()
+	output, err := p.run(input)
+	t.Finish(output, writer, err, time.Since(start))
+	return t
 }
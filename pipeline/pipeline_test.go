@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	"go.uber.org/zap"
+)
+
+type tagStep struct{}
+
+func (tagStep) Name() string { return "tag" }
+func (tagStep) Process(_ *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	(*input.Tags)["home"] = "home"
+	return input, nil
+}
+
+type tagFactory struct{}
+
+func (tagFactory) Name() string { return "tag" }
+func (tagFactory) Build(config.PipelineStepConfig, map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	return tagStep{}, nil
+}
+
+type errorStep struct{}
+
+func (errorStep) Name() string { return "error" }
+func (errorStep) Process(*[]models.PipelineStep, *models.PipelineMessage) (*models.PipelineMessage, error) {
+	return nil, errors.New("boom")
+}
+
+type errorFactory struct{}
+
+func (errorFactory) Name() string { return "error" }
+func (errorFactory) Build(config.PipelineStepConfig, map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	return errorStep{}, nil
+}
+
+func TestPipeline_Trace(t *testing.T) {
+	pipelineConfig := pipelineOf("tag", "error", "tag")
+	p, err := NewPipeline(Params{
+		Config:        &config.Config{Pipeline: &pipelineConfig},
+		StepFactories: map[string]models.PipelineStepFactory{"tag": tagFactory{}, "error": errorFactory{}},
+		Logger:        zap.NewNop(),
+	})
+	require.NoError(t, err)
+
+	result := p.Trace(context.Background(), models.ChatCompletionRequest{Model: "mistral"}, trace.Options{StubLLM: true})
+	require.Len(t, result.Steps, 2, "the trace should stop where the pipeline stopped")
+	assert.Equal(t, "tag", result.Steps[0].Name)
+	assert.Contains(t, result.Steps[0].Changes, "tags")
+	assert.Equal(t, "boom", result.Steps[1].Error)
+	assert.Equal(t, "boom", result.Error)
+}
+
+// refFactory stands in for the `pipeline` step, every named pipeline just tags the message.
+type refFactory struct{}
+
+func (refFactory) Name() string { return "pipeline" }
+func (refFactory) Build(config.PipelineStepConfig, map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	return tagStep{}, nil
+}
+
+func TestPipeline_ProcessPipeline(t *testing.T) {
+	p, err := NewPipeline(Params{
+		Config:        &config.Config{Pipelines: map[string]config.PipelineConfig{"home": pipelineOf("tag")}},
+		StepFactories: map[string]models.PipelineStepFactory{"tag": tagFactory{}, "pipeline": refFactory{}},
+		Logger:        zap.NewNop(),
+	})
+	require.NoError(t, err)
+	require.Contains(t, p.Named, "home")
+
+	output, err := p.ProcessPipeline(context.Background(), "home", models.ChatCompletionRequest{Model: "mistral"}, trace.NewResponseBuffer())
+	require.NoError(t, err)
+	assert.Equal(t, "home", (*output.Tags)["home"])
+
+	_, err = p.ProcessPipeline(context.Background(), "away", models.ChatCompletionRequest{}, trace.NewResponseBuffer())
+	var statusErr *models.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, 404, statusErr.Status)
+}
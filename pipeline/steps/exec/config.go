@@ -0,0 +1,15 @@
+package exec
+
+// ExecConfig is the `exec` section of an exec step.
+type ExecConfig struct {
+	Command string            `json:"command" yaml:"command" validate:"required"`
+	Args    []string          `json:"args,omitempty" yaml:"args,omitempty" validate:"omitempty"`
+	Env     map[string]string `json:"env,omitempty" yaml:"env,omitempty" validate:"omitempty,dive,keys,required"`
+	Dir     string            `json:"dir,omitempty" yaml:"dir,omitempty" validate:"omitempty"`
+	Mode    string            `json:"mode,omitempty" yaml:"mode,omitempty" validate:"omitempty,oneof=oneshot session"`
+	Timeout *int              `json:"timeout,omitempty" yaml:"timeout,omitempty" validate:"omitempty,min=1"`
+}
+
+func (c *ExecConfig) SetDefaults() {
+	c.Mode = ModeOneShot
+}
@@ -0,0 +1,182 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	ModeOneShot = "oneshot"
+	ModeSession = "session"
+)
+
+type Exec struct {
+	ExecConfig
+	Session *Session
+	Logger  *zap.Logger
+}
+
+type Params struct {
+	fx.In
+	Logger    *zap.Logger
+	Lifecycle fx.Lifecycle
+}
+
+type Result struct {
+	fx.Out
+	Factory models.PipelineStepFactory `group:"pipelineStepFactory"`
+}
+
+type ExecFactory struct {
+	Logger   *zap.Logger
+	sessions *[]*Session
+	lock     *sync.Mutex
+}
+
+func (f ExecFactory) Name() string {
+	return "exec"
+}
+
+func (f ExecFactory) ValidateConfig(stepConfig config.PipelineStepConfig) error {
+	_, err := config.DecodeSection[ExecConfig](stepConfig, "exec")
+	return err
+}
+
+func (f ExecFactory) Build(stepConfig config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	execConfig, err := config.DecodeSection[ExecConfig](stepConfig, "exec")
+	if err != nil {
+		return nil, err
+	}
+	step := &Exec{
+		ExecConfig: execConfig,
+		Logger:     f.Logger.Named("Exec").With(zap.String("command", execConfig.Command)),
+	}
+	if step.Mode == ModeSession {
+		step.Session = NewSession(step.command, step.Logger)
+		f.lock.Lock()
+		*f.sessions = append(*f.sessions, step.Session)
+		f.lock.Unlock()
+	}
+	return step, nil
+}
+
+// Close stops every long-lived session started by steps built from this factory.
+func (f ExecFactory) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, session := range *f.sessions {
+		session.Close()
+	}
+	*f.sessions = nil
+}
+
+func NewExec(p Params) (Result, error) {
+	factory := ExecFactory{
+		Logger:   p.Logger.Named("ExecFactory"),
+		sessions: &[]*Session{},
+		lock:     &sync.Mutex{},
+	}
+	p.Lifecycle.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			factory.Close()
+			return nil
+		},
+	})
+	return Result{
+		Factory: factory,
+	}, nil
+}
+
+func (s Exec) Name() string {
+	return "exec"
+}
+
+func (s Exec) command() *osexec.Cmd {
+	cmd := osexec.Command(s.Command, s.Args...)
+	cmd.Dir = s.Dir
+	if len(s.Env) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range s.Env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	return cmd
+}
+
+func (s Exec) context() (context.Context, context.CancelFunc) {
+	if s.Timeout == nil {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), time.Duration(*s.Timeout)*time.Second)
+}
+
+func (s Exec) runOnce(ctx context.Context, message Message) (Message, error) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return Message{}, err
+	}
+	cmd := s.command()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return Message{}, fmt.Errorf("failed to start %s: %w", s.Command, err)
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- cmd.Wait()
+	}()
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-waitErr
+		return Message{}, fmt.Errorf("exec %s: %w", s.Command, ctx.Err())
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("exec %s failed: %w: %s", s.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	if stderr.Len() > 0 {
+		s.Logger.Info("Exec stderr", zap.String("stderr", stderr.String()))
+	}
+	var reply Message
+	if err := json.Unmarshal(stdout.Bytes(), &reply); err != nil {
+		return Message{}, fmt.Errorf("exec %s returned invalid JSON: %w", s.Command, err)
+	}
+	return reply, nil
+}
+
+func (s Exec) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	message, err := encodeMessage(input)
+	if err != nil {
+		s.Logger.Error("Error encoding message", zap.Error(err))
+		return nil, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+
+	var reply Message
+	if s.Mode == ModeSession {
+		reply, err = s.Session.Call(ctx, message)
+	} else {
+		reply, err = s.runOnce(ctx, message)
+	}
+	if err != nil {
+		s.Logger.Error("Error running external step", zap.Error(err))
+		return nil, err
+	}
+	return applyMessage(input, message, reply)
+}
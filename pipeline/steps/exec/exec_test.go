@@ -0,0 +1,155 @@
+package exec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+// The test binary doubles as the external step: when EXEC_STEP_HELPER is set it speaks the protocol instead of running tests.
+func TestMain(m *testing.M) {
+	switch os.Getenv("EXEC_STEP_HELPER") {
+	case "oneshot":
+		var message Message
+		if err := json.NewDecoder(os.Stdin).Decode(&message); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		memories := append(*message.Memories, "from python, probably")
+		json.NewEncoder(os.Stdout).Encode(Message{
+			Memories: &memories,
+			State:    map[string]json.RawMessage{"external.score": json.RawMessage(`0.5`), "test.typed": message.State["test.typed"]},
+		})
+		os.Exit(0)
+	case "session":
+		scanner := bufio.NewScanner(os.Stdin)
+		calls := 0
+		for scanner.Scan() {
+			var request rpcRequest
+			json.Unmarshal(scanner.Bytes(), &request)
+			calls++
+			knowledge := []string{fmt.Sprintf("call %d", calls)}
+			json.NewEncoder(os.Stdout).Encode(rpcResponse{JSONRPC: "2.0", ID: request.ID, Result: &Message{Knowledge: &knowledge}})
+		}
+		os.Exit(0)
+	case "session-error":
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			var request rpcRequest
+			json.Unmarshal(scanner.Bytes(), &request)
+			json.NewEncoder(os.Stdout).Encode(rpcResponse{JSONRPC: "2.0", ID: request.ID, Error: &rpcError{Code: -32000, Message: "nope"}})
+		}
+		os.Exit(0)
+	case "fail":
+		fmt.Fprintln(os.Stderr, "something broke")
+		os.Exit(1)
+	case "sleep":
+		time.Sleep(5 * time.Second)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var typedKey = models.NewStateKey[[]int]("test.typed")
+
+func newFactory() ExecFactory {
+	return ExecFactory{
+		Logger:   zap.NewNop(),
+		sessions: &[]*Session{},
+		lock:     &sync.Mutex{},
+	}
+}
+
+func buildStep(t *testing.T, factory ExecFactory, helper string, mode string, timeout *int) models.PipelineStep {
+	t.Helper()
+	step, err := factory.Build(config.PipelineStepConfig{
+		Type: "exec",
+		Raw: map[string]any{"exec": ExecConfig{
+			Command: os.Args[0],
+			Env:     map[string]string{"EXEC_STEP_HELPER": helper},
+			Mode:    mode,
+			Timeout: timeout,
+		}},
+	}, nil)
+	require.NoError(t, err)
+	return step
+}
+
+func newInput() *models.PipelineMessage {
+	msg := &models.PipelineMessage{
+		Request:  &models.ChatCompletionRequest{Model: "mistral"},
+		Tags:     &map[string]string{"memory": "memory"},
+		Memories: &[]string{"existing"},
+	}
+	models.SetState(msg, typedKey, []int{1, 2})
+	return msg
+}
+
+func TestExecFactory_Build_Errors(t *testing.T) {
+	factory := newFactory()
+	_, err := factory.Build(config.PipelineStepConfig{Type: "exec"}, nil)
+	assert.EqualError(t, err, "exec: section is required")
+	_, err = factory.Build(config.PipelineStepConfig{Type: "exec", Raw: map[string]any{"exec": map[string]any{}}}, nil)
+	assert.EqualError(t, err, `exec.command: failed "required"`)
+	_, err = factory.Build(config.PipelineStepConfig{Type: "exec", Raw: map[string]any{"exec": map[string]any{"command": "x", "mode": "daemon"}}}, nil)
+	assert.EqualError(t, err, `exec.mode: failed "oneof" (oneshot session)`)
+}
+
+func TestExec_Process_OneShot(t *testing.T) {
+	step := buildStep(t, newFactory(), "oneshot", "", nil)
+	out, err := step.Process(nil, newInput())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"existing", "from python, probably"}, *out.Memories)
+	assert.Equal(t, map[string]string{"memory": "memory"}, *out.Tags, "fields left out of the reply are kept")
+	assert.Equal(t, 0.5, out.StateSnapshot()["external.score"])
+	typed, ok := models.GetState(out, typedKey)
+	assert.True(t, ok, "unchanged state keeps its type")
+	assert.Equal(t, []int{1, 2}, typed)
+}
+
+func TestExec_Process_Failure(t *testing.T) {
+	step := buildStep(t, newFactory(), "fail", ModeOneShot, nil)
+	_, err := step.Process(nil, newInput())
+	assert.ErrorContains(t, err, "something broke")
+}
+
+func TestExec_Process_Timeout(t *testing.T) {
+	timeout := 1
+	step := buildStep(t, newFactory(), "sleep", ModeOneShot, &timeout)
+	start := time.Now()
+	_, err := step.Process(nil, newInput())
+	assert.ErrorContains(t, err, "deadline exceeded")
+	assert.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestExec_Process_Session(t *testing.T) {
+	factory := newFactory()
+	step := buildStep(t, factory, "session", ModeSession, nil)
+	defer factory.Close()
+
+	for i := 1; i <= 2; i++ {
+		out, err := step.Process(nil, newInput())
+		require.NoError(t, err)
+		assert.Equal(t, []string{fmt.Sprintf("call %d", i)}, *out.Knowledge, "session should stay alive between calls")
+	}
+	assert.Len(t, *factory.sessions, 1)
+}
+
+func TestExec_Process_SessionError(t *testing.T) {
+	factory := newFactory()
+	step := buildStep(t, factory, "session-error", ModeSession, nil)
+	defer factory.Close()
+
+	_, err := step.Process(nil, newInput())
+	assert.EqualError(t, err, "exec session error -32000: nope")
+}
@@ -0,0 +1,88 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/teagan42/snidemind/models"
+)
+
+// Message is the JSON form of a PipelineMessage exchanged with external steps.
+// Fields the external step leaves out of its reply are kept as they were.
+type Message struct {
+	Request   *models.ChatCompletionRequest  `json:"request,omitempty"`
+	Tags      *map[string]string             `json:"tags,omitempty"`
+	TagScores *map[string]float64            `json:"tag_scores,omitempty"`
+	Tools     *[]models.MCPTool              `json:"tools,omitempty"`
+	Prompts   *[]string                      `json:"prompts,omitempty"`
+	Memories  *[]string                      `json:"memories,omitempty"`
+	Knowledge *[]string                      `json:"knowledge,omitempty"`
+	Response  *models.ChatCompletionResponse `json:"response,omitempty"`
+	State     map[string]json.RawMessage     `json:"state,omitempty"`
+	Identity  *models.Identity               `json:"identity,omitempty"` // Read-only, changes in the reply are ignored
+}
+
+func encodeMessage(input *models.PipelineMessage) (Message, error) {
+	message := Message{
+		Request:   input.Request,
+		Tags:      input.Tags,
+		TagScores: input.TagScores,
+		Tools:     input.Tools,
+		Prompts:   input.Prompts,
+		Memories:  input.Memories,
+		Knowledge: input.Knowledge,
+		Response:  input.Response,
+		Identity:  input.Identity,
+	}
+	if state := input.StateSnapshot(); len(state) > 0 {
+		message.State = make(map[string]json.RawMessage, len(state))
+		for name, value := range state {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return message, err
+			}
+			message.State[name] = raw
+		}
+	}
+	return message, nil
+}
+
+// applyMessage copies the fields returned by the external step onto the input.
+// State entries that come back unchanged are skipped, so typed values set by Go steps keep their types.
+func applyMessage(input *models.PipelineMessage, sent Message, reply Message) (*models.PipelineMessage, error) {
+	if reply.Request != nil {
+		input.Request = reply.Request
+	}
+	if reply.Tags != nil {
+		input.Tags = reply.Tags
+	}
+	if reply.TagScores != nil {
+		input.TagScores = reply.TagScores
+	}
+	if reply.Tools != nil {
+		input.Tools = reply.Tools
+	}
+	if reply.Prompts != nil {
+		input.Prompts = reply.Prompts
+	}
+	if reply.Memories != nil {
+		input.Memories = reply.Memories
+	}
+	if reply.Knowledge != nil {
+		input.Knowledge = reply.Knowledge
+	}
+	if reply.Response != nil {
+		input.Response = reply.Response
+	}
+	for name, raw := range reply.State {
+		if original, ok := sent.State[name]; ok && bytes.Equal(original, raw) {
+			continue
+		}
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, err
+		}
+		input.SetRawState(name, value)
+	}
+	return input, nil
+}
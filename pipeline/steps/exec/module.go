@@ -0,0 +1,10 @@
+package exec
+
+import "go.uber.org/fx"
+
+var Module = fx.Module(
+	"exec",
+	fx.Provide(
+		NewExec,
+	),
+)
@@ -0,0 +1,41 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func TestExecModule_IsNotNil(t *testing.T) {
+	if execModule := fx.Option(Module); execModule == nil {
+		t.Error("exec.Module should not be nil")
+	}
+}
+
+type ExecTestParams struct {
+	fx.In
+	Factory []models.PipelineStepFactory `group:"pipelineStepFactory"`
+}
+
+func TestExecModule_ProvidesExec(t *testing.T) {
+	app := fxtest.New(
+		t,
+		fx.Provide(func() *zap.Logger {
+			return zap.NewNop()
+		}),
+		Module,
+		fx.Invoke(func(p ExecTestParams) {
+			if len(p.Factory) == 0 {
+				t.Error("Expected Exec to be provided, got empty slice")
+			}
+			if p.Factory[0].Name() != "exec" {
+				t.Errorf("Expected factory name to be 'exec', got '%s'", p.Factory[0].Name())
+			}
+		}),
+	)
+	app.RequireStart()
+	app.RequireStop()
+}
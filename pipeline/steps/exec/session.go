@@ -0,0 +1,152 @@
+package exec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	osexec "os/exec"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+type rpcRequest struct {
+	JSONRPC string  `json:"jsonrpc"`
+	ID      int64   `json:"id"`
+	Method  string  `json:"method"`
+	Params  Message `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string    `json:"jsonrpc"`
+	ID      int64     `json:"id"`
+	Result  *Message  `json:"result,omitempty"`
+	Error   *rpcError `json:"error,omitempty"`
+}
+
+// Session is a long-lived external step speaking line-delimited JSON-RPC 2.0 on stdin/stdout.
+// Calls are serialised; the process is (re)started on demand and killed if a call times out.
+type Session struct {
+	newCommand func() *osexec.Cmd
+	logger     *zap.Logger
+	lock       sync.Mutex
+	cmd        *osexec.Cmd
+	stdin      io.WriteCloser
+	stdout     *bufio.Reader
+	nextID     int64
+}
+
+func NewSession(newCommand func() *osexec.Cmd, logger *zap.Logger) *Session {
+	return &Session{
+		newCommand: newCommand,
+		logger:     logger,
+	}
+}
+
+func (s *Session) start() error {
+	cmd := s.newCommand()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", cmd.Path, err)
+	}
+	s.logger.Info("Started exec session", zap.String("command", cmd.Path), zap.Int("pid", cmd.Process.Pid))
+	s.cmd = cmd
+	s.stdin = stdin
+	s.stdout = bufio.NewReader(stdout)
+	return nil
+}
+
+// stop kills the process; the caller must hold the lock.
+func (s *Session) stop() {
+	if s.cmd == nil {
+		return
+	}
+	s.stdin.Close()
+	if s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+	}
+	s.cmd.Wait()
+	s.cmd = nil
+}
+
+func (s *Session) call(message Message) (Message, error) {
+	if s.cmd == nil {
+		if err := s.start(); err != nil {
+			return Message{}, err
+		}
+	}
+	s.nextID++
+	request, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: s.nextID, Method: "process", Params: message})
+	if err != nil {
+		return Message{}, err
+	}
+	if _, err := s.stdin.Write(append(request, '\n')); err != nil {
+		return Message{}, fmt.Errorf("failed to write to exec session: %w", err)
+	}
+	line, err := s.stdout.ReadBytes('\n')
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to read from exec session: %w", err)
+	}
+	var response rpcResponse
+	if err := json.Unmarshal(line, &response); err != nil {
+		return Message{}, fmt.Errorf("invalid JSON-RPC response: %w", err)
+	}
+	if response.ID != s.nextID {
+		return Message{}, fmt.Errorf("JSON-RPC response id %d does not match request id %d", response.ID, s.nextID)
+	}
+	if response.Error != nil {
+		return Message{}, fmt.Errorf("exec session error %d: %s", response.Error.Code, response.Error.Message)
+	}
+	if response.Result == nil {
+		return Message{}, fmt.Errorf("JSON-RPC response has no result")
+	}
+	return *response.Result, nil
+}
+
+func (s *Session) Call(ctx context.Context, message Message) (Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	type callResult struct {
+		message Message
+		err     error
+	}
+	done := make(chan callResult, 1)
+	go func() {
+		reply, err := s.call(message)
+		done <- callResult{reply, err}
+	}()
+	select {
+	case result := <-done:
+		if result.err != nil {
+			// The stream may be out of sync, start over on the next call
+			s.stop()
+		}
+		return result.message, result.err
+	case <-ctx.Done():
+		s.stop()
+		<-done
+		return Message{}, fmt.Errorf("exec session call: %w", ctx.Err())
+	}
+}
+
+func (s *Session) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stop()
+	return nil
+}
@@ -0,0 +1,9 @@
+package extracttags
+
+// EmbedderConfig is the `embedder` section of an extractTags step.
+type EmbedderConfig struct {
+	Model        string  `json:"model,omitempty" yaml:"model,omitempty" validate:"omitempty,required"`
+	APIKey       *string `json:"api_key,omitempty" yaml:"api_key,omitempty" validate:"omitempty,required"`
+	APIKeyHeader *string `json:"api_key_header,omitempty" yaml:"api_key,omitempty" validate:"omitempty,required"`
+	URL          string  `json:"base_url,omitempty" yaml:"base_url,omitempty" validate:"omitempty,url"`
+}
@@ -2,15 +2,20 @@ package extracttags
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"maps"
 	"net/http"
 	"net/url"
 	"slices"
 	"sort"
+	"time"
 
 	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/telemetry"
 	"go.uber.org/zap"
 )
 
@@ -32,7 +37,7 @@ func NewEmbedder(logger *zap.Logger, endpoint, model string, apiKey *string, api
 		APIKey:       apiKey,
 		APIKeyHeader: apiKeyHeader,
 		Cache:        make(map[string]TagNode),
-		Client:       &http.Client{},
+		Client:       telemetry.NewHTTPClient("embedder"),
 	}
 	embedder.Logger.Info("Generating tag embeddings", zap.String("model", model), zap.String("endpoint", endpoint))
 	tags := make([]TagNode, 0, len(TagTree))
@@ -42,7 +47,7 @@ func NewEmbedder(logger *zap.Logger, endpoint, model string, apiKey *string, api
 		batch = append(batch, tag.Description)
 	}
 
-	if vectors, err := embedder.Embed(batch...); err != nil {
+	if vectors, _, err := embedder.Embed(context.Background(), batch...); err != nil {
 		embedder.Logger.Error("Failed to embed tag descriptions", zap.Error(err))
 		return nil
 	} else {
@@ -60,7 +65,15 @@ func NewEmbedder(logger *zap.Logger, endpoint, model string, apiKey *string, api
 	return &embedder
 }
 
-func (e *Embedder) Embed(text ...string) ([][]float64, error) {
+// Embed returns a vector for each text, along with the tokens the embedding model reports spending on them.
+func (e *Embedder) Embed(ctx context.Context, text ...string) ([][]float64, models.Usage, error) {
+	start := time.Now()
+	vectors, usage, err := e.embed(ctx, text...)
+	telemetry.ObserveEmbedding(e.Model, err, time.Since(start))
+	return vectors, usage, err
+}
+
+func (e *Embedder) embed(ctx context.Context, text ...string) ([][]float64, models.Usage, error) {
 	payload, _ := json.Marshal(map[string]interface{}{
 		"input": text,
 		"model": e.Model,
@@ -68,32 +81,34 @@ func (e *Embedder) Embed(text ...string) ([][]float64, error) {
 	url, err := url.JoinPath(e.Endpoint, "embeddings")
 	if err != nil {
 		e.Logger.Error("Failed to join URL path", zap.Error(err))
-		return nil, err
+		return nil, models.Usage{}, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
 	if err != nil {
-		return nil, err
+		return nil, models.Usage{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := e.Client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, models.Usage{}, err
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, errors.New("embedding request failed")
+		data, _ := io.ReadAll(resp.Body)
+		// Whatever the embedder didn't like, it's not the client's fault, so this stays a 500
+		return nil, models.Usage{}, fmt.Errorf("embedding request failed: %s", models.UpstreamError(resp.StatusCode, data).Detail.Message)
 	}
 
 	var result models.EmbeddingResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+		return nil, models.Usage{}, err
 	}
 	if len(result.Data) == 0 {
-		return nil, errors.New("embedding data response is empty")
+		return nil, models.Usage{}, errors.New("embedding data response is empty")
 	}
 
 	vectors := make([][]float64, len(result.Data))
@@ -101,17 +116,20 @@ func (e *Embedder) Embed(text ...string) ([][]float64, error) {
 		vectors[i] = data.Embedding
 	}
 
-	return vectors, nil
+	usage := models.Usage{}
+	if result.Usage != nil {
+		usage = *result.Usage
+	}
+	return vectors, usage, nil
 }
 
-func (e *Embedder) ExtractTagsWithWeights(userInput string) ([]ScoredTag, error) {
+func (e *Embedder) ExtractTagsWithWeights(ctx context.Context, userInput string) ([]ScoredTag, models.Usage, error) {
 	e.Logger.Info("Extracting tags with weights", zap.String("input", userInput))
-	var inputVec []float64
-	if vec, err := e.Embed(userInput); err != nil {
-		return nil, err
-	} else {
-		inputVec = vec[0]
+	vec, usage, err := e.Embed(ctx, userInput)
+	if err != nil {
+		return nil, usage, err
 	}
+	inputVec := vec[0]
 
 	tagScores := map[string]ScoredTag{}
 	// Embed all tag descriptions
@@ -146,5 +164,5 @@ func (e *Embedder) ExtractTagsWithWeights(userInput string) ([]ScoredTag, error)
 		return values[i].Score > values[j].Score
 	})
 
-	return values, nil
+	return values, usage, nil
 }
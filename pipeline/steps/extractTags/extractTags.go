@@ -31,11 +31,16 @@ type ExtractTagsFactory struct {
 func (f ExtractTagsFactory) Name() string {
 	return "extractTags"
 }
-func (f ExtractTagsFactory) Build(config config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
-	if config.Embedder == nil {
-		return nil, fmt.Errorf("extractTags config is nil")
+func (f ExtractTagsFactory) ValidateConfig(stepConfig config.PipelineStepConfig) error {
+	_, err := config.DecodeSection[EmbedderConfig](stepConfig, "embedder")
+	return err
+}
+
+func (f ExtractTagsFactory) Build(pipelineStepConfig config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	stepConfig, err := config.DecodeSection[EmbedderConfig](pipelineStepConfig, "embedder")
+	if err != nil {
+		return nil, err
 	}
-	stepConfig := *config.Embedder
 	key := stepConfig.URL + stepConfig.Model
 	if _, ok := f.Embedders[key]; !ok {
 		f.Embedders[key] = NewEmbedder(
@@ -74,15 +79,21 @@ func (s ExtractTags) Process(previous *[]models.PipelineStep, input *models.Pipe
 	}
 	msg := input.Request.Messages[len(input.Request.Messages)-1].Content
 
-	if tags, error := s.Embedder.ExtractTagsWithWeights(msg); error != nil {
-		return nil, fmt.Errorf("failed to extract tags: %w", error)
+	tags, usage, err := s.Embedder.ExtractTagsWithWeights(input.Context(), msg)
+	input.AddUsage(s.Embedder.Model, usage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract tags: %w", err)
 	} else {
 		s.Embedder.Logger.Info("Extracted tags", zap.String("tags", fmt.Sprintf("%v", tags)))
 		if input.Tags == nil {
 			input.Tags = &map[string]string{}
 		}
+		if input.TagScores == nil {
+			input.TagScores = &map[string]float64{}
+		}
 		for _, tag := range tags {
 			(*input.Tags)[tag.Tag.ID] = tag.Tag.ID
+			(*input.TagScores)[tag.Tag.ID] = tag.Score
 		}
 	}
 	return input, nil
@@ -0,0 +1,19 @@
+package fork
+
+type ForkMergeConfig struct {
+	Tags     string `json:"tags,omitempty" yaml:"tags,omitempty" validate:"omitempty,oneof=union first_wins last_wins score_max"`
+	Tools    string `json:"tools,omitempty" yaml:"tools,omitempty" validate:"omitempty,oneof=union first_wins last_wins"`
+	Memories string `json:"memories,omitempty" yaml:"memories,omitempty" validate:"omitempty,oneof=union first_wins last_wins"`
+}
+
+// ForkOptionsConfig is the optional `fork_options` section of a fork step; the branches themselves are the `fork` section.
+type ForkOptionsConfig struct {
+	ErrorPolicy string           `json:"error_policy,omitempty" yaml:"error_policy,omitempty" validate:"omitempty,oneof=ignore fail_fast require_n"`
+	Require     *int             `json:"require,omitempty" yaml:"require,omitempty" validate:"required_if=ErrorPolicy require_n,omitempty,min=1"`
+	Timeout     *int             `json:"timeout,omitempty" yaml:"timeout,omitempty" validate:"omitempty,min=1"`
+	Merge       *ForkMergeConfig `json:"merge,omitempty" yaml:"merge,omitempty" validate:"omitempty"`
+}
+
+func (o *ForkOptionsConfig) SetDefaults() {
+	o.ErrorPolicy = ErrorPolicyIgnore
+}
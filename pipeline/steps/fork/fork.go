@@ -1,10 +1,15 @@
 package fork
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/teagan42/snidemind/config"
 	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/build"
+	"github.com/teagan42/snidemind/pipeline/trace"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
@@ -13,9 +18,25 @@ type ForkedPipelineStages struct {
 	Steps []models.PipelineStep
 }
 
+const (
+	ErrorPolicyIgnore   = "ignore"
+	ErrorPolicyFailFast = "fail_fast"
+	ErrorPolicyRequireN = "require_n"
+)
+
 type ForkPipelineStage struct {
-	Forks  []ForkedPipelineStages
-	Logger *zap.Logger
+	Forks       []ForkedPipelineStages
+	ErrorPolicy string
+	Require     int
+	Timeout     time.Duration
+	Merge       ForkMergeConfig
+	Logger      *zap.Logger
+}
+
+type branchResult struct {
+	index   int
+	message *models.PipelineMessage
+	err     error
 }
 
 type Params struct {
@@ -37,44 +58,77 @@ func (f ForkPipelineStageFactory) Name() string {
 	return "fork"
 }
 
-func (f ForkPipelineStageFactory) Build(config config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
-	f.Logger.Info("Building Fork Stage", zap.Any("config", config))
-	if config.Fork == nil {
-		return nil, fmt.Errorf("fork config is nil")
+// decode reads the `fork` branches and the optional `fork_options` section.
+func (f ForkPipelineStageFactory) decode(stepConfig config.PipelineStepConfig) ([]config.PipelineConfig, ForkOptionsConfig, error) {
+	options := ForkOptionsConfig{}
+	options.SetDefaults()
+	branches, err := config.DecodeSection[[]config.PipelineConfig](stepConfig, "fork")
+	if err != nil {
+		return nil, options, err
+	}
+	if len(branches) == 0 {
+		return nil, options, fmt.Errorf("fork config is empty")
+	}
+	if stepConfig.Has("fork_options") {
+		if options, err = config.DecodeSection[ForkOptionsConfig](stepConfig, "fork_options"); err != nil {
+			return nil, options, err
+		}
+	}
+	if options.ErrorPolicy == ErrorPolicyRequireN && *options.Require > len(branches) {
+		return nil, options, fmt.Errorf("fork_options.require: fork requires %d successful branches but only has %d", *options.Require, len(branches))
+	}
+	return branches, options, nil
+}
+
+func (f ForkPipelineStageFactory) ValidateConfig(stepConfig config.PipelineStepConfig) error {
+	_, _, err := f.decode(stepConfig)
+	return err
+}
+
+func (f ForkPipelineStageFactory) NestedPipelines(stepConfig config.PipelineStepConfig) []models.NestedPipeline {
+	branches, _, err := f.decode(stepConfig)
+	if err != nil {
+		return nil
+	}
+	nested := make([]models.NestedPipeline, 0, len(branches))
+	for i, forkConfig := range branches {
+		nested = append(nested, models.NestedPipeline{Path: fmt.Sprintf("fork[%d]", i), Config: forkConfig})
 	}
-	if len(*config.Fork) == 0 {
-		return nil, fmt.Errorf("fork config is empty")
+	return nested
+}
+
+func (f ForkPipelineStageFactory) Build(stepConfig config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	f.Logger.Info("Building Fork Stage", zap.Any("config", stepConfig))
+	branches, options, err := f.decode(stepConfig)
+	if err != nil {
+		return nil, err
 	}
 	var forkedStages = []ForkedPipelineStages{}
-	for _, forkConfig := range *config.Fork {
+	for _, forkConfig := range branches {
 		f.Logger.Info("Processing Fork Config", zap.Any("forkConfig", forkConfig))
-		var steps = []models.PipelineStep{}
-		for j, step := range forkConfig.Steps {
-			f.Logger.Info("Processing Step", zap.Int("index", j), zap.Any("step", step))
-			if step.Type == "" {
-				f.Logger.Error("Step type is empty in fork config", zap.Int("index", j))
-				return nil, fmt.Errorf("step type is empty in fork config at index %d", j)
-			}
-			factory, ok := stepFactories[step.Type]
-			if !ok {
-				f.Logger.Error("Unknown pipeline step type", zap.String("type", step.Type))
-				return nil, fmt.Errorf("unknown pipeline step type: %s", step.Type)
-			}
-			stage, err := factory.Build(step, stepFactories)
-			if err != nil {
-				f.Logger.Error("Error building pipeline step", zap.String("type", step.Type), zap.Error(err))
-				return nil, fmt.Errorf("failed to build pipeline step: %w", err)
-			}
-			steps = append(steps, stage)
+		steps, err := build.Steps("fork", forkConfig.Steps, stepFactories, f.Logger)
+		if err != nil {
+			return nil, err
 		}
 		f.Logger.Info("Forked Steps", zap.Int("count", len(steps)), zap.Any("steps", steps))
 		forkedStages = append(forkedStages, ForkedPipelineStages{Steps: steps})
 	}
-	f.Logger.Info("Fork Stage Built", zap.Int("forks", len(forkedStages)), zap.Any("forkedStages", forkedStages))
-	return &ForkPipelineStage{
-		Forks:  forkedStages,
-		Logger: f.Logger.Named("ForkPipelineStage"),
-	}, nil
+	stage := &ForkPipelineStage{
+		Forks:       forkedStages,
+		ErrorPolicy: options.ErrorPolicy,
+		Logger:      f.Logger.Named("ForkPipelineStage"),
+	}
+	if stage.ErrorPolicy == ErrorPolicyRequireN {
+		stage.Require = *options.Require
+	}
+	if options.Timeout != nil {
+		stage.Timeout = time.Duration(*options.Timeout) * time.Second
+	}
+	if options.Merge != nil {
+		stage.Merge = *options.Merge
+	}
+	f.Logger.Info("Fork Stage Built", zap.Int("forks", len(forkedStages)), zap.String("errorPolicy", stage.ErrorPolicy), zap.Duration("timeout", stage.Timeout))
+	return stage, nil
 }
 
 func NewFork(p Params) (Result, error) {
@@ -89,38 +143,104 @@ func (f ForkPipelineStage) Name() string {
 	return "fork"
 }
 
-func (f ForkPipelineStage) processFork(previous *[]models.PipelineStep, steps []models.PipelineStep, input *models.PipelineMessage, resultsChannel chan *models.PipelineMessage) {
-	f.Logger.Info("Processing Forked Steps", zap.Any("steps", steps))
+func (f ForkPipelineStage) runBranch(index int, previous *[]models.PipelineStep, steps []models.PipelineStep, input *models.PipelineMessage) branchResult {
+	f.Logger.Info("Processing Forked Steps", zap.Int("branch", index), zap.Any("steps", steps))
 	for _, step := range steps {
+		if err := input.Context().Err(); err != nil {
+			return branchResult{index: index, err: err} // Cancelled, nobody is waiting for the result
+		}
 		var err error
 		input, err = step.Process(previous, input)
 		if err != nil {
-			fmt.Printf("Error processing step: %v\n", err)
-			resultsChannel <- nil
-			return
+			return branchResult{index: index, err: fmt.Errorf("fork branch %d step %s: %w", index, step.Name(), err)}
 		}
 		previous = &[]models.PipelineStep{step}
 	}
-	resultsChannel <- input
+	return branchResult{index: index, message: input}
 }
 
+// processFork runs the branch until it's done or its context is, whichever comes first. A branch that times
+// out or is cancelled stops at its next step, the upstream call it's making is cancelled with it.
+func (f ForkPipelineStage) processFork(index int, previous *[]models.PipelineStep, steps []models.PipelineStep, input *models.PipelineMessage, resultsChannel chan<- branchResult) {
+	ctx := input.Context()
+	done := make(chan branchResult, 1)
+	go func() {
+		done <- f.runBranch(index, previous, steps, input)
+	}()
+	select {
+	case result := <-done:
+		resultsChannel <- result
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			resultsChannel <- branchResult{index: index, err: fmt.Errorf("fork branch %d timed out after %s", index, f.Timeout)}
+		} else {
+			resultsChannel <- branchResult{index: index, err: fmt.Errorf("fork branch %d: %w", index, ctx.Err())}
+		}
+	}
+}
+
+// Process runs the branches concurrently, each on a clone of the input with a context of its own and a buffer
+// for what it writes to the client. Once the outcome is known the branches still running are cancelled, and
+// only the buffer of the branch whose response is kept reaches the client.
 func (f ForkPipelineStage) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
 	f.Logger.Info("Processing Fork Stage")
-	var resultsChannel = make(chan *models.PipelineMessage, len(f.Forks))
-	defer close(resultsChannel)
-	for _, fork := range f.Forks {
-		go f.processFork(previous, fork.Steps, input, resultsChannel)
+	ctx, cancel := context.WithCancel(input.Context())
+	defer cancel()
+	// Buffered so branches still running after an early return never block
+	var resultsChannel = make(chan branchResult, len(f.Forks))
+	finishBranch := make([]func(error), len(f.Forks))
+	written := make([]*trace.ResponseBuffer, len(f.Forks))
+	state := input.StateSnapshot()
+	for i, fork := range f.Forks {
+		branchInput := input.Clone()
+		written[i] = trace.NewResponseBuffer()
+		branchInput.ResponseWriter = written[i]
+		branchCtx := ctx
+		if f.Timeout > 0 {
+			var cancelBranch context.CancelFunc
+			branchCtx, cancelBranch = context.WithTimeout(ctx, f.Timeout)
+			defer cancelBranch()
+		}
+		branchInput.SetContext(branchCtx)
+		finishBranch[i] = trace.StartBranch(branchInput, i)
+		go f.processFork(i, previous, fork.Steps, branchInput, resultsChannel)
 	}
 
-	resultsDone := 0
-	for result := range resultsChannel {
-		resultsDone++
-		if result != nil {
-			input.Combine(result)
+	branches := make([]*models.PipelineMessage, len(f.Forks))
+	failed := 0
+	for range f.Forks {
+		result := <-resultsChannel
+		finishBranch[result.index](result.err)
+		if result.err == nil {
+			branches[result.index] = result.message
+			continue
 		}
-		if resultsDone == len(f.Forks) {
+		failed++
+		f.Logger.Error("Fork branch failed", zap.Int("branch", result.index), zap.Error(result.err))
+		switch f.ErrorPolicy {
+		case ErrorPolicyFailFast:
+			return nil, result.err
+		case ErrorPolicyRequireN:
+			if len(f.Forks)-failed < f.Require {
+				return nil, fmt.Errorf("fork requires %d successful branches, %d of %d failed: %w", f.Require, failed, len(f.Forks), result.err)
+			}
+		}
+	}
+
+	succeeded := []*models.PipelineMessage{}
+	for _, branch := range branches {
+		if branch != nil {
+			succeeded = append(succeeded, branch)
+		}
+	}
+	output := mergeBranches(input, state, succeeded, f.Merge)
+	for i, branch := range branches {
+		if branch != nil && branch.Response != nil && branch.Response == output.Response && input.ResponseWriter != nil {
+			if err := written[i].Replay(input.ResponseWriter); err != nil {
+				return nil, err
+			}
 			break
 		}
 	}
-	return input, nil
+	return output, nil
 }
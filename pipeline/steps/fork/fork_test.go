@@ -3,7 +3,10 @@ package fork
 
 import (
 	"errors"
+	"net/http/httptest"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/teagan42/snidemind/config"
 	"github.com/teagan42/snidemind/models"
@@ -46,7 +49,8 @@ func TestForkPipelineStageFactory_Build_Success(t *testing.T) {
 		},
 	}
 	cfg := config.PipelineStepConfig{
-		Fork: &forkConfig,
+		Type: "fork",
+		Raw:  map[string]any{"fork": forkConfig},
 	}
 
 	stepFactories := map[string]models.PipelineStepFactory{
@@ -75,9 +79,9 @@ func TestForkPipelineStageFactory_Build_Success(t *testing.T) {
 func TestForkPipelineStageFactory_Build_NilForkConfig(t *testing.T) {
 	logger := zaptest.NewLogger(t)
 	factory := ForkPipelineStageFactory{Logger: logger}
-	cfg := config.PipelineStepConfig{Fork: nil}
+	cfg := config.PipelineStepConfig{Type: "fork"}
 	_, err := factory.Build(cfg, nil)
-	if err == nil || err.Error() != "fork config is nil" {
+	if err == nil || err.Error() != "fork: section is required" {
 		t.Errorf("expected error 'fork config is nil', got %v", err)
 	}
 }
@@ -86,7 +90,7 @@ func TestForkPipelineStageFactory_Build_EmptyForkConfig(t *testing.T) {
 	logger := zaptest.NewLogger(t)
 	factory := ForkPipelineStageFactory{Logger: logger}
 	empty := []config.PipelineConfig{}
-	cfg := config.PipelineStepConfig{Fork: &empty}
+	cfg := config.PipelineStepConfig{Type: "fork", Raw: map[string]any{"fork": empty}}
 	_, err := factory.Build(cfg, nil)
 	if err == nil || err.Error() != "fork config is empty" {
 		t.Errorf("expected error 'fork config is empty', got %v", err)
@@ -102,7 +106,7 @@ func TestForkPipelineStageFactory_Build_EmptyStepType(t *testing.T) {
 			Steps: []config.PipelineStepConfig{stepConfig},
 		},
 	}
-	cfg := config.PipelineStepConfig{Fork: &forkConfig}
+	cfg := config.PipelineStepConfig{Type: "fork", Raw: map[string]any{"fork": forkConfig}}
 	stepFactories := map[string]models.PipelineStepFactory{}
 	_, err := factory.Build(cfg, stepFactories)
 	if err == nil || err.Error() != "step type is empty in fork config at index 0" {
@@ -119,7 +123,7 @@ func TestForkPipelineStageFactory_Build_UnknownStepType(t *testing.T) {
 			Steps: []config.PipelineStepConfig{stepConfig},
 		},
 	}
-	cfg := config.PipelineStepConfig{Fork: &forkConfig}
+	cfg := config.PipelineStepConfig{Type: "fork", Raw: map[string]any{"fork": forkConfig}}
 	stepFactories := map[string]models.PipelineStepFactory{}
 	_, err := factory.Build(cfg, stepFactories)
 	if err == nil || err.Error() != "unknown pipeline step type: unknown" {
@@ -137,7 +141,7 @@ func TestForkPipelineStageFactory_Build_FactoryBuildError(t *testing.T) {
 			Steps: []config.PipelineStepConfig{stepConfig},
 		},
 	}
-	cfg := config.PipelineStepConfig{Fork: &forkConfig}
+	cfg := config.PipelineStepConfig{Type: "fork", Raw: map[string]any{"fork": forkConfig}}
 	stepFactories := map[string]models.PipelineStepFactory{
 		stepType: dummyFactory{stepType: stepType, buildErr: errors.New("fail")},
 	}
@@ -146,3 +150,283 @@ func TestForkPipelineStageFactory_Build_FactoryBuildError(t *testing.T) {
 		t.Errorf("expected error for failed build, got %v", err)
 	}
 }
+
+type funcStep struct {
+	name string
+	fn   func(msg *models.PipelineMessage) (*models.PipelineMessage, error)
+}
+
+func (s *funcStep) Name() string { return s.name }
+func (s *funcStep) Process(_ *[]models.PipelineStep, msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+	return s.fn(msg)
+}
+
+func addTool(name string) *funcStep {
+	return &funcStep{name: name, fn: func(msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+		*msg.Tools = append(*msg.Tools, models.MCPTool{ToolMetadata: models.ToolMetadata{Name: name}})
+		(*msg.Tags)[name] = name
+		return msg, nil
+	}}
+}
+
+func failing(err error) *funcStep {
+	return &funcStep{name: "failing", fn: func(msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+		return nil, err
+	}}
+}
+
+func newStage(t *testing.T, policy string, require int, branches ...models.PipelineStep) *ForkPipelineStage {
+	forks := []ForkedPipelineStages{}
+	for _, step := range branches {
+		forks = append(forks, ForkedPipelineStages{Steps: []models.PipelineStep{step}})
+	}
+	return &ForkPipelineStage{
+		Forks:       forks,
+		ErrorPolicy: policy,
+		Require:     require,
+		Logger:      zaptest.NewLogger(t),
+	}
+}
+
+func newInput() *models.PipelineMessage {
+	return &models.PipelineMessage{
+		Tags:  &map[string]string{},
+		Tools: &[]models.MCPTool{{ToolMetadata: models.ToolMetadata{Name: "shared"}}},
+	}
+}
+
+func TestForkPipelineStage_Process_IsolatesBranchesAndDedupes(t *testing.T) {
+	stage := newStage(t, ErrorPolicyIgnore, 0, addTool("a"), addTool("b"), addTool("c"))
+	out, err := stage.Process(nil, newInput())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	names := []string{}
+	for _, tool := range *out.Tools {
+		names = append(names, tool.ToolMetadata.Name)
+	}
+	want := []string{"shared", "a", "b", "c"}
+	if len(names) != len(want) {
+		t.Fatalf("expected tools %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("expected tools %v in branch order, got %v", want, names)
+		}
+	}
+	if len(*out.Tags) != 3 {
+		t.Errorf("expected 3 tags, got %v", *out.Tags)
+	}
+}
+
+func TestForkPipelineStage_Process_IgnoreErrors(t *testing.T) {
+	stage := newStage(t, ErrorPolicyIgnore, 0, addTool("a"), failing(errors.New("boom")))
+	out, err := stage.Process(nil, newInput())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(*out.Tools) != 2 {
+		t.Errorf("expected tools from successful branch, got %v", *out.Tools)
+	}
+}
+
+func TestForkPipelineStage_Process_FailFast(t *testing.T) {
+	stage := newStage(t, ErrorPolicyFailFast, 0, addTool("a"), failing(errors.New("boom")))
+	_, err := stage.Process(nil, newInput())
+	if err == nil || err.Error() != "fork branch 1 step failing: boom" {
+		t.Errorf("expected fail fast error, got %v", err)
+	}
+}
+
+func TestForkPipelineStage_Process_RequireN(t *testing.T) {
+	stage := newStage(t, ErrorPolicyRequireN, 2, addTool("a"), addTool("b"), failing(errors.New("boom")))
+	if _, err := stage.Process(nil, newInput()); err != nil {
+		t.Errorf("expected 2 of 3 branches to be enough, got %v", err)
+	}
+
+	stage = newStage(t, ErrorPolicyRequireN, 2, addTool("a"), failing(errors.New("boom")), failing(errors.New("bang")))
+	if _, err := stage.Process(nil, newInput()); err == nil {
+		t.Error("expected error when fewer than 2 branches succeed")
+	}
+}
+
+func TestForkPipelineStage_Process_Timeout(t *testing.T) {
+	slow := &funcStep{name: "slow", fn: func(msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+		time.Sleep(200 * time.Millisecond)
+		return msg, nil
+	}}
+	stage := newStage(t, ErrorPolicyFailFast, 0, addTool("a"), slow)
+	stage.Timeout = 20 * time.Millisecond
+	_, err := stage.Process(nil, newInput())
+	if err == nil || err.Error() != "fork branch 1 timed out after 20ms" {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+// waiting is a step that blocks until its context is done, closing started when it runs and stopped when it
+// gives up.
+func waiting(started, stopped chan struct{}) *funcStep {
+	return &funcStep{name: "waiting", fn: func(msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+		close(started)
+		select {
+		case <-msg.Context().Done():
+			close(stopped)
+			return nil, msg.Context().Err()
+		case <-time.After(5 * time.Second):
+			return msg, nil
+		}
+	}}
+}
+
+func TestForkPipelineStage_Process_TimeoutStopsTheBranch(t *testing.T) {
+	stopped, ranOn := make(chan struct{}), make(chan struct{}, 1)
+	after := &funcStep{name: "after", fn: func(msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+		ranOn <- struct{}{}
+		return msg, nil
+	}}
+	stage := newStage(t, ErrorPolicyIgnore, 0, addTool("a"))
+	stage.Forks = append(stage.Forks, ForkedPipelineStages{Steps: []models.PipelineStep{waiting(make(chan struct{}), stopped), after}})
+	stage.Timeout = 20 * time.Millisecond
+	if _, err := stage.Process(nil, newInput()); err != nil {
+		t.Fatalf("expected the timed out branch to be ignored, got %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the timed out branch's context to be cancelled")
+	}
+	select {
+	case <-ranOn:
+		t.Error("expected the timed out branch not to run its next step")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestForkPipelineStage_Process_FailFastCancelsTheOtherBranches(t *testing.T) {
+	started, stopped := make(chan struct{}), make(chan struct{})
+	failsOnceStarted := &funcStep{name: "failing", fn: func(msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+		<-started
+		return nil, errors.New("boom")
+	}}
+	stage := newStage(t, ErrorPolicyFailFast, 0, waiting(started, stopped), failsOnceStarted)
+	if _, err := stage.Process(nil, newInput()); err == nil {
+		t.Fatal("expected fail fast error")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the branch still running to be cancelled")
+	}
+}
+
+func TestForkPipelineStage_Process_OnlyTheKeptResponseIsWritten(t *testing.T) {
+	answer := func(text string, delay time.Duration) *funcStep {
+		return &funcStep{name: "answer", fn: func(msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+			time.Sleep(delay)
+			msg.ResponseWriter.Header().Set("Content-Type", "text/plain")
+			msg.ResponseWriter.Write([]byte(text))
+			msg.Response = &models.ChatCompletionResponse{ID: text}
+			return msg, nil
+		}}
+	}
+	// The second branch finishes first, but the first branch's answer is the one kept
+	stage := newStage(t, ErrorPolicyIgnore, 0, answer("first", 20*time.Millisecond), answer("second", 0))
+	input := newInput()
+	recorder := httptest.NewRecorder()
+	input.ResponseWriter = recorder
+	out, err := stage.Process(nil, input)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if out.Response == nil || out.Response.ID != "first" {
+		t.Fatalf("expected the first branch's response, got %v", out.Response)
+	}
+	if recorder.Body.String() != "first" || recorder.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("expected only the first branch's output, got %q", recorder.Body.String())
+	}
+}
+
+// testTrail appends on merge, so the parent's entries must not be merged in once per branch
+type testTrail []string
+
+func (t testTrail) CloneState() any { return slices.Clone(t) }
+func (t testTrail) DiffState(since any) any {
+	return slices.Clone(t[len(since.(testTrail)):])
+}
+
+var testTrailKey = models.NewMergeableStateKey("fork.test.trail", func(current testTrail, incoming testTrail) testTrail {
+	return append(slices.Clone(current), incoming...)
+})
+
+func TestForkPipelineStage_Process_MergesOnlyWhatEachBranchAdded(t *testing.T) {
+	visit := func(name string) *funcStep {
+		return &funcStep{name: name, fn: func(msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+			trail, _ := models.GetState(msg, testTrailKey)
+			models.SetState(msg, testTrailKey, append(trail, name))
+			return msg, nil
+		}}
+	}
+	unchanged := &funcStep{name: "unchanged", fn: func(msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+		return msg, nil
+	}}
+	stage := newStage(t, ErrorPolicyIgnore, 0, visit("b"), unchanged, visit("c"))
+	input := newInput()
+	models.SetState(input, testTrailKey, testTrail{"a"})
+	out, err := stage.Process(nil, input)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if trail, _ := models.GetState(out, testTrailKey); !slices.Equal(trail, testTrail{"a", "b", "c"}) {
+		t.Errorf("expected the parent's entry once followed by each branch's, got %v", trail)
+	}
+}
+
+func TestForkPipelineStageFactory_Build_Options(t *testing.T) {
+	factory := ForkPipelineStageFactory{Logger: zaptest.NewLogger(t)}
+	forkConfig := []config.PipelineConfig{
+		{Steps: []config.PipelineStepConfig{{Type: "dummy"}}},
+	}
+	require := 2
+	timeout := 3
+	cfg := config.PipelineStepConfig{
+		Type: "fork",
+		Raw: map[string]any{
+			"fork": forkConfig,
+			"fork_options": &ForkOptionsConfig{
+				ErrorPolicy: ErrorPolicyRequireN,
+				Require:     &require,
+				Timeout:     &timeout,
+			},
+		},
+	}
+	stepFactories := map[string]models.PipelineStepFactory{"dummy": dummyFactory{stepType: "dummy"}}
+	_, err := factory.Build(cfg, stepFactories)
+	if err == nil || err.Error() != "fork_options.require: fork requires 2 successful branches but only has 1" {
+		t.Errorf("expected require_n validation error, got %v", err)
+	}
+
+	require = 1
+	stage, err := factory.Build(cfg, stepFactories)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	forkStage := stage.(*ForkPipelineStage)
+	if forkStage.Timeout != 3*time.Second || forkStage.Require != 1 || forkStage.ErrorPolicy != ErrorPolicyRequireN {
+		t.Errorf("fork options not applied: %+v", forkStage)
+	}
+}
+
+func TestForkPipelineStageFactory_ValidateConfig_ReportsField(t *testing.T) {
+	factory := ForkPipelineStageFactory{Logger: zaptest.NewLogger(t)}
+	cfg := config.PipelineStepConfig{
+		Type: "fork",
+		Raw: map[string]any{
+			"fork":         []config.PipelineConfig{{Steps: []config.PipelineStepConfig{{Type: "dummy"}}}},
+			"fork_options": map[string]any{"error_policy": "shrug"},
+		},
+	}
+	err := factory.ValidateConfig(cfg)
+	if err == nil || err.Error() != `fork_options.error_policy: failed "oneof" (ignore fail_fast require_n)` {
+		t.Errorf("expected error naming the field, got %v", err)
+	}
+}
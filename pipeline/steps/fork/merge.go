@@ -0,0 +1,113 @@
+package fork
+
+import (
+	"slices"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/utils"
+)
+
+const (
+	MergeUnion     = "union"
+	MergeFirstWins = "first_wins"
+	MergeLastWins  = "last_wins"
+	MergeScoreMax  = "score_max"
+)
+
+// mergeBranches folds the results of the successful branches (in config order) back into the input message.
+// state is the input's state as it was when the branches were cloned from it.
+func mergeBranches(input *models.PipelineMessage, state map[string]any, branches []*models.PipelineMessage, strategy ForkMergeConfig) *models.PipelineMessage {
+	if len(branches) == 0 {
+		return input
+	}
+	input.Tags, input.TagScores = mergeTags(branches, strategy.Tags)
+	input.Tools = mergeList(branches, func(m *models.PipelineMessage) *[]models.MCPTool { return m.Tools }, strategy.Tools, func(tool models.MCPTool) string {
+		return tool.ToolMetadata.Name
+	})
+	input.Memories = mergeList(branches, func(m *models.PipelineMessage) *[]string { return m.Memories }, strategy.Memories, identity)
+	input.Prompts = mergeList(branches, func(m *models.PipelineMessage) *[]string { return m.Prompts }, MergeUnion, identity)
+	input.Knowledge = mergeList(branches, func(m *models.PipelineMessage) *[]string { return m.Knowledge }, MergeUnion, identity)
+	input.MergeBranchState(state, branches...)
+	if input.Response == nil {
+		for _, branch := range branches {
+			if branch.Response != nil {
+				input.Response = branch.Response
+				break
+			}
+		}
+	}
+	return input
+}
+
+func identity(s string) string { return s }
+
+// pickBranch returns the first (or last) branch for which the field is non-empty.
+func pickBranch(branches []*models.PipelineMessage, strategy string, nonEmpty func(*models.PipelineMessage) bool) *models.PipelineMessage {
+	ordered := branches
+	if strategy == MergeLastWins {
+		ordered = slices.Clone(branches)
+		slices.Reverse(ordered)
+	}
+	for _, branch := range ordered {
+		if nonEmpty(branch) {
+			return branch
+		}
+	}
+	return nil
+}
+
+func mergeList[T any, K comparable](branches []*models.PipelineMessage, field func(*models.PipelineMessage) *[]T, strategy string, key func(T) K) *[]T {
+	if strategy == MergeFirstWins || strategy == MergeLastWins {
+		if branch := pickBranch(branches, strategy, func(m *models.PipelineMessage) bool {
+			return field(m) != nil && len(*field(m)) > 0
+		}); branch != nil {
+			return field(branch)
+		}
+	}
+	var merged *[]T
+	for _, branch := range branches {
+		if values := field(branch); values != nil {
+			if merged == nil {
+				merged = &[]T{}
+			}
+			*merged = utils.UnionFunc(*merged, *values, key)
+		}
+	}
+	return merged
+}
+
+func mergeTags(branches []*models.PipelineMessage, strategy string) (*map[string]string, *map[string]float64) {
+	if strategy == MergeFirstWins || strategy == MergeLastWins {
+		if branch := pickBranch(branches, strategy, func(m *models.PipelineMessage) bool {
+			return m.Tags != nil && len(*m.Tags) > 0
+		}); branch != nil {
+			return branch.Tags, branch.TagScores
+		}
+	}
+	var tags *map[string]string
+	var scores *map[string]float64
+	for _, branch := range branches {
+		if branch.Tags == nil {
+			continue
+		}
+		if tags == nil {
+			tags = &map[string]string{}
+			scores = &map[string]float64{}
+		}
+		for id, value := range *branch.Tags {
+			score, hasScore := 0.0, false
+			if branch.TagScores != nil {
+				score, hasScore = (*branch.TagScores)[id]
+			}
+			_, exists := (*tags)[id]
+			current, hasCurrent := (*scores)[id]
+			if !exists || (strategy == MergeScoreMax && hasScore && (!hasCurrent || score > current)) {
+				(*tags)[id] = value
+				if hasScore {
+					(*scores)[id] = score
+				}
+			}
+		}
+	}
+	return tags, scores
+}
@@ -0,0 +1,109 @@
+package fork
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/teagan42/snidemind/models"
+)
+
+func branch(tags map[string]float64, memories ...string) *models.PipelineMessage {
+	tagMap := map[string]string{}
+	for id := range tags {
+		tagMap[id] = id
+	}
+	return &models.PipelineMessage{
+		Tags:      &tagMap,
+		TagScores: &tags,
+		Memories:  &memories,
+	}
+}
+
+func TestMergeBranches_NoBranches(t *testing.T) {
+	input := branch(map[string]float64{"a": 0.5})
+	out := mergeBranches(input, nil, nil, ForkMergeConfig{})
+	if out != input || !reflect.DeepEqual(*out.TagScores, map[string]float64{"a": 0.5}) {
+		t.Errorf("expected input unchanged, got %+v", out)
+	}
+}
+
+func TestMergeBranches_Tags(t *testing.T) {
+	branches := []*models.PipelineMessage{
+		branch(map[string]float64{"a": 0.6, "b": 0.9}),
+		branch(map[string]float64{"a": 0.8, "c": 0.7}),
+	}
+	tests := []struct {
+		strategy string
+		want     map[string]float64
+	}{
+		{MergeUnion, map[string]float64{"a": 0.6, "b": 0.9, "c": 0.7}},
+		{MergeScoreMax, map[string]float64{"a": 0.8, "b": 0.9, "c": 0.7}},
+		{MergeFirstWins, map[string]float64{"a": 0.6, "b": 0.9}},
+		{MergeLastWins, map[string]float64{"a": 0.8, "c": 0.7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			out := mergeBranches(&models.PipelineMessage{}, nil, branches, ForkMergeConfig{Tags: tt.strategy})
+			if !reflect.DeepEqual(*out.TagScores, tt.want) {
+				t.Errorf("expected scores %v, got %v", tt.want, *out.TagScores)
+			}
+			if len(*out.Tags) != len(tt.want) {
+				t.Errorf("expected %d tags, got %v", len(tt.want), *out.Tags)
+			}
+		})
+	}
+}
+
+func TestMergeBranches_Memories(t *testing.T) {
+	branches := []*models.PipelineMessage{
+		branch(nil, "one", "two"),
+		branch(nil),
+		branch(nil, "two", "three"),
+	}
+	tests := []struct {
+		strategy string
+		want     []string
+	}{
+		{MergeUnion, []string{"one", "two", "three"}},
+		{"", []string{"one", "two", "three"}},
+		{MergeFirstWins, []string{"one", "two"}},
+		{MergeLastWins, []string{"two", "three"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			out := mergeBranches(&models.PipelineMessage{}, nil, branches, ForkMergeConfig{Memories: tt.strategy})
+			if !reflect.DeepEqual(*out.Memories, tt.want) {
+				t.Errorf("expected memories %v, got %v", tt.want, *out.Memories)
+			}
+		})
+	}
+}
+
+func TestMergeBranches_KeepsFirstResponse(t *testing.T) {
+	first := &models.ChatCompletionResponse{ID: "first"}
+	branches := []*models.PipelineMessage{
+		{},
+		{Response: first},
+		{Response: &models.ChatCompletionResponse{ID: "second"}},
+	}
+	out := mergeBranches(&models.PipelineMessage{}, nil, branches, ForkMergeConfig{})
+	if out.Response != first {
+		t.Errorf("expected first branch response, got %+v", out.Response)
+	}
+}
+
+var testRouteKey = models.NewStateKey[string]("fork.test.route")
+
+func TestMergeBranches_State(t *testing.T) {
+	input := &models.PipelineMessage{}
+	models.SetState(input, testRouteKey, "input")
+	left := input.Clone()
+	right := input.Clone()
+	models.SetState(right, testRouteKey, "right")
+
+	out := mergeBranches(input, input.StateSnapshot(), []*models.PipelineMessage{left, right}, ForkMergeConfig{})
+
+	if value, _ := models.GetState(out, testRouteKey); value != "right" {
+		t.Errorf("expected branch state to be merged, got %q", value)
+	}
+}
@@ -0,0 +1,268 @@
+package llm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/telemetry"
+	"go.uber.org/zap"
+)
+
+// Anthropic's Messages API: https://docs.anthropic.com/en/api/messages. Like Ollama's, its answers are turned
+// into chat completions. The request and answer types are the ones the /v1/messages ingress serves.
+
+const anthropicVersion = "2023-06-01"
+
+var anthropicKeyHeader = "x-api-key"
+
+// buildAnthropicRequest builds the same request as for an OpenAI compatible upstream, then moves the system
+// messages to `system` and the tool calls and results into content blocks.
+func (s LLM) buildAnthropicRequest(input *models.PipelineMessage) models.MessagesRequest {
+	chat := s.buildRequestBody(input)
+	stream := s.streaming(input)
+	request := models.MessagesRequest{
+		Model:       chat.Model,
+		MaxTokens:   DefaultAnthropicMaxTokens,
+		Stream:      &stream,
+		Temperature: chat.Temperature,
+		TopP:        chat.TopP,
+	}
+	if chat.MaxCompletionTokens != nil {
+		request.MaxTokens = *chat.MaxCompletionTokens
+	}
+	if input.Request.User != "" {
+		request.Metadata = &models.MessagesMetadata{UserID: input.Request.User}
+	}
+	var system []string
+	request.Messages, system = anthropicMessages(chat.Messages)
+	if len(system) > 0 {
+		request.System = &models.MessagesContent{Text: strings.Join(system, "\n\n")}
+	}
+	if chat.Tools != nil {
+		for _, tool := range *chat.Tools {
+			schema := tool.Function.Parameters
+			if len(schema) == 0 {
+				schema = models.ToolFunctionParameters{"type": "object"}
+			}
+			request.Tools = append(request.Tools, models.MessagesTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+		}
+	}
+	return request
+}
+
+// anthropicMessages converts the conversation, returning the system messages' text apart. Tool results are
+// sent by the user, and the roles have to alternate, so consecutive messages of a role are merged into one.
+func anthropicMessages(messages []models.ChatMessage) ([]models.MessagesMessage, []string) {
+	var system []string
+	converted := make([]models.MessagesMessage, 0, len(messages))
+	for _, message := range messages {
+		role, blocks := message.Role, []models.MessagesContentBlock{}
+		switch message.Role {
+		case "system", "developer":
+			system = append(system, message.Content)
+			continue
+		case "tool":
+			role = "user"
+			blocks = append(blocks, models.MessagesContentBlock{Type: "tool_result", ToolUseID: message.ToolCallID, Content: &models.MessagesContent{Text: message.Content}})
+		default:
+			if message.Content != "" {
+				blocks = append(blocks, models.MessagesContentBlock{Type: "text", Text: message.Content})
+			}
+		}
+		if message.ToolCalls != nil {
+			for _, call := range *message.ToolCalls {
+				arguments := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(arguments) {
+					arguments = json.RawMessage(`{}`)
+				}
+				blocks = append(blocks, models.MessagesContentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: arguments})
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		if last := len(converted) - 1; last >= 0 && converted[last].Role == role {
+			// Tool results go before anything else the user says, as Anthropic requires
+			merged := converted[last].Content.Blocks
+			at := len(merged)
+			if blocks[0].Type == "tool_result" {
+				at = slices.IndexFunc(merged, func(b models.MessagesContentBlock) bool { return b.Type != "tool_result" })
+				if at < 0 {
+					at = len(merged)
+				}
+			}
+			converted[last].Content.Blocks = slices.Insert(merged, at, blocks...)
+			continue
+		}
+		converted = append(converted, models.MessagesMessage{Role: role, Content: models.MessagesContent{Blocks: blocks}})
+	}
+	return converted, system
+}
+
+func anthropicFinishReason(stopReason *string) string {
+	if stopReason == nil {
+		return "stop"
+	}
+	switch *stopReason {
+	case "tool_use":
+		return "tool_calls"
+	case "max_tokens":
+		return "length"
+	}
+	return "stop"
+}
+
+func anthropicUsage(usage models.MessagesUsage) models.Usage {
+	return models.Usage{PromptTokens: usage.InputTokens, CompletionTokens: usage.OutputTokens, TotalTokens: usage.InputTokens + usage.OutputTokens}
+}
+
+func (s LLM) bufferAnthropicResponse(input *models.PipelineMessage, body io.Reader, start time.Time) (*models.PipelineMessage, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		s.Logger.Error("Read error", zap.Error(err))
+		return nil, err
+	}
+	telemetry.ObserveTimeToFirstToken(s.model(input), time.Since(start))
+	s.Logger.Info("Buffered response", zap.ByteString("data", data))
+	var answer models.Message
+	if err := json.Unmarshal(data, &answer); err != nil {
+		s.Logger.Error("Unmarshal error", zap.Error(err))
+		return nil, err
+	}
+	input.AddUsage(s.model(input), anthropicUsage(answer.Usage))
+
+	message := models.ChatMessage{Role: "assistant"}
+	var calls []models.ChatCompletionsMessageToolCall
+	for _, block := range answer.Content {
+		switch block.Type {
+		case "text":
+			message.Content += block.Text
+		case "tool_use":
+			calls = append(calls, models.ChatCompletionsMessageToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: models.ChatCompletionsMessageFunctionCall{Name: block.Name, Arguments: string(block.Input)},
+			})
+		}
+	}
+	if calls != nil {
+		message.ToolCalls = &calls
+	}
+	resp := &models.ChatCompletionResponse{
+		ID:      answer.ID,
+		Choices: []models.ChatCompletionChoice{{FinishReason: anthropicFinishReason(answer.StopReason), Message: message}},
+		Created: time.Now().Unix(),
+		Model:   answer.Model,
+		Object:  "chat.completion",
+	}
+	if total := input.TotalUsage(); total != (models.Usage{}) {
+		resp.Usage = &total
+	}
+
+	w := input.ResponseWriter
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.Logger.Error("Write error", zap.Error(err))
+		return nil, err
+	}
+	input.Response = resp
+	return input, nil
+}
+
+// streamAnthropicResponse relays Anthropic's event stream as a chat completion stream. Only the data lines are
+// read, each event's type is in its data too.
+func (s LLM) streamAnthropicResponse(input *models.PipelineMessage, body io.Reader, start time.Time) (*models.PipelineMessage, error) {
+	stream := newChunkStream(input.ResponseWriter, s.model(input), start)
+	usage := models.MessagesUsage{}
+	calls := map[int]int{} // Content block index to tool call index
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.Logger.Info("Stream chunk", zap.String("chunk", line))
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event models.MessagesStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			s.Logger.Error("Unparseable stream chunk", zap.String("chunk", line), zap.Error(err))
+			continue
+		}
+		choice := models.ChatCompletionChunkChoice{}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				stream.resp.ID, stream.resp.Model = event.Message.ID, event.Message.Model
+				usage = event.Message.Usage
+			}
+			continue
+		case "content_block_start":
+			if event.ContentBlock == nil || event.ContentBlock.Type != "tool_use" || event.Index == nil {
+				continue
+			}
+			calls[*event.Index] = len(calls)
+			choice.Delta.ToolCalls = []models.ChatCompletionToolCallDelta{{
+				Index: calls[*event.Index],
+				ChatCompletionsMessageToolCall: models.ChatCompletionsMessageToolCall{
+					ID:       event.ContentBlock.ID,
+					Type:     "function",
+					Function: models.ChatCompletionsMessageFunctionCall{Name: event.ContentBlock.Name},
+				},
+			}}
+		case "content_block_delta":
+			if event.Delta == nil {
+				continue
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				choice.Delta.Content = event.Delta.Text
+			case "input_json_delta":
+				if event.Index == nil || event.Delta.PartialJSON == "" {
+					continue
+				}
+				choice.Delta.ToolCalls = []models.ChatCompletionToolCallDelta{{
+					Index:                          calls[*event.Index],
+					ChatCompletionsMessageToolCall: models.ChatCompletionsMessageToolCall{Function: models.ChatCompletionsMessageFunctionCall{Arguments: event.Delta.PartialJSON}},
+				}}
+			default:
+				continue
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+			if event.Delta == nil || event.Delta.StopReason == nil {
+				continue
+			}
+			reason := anthropicFinishReason(event.Delta.StopReason)
+			choice.FinishReason = &reason
+		case "error":
+			if event.Error == nil {
+				event.Error = &models.MessagesError{Type: "api_error", Message: "unknown error"}
+			}
+			s.Logger.Error("Error event from LLM", zap.String("type", event.Error.Type), zap.String("message", event.Error.Message))
+			return nil, fmt.Errorf("upstream %s: %s", event.Error.Type, event.Error.Message)
+		default:
+			continue
+		}
+		if err := stream.send(models.ChatCompletionChunk{Choices: []models.ChatCompletionChunkChoice{choice}}); err != nil {
+			s.Logger.Error("Write error during stream", zap.Error(err))
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		s.Logger.Error("Stream scanner error", zap.Error(err))
+		return nil, err
+	}
+	input.AddUsage(s.model(input), anthropicUsage(usage))
+	return stream.finish(input)
+}
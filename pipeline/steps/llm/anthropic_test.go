@@ -0,0 +1,205 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+)
+
+func newAnthropicStep(t *testing.T, upstream http.HandlerFunc) LLM {
+	t.Helper()
+	step := newStep(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/messages", r.URL.Path)
+		assert.Equal(t, anthropicVersion, r.Header.Get("anthropic-version"))
+		upstream(w, r)
+	})
+	step.Provider = ProviderAnthropic
+	return step
+}
+
+func TestAnthropic_RequestMapsConversation(t *testing.T) {
+	var request map[string]any
+	var apiKey string
+	step := newAnthropicStep(t, func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("x-api-key")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"No."}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`)
+	})
+	key, model := "sk-ant", "claude-sonnet-4-5"
+	step.APIKey, step.Model = &key, &model
+
+	calls := []models.ChatCompletionsMessageToolCall{
+		{ID: "toolu_1", Type: "function", Function: models.ChatCompletionsMessageFunctionCall{Name: "lights", Arguments: `{"on":true}`}},
+		{ID: "toolu_2", Type: "function", Function: models.ChatCompletionsMessageFunctionCall{Name: "fan", Arguments: `{}`}},
+	}
+	_, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "snidemind", Messages: []models.ChatMessage{
+		{Role: "system", Content: "Be snide."},
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "lights and fan"},
+		{Role: "assistant", Content: "Fine.", ToolCalls: &calls},
+		{Role: "tool", ToolCallID: "toolu_1", Content: "on"},
+		{Role: "tool", ToolCallID: "toolu_2", Content: "off"},
+		{Role: "user", Content: "thanks"},
+	}}, httptest.NewRecorder()))
+	require.NoError(t, err)
+
+	assert.Equal(t, "sk-ant", apiKey)
+	assert.Equal(t, "claude-sonnet-4-5", request["model"], "the step's model, not the client's")
+	assert.Equal(t, "Be snide.\n\nBe brief.", request["system"])
+	assert.Equal(t, float64(DefaultAnthropicMaxTokens), request["max_tokens"])
+	assert.Equal(t, false, request["stream"])
+	messages := request["messages"].([]any)
+	require.Len(t, messages, 3)
+	assistant := messages[1].(map[string]any)["content"].([]any)
+	assert.Equal(t, map[string]any{"type": "text", "text": "Fine."}, assistant[0])
+	assert.Equal(t, map[string]any{"type": "tool_use", "id": "toolu_1", "name": "lights", "input": map[string]any{"on": true}}, assistant[1])
+	results := messages[2].(map[string]any)
+	assert.Equal(t, "user", results["role"])
+	blocks := results["content"].([]any)
+	require.Len(t, blocks, 3, "both results and the user's text go in one message")
+	assert.Equal(t, map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": "on"}, blocks[0])
+	assert.Equal(t, "tool_result", blocks[1].(map[string]any)["type"])
+	assert.Equal(t, "thanks", blocks[2].(map[string]any)["text"])
+}
+
+func TestAnthropic_MaxTokens(t *testing.T) {
+	var request models.MessagesRequest
+	step := newAnthropicStep(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		io.WriteString(w, `{"id":"msg_1","content":[],"usage":{}}`)
+	})
+	asked := int64(100)
+	_, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "claude", MaxCompletionTokens: &asked}, httptest.NewRecorder()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), request.MaxTokens, "the client's max_completion_tokens")
+
+	configured := int64(200)
+	step.MaxTokens = &configured
+	_, err = step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "claude", MaxCompletionTokens: &asked}, httptest.NewRecorder()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), request.MaxTokens, "the step's max_tokens wins")
+}
+
+func TestAnthropic_APIKeyHeader(t *testing.T) {
+	var header http.Header
+	step := newAnthropicStep(t, func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		io.WriteString(w, `{"id":"msg_1","content":[],"usage":{}}`)
+	})
+	key, name := "Bearer token", "Authorization"
+	step.APIKey, step.APIKeyHeader = &key, &name
+	_, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "claude"}, httptest.NewRecorder()))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Empty(t, header.Get("x-api-key"))
+}
+
+func TestAnthropic_BufferedResponse(t *testing.T) {
+	step := newAnthropicStep(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-3","content":[{"type":"text","text":"Fine."},{"type":"tool_use","id":"toolu_1","name":"lights","input":{"on":true}}],"stop_reason":"tool_use","usage":{"input_tokens":10,"output_tokens":3}}`)
+	})
+	recorder := httptest.NewRecorder()
+	output, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "claude"}, recorder))
+	require.NoError(t, err)
+
+	response := output.Response
+	assert.Equal(t, "msg_1", response.ID)
+	assert.Equal(t, "claude-3", response.Model)
+	assert.Equal(t, "tool_calls", response.Choices[0].FinishReason)
+	assert.Equal(t, "Fine.", response.Choices[0].Message.Content)
+	require.NotNil(t, response.Choices[0].Message.ToolCalls)
+	call := (*response.Choices[0].Message.ToolCalls)[0]
+	assert.Equal(t, "toolu_1", call.ID)
+	assert.JSONEq(t, `{"on":true}`, call.Function.Arguments)
+	assert.Equal(t, models.Usage{PromptTokens: 15, CompletionTokens: 3, TotalTokens: 18}, *response.Usage, "usage includes the earlier embedding")
+
+	var sent models.ChatCompletionResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &sent))
+	assert.Equal(t, *response.Usage, *sent.Usage)
+}
+
+const anthropicStream = "event: message_start\n" +
+	`data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-3","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":1}}}` + "\n\n" +
+	"event: content_block_start\n" +
+	`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Fine."}}` + "\n\n" +
+	"event: content_block_stop\n" +
+	`data: {"type":"content_block_stop","index":0}` + "\n\n" +
+	"event: content_block_start\n" +
+	`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"lights","input":{}}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"on\":"}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"true}"}}` + "\n\n" +
+	"event: content_block_stop\n" +
+	`data: {"type":"content_block_stop","index":1}` + "\n\n" +
+	"event: message_delta\n" +
+	`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":4}}` + "\n\n" +
+	"event: message_stop\n" +
+	`data: {"type":"message_stop"}` + "\n\n"
+
+func TestAnthropic_StreamedResponse(t *testing.T) {
+	step := newAnthropicStep(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, anthropicStream)
+	})
+	stream := true
+	recorder := httptest.NewRecorder()
+	request := models.ChatCompletionRequest{Model: "claude", Stream: &stream, StreamOptions: &models.StreamOptions{IncludeUsage: true}}
+	output, err := step.Process(nil, newUsageMessage(request, recorder))
+	require.NoError(t, err)
+
+	response := output.Response
+	assert.Equal(t, "msg_1", response.ID)
+	assert.Equal(t, "Fine.", response.Choices[0].Message.Content)
+	assert.Equal(t, "tool_calls", response.Choices[0].FinishReason)
+	require.NotNil(t, response.Choices[0].Message.ToolCalls)
+	call := (*response.Choices[0].Message.ToolCalls)[0]
+	assert.Equal(t, "toolu_1", call.ID)
+	assert.Equal(t, "lights", call.Function.Name)
+	assert.Equal(t, `{"on":true}`, call.Function.Arguments)
+	assert.Equal(t, models.Usage{PromptTokens: 15, CompletionTokens: 4, TotalTokens: 19}, *response.Usage)
+
+	events := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n\n")
+	require.Len(t, events, 7, "text, tool call, two argument pieces, finish, usage and [DONE]")
+	assert.Equal(t, "data: [DONE]", events[6])
+	var call1 models.ChatCompletionChunk
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(events[1], "data: ")), &call1))
+	assert.Equal(t, "chat.completion.chunk", call1.Object)
+	assert.Equal(t, "msg_1", call1.ID)
+	assert.Equal(t, 0, call1.Choices[0].Delta.ToolCalls[0].Index)
+	assert.Equal(t, "lights", call1.Choices[0].Delta.ToolCalls[0].Function.Name)
+}
+
+func TestAnthropic_StreamErrorEvent(t *testing.T) {
+	step := newAnthropicStep(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "event: error\n"+`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`+"\n\n")
+	})
+	stream := true
+	_, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "claude", Stream: &stream}, httptest.NewRecorder()))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Overloaded")
+}
+
+func TestAnthropic_ErrorIsMappedThrough(t *testing.T) {
+	step := newAnthropicStep(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: too large"}}`)
+	})
+	_, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "claude"}, httptest.NewRecorder()))
+
+	var statusErr *models.StatusError
+	require.True(t, errors.As(err, &statusErr), "expected a StatusError, got %v", err)
+	assert.Equal(t, http.StatusBadRequest, statusErr.Status)
+	assert.Equal(t, "invalid_request_error", statusErr.Detail.Type)
+	assert.Contains(t, statusErr.Detail.Message, "too large")
+}
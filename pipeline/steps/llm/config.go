@@ -0,0 +1,40 @@
+package llm
+
+const (
+	ProviderOpenAI    = "openai"    // Anything with an OpenAI compatible /chat/completions
+	ProviderOllama    = "ollama"    // Ollama's own /api/chat
+	ProviderAnthropic = "anthropic" // Anthropic's /v1/messages
+)
+
+// DefaultAnthropicMaxTokens is the max_tokens sent to Anthropic, which wants one on every request, when neither the
+// step's `max_tokens` nor the client's `max_completion_tokens` says.
+const DefaultAnthropicMaxTokens int64 = 4096
+
+// LLMConfig is the `llm` section of an llm step.
+type LLMConfig struct {
+	Provider          string            `json:"provider,omitempty" yaml:"provider,omitempty" validate:"omitempty,oneof=openai ollama anthropic"`
+	Model             *string           `json:"model,omitempty" yaml:"model,omitempty" validate:"omitempty,required"` // Asked for instead of the model the client named
+	APIKey            *string           `json:"api_key,omitempty" yaml:"api_key,omitempty" validate:"omitempty,required"`
+	APIKeyHeader      *string           `json:"api_key_header,omitempty" yaml:"api_key,omitempty" validate:"omitempty,required=api_key"`
+	BaseURL           string            `json:"base_url,omitempty" yaml:"base_url,omitempty" validate:"omitempty,url"`
+	Timeout           *int              `json:"timeout,omitempty" yaml:"timeout,omitempty" validate:"omitempty,min=1"`
+	Headers           map[string]string `json:"headers,omitempty" yaml:"headers,omitempty" validate:"omitempty,dive,keys,required"`
+	Temperature       *float64          `json:"temperature,omitempty" yaml:"temperature,omitempty" validate:"omitempty,min=0,max=1"`
+	MaxTokens         *int64            `json:"max_tokens,omitempty" yaml:"max_tokens,omitempty" validate:"omitempty,min=1"` // Instead of the client's max_completion_tokens
+	TopP              *float64          `json:"top_p,omitempty" yaml:"top_p,omitempty" validate:"omitempty,min=0,max=1"`
+	FrequencyPenalty  *float64          `json:"frequency_penalty,omitempty" yaml:"frequency_penalty,omitempty" validate:"omitempty,min=0,max=1"`
+	PresencePenalty   *float64          `json:"presence_penalty,omitempty" yaml:"presence_penalty,omitempty" validate:"omitempty,min=0,max=1"`
+	N                 *int              `json:"n,omitempty" yaml:"n,omitempty" validate:"omitempty,min=1"`
+	Stream            *bool             `json:"stream,omitempty" yaml:"stream,omitempty" validate:"omitempty"`
+	ParallelToolCalls *bool             `json:"parallel_tool_calls,omitempty" yaml:"parallel_tool_calls,omitempty" validate:"omitempty"`
+
+	// Ollama only, see https://github.com/ollama/ollama/blob/main/docs/api.md#generate-a-chat-completion
+	KeepAlive *string        `json:"keep_alive,omitempty" yaml:"keep_alive,omitempty" validate:"omitempty"` // How long the model stays loaded, e.g. 10m, negative to keep it loaded
+	NumCtx    *int           `json:"num_ctx,omitempty" yaml:"num_ctx,omitempty" validate:"omitempty,min=1"`
+	Options   map[string]any `json:"options,omitempty" yaml:"options,omitempty" validate:"omitempty"` // Passed as is, over whatever the fields above set
+	Format    any            `json:"format,omitempty" yaml:"format,omitempty" validate:"omitempty"`   // "json" or a JSON schema the answer has to match
+}
+
+func (c *LLMConfig) SetDefaults() {
+	c.Provider = ProviderOpenAI
+}
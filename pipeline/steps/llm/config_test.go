@@ -1,5 +1,5 @@
-// File: config/types_test.go
-package config
+// File: pipeline/steps/llm/config_test.go
+package llm
 
 import (
 	"testing"
@@ -93,6 +93,43 @@ func TestLLMConfig_Validation(t *testing.T) {
 			},
 			valid: false,
 		},
+		{
+			name: "Ollama with its own settings",
+			cfg: LLMConfig{
+				Provider: ProviderOllama,
+				Model:    &model,
+				NumCtx:   intPtr(8192),
+				Options:  map[string]any{"seed": 42},
+				Format:   map[string]any{"type": "object"},
+			},
+			valid: true,
+		},
+		{
+			name: "Anthropic",
+			cfg: LLMConfig{
+				Provider: ProviderAnthropic,
+				Model:    &model,
+				APIKey:   &apiKey,
+			},
+			valid: true,
+		},
+		{
+			name: "Unknown provider",
+			cfg: LLMConfig{
+				Provider: "watson",
+				Model:    &model,
+			},
+			valid: false,
+		},
+		{
+			name: "Invalid NumCtx (zero)",
+			cfg: LLMConfig{
+				Provider: ProviderOllama,
+				Model:    &model,
+				NumCtx:   intPtr(0),
+			},
+			valid: false,
+		},
 		{
 			name: "Valid minimal config",
 			cfg: LLMConfig{
@@ -121,6 +158,7 @@ func TestLLMConfig_Validation(t *testing.T) {
 
 func floatPtr(f float64) *float64 { return &f }
 func int64Ptr(i int64) *int64     { return &i }
+func intPtr(i int) *int           { return &i }
 
 // getValidator returns a validator instance with required tag support.
 func getValidator() *validator.Validate {
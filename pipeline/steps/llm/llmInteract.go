@@ -7,15 +7,19 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/teagan42/snidemind/config"
 	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/telemetry"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
 type LLM struct {
-	config.LLMConfig
+	LLMConfig
+	Client *http.Client
 	Logger *zap.Logger
 }
 
@@ -36,9 +40,19 @@ type LLMFactory struct {
 func (f LLMFactory) Name() string {
 	return "llm"
 }
-func (f LLMFactory) Build(config config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+func (f LLMFactory) ValidateConfig(stepConfig config.PipelineStepConfig) error {
+	_, err := config.DecodeSection[LLMConfig](stepConfig, "llm")
+	return err
+}
+
+func (f LLMFactory) Build(stepConfig config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	llmConfig, err := config.DecodeSection[LLMConfig](stepConfig, "llm")
+	if err != nil {
+		return nil, err
+	}
 	return &LLM{
-		LLMConfig: *config.LLM,
+		LLMConfig: llmConfig,
+		Client:    telemetry.NewHTTPClient("llm"),
 		Logger:    f.Logger,
 	}, nil
 }
@@ -55,12 +69,22 @@ func (s LLM) Name() string {
 	return "LLM"
 }
 
+// model is the model the upstream is asked for: the step's, or else whatever the client named, which may be
+// one of the virtual `models` only SnideMind knows about.
+func (s LLM) model(input *models.PipelineMessage) string {
+	if s.Model != nil {
+		return *s.Model
+	}
+	return input.Request.Model
+}
+
 func (s LLM) buildRequestBody(input *models.PipelineMessage) models.ChatCompletionRequest {
 	reqBody := models.ChatCompletionRequest{
-		Messages:         input.Request.Messages,
-		Model:            input.Request.Model,
-		FrequencyPenalty: input.Request.FrequencyPenalty,
-		N:                input.Request.N,
+		Messages:            input.Request.Messages,
+		Model:               s.model(input),
+		FrequencyPenalty:    input.Request.FrequencyPenalty,
+		MaxCompletionTokens: input.Request.MaxCompletionTokens,
+		N:                   input.Request.N,
 		// Tools:             input.Tools,
 		ParallelToolCalls: input.Request.ParallelToolCalls,
 		PresencePenalty:   input.Request.PresencePenalty,
@@ -71,6 +95,9 @@ func (s LLM) buildRequestBody(input *models.PipelineMessage) models.ChatCompleti
 	if s.FrequencyPenalty != nil {
 		reqBody.FrequencyPenalty = s.FrequencyPenalty
 	}
+	if s.MaxTokens != nil {
+		reqBody.MaxCompletionTokens = s.MaxTokens
+	}
 	if s.N != nil {
 		reqBody.N = s.N
 	}
@@ -89,6 +116,10 @@ func (s LLM) buildRequestBody(input *models.PipelineMessage) models.ChatCompleti
 	if s.TopP != nil {
 		reqBody.TopP = s.TopP
 	}
+	if reqBody.Stream != nil && *reqBody.Stream {
+		// Always ask for usage so it can be counted; streamResponse hides it from clients that didn't ask
+		reqBody.StreamOptions = &models.StreamOptions{IncludeUsage: true}
+	}
 
 	if input.Tools != nil && len(*input.Tools) > 0 {
 		tools := make([]models.Tool, len(*input.Tools))
@@ -108,11 +139,15 @@ func (s LLM) buildRequestBody(input *models.PipelineMessage) models.ChatCompleti
 	return reqBody
 }
 
-func (s LLM) streamResponse(input *models.PipelineMessage, body io.Reader) (*models.PipelineMessage, error) {
+// streamResponse relays the upstream stream to the client. start is when the request was sent, for the
+// time-to-first-token and tokens/sec metrics, which count each content chunk as a token.
+func (s LLM) streamResponse(input *models.PipelineMessage, body io.Reader, start time.Time) (*models.PipelineMessage, error) {
 	w := input.ResponseWriter
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	var resp *models.ChatCompletionResponse = nil
+	var firstToken time.Time
+	tokens := 0
 	scanner := bufio.NewScanner(body)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -131,13 +166,24 @@ func (s LLM) streamResponse(input *models.PipelineMessage, body io.Reader) (*mod
 					},
 				},
 				Created: 0,
-				Model:   *s.Model,
+				Model:   s.model(input),
 				Object:  "chat.completion",
 			}
 		}
+		if line != "data: [DONE]" && strings.HasPrefix(line, "data: ") {
+			var forward bool
+			if line, forward = s.streamUsage(input, line); !forward {
+				continue
+			}
+		}
 		if line != "data: [DONE]" {
 			if len(line) > 6 && line[:6] == "data: " {
-				resp.Choices[0].Message.Content = resp.Choices[0].Message.Content + line[6:]
+				accumulate(resp, line[6:])
+				if tokens == 0 {
+					firstToken = time.Now()
+					telemetry.ObserveTimeToFirstToken(s.model(input), firstToken.Sub(start))
+				}
+				tokens++
 			}
 		}
 		if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
@@ -153,6 +199,13 @@ func (s LLM) streamResponse(input *models.PipelineMessage, body io.Reader) (*mod
 		return nil, err
 	}
 
+	if tokens > 1 {
+		telemetry.ObserveTokenRate(s.model(input), tokens-1, time.Since(firstToken))
+	}
+	if resp != nil && input.Usage != nil {
+		total := input.TotalUsage()
+		resp.Usage = &total
+	}
 	input.Response = resp
 
 	w.WriteHeader(http.StatusOK)
@@ -163,14 +216,30 @@ func (s LLM) streamResponse(input *models.PipelineMessage, body io.Reader) (*mod
 	return input, nil
 }
 
-func (s LLM) bufferResponse(input *models.PipelineMessage, body io.Reader) (*models.PipelineMessage, error) {
+func (s LLM) bufferResponse(input *models.PipelineMessage, body io.Reader, start time.Time) (*models.PipelineMessage, error) {
 	data, err := io.ReadAll(body)
 	if err != nil {
 		s.Logger.Error("Read error", zap.Error(err))
 		return nil, err
 	}
+	telemetry.ObserveTimeToFirstToken(s.model(input), time.Since(start))
 	s.Logger.Info("Buffered response", zap.ByteString("data", data))
 
+	var resp models.ChatCompletionResponse
+	parseErr := json.Unmarshal(data, &resp)
+	if parseErr == nil {
+		if resp.Usage != nil {
+			input.AddUsage(s.model(input), *resp.Usage)
+		}
+		// The client is told about every token spent on its request, not just this call's
+		if total := input.TotalUsage(); total != (models.Usage{}) {
+			resp.Usage = &total
+			if rewritten, err := withUsage(data, &total); err == nil {
+				data = rewritten
+			}
+		}
+	}
+
 	w := input.ResponseWriter
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -179,57 +248,136 @@ func (s LLM) bufferResponse(input *models.PipelineMessage, body io.Reader) (*mod
 		return nil, err
 	}
 
-	var resp models.ChatCompletionResponse
-	if err := json.Unmarshal(data, &resp); err != nil {
-		s.Logger.Error("Unmarshal error", zap.Error(err))
-		return nil, err
+	if parseErr != nil {
+		s.Logger.Error("Unmarshal error", zap.Error(parseErr))
+		return nil, parseErr
 	}
 	input.Response = &resp
 
 	return input, nil
 }
 
-func (s LLM) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
-	s.Logger.Info("Processing", zap.String("model", *s.Model), zap.String("baseURL", s.BaseURL))
+// Stub answers in place of the model during a dry-run trace, describing the request it would have sent.
+func (s LLM) Stub(input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	request := s.buildRequestBody(input)
+	tools := 0
+	if request.Tools != nil {
+		tools = len(*request.Tools)
+	}
+	input.Response = &models.ChatCompletionResponse{
+		ID: "trace-stub",
+		Choices: []models.ChatCompletionChoice{
+			{
+				FinishReason: "stop",
+				Message: models.ChatMessage{
+					Role:    "assistant",
+					Content: fmt.Sprintf("[stubbed] %s would have been called with %d messages and %d tools", s.endpoint(), len(request.Messages), tools),
+				},
+			},
+		},
+		Model:  request.Model,
+		Object: "chat.completion",
+	}
+	return input, nil
+}
 
-	bodyBytes, err := json.Marshal(s.buildRequestBody(input))
+// endpoint is where the step's provider takes chat requests.
+func (s LLM) endpoint() string {
+	switch s.Provider {
+	case ProviderOllama:
+		return s.BaseURL + "/api/chat"
+	case ProviderAnthropic:
+		return s.BaseURL + "/messages" // The base URL ends in /v1, like an OpenAI one does
+	}
+	return s.BaseURL + "/chat/completions"
+}
+
+// streaming reports whether the answer is streamed, because the step or the client asked for it.
+func (s LLM) streaming(input *models.PipelineMessage) bool {
+	return (s.Stream != nil && *s.Stream) || (input.Request.Stream != nil && *input.Request.Stream)
+}
+
+// setAuth sets the API key on req, in the step's header or else the one the provider expects.
+func (s LLM) setAuth(req *http.Request) {
+	header := s.APIKeyHeader
+	if s.Provider == ProviderAnthropic {
+		req.Header.Set("anthropic-version", anthropicVersion)
+		if header == nil {
+			header = &anthropicKeyHeader
+		}
+	}
+	if s.APIKey != nil && header != nil {
+		req.Header.Set(*header, *s.APIKey)
+	}
+}
+
+// send posts body to the provider. An error response is returned as the StatusError the client should get; on
+// success the caller owns the response body. start is when the request was sent, for the latency metrics.
+func (s LLM) send(input *models.PipelineMessage, body any) (resp *http.Response, start time.Time, err error) {
+	bodyBytes, err := json.Marshal(body)
 	if err != nil {
 		s.Logger.Error("Error marshalling request", zap.Error(err))
-		return nil, err
+		return nil, start, err
 	}
-	url := fmt.Sprintf("%s/chat/completions", s.BaseURL)
+	url := s.endpoint()
 	s.Logger.Info("Creating request", zap.String("url", url), zap.ByteString("body", bodyBytes))
-	client := &http.Client{}
-	if req, err := http.NewRequest("POST", url, io.NopCloser(bytes.NewBuffer(bodyBytes))); err != nil {
+	req, err := http.NewRequestWithContext(input.Context(), "POST", url, io.NopCloser(bytes.NewBuffer(bodyBytes)))
+	if err != nil {
 		s.Logger.Error("Error creating request", zap.Error(err))
+		return nil, start, err
+	}
+	s.setAuth(req)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	s.Logger.Info("Sending request", zap.String("url", url), zap.ByteString("body", bodyBytes))
+	start = time.Now()
+	resp, err = s.Client.Do(req)
+	if err != nil {
+		s.Logger.Error("Error sending request", zap.Error(err))
+		return nil, start, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		s.Logger.Error("Error response from LLM", zap.String("status", resp.Status), zap.ByteString("body", data))
+		return nil, start, models.UpstreamError(resp.StatusCode, data)
+	}
+	return resp, start, nil
+}
+
+func (s LLM) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	s.Logger.Info("Processing", zap.String("provider", s.Provider), zap.String("model", s.model(input)), zap.String("baseURL", s.BaseURL))
+
+	var body any = s.buildRequestBody(input)
+	switch s.Provider {
+	case ProviderOllama:
+		body = s.buildOllamaRequest(input)
+	case ProviderAnthropic:
+		body = s.buildAnthropicRequest(input)
+	}
+	resp, start, err := s.send(input, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var respMsg *models.PipelineMessage
+	switch {
+	case s.Provider == ProviderOllama && s.streaming(input):
+		respMsg, err = s.streamOllamaResponse(input, resp.Body, start)
+	case s.Provider == ProviderOllama:
+		respMsg, err = s.bufferOllamaResponse(input, resp.Body, start)
+	case s.Provider == ProviderAnthropic && s.streaming(input):
+		respMsg, err = s.streamAnthropicResponse(input, resp.Body, start)
+	case s.Provider == ProviderAnthropic:
+		respMsg, err = s.bufferAnthropicResponse(input, resp.Body, start)
+	case s.streaming(input):
+		respMsg, err = s.streamResponse(input, resp.Body, start)
+	default:
+		respMsg, err = s.bufferResponse(input, resp.Body, start)
+	}
+	if err != nil {
+		s.Logger.Error("Error reading response body", zap.Error(err))
 		return nil, err
-	} else {
-		if s.APIKey != nil && s.APIKeyHeader != nil {
-			req.Header.Set(*s.APIKeyHeader, *s.APIKey)
-		}
-		req.Header.Set("Accept", "application/json")
-		req.Header.Set("Content-Type", "application/json")
-		s.Logger.Info("Sending request", zap.String("url", url), zap.ByteString("body", bodyBytes))
-		if resp, err := client.Do(req); err != nil {
-			s.Logger.Error("Error sending request", zap.Error(err))
-			return nil, err
-		} else {
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				s.Logger.Error("Error response from LLM", zap.String("status", resp.Status))
-				return nil, fmt.Errorf("error: %s", resp.Status)
-			}
-			var respMsg *models.PipelineMessage
-			if (s.Stream != nil && *s.Stream) || (input.Request.Stream != nil && *input.Request.Stream) {
-				respMsg, err = s.streamResponse(input, resp.Body)
-			} else {
-				respMsg, err = s.bufferResponse(input, resp.Body)
-			}
-			if err != nil {
-				s.Logger.Error("Error reading response body", zap.Error(err))
-				return nil, err
-			}
-			return respMsg, nil
-		}
 	}
+	return respMsg, nil
 }
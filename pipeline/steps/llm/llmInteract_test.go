@@ -0,0 +1,29 @@
+package llm
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+)
+
+func TestProcess_UpstreamErrorIsMappedThrough(t *testing.T) {
+	step := newStep(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":{"message":"maximum context length exceeded","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`)
+	})
+	recorder := httptest.NewRecorder()
+	_, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "mistral"}, recorder))
+
+	var statusErr *models.StatusError
+	require.True(t, errors.As(err, &statusErr), "expected a StatusError, got %v", err)
+	assert.Equal(t, http.StatusBadRequest, statusErr.Status)
+	assert.Equal(t, "maximum context length exceeded", statusErr.Detail.Message)
+	assert.Equal(t, "context_length_exceeded", *statusErr.Detail.Code)
+	assert.Zero(t, recorder.Body.Len(), "nothing should reach the client before the error is reported")
+}
@@ -0,0 +1,199 @@
+package llm
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/telemetry"
+	"github.com/teagan42/snidemind/utils"
+	"go.uber.org/zap"
+)
+
+// Ollama's native chat API: https://github.com/ollama/ollama/blob/main/docs/api.md#generate-a-chat-completion.
+// Whatever it answers is turned into a chat completion, so neither the client nor the rest of the pipeline can
+// tell which provider the step used.
+
+// buildOllamaRequest builds the same request as for an OpenAI compatible upstream, then moves the sampling
+// settings to `options` where Ollama wants them. The step's `options` have the last word.
+func (s LLM) buildOllamaRequest(input *models.PipelineMessage) models.OllamaChatRequest {
+	chat := s.buildRequestBody(input)
+	stream := s.streaming(input)
+	request := models.OllamaChatRequest{
+		Model:     chat.Model,
+		Messages:  ollamaMessages(chat.Messages),
+		Stream:    &stream,
+		Format:    s.Format,
+		KeepAlive: s.KeepAlive,
+	}
+	if chat.Tools != nil {
+		request.Tools = *chat.Tools
+	}
+	options := map[string]any{}
+	setOption(options, "temperature", chat.Temperature)
+	setOption(options, "top_p", chat.TopP)
+	setOption(options, "frequency_penalty", chat.FrequencyPenalty)
+	setOption(options, "presence_penalty", chat.PresencePenalty)
+	setOption(options, "num_predict", chat.MaxCompletionTokens)
+	setOption(options, "num_ctx", s.NumCtx)
+	maps.Copy(options, s.Options)
+	if len(options) > 0 {
+		request.Options = options
+	}
+	return request
+}
+
+func setOption[T any](options map[string]any, key string, value *T) {
+	if value != nil {
+		options[key] = *value
+	}
+}
+
+// ollamaMessages converts the conversation. Tool results are matched to their calls by name rather than ID,
+// so the name is looked up from the call the result answers.
+func ollamaMessages(messages []models.ChatMessage) []models.OllamaMessage {
+	names := map[string]string{}
+	converted := make([]models.OllamaMessage, 0, len(messages))
+	for _, message := range messages {
+		m := models.OllamaMessage{Role: message.Role, Content: message.Content}
+		if message.ToolCalls != nil {
+			for _, call := range *message.ToolCalls {
+				names[call.ID] = call.Function.Name
+				arguments := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(arguments) {
+					arguments = json.RawMessage(`{}`)
+				}
+				m.ToolCalls = append(m.ToolCalls, models.OllamaToolCall{Function: models.OllamaFunction{Name: call.Function.Name, Arguments: arguments}})
+			}
+		}
+		if message.Role == "tool" {
+			m.ToolName = names[message.ToolCallID]
+		}
+		converted = append(converted, m)
+	}
+	return converted
+}
+
+// toolCalls converts Ollama's tool calls, which come whole rather than in pieces, giving each the ID Ollama doesn't.
+func toolCalls(calls []models.OllamaToolCall) []models.ChatCompletionsMessageToolCall {
+	converted := make([]models.ChatCompletionsMessageToolCall, 0, len(calls))
+	for _, call := range calls {
+		arguments := string(call.Function.Arguments)
+		if arguments == "" {
+			arguments = "{}"
+		}
+		converted = append(converted, models.ChatCompletionsMessageToolCall{
+			ID:       utils.NewID("call_"),
+			Type:     "function",
+			Function: models.ChatCompletionsMessageFunctionCall{Name: call.Function.Name, Arguments: arguments},
+		})
+	}
+	return converted
+}
+
+func finishReason(doneReason string, toolCalls bool) string {
+	switch {
+	case toolCalls:
+		return "tool_calls"
+	case doneReason == "length":
+		return "length"
+	}
+	return "stop"
+}
+
+func created(answer models.OllamaChatResponse) int64 {
+	if answer.CreatedAt.IsZero() {
+		return time.Now().Unix()
+	}
+	return answer.CreatedAt.Unix()
+}
+
+func (s LLM) bufferOllamaResponse(input *models.PipelineMessage, body io.Reader, start time.Time) (*models.PipelineMessage, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		s.Logger.Error("Read error", zap.Error(err))
+		return nil, err
+	}
+	telemetry.ObserveTimeToFirstToken(s.model(input), time.Since(start))
+	s.Logger.Info("Buffered response", zap.ByteString("data", data))
+	var answer models.OllamaChatResponse
+	if err := json.Unmarshal(data, &answer); err != nil {
+		s.Logger.Error("Unmarshal error", zap.Error(err))
+		return nil, err
+	}
+	input.AddUsage(s.model(input), answer.Usage())
+
+	message := models.ChatMessage{Role: "assistant", Content: answer.Message.Content}
+	if len(answer.Message.ToolCalls) > 0 {
+		calls := toolCalls(answer.Message.ToolCalls)
+		message.ToolCalls = &calls
+	}
+	resp := &models.ChatCompletionResponse{
+		ID:      utils.NewID("chatcmpl-"),
+		Choices: []models.ChatCompletionChoice{{FinishReason: finishReason(answer.DoneReason, message.ToolCalls != nil), Message: message}},
+		Created: created(answer),
+		Model:   answer.Model,
+		Object:  "chat.completion",
+	}
+	if total := input.TotalUsage(); total != (models.Usage{}) {
+		resp.Usage = &total
+	}
+
+	w := input.ResponseWriter
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.Logger.Error("Write error", zap.Error(err))
+		return nil, err
+	}
+	input.Response = resp
+	return input, nil
+}
+
+// streamOllamaResponse relays Ollama's NDJSON stream as a chat completion stream.
+func (s LLM) streamOllamaResponse(input *models.PipelineMessage, body io.Reader, start time.Time) (*models.PipelineMessage, error) {
+	stream := newChunkStream(input.ResponseWriter, s.model(input), start)
+	calls := 0
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		s.Logger.Info("Stream chunk", zap.ByteString("chunk", line))
+		if len(line) == 0 {
+			continue
+		}
+		var answer models.OllamaChatResponse
+		if err := json.Unmarshal(line, &answer); err != nil {
+			s.Logger.Error("Unparseable stream chunk", zap.ByteString("chunk", line), zap.Error(err))
+			continue
+		}
+		if calls == 0 && stream.tokens == 0 {
+			stream.resp.Created, stream.resp.Model = created(answer), answer.Model
+		}
+		choice := models.ChatCompletionChunkChoice{Delta: models.ChatCompletionChunkDelta{Content: answer.Message.Content}}
+		for _, call := range toolCalls(answer.Message.ToolCalls) {
+			choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, models.ChatCompletionToolCallDelta{Index: calls, ChatCompletionsMessageToolCall: call})
+			calls++
+		}
+		if answer.Done {
+			reason := finishReason(answer.DoneReason, calls > 0)
+			choice.FinishReason = &reason
+			input.AddUsage(s.model(input), answer.Usage())
+		} else if choice.Delta.Content == "" && len(choice.Delta.ToolCalls) == 0 {
+			continue
+		}
+		if err := stream.send(models.ChatCompletionChunk{Choices: []models.ChatCompletionChunkChoice{choice}}); err != nil {
+			s.Logger.Error("Write error during stream", zap.Error(err))
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		s.Logger.Error("Stream scanner error", zap.Error(err))
+		return nil, err
+	}
+	return stream.finish(input)
+}
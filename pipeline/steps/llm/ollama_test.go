@@ -0,0 +1,123 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+)
+
+func newOllamaStep(t *testing.T, upstream http.HandlerFunc) LLM {
+	t.Helper()
+	step := newStep(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+		upstream(w, r)
+	})
+	step.Provider = ProviderOllama
+	return step
+}
+
+func TestOllama_RequestMapsConfig(t *testing.T) {
+	var request map[string]any
+	step := newOllamaStep(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		io.WriteString(w, `{"model":"mistral","message":{"role":"assistant","content":"No."},"done":true}`)
+	})
+	temperature, maxTokens, numCtx, keepAlive := 0.2, int64(64), 8192, "10m"
+	step.Temperature, step.MaxTokens, step.NumCtx, step.KeepAlive = &temperature, &maxTokens, &numCtx, &keepAlive
+	step.Options = map[string]any{"seed": 42, "temperature": 0.5}
+	step.Format = "json"
+
+	calls := []models.ChatCompletionsMessageToolCall{{ID: "call_1", Type: "function", Function: models.ChatCompletionsMessageFunctionCall{Name: "lights", Arguments: `{"on":true}`}}}
+	_, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "snidemind", Messages: []models.ChatMessage{
+		{Role: "user", Content: "lights"},
+		{Role: "assistant", ToolCalls: &calls},
+		{Role: "tool", ToolCallID: "call_1", Content: "on"},
+	}}, httptest.NewRecorder()))
+	require.NoError(t, err)
+
+	assert.Equal(t, "mistral", request["model"], "the step's model, not the client's")
+	assert.Equal(t, false, request["stream"])
+	assert.Equal(t, "json", request["format"])
+	assert.Equal(t, "10m", request["keep_alive"])
+	assert.Equal(t, map[string]any{"temperature": 0.5, "num_predict": float64(64), "num_ctx": float64(8192), "seed": float64(42)}, request["options"])
+	messages := request["messages"].([]any)
+	assert.Equal(t, map[string]any{"on": true}, messages[1].(map[string]any)["tool_calls"].([]any)[0].(map[string]any)["function"].(map[string]any)["arguments"])
+	assert.Equal(t, "lights", messages[2].(map[string]any)["tool_name"])
+}
+
+func TestOllama_BufferedResponse(t *testing.T) {
+	step := newOllamaStep(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"model":"mistral:7b","created_at":"2023-11-14T22:13:20Z","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"lights","arguments":{"on":true}}}]},"done":true,"done_reason":"stop","prompt_eval_count":10,"eval_count":3}`)
+	})
+	recorder := httptest.NewRecorder()
+	output, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "mistral"}, recorder))
+	require.NoError(t, err)
+
+	response := output.Response
+	assert.True(t, strings.HasPrefix(response.ID, "chatcmpl-"))
+	assert.Equal(t, int64(1700000000), response.Created)
+	assert.Equal(t, "mistral:7b", response.Model)
+	assert.Equal(t, "tool_calls", response.Choices[0].FinishReason)
+	require.NotNil(t, response.Choices[0].Message.ToolCalls)
+	call := (*response.Choices[0].Message.ToolCalls)[0]
+	assert.Equal(t, "lights", call.Function.Name)
+	assert.JSONEq(t, `{"on":true}`, call.Function.Arguments)
+	assert.NotEmpty(t, call.ID)
+	assert.Equal(t, models.Usage{PromptTokens: 15, CompletionTokens: 3, TotalTokens: 18}, *response.Usage, "usage includes the earlier embedding")
+
+	var sent models.ChatCompletionResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &sent))
+	assert.Equal(t, *response.Usage, *sent.Usage)
+	assert.Equal(t, response.ID, sent.ID)
+}
+
+func TestOllama_StreamedResponse(t *testing.T) {
+	step := newOllamaStep(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"model":"mistral","created_at":"2023-11-14T22:13:20Z","message":{"role":"assistant","content":"Hel"},"done":false}`+"\n"+
+			`{"model":"mistral","created_at":"2023-11-14T22:13:20Z","message":{"role":"assistant","content":"lo"},"done":false}`+"\n"+
+			`{"model":"mistral","created_at":"2023-11-14T22:13:21Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"length","prompt_eval_count":10,"eval_count":2}`+"\n")
+	})
+	stream := true
+	recorder := httptest.NewRecorder()
+	request := models.ChatCompletionRequest{Model: "mistral", Stream: &stream, StreamOptions: &models.StreamOptions{IncludeUsage: true}}
+	output, err := step.Process(nil, newUsageMessage(request, recorder))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hello", output.Response.Choices[0].Message.Content)
+	assert.Equal(t, "length", output.Response.Choices[0].FinishReason)
+	assert.Equal(t, models.Usage{PromptTokens: 15, CompletionTokens: 2, TotalTokens: 17}, *output.Response.Usage)
+
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	events := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n\n")
+	require.Len(t, events, 5)
+	assert.Equal(t, "data: [DONE]", events[4])
+	var first, usage models.ChatCompletionChunk
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(events[0], "data: ")), &first))
+	assert.Equal(t, "chat.completion.chunk", first.Object)
+	assert.Equal(t, "assistant", first.Choices[0].Delta.Role)
+	assert.Equal(t, "Hel", first.Choices[0].Delta.Content)
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(events[3], "data: ")), &usage))
+	assert.Empty(t, usage.Choices)
+	assert.Equal(t, 17, usage.Usage.TotalTokens)
+}
+
+func TestOllama_ErrorIsMappedThrough(t *testing.T) {
+	step := newOllamaStep(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error":"model \"mistral\" not found, try pulling it first"}`)
+	})
+	_, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "mistral"}, httptest.NewRecorder()))
+
+	var statusErr *models.StatusError
+	require.True(t, errors.As(err, &statusErr), "expected a StatusError, got %v", err)
+	assert.Equal(t, http.StatusNotFound, statusErr.Status)
+	assert.Contains(t, statusErr.Detail.Message, "try pulling it first")
+}
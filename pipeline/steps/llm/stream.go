@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/telemetry"
+	"github.com/teagan42/snidemind/utils"
+)
+
+// accumulate adds a stream chunk to the response being assembled from the stream, so the pipeline (and the
+// completion store) see the whole answer rather than a pile of chunks.
+func accumulate(resp *models.ChatCompletionResponse, payload string) {
+	var chunk models.ChatCompletionChunk
+	if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+		return
+	}
+	if chunk.ID != "" {
+		resp.ID = chunk.ID
+	}
+	if chunk.Created != 0 {
+		resp.Created = chunk.Created
+	}
+	if chunk.Model != "" {
+		resp.Model = chunk.Model
+	}
+	if len(chunk.Choices) == 0 {
+		return
+	}
+	choice := chunk.Choices[0]
+	message := &resp.Choices[0].Message
+	if choice.Delta.Role != "" {
+		message.Role = choice.Delta.Role
+	}
+	message.Content += choice.Delta.Content
+	for _, delta := range choice.Delta.ToolCalls {
+		message.ToolCalls = mergeToolCall(message.ToolCalls, delta)
+	}
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		resp.Choices[0].FinishReason = *choice.FinishReason
+	}
+}
+
+func mergeToolCall(toolCalls *[]models.ChatCompletionsMessageToolCall, delta models.ChatCompletionToolCallDelta) *[]models.ChatCompletionsMessageToolCall {
+	if toolCalls == nil {
+		toolCalls = &[]models.ChatCompletionsMessageToolCall{}
+	}
+	if delta.Index < 0 {
+		return toolCalls
+	}
+	for len(*toolCalls) <= delta.Index {
+		*toolCalls = append(*toolCalls, models.ChatCompletionsMessageToolCall{})
+	}
+	call := &(*toolCalls)[delta.Index]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Type != "" {
+		call.Type = delta.Type
+	}
+	if delta.Function.Name != "" {
+		call.Function.Name = delta.Function.Name
+	}
+	call.Function.Arguments += delta.Function.Arguments
+	return toolCalls
+}
+
+// chunkStream writes the client a chat completion stream translated from a provider that streams something
+// else, assembling the response from the chunks as it goes like streamResponse does.
+type chunkStream struct {
+	w          http.ResponseWriter
+	resp       *models.ChatCompletionResponse
+	model      string // The configured model, for metrics
+	start      time.Time
+	firstToken time.Time
+	tokens     int
+}
+
+func newChunkStream(w http.ResponseWriter, model string, start time.Time) *chunkStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	return &chunkStream{
+		w: w,
+		resp: &models.ChatCompletionResponse{
+			ID:      utils.NewID("chatcmpl-"),
+			Choices: []models.ChatCompletionChoice{{FinishReason: "stop", Message: models.ChatMessage{Role: "assistant"}}},
+			Created: time.Now().Unix(),
+			Model:   model,
+			Object:  "chat.completion",
+		},
+		model: model,
+		start: start,
+	}
+}
+
+// send writes one chunk with the stream's ID, creation time and model. Every chunk counts as a token.
+func (c *chunkStream) send(chunk models.ChatCompletionChunk) error {
+	chunk.ID, chunk.Object, chunk.Created, chunk.Model = c.resp.ID, "chat.completion.chunk", c.resp.Created, c.resp.Model
+	if len(chunk.Choices) > 0 {
+		if c.tokens == 0 {
+			chunk.Choices[0].Delta.Role = "assistant"
+			c.firstToken = time.Now()
+			telemetry.ObserveTimeToFirstToken(c.model, c.firstToken.Sub(c.start))
+		}
+		c.tokens++
+	}
+	payload, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	accumulate(c.resp, string(payload))
+	return c.write(fmt.Sprintf("data: %s\n\n", payload))
+}
+
+func (c *chunkStream) write(data string) error {
+	if _, err := io.WriteString(c.w, data); err != nil {
+		return err
+	}
+	if flusher, ok := c.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// finish ends the stream, with a usage chunk first for clients that asked for one, and hands the assembled
+// response to the pipeline.
+func (c *chunkStream) finish(input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	if c.tokens > 1 {
+		telemetry.ObserveTokenRate(c.model, c.tokens-1, time.Since(c.firstToken))
+	}
+	total := input.TotalUsage()
+	if options := input.Request.StreamOptions; options != nil && options.IncludeUsage {
+		if err := c.send(models.ChatCompletionChunk{Choices: []models.ChatCompletionChunkChoice{}, Usage: &total}); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.write("data: [DONE]\n\n"); err != nil {
+		return nil, err
+	}
+	c.resp.Usage = &total
+	input.Response = c.resp
+	return input, nil
+}
@@ -0,0 +1,34 @@
+package llm
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+)
+
+func TestProcess_StreamAssemblesResponse(t *testing.T) {
+	step := newStep(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-1\",\"created\":1700000000,\"model\":\"mistral:7b\",\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"Hel\"}}]}\n\n"+
+			"data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n"+
+			"data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"lights\",\"arguments\":\"{\\\"on\\\":\"}}]}}]}\n\n"+
+			"data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"true}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n"+
+			"data: [DONE]\n\n")
+	})
+	stream := true
+	output, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "mistral", Stream: &stream}, httptest.NewRecorder()))
+	require.NoError(t, err)
+
+	response := output.Response
+	assert.Equal(t, "chatcmpl-1", response.ID)
+	assert.Equal(t, int64(1700000000), response.Created)
+	assert.Equal(t, "mistral:7b", response.Model)
+	assert.Equal(t, "Hello", response.Choices[0].Message.Content)
+	assert.Equal(t, "tool_calls", response.Choices[0].FinishReason)
+	require.NotNil(t, response.Choices[0].Message.ToolCalls)
+	assert.Equal(t, []models.ChatCompletionsMessageToolCall{{ID: "call_1", Type: "function", Function: models.ChatCompletionsMessageFunctionCall{Name: "lights", Arguments: `{"on":true}`}}}, *response.Choices[0].Message.ToolCalls)
+}
@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/teagan42/snidemind/models"
+)
+
+type usageChunk struct {
+	Choices []json.RawMessage `json:"choices"`
+	Usage   *models.Usage     `json:"usage"`
+}
+
+// streamUsage records the usage reported in a stream chunk and rewrites the chunk for the client: clients that
+// asked for usage get the request's running total, the others get the chunk without it. It reports false when
+// nothing is left worth forwarding.
+func (s LLM) streamUsage(input *models.PipelineMessage, line string) (string, bool) {
+	payload := []byte(strings.TrimPrefix(line, "data: "))
+	var chunk usageChunk
+	if err := json.Unmarshal(payload, &chunk); err != nil || chunk.Usage == nil {
+		return line, true
+	}
+	input.AddUsage(s.model(input), *chunk.Usage)
+	var usage *models.Usage
+	if options := input.Request.StreamOptions; options != nil && options.IncludeUsage {
+		total := input.TotalUsage()
+		usage = &total
+	} else if len(chunk.Choices) == 0 {
+		return "", false
+	}
+	rewritten, err := withUsage(payload, usage)
+	if err != nil {
+		return line, true
+	}
+	return "data: " + string(rewritten), true
+}
+
+// withUsage replaces the `usage` of a response body, or removes it when usage is nil, leaving the other fields untouched.
+func withUsage(body []byte, usage *models.Usage) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	if usage == nil {
+		delete(fields, "usage")
+	} else {
+		data, err := json.Marshal(usage)
+		if err != nil {
+			return nil, err
+		}
+		fields["usage"] = data
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+func newStep(t *testing.T, upstream http.HandlerFunc) LLM {
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+	model := "mistral"
+	return LLM{
+		LLMConfig: LLMConfig{Model: &model, BaseURL: server.URL},
+		Client:    server.Client(),
+		Logger:    zap.NewNop(),
+	}
+}
+
+func newUsageMessage(request models.ChatCompletionRequest, w http.ResponseWriter) *models.PipelineMessage {
+	message := &models.PipelineMessage{Request: &request, ResponseWriter: w, Usage: models.NewUsageMeter()}
+	// Tokens spent by an earlier step, e.g. embedding the request for tagging
+	message.AddUsage("nomic-embed-text", models.Usage{PromptTokens: 5, TotalTokens: 5})
+	return message
+}
+
+func TestProcess_BufferedResponseReportsTotalUsage(t *testing.T) {
+	step := newStep(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"1","choices":[],"system_fingerprint":"fp","usage":{"prompt_tokens":10,"completion_tokens":3,"total_tokens":13}}`)
+	})
+	recorder := httptest.NewRecorder()
+	output, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "mistral"}, recorder))
+	require.NoError(t, err)
+
+	want := models.Usage{PromptTokens: 15, CompletionTokens: 3, TotalTokens: 18}
+	assert.Equal(t, &want, output.Response.Usage)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, map[string]any{"prompt_tokens": 15.0, "completion_tokens": 3.0, "total_tokens": 18.0}, body["usage"])
+	assert.Equal(t, "fp", body["system_fingerprint"], "fields SnideMind doesn't model should survive the rewrite")
+	assert.Equal(t, models.Usage{PromptTokens: 10, CompletionTokens: 3, TotalTokens: 13}, output.Usage.ByModel()["mistral"])
+}
+
+const usageStream = "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n" +
+	"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":1,\"total_tokens\":11}}\n\n" +
+	"data: [DONE]\n\n"
+
+func TestProcess_StreamHidesUsageUnlessAsked(t *testing.T) {
+	var upstreamRequest models.ChatCompletionRequest
+	step := newStep(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&upstreamRequest)
+		io.WriteString(w, usageStream)
+	})
+	stream := true
+	recorder := httptest.NewRecorder()
+	output, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "mistral", Stream: &stream}, recorder))
+	require.NoError(t, err)
+
+	require.NotNil(t, upstreamRequest.StreamOptions)
+	assert.True(t, upstreamRequest.StreamOptions.IncludeUsage)
+	assert.NotContains(t, recorder.Body.String(), "usage")
+	assert.Contains(t, recorder.Body.String(), "data: [DONE]")
+	assert.Equal(t, 16, output.Response.Usage.TotalTokens)
+}
+
+func TestProcess_StreamWithoutAStepModel(t *testing.T) {
+	var upstreamRequest models.ChatCompletionRequest
+	step := newStep(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&upstreamRequest)
+		io.WriteString(w, usageStream)
+	})
+	step.Model = nil
+	stream := true
+	recorder := httptest.NewRecorder()
+	output, err := step.Process(nil, newUsageMessage(models.ChatCompletionRequest{Model: "llama3", Stream: &stream}, recorder))
+	require.NoError(t, err)
+
+	assert.Equal(t, "llama3", upstreamRequest.Model)
+	assert.Contains(t, recorder.Body.String(), "data: [DONE]")
+	assert.Equal(t, models.Usage{PromptTokens: 10, CompletionTokens: 1, TotalTokens: 11}, output.Usage.ByModel()["llama3"], "the usage is the client's model's")
+}
+
+func TestProcess_StreamReportsTotalUsageWhenAsked(t *testing.T) {
+	step := newStep(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, usageStream)
+	})
+	stream := true
+	recorder := httptest.NewRecorder()
+	request := models.ChatCompletionRequest{Model: "mistral", Stream: &stream, StreamOptions: &models.StreamOptions{IncludeUsage: true}}
+	_, err := step.Process(nil, newUsageMessage(request, recorder))
+	require.NoError(t, err)
+
+	var usageLine string
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if strings.Contains(line, "usage") {
+			usageLine = line
+		}
+	}
+	assert.JSONEq(t, `{"choices":[],"usage":{"prompt_tokens":15,"completion_tokens":1,"total_tokens":16}}`, strings.TrimPrefix(usageLine, "data: "))
+}
+
+func TestWithUsage_RemovesUsage(t *testing.T) {
+	body, err := withUsage([]byte(`{"id":"1","usage":{"total_tokens":3}}`), nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"1"}`, string(body))
+}
@@ -2,22 +2,28 @@ package steps
 
 import (
 	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/steps/exec"
 	extracttags "github.com/teagan42/snidemind/pipeline/steps/extractTags"
 	"github.com/teagan42/snidemind/pipeline/steps/fork"
 	"github.com/teagan42/snidemind/pipeline/steps/llm"
+	pipelineref "github.com/teagan42/snidemind/pipeline/steps/pipelineRef"
 	reducetools "github.com/teagan42/snidemind/pipeline/steps/reduceTools"
 	retrievememory "github.com/teagan42/snidemind/pipeline/steps/retrieveMemory"
+	"github.com/teagan42/snidemind/pipeline/steps/router"
 	storememory "github.com/teagan42/snidemind/pipeline/steps/storeMemory"
 	"go.uber.org/fx"
 )
 
 var Module = fx.Module(
 	"steps",
+	exec.Module,
 	extracttags.Module,
 	fork.Module,
 	llm.Module,
+	pipelineref.Module,
 	reducetools.Module,
 	retrievememory.Module,
+	router.Module,
 	storememory.Module,
 	fx.Provide(
 		fx.Annotate(
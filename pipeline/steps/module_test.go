@@ -42,6 +42,9 @@ func TestStepsModule_ProvidesPipelineStepFactoryMap(t *testing.T) {
 			if _, ok := p.StepMap["storeMemory"]; !ok {
 				t.Error("Expected 'storeMemory' to be in pipelineStepFactoryMap, got nil")
 			}
+			if _, ok := p.StepMap["exec"]; !ok {
+				t.Error("Expected 'exec' to be in pipelineStepFactoryMap, got nil")
+			}
 			if _, ok := p.StepMap["extractTags"]; !ok {
 				t.Error("Expected 'extractTags' to be in pipelineStepFactoryMap, got nil")
 			}
@@ -57,6 +60,12 @@ func TestStepsModule_ProvidesPipelineStepFactoryMap(t *testing.T) {
 			if _, ok := p.StepMap["retrieveMemory"]; !ok {
 				t.Error("Expected 'retrieveMemory' to be in pipelineStepFactoryMap, got nil")
 			}
+			if _, ok := p.StepMap["pipeline"]; !ok {
+				t.Error("Expected 'pipeline' to be in pipelineStepFactoryMap, got nil")
+			}
+			if _, ok := p.StepMap["router"]; !ok {
+				t.Error("Expected 'router' to be in pipelineStepFactoryMap, got nil")
+			}
 		}),
 	)
 	app.RequireStart()
@@ -0,0 +1,10 @@
+package pipelineref
+
+import "go.uber.org/fx"
+
+var Module = fx.Module(
+	"pipelineref",
+	fx.Provide(
+		NewPipelineRef,
+	),
+)
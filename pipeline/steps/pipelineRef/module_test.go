@@ -0,0 +1,45 @@
+package pipelineref
+
+import (
+	"testing"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func TestPipelineRefModule_IsNotNil(t *testing.T) {
+	if pipelineRefModule := fx.Option(Module); pipelineRefModule == nil {
+		t.Error("pipelineref.Module should not be nil")
+	}
+}
+
+type PipelineRefTestParams struct {
+	fx.In
+	Factory []models.PipelineStepFactory `group:"pipelineStepFactory"`
+}
+
+func TestPipelineRefModule_ProvidesPipelineRef(t *testing.T) {
+	app := fxtest.New(
+		t,
+		fx.Provide(func() *zap.Logger {
+			return zap.NewNop()
+		}),
+		fx.Provide(func() *config.Config {
+			return &config.Config{}
+		}),
+		Module,
+		fx.Invoke(func(p PipelineRefTestParams) {
+			if len(p.Factory) == 0 {
+				t.Error("Expected PipelineRef to be provided, got empty slice")
+			}
+			if p.Factory[0].Name() != "pipeline" {
+				t.Errorf("Expected factory name to be 'pipeline', got '%s'", p.Factory[0].Name())
+			}
+		}),
+	)
+	app.RequireStart()
+	app.RequireStop()
+}
@@ -0,0 +1,131 @@
+package pipelineref
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teagan42/snidemind/completions"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/build"
+	"github.com/teagan42/snidemind/telemetry"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type PipelineRef struct {
+	Ref    string
+	Steps  []models.PipelineStep
+	Store  bool     // The referenced pipeline is configured to store its completions
+	Models []string // The configured model names, the only ones used as metric labels
+	Logger *zap.Logger
+}
+
+type Params struct {
+	fx.In
+	Config *config.Config
+	Logger *zap.Logger
+}
+
+type Result struct {
+	fx.Out
+	Factory models.PipelineStepFactory `group:"pipelineStepFactory"`
+}
+
+type PipelineRefFactory struct {
+	Config *config.Config
+	Logger *zap.Logger
+}
+
+func (f PipelineRefFactory) Name() string {
+	return "pipeline"
+}
+
+// PipelineRefConfig is read from the step's own node: `{type: pipeline, ref: <name>}`.
+type PipelineRefConfig struct {
+	Ref string `json:"ref" yaml:"ref" validate:"required"`
+}
+
+func (f PipelineRefFactory) decode(stepConfig config.PipelineStepConfig) (PipelineRefConfig, config.PipelineConfig, error) {
+	refConfig, err := config.DecodeSection[PipelineRefConfig](stepConfig, "")
+	if err != nil {
+		return refConfig, config.PipelineConfig{}, err
+	}
+	if f.Config == nil {
+		return refConfig, config.PipelineConfig{}, fmt.Errorf("ref: unknown pipeline %q", refConfig.Ref)
+	}
+	pipelineConfig, ok := f.Config.GetPipeline(refConfig.Ref)
+	if !ok {
+		return refConfig, config.PipelineConfig{}, fmt.Errorf("ref: unknown pipeline %q", refConfig.Ref)
+	}
+	return refConfig, pipelineConfig, nil
+}
+
+// ValidateConfig only checks the ref resolves; the named pipeline's own steps are validated under pipelines.<name>.
+func (f PipelineRefFactory) ValidateConfig(stepConfig config.PipelineStepConfig) error {
+	_, _, err := f.decode(stepConfig)
+	return err
+}
+
+// Build expands the named pipeline in place. Reference cycles are rejected when the config is loaded.
+func (f PipelineRefFactory) Build(stepConfig config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	refConfig, pipelineConfig, err := f.decode(stepConfig)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := build.Steps("pipeline", pipelineConfig.Steps, stepFactories, f.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline %s: %w", refConfig.Ref, err)
+	}
+	f.Logger.Info("Pipeline reference built", zap.String("ref", refConfig.Ref), zap.Int("steps", len(steps)))
+	return &PipelineRef{
+		Ref:    refConfig.Ref,
+		Steps:  steps,
+		Store:  pipelineConfig.Store,
+		Models: f.Config.ModelNames(),
+		Logger: f.Logger.Named("PipelineRef"),
+	}, nil
+}
+
+func NewPipelineRef(p Params) (Result, error) {
+	return Result{
+		Factory: PipelineRefFactory{
+			Config: p.Config,
+			Logger: p.Logger.Named("PipelineRefFactory"),
+		},
+	}, nil
+}
+
+func (s PipelineRef) Name() string {
+	return "pipeline:" + s.Ref
+}
+
+func (s PipelineRef) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	if !input.Identity.AllowsPipeline(s.Ref) {
+		s.Logger.Warn("Identity is not allowed to run pipeline", zap.String("ref", s.Ref), zap.String("identity", input.Identity.Name))
+		return nil, fmt.Errorf("%w: %s is not allowed to run pipeline %s", models.ErrForbidden, input.Identity.Name, s.Ref)
+	}
+	if s.Store {
+		models.SetState(input, completions.RequestedKey, true)
+	}
+	model := ""
+	if input.Request != nil {
+		model = input.Request.Model
+	}
+	start := time.Now()
+	output, err := s.run(previous, input)
+	telemetry.ObservePipeline(s.Ref, model, s.Models, err, time.Since(start))
+	return output, err
+}
+
+func (s PipelineRef) run(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	for _, step := range s.Steps {
+		var err error
+		if input, err = step.Process(previous, input); err != nil {
+			s.Logger.Error("Error processing referenced step", zap.String("ref", s.Ref), zap.String("step", step.Name()), zap.Error(err))
+			return nil, fmt.Errorf("pipeline %s: %w", s.Ref, err)
+		}
+		previous = &[]models.PipelineStep{step}
+	}
+	return input, nil
+}
@@ -0,0 +1,98 @@
+package pipelineref
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+type appendStep struct {
+	name string
+	err  error
+}
+
+func (s *appendStep) Name() string { return s.name }
+func (s *appendStep) Process(_ *[]models.PipelineStep, msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	*msg.Memories = append(*msg.Memories, s.name)
+	return msg, nil
+}
+
+type appendFactory struct {
+	name string
+	err  error
+}
+
+func (f appendFactory) Name() string { return f.name }
+func (f appendFactory) Build(_ config.PipelineStepConfig, _ map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	return &appendStep{name: f.name, err: f.err}, nil
+}
+
+func newFactory() PipelineRefFactory {
+	return PipelineRefFactory{
+		Logger: zap.NewNop(),
+		Config: &config.Config{
+			Pipelines: map[string]config.PipelineConfig{
+				"memorylookup": {Steps: []config.PipelineStepConfig{{Type: "first"}, {Type: "second"}}},
+				"broken":       {Steps: []config.PipelineStepConfig{{Type: "failing"}}},
+			},
+		},
+	}
+}
+
+var stepFactories = map[string]models.PipelineStepFactory{
+	"first":   appendFactory{name: "first"},
+	"second":  appendFactory{name: "second"},
+	"failing": appendFactory{name: "failing", err: errors.New("boom")},
+}
+
+func TestPipelineRefFactory_Build(t *testing.T) {
+	step, err := newFactory().Build(config.PipelineStepConfig{Type: "pipeline", Raw: map[string]any{"ref": "memoryLookup"}}, stepFactories)
+	assert.NoError(t, err)
+	assert.Equal(t, "pipeline:memoryLookup", step.Name())
+
+	msg := &models.PipelineMessage{Memories: &[]string{}}
+	out, err := step.Process(nil, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, *out.Memories)
+}
+
+func TestPipelineRefFactory_Build_Errors(t *testing.T) {
+	factory := newFactory()
+	_, err := factory.Build(config.PipelineStepConfig{Type: "pipeline"}, stepFactories)
+	assert.EqualError(t, err, `ref: failed "required"`)
+
+	_, err = factory.Build(config.PipelineStepConfig{Type: "pipeline", Raw: map[string]any{"ref": "missing"}}, stepFactories)
+	assert.EqualError(t, err, `ref: unknown pipeline "missing"`)
+
+	_, err = factory.Build(config.PipelineStepConfig{Type: "pipeline", Raw: map[string]any{"ref": "memoryLookup"}}, map[string]models.PipelineStepFactory{})
+	assert.EqualError(t, err, "pipeline memoryLookup: unknown pipeline step type: first")
+}
+
+func TestPipelineRef_Process_Error(t *testing.T) {
+	step, err := newFactory().Build(config.PipelineStepConfig{Type: "pipeline", Raw: map[string]any{"ref": "broken"}}, stepFactories)
+	assert.NoError(t, err)
+	_, err = step.Process(nil, &models.PipelineMessage{Memories: &[]string{}})
+	assert.EqualError(t, err, "pipeline broken: boom")
+}
+
+func TestPipelineRef_Process_ChecksIdentity(t *testing.T) {
+	step, err := newFactory().Build(config.PipelineStepConfig{Type: "pipeline", Raw: map[string]any{"ref": "memoryLookup"}}, stepFactories)
+	assert.NoError(t, err)
+
+	allowed := &models.PipelineMessage{Memories: &[]string{}, Identity: &models.Identity{Name: "kitchen", Pipelines: []string{"MemoryLookup"}}}
+	_, err = step.Process(nil, allowed)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, *allowed.Memories)
+
+	denied := &models.PipelineMessage{Memories: &[]string{}, Identity: &models.Identity{Name: "kitchen", Pipelines: []string{"broken"}}}
+	_, err = step.Process(nil, denied)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	assert.Empty(t, *denied.Memories)
+}
@@ -14,11 +14,13 @@ import (
 type ReduceTools struct {
 	Logger  *zap.Logger
 	ToolSet []models.MCPTool
+	Servers models.ToolSource // The MCP servers' tools, nil without the mcp module
 }
 
 type Params struct {
 	fx.In
-	Logger *zap.Logger
+	Logger  *zap.Logger
+	Servers models.ToolSource `optional:"true"`
 }
 
 type Result struct {
@@ -29,6 +31,7 @@ type Result struct {
 type ReduceToolsFactory struct {
 	Logger  *zap.Logger
 	ToolSet []models.MCPTool
+	Servers models.ToolSource
 }
 
 func (f ReduceToolsFactory) Name() string {
@@ -38,13 +41,15 @@ func (f ReduceToolsFactory) Build(config config.PipelineStepConfig, stepFactorie
 	return &ReduceTools{
 		Logger:  f.Logger.Named("ReduceTools"),
 		ToolSet: f.ToolSet,
+		Servers: f.Servers,
 	}, nil
 }
 
 func NewReduceTools(p Params) (Result, error) {
 	return Result{
 		Factory: ReduceToolsFactory{
-			Logger: p.Logger.Named("ReduceToolsFactory"),
+			Logger:  p.Logger.Named("ReduceToolsFactory"),
+			Servers: p.Servers,
 			ToolSet: []models.MCPTool{
 				{
 					ToolMetadata: models.ToolMetadata{
@@ -83,10 +88,10 @@ func (s ReduceTools) Process(previous *[]models.PipelineStep, input *models.Pipe
 	}
 	tags := slices.Collect(maps.Values(*input.Tags))
 	input.Tools = &[]models.MCPTool{}
-	for _, tool := range s.ToolSet {
+	for _, tool := range s.tools(input.Identity) {
 		s.Logger.Debug("Checking tool", zap.String("tool", tool.ToolMetadata.Name))
 
-		if len(utils.Intersection(tags, *tool.ToolMetadata.Tags)) > 0 {
+		if tool.ToolMetadata.Tags != nil && len(utils.Intersection(tags, *tool.ToolMetadata.Tags)) > 0 {
 			s.Logger.Debug("Tool matches tags", zap.String("tool", tool.ToolMetadata.Name))
 			*input.Tools = append(*input.Tools, tool)
 		} else {
@@ -96,3 +101,18 @@ func (s ReduceTools) Process(previous *[]models.PipelineStep, input *models.Pipe
 
 	return input, nil
 }
+
+// tools are the tools to choose from: the tool set, and the tools of the MCP servers that are up and the
+// identity may use. A server that goes down takes its tools with it until it's back.
+func (s ReduceTools) tools(identity *models.Identity) []models.MCPTool {
+	if s.Servers == nil {
+		return s.ToolSet
+	}
+	tools := slices.Clone(s.ToolSet)
+	for _, tool := range s.Servers.Tools() {
+		if identity.AllowsMCPServer(tool.Server) {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
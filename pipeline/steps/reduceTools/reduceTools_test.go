@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/teagan42/snidemind/models"
 	"go.uber.org/zap"
 )
@@ -180,3 +181,52 @@ func TestReduceTools_Process_NoMatchingTags(t *testing.T) {
 	assert.NotNil(t, result.Tools)
 	assert.Len(t, *result.Tools, 0)
 }
+
+// servers stands in for the MCP clients: only the servers that are up have tools.
+type servers map[string][]string
+
+func (s servers) Tools() []models.MCPTool {
+	tools := []models.MCPTool{}
+	for server, names := range s {
+		for _, name := range names {
+			tools = append(tools, models.MCPTool{ToolMetadata: models.ToolMetadata{Name: name, Tags: &[]string{server, name}}, Server: server})
+		}
+	}
+	return tools
+}
+
+func TestReduceTools_Process_ServerTools(t *testing.T) {
+	up := servers{"home": {"light_on"}, "plex": {"search"}}
+	rt := ReduceTools{Logger: zap.NewNop(), Servers: up}
+	tags := map[string]string{"home": "home", "plex": "plex"}
+
+	result, err := rt.Process(nil, &models.PipelineMessage{Tags: &tags})
+	assert.NoError(t, err)
+	assert.Len(t, *result.Tools, 2)
+
+	result, err = rt.Process(nil, &models.PipelineMessage{Tags: &tags, Identity: &models.Identity{Name: "kitchen", MCPServers: []string{"home"}}})
+	assert.NoError(t, err)
+	assert.Len(t, *result.Tools, 1, "only the servers the identity may use")
+	assert.Equal(t, "light_on", (*result.Tools)[0].ToolMetadata.Name)
+
+	delete(up, "plex")
+	result, err = rt.Process(nil, &models.PipelineMessage{Tags: &tags})
+	assert.NoError(t, err)
+	assert.Len(t, *result.Tools, 1, "a server that's down takes its tools with it")
+}
+
+func TestReduceTools_Process_DropsToolsOfServersThatGoDown(t *testing.T) {
+	up := servers{"home": {"light_on"}, "plex": {"search"}}
+	rt := ReduceTools{Logger: zap.NewNop(), Servers: up}
+	tags := map[string]string{"media.search": "search"}
+
+	result, err := rt.Process(nil, &models.PipelineMessage{Tags: &tags})
+	assert.NoError(t, err)
+	require.Len(t, *result.Tools, 1, "the tag picks the tool by its name")
+	assert.Equal(t, "search", (*result.Tools)[0].ToolMetadata.Name)
+
+	delete(up, "plex")
+	result, err = rt.Process(nil, &models.PipelineMessage{Tags: &tags})
+	assert.NoError(t, err)
+	assert.Empty(t, *result.Tools, "not while its server is down")
+}
@@ -0,0 +1,15 @@
+package router
+
+import "github.com/teagan42/snidemind/config"
+
+type RouteConfig struct {
+	Name     string                `json:"name,omitempty" yaml:"name,omitempty" validate:"omitempty"`
+	Match    *config.StepCondition `json:"match,omitempty" yaml:"match,omitempty" validate:"omitempty"`
+	Pipeline config.PipelineConfig `json:"pipeline" yaml:"pipeline" validate:"required"`
+}
+
+// RouterConfig is the `router` section of a router step.
+type RouterConfig struct {
+	Routes  []RouteConfig          `json:"routes" yaml:"routes" validate:"required,min=1,dive"`
+	Default *config.PipelineConfig `json:"default,omitempty" yaml:"default,omitempty" validate:"omitempty"`
+}
@@ -0,0 +1,41 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func TestRouterModule_IsNotNil(t *testing.T) {
+	if routerModule := fx.Option(Module); routerModule == nil {
+		t.Error("router.Module should not be nil")
+	}
+}
+
+type RouterTestParams struct {
+	fx.In
+	Factory []models.PipelineStepFactory `group:"pipelineStepFactory"`
+}
+
+func TestRouterModule_ProvidesRouter(t *testing.T) {
+	app := fxtest.New(
+		t,
+		fx.Provide(func() *zap.Logger {
+			return zap.NewNop()
+		}),
+		Module,
+		fx.Invoke(func(p RouterTestParams) {
+			if len(p.Factory) == 0 {
+				t.Error("Expected Router to be provided, got empty slice")
+			}
+			if p.Factory[0].Name() != "router" {
+				t.Errorf("Expected factory name to be 'router', got '%s'", p.Factory[0].Name())
+			}
+		}),
+	)
+	app.RequireStart()
+	app.RequireStop()
+}
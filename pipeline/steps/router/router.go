@@ -0,0 +1,142 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/build"
+	"github.com/teagan42/snidemind/pipeline/condition"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type Route struct {
+	Name  string
+	Match *config.StepCondition
+	Steps []models.PipelineStep
+}
+
+type RouterPipelineStage struct {
+	Routes  []Route
+	Default *[]models.PipelineStep
+	Logger  *zap.Logger
+}
+
+type Params struct {
+	fx.In
+	Logger *zap.Logger
+}
+
+type Result struct {
+	fx.Out
+	Factory models.PipelineStepFactory `group:"pipelineStepFactory"`
+}
+
+type RouterPipelineStageFactory struct {
+	Logger *zap.Logger
+}
+
+func (f RouterPipelineStageFactory) Name() string {
+	return "router"
+}
+
+func (f RouterPipelineStageFactory) ValidateConfig(stepConfig config.PipelineStepConfig) error {
+	_, err := config.DecodeSection[RouterConfig](stepConfig, "router")
+	return err
+}
+
+func (f RouterPipelineStageFactory) NestedPipelines(stepConfig config.PipelineStepConfig) []models.NestedPipeline {
+	routerConfig, err := config.DecodeSection[RouterConfig](stepConfig, "router")
+	if err != nil {
+		return nil
+	}
+	nested := []models.NestedPipeline{}
+	for i, route := range routerConfig.Routes {
+		nested = append(nested, models.NestedPipeline{Path: fmt.Sprintf("router.routes[%d].pipeline", i), Config: route.Pipeline})
+	}
+	if routerConfig.Default != nil {
+		nested = append(nested, models.NestedPipeline{Path: "router.default", Config: *routerConfig.Default})
+	}
+	return nested
+}
+
+func (f RouterPipelineStageFactory) Build(stepConfig config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	f.Logger.Info("Building Router Stage", zap.Any("config", stepConfig))
+	routerConfig, err := config.DecodeSection[RouterConfig](stepConfig, "router")
+	if err != nil {
+		return nil, err
+	}
+	var routes = []Route{}
+	for i, routeConfig := range routerConfig.Routes {
+		steps, err := build.Steps("router", routeConfig.Pipeline.Steps, stepFactories, f.Logger)
+		if err != nil {
+			return nil, err
+		}
+		name := routeConfig.Name
+		if name == "" {
+			name = fmt.Sprintf("route-%d", i)
+		}
+		routes = append(routes, Route{
+			Name:  name,
+			Match: routeConfig.Match,
+			Steps: steps,
+		})
+	}
+	var defaultSteps *[]models.PipelineStep
+	if routerConfig.Default != nil {
+		steps, err := build.Steps("router", routerConfig.Default.Steps, stepFactories, f.Logger)
+		if err != nil {
+			return nil, err
+		}
+		defaultSteps = &steps
+	}
+	f.Logger.Info("Router Stage Built", zap.Int("routes", len(routes)), zap.Bool("default", defaultSteps != nil))
+	return &RouterPipelineStage{
+		Routes:  routes,
+		Default: defaultSteps,
+		Logger:  f.Logger.Named("RouterPipelineStage"),
+	}, nil
+}
+
+func NewRouter(p Params) (Result, error) {
+	return Result{
+		Factory: RouterPipelineStageFactory{
+			Logger: p.Logger.Named("RouterPipelineStage"),
+		},
+	}, nil
+}
+
+func (r RouterPipelineStage) Name() string {
+	return "router"
+}
+
+func (r RouterPipelineStage) selectRoute(input *models.PipelineMessage) (string, *[]models.PipelineStep) {
+	for _, route := range r.Routes {
+		if condition.Matches(route.Match, input) {
+			return route.Name, &route.Steps
+		}
+	}
+	if r.Default != nil {
+		return "default", r.Default
+	}
+	return "", nil
+}
+
+func (r RouterPipelineStage) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	name, steps := r.selectRoute(input)
+	if steps == nil {
+		r.Logger.Info("No route matched, passing message through")
+		return input, nil
+	}
+	r.Logger.Info("Routing message", zap.String("route", name))
+	for _, step := range *steps {
+		var err error
+		if input, err = step.Process(previous, input); err != nil {
+			r.Logger.Error("Error processing routed step", zap.String("route", name), zap.String("step", step.Name()), zap.Error(err))
+			return nil, fmt.Errorf("route %s: %w", name, err)
+		}
+		previous = &[]models.PipelineStep{step}
+	}
+	return input, nil
+}
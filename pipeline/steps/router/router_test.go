@@ -0,0 +1,180 @@
+package router
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap/zaptest"
+)
+
+type dummyStep struct {
+	name string
+	err  error
+}
+
+func (d *dummyStep) Name() string { return d.name }
+func (d *dummyStep) Process(_ *[]models.PipelineStep, msg *models.PipelineMessage) (*models.PipelineMessage, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	(*msg.Tags)["routed"] = d.name
+	return msg, nil
+}
+
+type dummyFactory struct {
+	buildErr error
+	stepType string
+}
+
+func (d dummyFactory) Name() string { return d.stepType }
+func (d dummyFactory) Build(_ config.PipelineStepConfig, _ map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	if d.buildErr != nil {
+		return nil, d.buildErr
+	}
+	return &dummyStep{name: d.stepType}, nil
+}
+
+func newMessage(content string, tags map[string]string) *models.PipelineMessage {
+	return &models.PipelineMessage{
+		Request: &models.ChatCompletionRequest{
+			Messages: []models.ChatMessage{{Role: "user", Content: content}},
+			Model:    "mistral",
+		},
+		Tags: &tags,
+	}
+}
+
+func buildRouter(t *testing.T, withDefault bool) *RouterPipelineStage {
+	factory := RouterPipelineStageFactory{Logger: zaptest.NewLogger(t)}
+	research := config.RegexList{regexp.MustCompile(`(?i)research`)}
+	routerConfig := RouterConfig{
+		Routes: []RouteConfig{
+			{
+				Name:     "home",
+				Match:    &config.StepCondition{Tags: []string{"home.*"}},
+				Pipeline: config.PipelineConfig{Steps: []config.PipelineStepConfig{{Type: "fast"}}},
+			},
+			{
+				Name:     "research",
+				Match:    &config.StepCondition{Message: &research},
+				Pipeline: config.PipelineConfig{Steps: []config.PipelineStepConfig{{Type: "big"}}},
+			},
+		},
+	}
+	if withDefault {
+		routerConfig.Default = &config.PipelineConfig{Steps: []config.PipelineStepConfig{{Type: "fallback"}}}
+	}
+	stepFactories := map[string]models.PipelineStepFactory{
+		"fast":     dummyFactory{stepType: "fast"},
+		"big":      dummyFactory{stepType: "big"},
+		"fallback": dummyFactory{stepType: "fallback"},
+	}
+	stage, err := factory.Build(config.PipelineStepConfig{Type: "router", Raw: map[string]any{"router": routerConfig}}, stepFactories)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return stage.(*RouterPipelineStage)
+}
+
+func TestRouterPipelineStageFactory_Build_NilConfig(t *testing.T) {
+	factory := RouterPipelineStageFactory{Logger: zaptest.NewLogger(t)}
+	_, err := factory.Build(config.PipelineStepConfig{Type: "router"}, nil)
+	if err == nil || err.Error() != "router: section is required" {
+		t.Errorf("expected error 'router config is nil', got %v", err)
+	}
+}
+
+func TestRouterPipelineStageFactory_Build_NoRoutes(t *testing.T) {
+	factory := RouterPipelineStageFactory{Logger: zaptest.NewLogger(t)}
+	_, err := factory.Build(config.PipelineStepConfig{Type: "router", Raw: map[string]any{"router": RouterConfig{}}}, nil)
+	if err == nil || err.Error() != `router.routes: failed "required"` {
+		t.Errorf("expected error 'router config has no routes', got %v", err)
+	}
+}
+
+func TestRouterPipelineStageFactory_Build_UnknownStepType(t *testing.T) {
+	factory := RouterPipelineStageFactory{Logger: zaptest.NewLogger(t)}
+	cfg := config.PipelineStepConfig{Type: "router", Raw: map[string]any{"router": RouterConfig{
+		Routes: []RouteConfig{{Pipeline: config.PipelineConfig{Steps: []config.PipelineStepConfig{{Type: "unknown"}}}}},
+	}}}
+	_, err := factory.Build(cfg, map[string]models.PipelineStepFactory{})
+	if err == nil || err.Error() != "unknown pipeline step type: unknown" {
+		t.Errorf("expected error for unknown step type, got %v", err)
+	}
+}
+
+func TestRouterPipelineStageFactory_Build_FactoryBuildError(t *testing.T) {
+	factory := RouterPipelineStageFactory{Logger: zaptest.NewLogger(t)}
+	cfg := config.PipelineStepConfig{Type: "router", Raw: map[string]any{"router": RouterConfig{
+		Routes: []RouteConfig{{Pipeline: config.PipelineConfig{Steps: []config.PipelineStepConfig{{Type: "dummy"}}}}},
+	}}}
+	stepFactories := map[string]models.PipelineStepFactory{
+		"dummy": dummyFactory{stepType: "dummy", buildErr: errors.New("fail")},
+	}
+	_, err := factory.Build(cfg, stepFactories)
+	if err == nil || err.Error() != "failed to build pipeline step: fail" {
+		t.Errorf("expected error for failed build, got %v", err)
+	}
+}
+
+func TestRouterPipelineStage_Process_FirstMatchWins(t *testing.T) {
+	stage := buildRouter(t, true)
+	msg := newMessage("do some research on the lights", map[string]string{"home.lighting": "home.lighting"})
+	out, err := stage.Process(nil, msg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if (*out.Tags)["routed"] != "fast" {
+		t.Errorf("expected home route to run, got %q", (*out.Tags)["routed"])
+	}
+}
+
+func TestRouterPipelineStage_Process_RegexMatch(t *testing.T) {
+	stage := buildRouter(t, true)
+	msg := newMessage("Research quantum dots", map[string]string{})
+	out, err := stage.Process(nil, msg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if (*out.Tags)["routed"] != "big" {
+		t.Errorf("expected research route to run, got %q", (*out.Tags)["routed"])
+	}
+}
+
+func TestRouterPipelineStage_Process_Default(t *testing.T) {
+	stage := buildRouter(t, true)
+	msg := newMessage("hello", map[string]string{})
+	out, err := stage.Process(nil, msg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if (*out.Tags)["routed"] != "fallback" {
+		t.Errorf("expected default route to run, got %q", (*out.Tags)["routed"])
+	}
+}
+
+func TestRouterPipelineStage_Process_NoMatchNoDefault(t *testing.T) {
+	stage := buildRouter(t, false)
+	msg := newMessage("hello", map[string]string{})
+	out, err := stage.Process(nil, msg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := (*out.Tags)["routed"]; ok {
+		t.Error("expected message to pass through untouched")
+	}
+}
+
+func TestRouterPipelineStage_Process_StepError(t *testing.T) {
+	stage := &RouterPipelineStage{
+		Routes: []Route{{Name: "broken", Steps: []models.PipelineStep{&dummyStep{name: "bad", err: errors.New("boom")}}}},
+		Logger: zaptest.NewLogger(t),
+	}
+	_, err := stage.Process(nil, newMessage("hello", map[string]string{}))
+	if err == nil || err.Error() != "route broken: boom" {
+		t.Errorf("expected routed step error, got %v", err)
+	}
+}
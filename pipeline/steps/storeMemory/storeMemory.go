@@ -1,17 +1,27 @@
 package storememory
 
 import (
+	"maps"
+	"slices"
+	"strings"
+
 	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/memory"
 	"github.com/teagan42/snidemind/models"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
 )
 
+// StoreMemory remembers the last thing the user said, under the tags the message was given.
 type StoreMemory struct {
+	Logger *zap.Logger
+	Store  *memory.Store // Where memories are kept, nil without the memory module
 }
 
 type Params struct {
 	fx.In
-	Config config.PipelineStepConfig
+	Logger *zap.Logger
+	Store  *memory.Store `optional:"true"`
 }
 
 type Result struct {
@@ -19,18 +29,21 @@ type Result struct {
 	Factory models.PipelineStepFactory `group:"pipelineStepFactory"`
 }
 
-type StoreMemoryFactory struct{}
+type StoreMemoryFactory struct {
+	Logger *zap.Logger
+	Store  *memory.Store
+}
 
 func (f StoreMemoryFactory) Name() string {
 	return "storeMemory"
 }
 func (f StoreMemoryFactory) Build(config config.PipelineStepConfig, stepFactories map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
-	return &StoreMemory{}, nil
+	return &StoreMemory{Logger: f.Logger.Named("StoreMemory"), Store: f.Store}, nil
 }
 
-func NewStoreMemory() (Result, error) {
+func NewStoreMemory(p Params) (Result, error) {
 	return Result{
-		Factory: StoreMemoryFactory{},
+		Factory: StoreMemoryFactory{Logger: p.Logger, Store: p.Store},
 	}, nil
 }
 
@@ -38,9 +51,35 @@ func (s StoreMemory) Name() string {
 	return "StoreMemory"
 }
 
+// Process stores the last user message. Failing to remember doesn't fail the request, the answer is all the
+// client came for.
 func (s StoreMemory) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
-	// Placeholder for memory storage logic
-	// In a real implementation, this would process the input string and store it in memory
-	// For now, we'll just return nil to indicate success
+	if input == nil || input.Request == nil || s.Store == nil {
+		return input, nil
+	}
+	var text string
+	for _, message := range slices.Backward(input.Request.Messages) {
+		if message.Role == "user" {
+			text = strings.TrimSpace(message.Content)
+			break
+		}
+	}
+	if text == "" {
+		return input, nil
+	}
+	var tags []string
+	if input.Tags != nil {
+		tags = slices.Sorted(maps.Keys(*input.Tags))
+	}
+	owner := ""
+	if input.Identity != nil {
+		owner = input.Identity.Name
+	}
+	stored, err := s.Store.Add(owner, text, tags)
+	if err != nil {
+		s.Logger.Error("Error storing memory", zap.Error(err))
+		return input, nil
+	}
+	s.Logger.Debug("Memory stored", zap.String("id", stored.ID), zap.Strings("tags", tags))
 	return input, nil
 }
@@ -4,32 +4,75 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/memory"
 	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
 )
 
-func TestStoreMemory_Process_ReturnsInputUnchanged(t *testing.T) {
-	storeMemory := StoreMemory{}
+func newStep(t *testing.T) StoreMemory {
+	t.Helper()
+	result, err := memory.NewStore(memory.Params{Config: &config.Config{Memory: &config.MemoryConfig{Dir: t.TempDir()}}, Logger: zap.NewNop()})
+	require.NoError(t, err)
+	return StoreMemory{Logger: zap.NewNop(), Store: result.Store}
+}
+
+func TestStoreMemory_Process_RemembersTheLastUserMessage(t *testing.T) {
+	storeMemory := newStep(t)
 	prevSteps := []models.PipelineStep{}
 	input := &models.PipelineMessage{
 		Request: &models.ChatCompletionRequest{
 			Messages: []models.ChatMessage{
-				{
-					Role:    "user",
-					Content: "Hello, world!",
-				},
+				{Role: "user", Content: "Hello, world!"},
+				{Role: "assistant", Content: "Go away."},
+				{Role: "user", Content: " The cat is called Biscuit. "},
+				{Role: "system", Content: "Be nice."},
 			},
 			Model: "gpt-3.5-turbo",
 		},
+		Tags:     &map[string]string{"pets": "Pets", "home": "Home"},
+		Identity: &models.Identity{Name: "kitchen"},
 	}
 
 	result, err := storeMemory.Process(&prevSteps, input)
 
+	require.NoError(t, err)
+	assert.Equal(t, input, result, "Process should return the input unchanged")
+	memories := storeMemory.Store.List("kitchen")
+	require.Len(t, memories, 1)
+	assert.Equal(t, "The cat is called Biscuit.", memories[0].Text)
+	assert.Equal(t, []string{"home", "pets"}, memories[0].Tags)
+	assert.Empty(t, storeMemory.Store.List(""), "memories belong to whoever said them")
+}
+
+func TestStoreMemory_Process_NothingToRemember(t *testing.T) {
+	storeMemory := newStep(t)
+	prevSteps := []models.PipelineStep{}
+	input := &models.PipelineMessage{
+		Request: &models.ChatCompletionRequest{Messages: []models.ChatMessage{{Role: "system", Content: "Be nice."}, {Role: "user", Content: "  "}}},
+	}
+
+	result, err := storeMemory.Process(&prevSteps, input)
+
+	require.NoError(t, err)
+	assert.Equal(t, input, result)
+	assert.Empty(t, storeMemory.Store.List(""))
+}
+
+func TestStoreMemory_Process_WithoutAStore(t *testing.T) {
+	storeMemory := StoreMemory{Logger: zap.NewNop()}
+	prevSteps := []models.PipelineStep{}
+	input := &models.PipelineMessage{Request: &models.ChatCompletionRequest{Messages: []models.ChatMessage{{Role: "user", Content: "Hello, world!"}}}}
+
+	result, err := storeMemory.Process(&prevSteps, input)
+
 	assert.NoError(t, err, "Process should not return an error")
 	assert.Equal(t, input, result, "Process should return the input unchanged")
 }
 
 func TestStoreMemory_Process_NilInput(t *testing.T) {
-	storeMemory := StoreMemory{}
+	storeMemory := newStep(t)
 	prevSteps := []models.PipelineStep{}
 
 	result, err := storeMemory.Process(&prevSteps, nil)
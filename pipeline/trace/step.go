@@ -0,0 +1,147 @@
+package trace
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/condition"
+	"github.com/teagan42/snidemind/telemetry"
+)
+
+// TracedStep records the wrapped step as an OpenTelemetry span and, when a trace is active, what the step
+// does to the message.
+type TracedStep struct {
+	Step models.PipelineStep
+}
+
+// Wrap returns the step wrapped so that it shows up in spans and traced runs.
+func Wrap(step models.PipelineStep) models.PipelineStep {
+	return &TracedStep{Step: step}
+}
+
+func (t TracedStep) Name() string {
+	return t.Step.Name()
+}
+
+func (t TracedStep) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	inner := t.Step
+	skipped := false
+	if conditional, ok := inner.(*condition.ConditionalStep); ok {
+		inner = conditional.Step
+		skipped = !condition.Matches(&conditional.Condition, input)
+	}
+	span, parentContext := telemetry.StartSpan(input, "step "+t.Step.Name(), telemetry.StepNameKey.String(t.Step.Name()), telemetry.StepSkippedKey.Bool(skipped))
+	start := time.Now()
+	output, err := t.record(previous, input, inner, skipped)
+	if !skipped {
+		telemetry.ObserveStep(t.Step.Name(), err, time.Since(start))
+	}
+	input.SetContext(parentContext)
+	if output != nil {
+		output.SetContext(parentContext)
+		span.SetAttributes(telemetry.MessageAttributes(output)...)
+	} else {
+		span.SetAttributes(telemetry.MessageAttributes(input)...)
+	}
+	telemetry.EndSpan(span, err)
+	return output, err
+}
+
+// record runs the step, adding it to the active trace if there is one.
+func (t TracedStep) record(previous *[]models.PipelineStep, input *models.PipelineMessage, inner models.PipelineStep, skipped bool) (*models.PipelineMessage, error) {
+	parent := current(input)
+	if parent == nil {
+		return t.Step.Process(previous, input)
+	}
+	record := &Step{Name: t.Step.Name()}
+	parent.add(record)
+
+	stubber, stub := inner.(Stubber)
+	stub = stub && !skipped && parent.trace.options.StubLLM
+
+	before := snapshot(input)
+	models.SetState(input, cursorKey, &cursor{trace: parent.trace, steps: &record.Steps, step: record})
+	start := time.Now()
+	var output *models.PipelineMessage
+	var err error
+	if stub {
+		output, err = stubber.Stub(input)
+	} else {
+		output, err = t.Step.Process(previous, input)
+	}
+	duration := time.Since(start)
+	models.SetState(input, cursorKey, parent)
+
+	var changes map[string]Change
+	if output != nil {
+		models.SetState(output, cursorKey, parent)
+		changes = diff(before, snapshot(output))
+	}
+	parent.trace.update(func() {
+		record.DurationMS = milliseconds(duration)
+		record.Skipped = skipped
+		record.Stubbed = stub
+		record.Changes = changes
+		if err != nil {
+			record.Error = err.Error()
+		}
+	})
+	return output, err
+}
+
+// snapshot copies the parts of the message worth reporting, so later steps mutating it in place don't rewrite history.
+func snapshot(msg *models.PipelineMessage) map[string]any {
+	values := map[string]any{}
+	if msg.Request != nil {
+		messages := make([]string, 0, len(msg.Request.Messages))
+		for _, message := range msg.Request.Messages {
+			messages = append(messages, message.Role+": "+message.Content)
+		}
+		values["messages"] = messages
+	}
+	if msg.Tags != nil {
+		values["tags"] = append([]string{}, slices.Sorted(maps.Keys(*msg.Tags))...)
+	}
+	if msg.TagScores != nil {
+		values["tag_scores"] = maps.Clone(*msg.TagScores)
+	}
+	if msg.Tools != nil {
+		tools := make([]string, 0, len(*msg.Tools))
+		for _, tool := range *msg.Tools {
+			tools = append(tools, tool.ToolMetadata.Name)
+		}
+		values["tools"] = tools
+	}
+	for name, field := range map[string]*[]string{"prompts": msg.Prompts, "memories": msg.Memories, "knowledge": msg.Knowledge} {
+		if field != nil {
+			values[name] = slices.Clone(*field)
+		}
+	}
+	if msg.Response != nil && len(msg.Response.Choices) > 0 {
+		values["response"] = msg.Response.Choices[0].Message.Content
+	}
+	state := msg.StateSnapshot()
+	delete(state, cursorKey.Name())
+	if len(state) > 0 {
+		values["state"] = slices.Sorted(maps.Keys(state))
+	}
+	return values
+}
+
+func diff(before map[string]any, after map[string]any) map[string]Change {
+	keys := maps.Clone(before)
+	maps.Copy(keys, after)
+	changes := map[string]Change{}
+	for key := range keys {
+		if !reflect.DeepEqual(before[key], after[key]) {
+			changes[key] = Change{Before: before[key], After: after[key]}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
@@ -0,0 +1,228 @@
+package trace
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/telemetry"
+)
+
+// Options control how a traced run behaves.
+type Options struct {
+	StubLLM bool // Replace calls to a model with a canned response, see Stubber
+}
+
+// OptionsFromQuery reads a traced run's options from the request's query, the same on every route that traces.
+// The model call is stubbed unless stub_llm=false, so a dry-run costs nothing unless asked to.
+func OptionsFromQuery(query url.Values) Options {
+	return Options{StubLLM: query.Get("stub_llm") != "false"}
+}
+
+// Stubber is implemented by steps that call out to a model, so a dry-run can skip the slow (and billed) call.
+type Stubber interface {
+	Stub(input *models.PipelineMessage) (*models.PipelineMessage, error)
+}
+
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// Step is what one step did to the message. Steps that run sub-pipelines (routers, pipeline references)
+// list the steps they ran in Steps; forks list each branch in Branches.
+type Step struct {
+	Name       string            `json:"name"`
+	DurationMS float64           `json:"duration_ms"`
+	Skipped    bool              `json:"skipped,omitempty"`
+	Stubbed    bool              `json:"stubbed,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Changes    map[string]Change `json:"changes,omitempty"`
+	Steps      []*Step           `json:"steps,omitempty"`
+	Branches   []*Branch         `json:"branches,omitempty"`
+}
+
+type Branch struct {
+	Index      int     `json:"index"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+	Steps      []*Step `json:"steps"`
+}
+
+// Trace is the record of a single run through the pipeline.
+type Trace struct {
+	DurationMS float64                        `json:"duration_ms"`
+	Error      string                         `json:"error,omitempty"`
+	Steps      []*Step                        `json:"steps"`
+	Response   *models.ChatCompletionResponse `json:"response,omitempty"`
+	Output     string                         `json:"output,omitempty"` // Whatever the steps wrote to the client
+	options    Options
+	// Fork branches record concurrently, and a timed out branch can keep recording after the run returns.
+	lock sync.Mutex
+}
+
+type traceAlias Trace
+
+func (t *Trace) MarshalJSON() ([]byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return json.Marshal((*traceAlias)(t))
+}
+
+func (t *Trace) update(fn func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	fn()
+}
+
+// cursor tells a traced step where to record itself. It travels in the message's state so that
+// steps nested in forks, routers and pipeline references end up under their parent.
+type cursor struct {
+	trace *Trace
+	steps *[]*Step
+	step  *Step // The step whose sub-steps are being recorded, nil at the top level
+}
+
+// The cursor is internal bookkeeping; external steps see it as null.
+func (c *cursor) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+func (c *cursor) add(step *Step) {
+	c.trace.update(func() {
+		*c.steps = append(*c.steps, step)
+	})
+}
+
+var cursorKey = models.NewStateKey[*cursor]("trace")
+
+func current(msg *models.PipelineMessage) *cursor {
+	if msg == nil {
+		return nil
+	}
+	c, _ := models.GetState(msg, cursorKey)
+	return c
+}
+
+// Start attaches a new trace to the message. Every wrapped step that processes it afterwards records into the trace.
+func Start(msg *models.PipelineMessage, options Options) *Trace {
+	t := &Trace{Steps: []*Step{}, options: options}
+	models.SetState(msg, cursorKey, &cursor{trace: t, steps: &t.Steps})
+	return t
+}
+
+// Finish records the outcome of the run and detaches the trace from the message.
+func (t *Trace) Finish(output *models.PipelineMessage, writer *ResponseBuffer, err error, duration time.Duration) {
+	t.update(func() {
+		t.DurationMS = milliseconds(duration)
+		if err != nil {
+			t.Error = err.Error()
+		}
+		if output != nil {
+			t.Response = output.Response
+			models.DeleteState(output, cursorKey)
+		}
+		if writer != nil {
+			t.Output = writer.String()
+		}
+	})
+}
+
+// Write sends the trace to the client as JSON.
+func Write(w http.ResponseWriter, t *Trace) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(t)
+}
+
+// StartBranch gives a fork branch its own span and, when a trace is active, its own section in the trace under
+// the fork's step. It returns the function that records how the branch ended.
+func StartBranch(msg *models.PipelineMessage, index int) func(err error) {
+	span, _ := telemetry.StartSpan(msg, "fork branch", telemetry.ForkBranchKey.Int(index))
+	parent := current(msg)
+	if parent == nil || parent.step == nil {
+		return func(err error) {
+			telemetry.EndSpan(span, err)
+		}
+	}
+	branch := &Branch{Index: index, Steps: []*Step{}}
+	parent.trace.update(func() {
+		parent.step.Branches = append(parent.step.Branches, branch)
+	})
+	models.SetState(msg, cursorKey, &cursor{trace: parent.trace, steps: &branch.Steps, step: parent.step})
+	start := time.Now()
+	return func(err error) {
+		telemetry.EndSpan(span, err)
+		parent.trace.update(func() {
+			branch.DurationMS = milliseconds(time.Since(start))
+			if err != nil {
+				branch.Error = err.Error()
+			}
+		})
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// ResponseBuffer collects what steps write to the client during a traced run, or in a fork branch until it's
+// known whether the branch's answer is the one kept.
+type ResponseBuffer struct {
+	header http.Header
+	status int
+	body   []byte
+	lock   sync.Mutex
+}
+
+func NewResponseBuffer() *ResponseBuffer {
+	return &ResponseBuffer{header: http.Header{}}
+}
+
+func (b *ResponseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *ResponseBuffer) Write(data []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.body = append(b.body, data...)
+	return len(data), nil
+}
+
+func (b *ResponseBuffer) WriteHeader(status int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.status = status
+}
+
+func (b *ResponseBuffer) Flush() {}
+
+// Replay writes what was collected to w, the status and headers first, as if it had been written there all along.
+func (b *ResponseBuffer) Replay(w http.ResponseWriter) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	maps.Copy(w.Header(), b.header)
+	if b.status != 0 {
+		w.WriteHeader(b.status)
+	}
+	if len(b.body) > 0 {
+		if _, err := w.Write(b.body); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (b *ResponseBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return string(b.body)
+}
@@ -0,0 +1,253 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline/condition"
+	"github.com/teagan42/snidemind/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+type funcStep struct {
+	name string
+	fn   func(*models.PipelineMessage) error
+}
+
+func (s funcStep) Name() string { return s.name }
+func (s funcStep) Process(_ *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	if err := s.fn(input); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+type modelStep struct {
+	called bool
+}
+
+func (s *modelStep) Name() string { return "LLM" }
+func (s *modelStep) Process(_ *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	s.called = true
+	input.Response = &models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{Message: models.ChatMessage{Content: "real"}}}}
+	return input, nil
+}
+func (s *modelStep) Stub(input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	input.Response = &models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{Message: models.ChatMessage{Content: "stub"}}}}
+	return input, nil
+}
+
+// nestingStep runs its inner steps like a router or pipeline reference would.
+type nestingStep struct {
+	steps []models.PipelineStep
+}
+
+func (s nestingStep) Name() string { return "nest" }
+func (s nestingStep) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	for _, step := range s.steps {
+		var err error
+		if input, err = step.Process(previous, input); err != nil {
+			return nil, err
+		}
+	}
+	return input, nil
+}
+
+// branchingStep runs each inner step on its own clone of the message, like a fork.
+type branchingStep struct {
+	branches []models.PipelineStep
+}
+
+func (s branchingStep) Name() string { return "fork" }
+func (s branchingStep) Process(previous *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	for i, step := range s.branches {
+		branch := input.Clone()
+		finish := StartBranch(branch, i)
+		_, err := step.Process(previous, branch)
+		finish(err)
+	}
+	return input, nil
+}
+
+func newMessage() *models.PipelineMessage {
+	return &models.PipelineMessage{
+		Request: &models.ChatCompletionRequest{Messages: []models.ChatMessage{{Role: "user", Content: "hi"}}},
+		Tags:    &map[string]string{},
+	}
+}
+
+func addTag(tag string) models.PipelineStep {
+	return Wrap(funcStep{name: "tag-" + tag, fn: func(m *models.PipelineMessage) error {
+		(*m.Tags)[tag] = tag
+		return nil
+	}})
+}
+
+func run(steps []models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	var err error
+	for _, step := range steps {
+		if input, err = step.Process(nil, input); err != nil {
+			return input, err
+		}
+	}
+	return input, nil
+}
+
+func TestOptionsFromQuery_StubsUnlessToldNotTo(t *testing.T) {
+	assert.True(t, OptionsFromQuery(url.Values{}).StubLLM)
+	assert.True(t, OptionsFromQuery(url.Values{"stub_llm": {"true"}}).StubLLM)
+	assert.False(t, OptionsFromQuery(url.Values{"stub_llm": {"false"}}).StubLLM)
+}
+
+func TestWrap_WithoutTrace(t *testing.T) {
+	msg := newMessage()
+	out, err := addTag("home").Process(nil, msg)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"home": "home"}, *out.Tags)
+}
+
+func TestTrace_RecordsChanges(t *testing.T) {
+	msg := newMessage()
+	tr := Start(msg, Options{})
+	out, err := run([]models.PipelineStep{addTag("home"), addTag("web")}, msg)
+	tr.Finish(out, nil, err, 0)
+
+	require.Len(t, tr.Steps, 2)
+	assert.Equal(t, "tag-home", tr.Steps[0].Name)
+	assert.Equal(t, map[string]Change{"tags": {Before: []string{}, After: []string{"home"}}}, tr.Steps[0].Changes)
+	assert.Equal(t, map[string]Change{"tags": {Before: []string{"home"}, After: []string{"home", "web"}}}, tr.Steps[1].Changes)
+	_, traced := models.GetState(out, cursorKey)
+	assert.False(t, traced, "finish should detach the trace from the message")
+}
+
+func TestTrace_RecordsErrors(t *testing.T) {
+	msg := newMessage()
+	tr := Start(msg, Options{})
+	failing := Wrap(funcStep{name: "broken", fn: func(*models.PipelineMessage) error { return errors.New("boom") }})
+	_, err := run([]models.PipelineStep{failing}, msg)
+	tr.Finish(msg, nil, err, 0)
+
+	assert.Equal(t, "boom", tr.Steps[0].Error)
+	assert.Equal(t, "boom", tr.Error)
+}
+
+func TestTrace_SkippedAndStubbed(t *testing.T) {
+	model := &modelStep{}
+	skipped := condition.Wrap(funcStep{name: "never", fn: func(*models.PipelineMessage) error { return nil }}, &config.StepCondition{Users: []string{"nobody"}}, zap.NewNop())
+	steps := []models.PipelineStep{Wrap(skipped), Wrap(model)}
+
+	msg := newMessage()
+	tr := Start(msg, Options{StubLLM: true})
+	out, err := run(steps, msg)
+	tr.Finish(out, nil, err, 0)
+
+	assert.True(t, tr.Steps[0].Skipped)
+	assert.True(t, tr.Steps[1].Stubbed)
+	assert.False(t, model.called)
+	assert.Equal(t, "stub", tr.Response.Choices[0].Message.Content)
+
+	msg = newMessage()
+	tr = Start(msg, Options{})
+	out, err = run(steps, msg)
+	tr.Finish(out, nil, err, 0)
+	assert.False(t, tr.Steps[1].Stubbed)
+	assert.True(t, model.called)
+}
+
+func TestTrace_NestedStepsAndBranches(t *testing.T) {
+	failing := Wrap(funcStep{name: "broken", fn: func(*models.PipelineMessage) error { return errors.New("boom") }})
+	steps := []models.PipelineStep{
+		Wrap(nestingStep{steps: []models.PipelineStep{addTag("inner")}}),
+		Wrap(branchingStep{branches: []models.PipelineStep{addTag("left"), failing}}),
+		addTag("after"),
+	}
+	msg := newMessage()
+	tr := Start(msg, Options{})
+	out, err := run(steps, msg)
+	tr.Finish(out, nil, err, 0)
+	require.NoError(t, err)
+
+	require.Len(t, tr.Steps, 3)
+	require.Len(t, tr.Steps[0].Steps, 1)
+	assert.Equal(t, "tag-inner", tr.Steps[0].Steps[0].Name)
+
+	fork := tr.Steps[1]
+	require.Len(t, fork.Branches, 2)
+	assert.Equal(t, "tag-left", fork.Branches[0].Steps[0].Name)
+	assert.Empty(t, fork.Branches[0].Error)
+	assert.Equal(t, "broken", fork.Branches[1].Steps[0].Name)
+	assert.Equal(t, "boom", fork.Branches[1].Error)
+	assert.Empty(t, fork.Steps)
+
+	assert.Equal(t, "tag-after", tr.Steps[2].Name, "steps after a fork should record at the top level again")
+
+	data, err := json.Marshal(tr)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"branches":[{"index":0`)
+}
+
+func TestCursor_MarshalsAsNull(t *testing.T) {
+	msg := newMessage()
+	Start(msg, Options{})
+	data, err := json.Marshal(msg.StateSnapshot())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"trace":null}`, string(data))
+}
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestWrap_RecordsSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	failing := Wrap(funcStep{name: "broken", fn: func(*models.PipelineMessage) error { return errors.New("boom") }})
+	steps := []models.PipelineStep{
+		Wrap(branchingStep{branches: []models.PipelineStep{addTag("a"), failing}}),
+	}
+	msg := newMessage()
+	_, err := run(steps, msg)
+	require.NoError(t, err)
+	assert.Equal(t, context.Background(), msg.Context(), "the step's span should not outlive it")
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Len(t, spans, 4)
+	fork := spans["step fork"]
+	branch := spans["fork branch"]
+	tag := spans["step tag-a"]
+	broken := spans["step broken"]
+	assert.Equal(t, fork.SpanContext().SpanID(), branch.Parent().SpanID())
+	assert.Equal(t, fork.SpanContext().TraceID(), tag.SpanContext().TraceID())
+	assert.Contains(t, tag.Attributes(), telemetry.StepNameKey.String("tag-a"))
+	assert.Contains(t, tag.Attributes(), telemetry.TagCountKey.Int(1))
+	assert.Equal(t, codes.Error, broken.Status().Code)
+	assert.Equal(t, "boom", broken.Status().Description)
+}
+
+func TestWrap_SpanMarksSkippedSteps(t *testing.T) {
+	recorder := recordSpans(t)
+	step := Wrap(condition.Wrap(addTag("a"), &config.StepCondition{Tags: []string{"missing"}}, zap.NewNop()))
+	_, err := run([]models.PipelineStep{step}, newMessage())
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.NotEmpty(t, spans)
+	assert.Contains(t, spans[len(spans)-1].Attributes(), telemetry.StepSkippedKey.Bool(true))
+}
@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+)
+
+// ValidatePipelineConfig checks every step (including those nested in forks, routers, ...) against the
+// registered step factories, so a typo fails at startup with the path to the offending step.
+func ValidatePipelineConfig(path string, pipelineConfig config.PipelineConfig, stepFactories map[string]models.PipelineStepFactory) error {
+	for i, step := range pipelineConfig.Steps {
+		stepPath := fmt.Sprintf("%s.steps[%d]", path, i)
+		factory, ok := stepFactories[step.Type]
+		if !ok {
+			registered := slices.Sorted(maps.Keys(stepFactories))
+			return fmt.Errorf("%s: unknown step type %q (registered types: %s)", stepPath, step.Type, strings.Join(registered, ", "))
+		}
+		if validator, ok := factory.(models.PipelineStepConfigValidator); ok {
+			if err := validator.ValidateConfig(step); err != nil {
+				return fmt.Errorf("%s (%s): %w", stepPath, step.Type, err)
+			}
+		}
+		if provider, ok := factory.(models.NestedPipelineProvider); ok {
+			for _, nested := range provider.NestedPipelines(step) {
+				if err := ValidatePipelineConfig(stepPath+"."+nested.Path, nested.Config, stepFactories); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateConfig validates the main pipeline and every named pipeline.
+func ValidateConfig(cfg *config.Config, stepFactories map[string]models.PipelineStepFactory) error {
+	if cfg.Pipeline != nil {
+		if err := ValidatePipelineConfig("pipeline", *cfg.Pipeline, stepFactories); err != nil {
+			return err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Pipelines)) {
+		if err := ValidatePipelineConfig("pipelines."+name, cfg.Pipelines[name], stepFactories); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+type passStep struct{}
+
+func (passStep) Name() string { return "pass" }
+func (passStep) Process(_ *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	return input, nil
+}
+
+type passFactory struct{}
+
+func (passFactory) Name() string { return "pass" }
+func (passFactory) Build(config.PipelineStepConfig, map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	return passStep{}, nil
+}
+
+type failingFactory struct{}
+
+func (failingFactory) Name() string { return "broken" }
+func (failingFactory) Build(config.PipelineStepConfig, map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	return nil, errors.New("boom")
+}
+
+// nestingFactory validates its own section and exposes its fork branches as nested pipelines.
+type nestingFactory struct{}
+
+func (nestingFactory) Name() string { return "nest" }
+func (nestingFactory) Build(config.PipelineStepConfig, map[string]models.PipelineStepFactory) (models.PipelineStep, error) {
+	return passStep{}, nil
+}
+func (nestingFactory) ValidateConfig(cfg config.PipelineStepConfig) error {
+	_, err := config.DecodeSection[[]config.PipelineConfig](cfg, "fork")
+	return err
+}
+func (nestingFactory) NestedPipelines(cfg config.PipelineStepConfig) []models.NestedPipeline {
+	forks, _ := config.DecodeSection[[]config.PipelineConfig](cfg, "fork")
+	nested := []models.NestedPipeline{}
+	for i, fork := range forks {
+		nested = append(nested, models.NestedPipeline{Path: fmt.Sprintf("fork[%d]", i), Config: fork})
+	}
+	return nested
+}
+
+var testFactories = map[string]models.PipelineStepFactory{
+	"pass":   passFactory{},
+	"broken": failingFactory{},
+	"nest":   nestingFactory{},
+}
+
+func pipelineOf(types ...string) config.PipelineConfig {
+	pipelineConfig := config.PipelineConfig{}
+	for _, stepType := range types {
+		pipelineConfig.Steps = append(pipelineConfig.Steps, config.PipelineStepConfig{Type: stepType})
+	}
+	return pipelineConfig
+}
+
+func TestValidatePipelineConfig(t *testing.T) {
+	nested := config.PipelineStepConfig{
+		Type: "nest",
+		Raw:  map[string]any{"fork": []config.PipelineConfig{pipelineOf("pass"), pipelineOf("pass", "typo")}},
+	}
+	tests := []struct {
+		name    string
+		config  config.PipelineConfig
+		wantErr string
+	}{
+		{"Valid", pipelineOf("pass", "broken"), ""},
+		{"UnknownType", pipelineOf("pass", "typo"), `pipeline.steps[1]: unknown step type "typo" (registered types: broken, nest, pass)`},
+		{"FactoryValidation", pipelineOf("nest"), "pipeline.steps[0] (nest): fork: section is required"},
+		{"NestedUnknownType", config.PipelineConfig{Steps: []config.PipelineStepConfig{{Type: "pass"}, nested}}, `pipeline.steps[1].fork[1].steps[1]: unknown step type "typo"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePipelineConfig("pipeline", tt.config, testFactories)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_NamedPipelines(t *testing.T) {
+	cfg := &config.Config{
+		Pipeline:  &config.PipelineConfig{Steps: []config.PipelineStepConfig{{Type: "pass"}}},
+		Pipelines: map[string]config.PipelineConfig{"research": pipelineOf("pass", "typo")},
+	}
+	err := ValidateConfig(cfg, testFactories)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pipelines.research.steps[1]")
+}
+
+func TestNewPipeline(t *testing.T) {
+	pipelineConfig := pipelineOf("pass", "pass")
+	p, err := NewPipeline(Params{
+		Config:        &config.Config{Pipeline: &pipelineConfig},
+		StepFactories: testFactories,
+		Logger:        zap.NewNop(),
+	})
+	require.NoError(t, err)
+	assert.Len(t, p.Steps, 2)
+}
+
+func TestNewPipeline_FailsOnUnknownStep(t *testing.T) {
+	pipelineConfig := pipelineOf("pass", "typo")
+	_, err := NewPipeline(Params{
+		Config:        &config.Config{Pipeline: &pipelineConfig},
+		StepFactories: testFactories,
+		Logger:        zap.NewNop(),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pipeline.steps[1]")
+}
+
+func TestNewPipeline_FailsOnBuildError(t *testing.T) {
+	pipelineConfig := pipelineOf("pass", "broken")
+	_, err := NewPipeline(Params{
+		Config:        &config.Config{Pipeline: &pipelineConfig},
+		StepFactories: testFactories,
+		Logger:        zap.NewNop(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, "pipeline.steps[1] (broken): failed to build: boom", err.Error())
+}
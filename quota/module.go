@@ -0,0 +1,10 @@
+package quota
+
+import "go.uber.org/fx"
+
+var Module = fx.Module(
+	"quota",
+	fx.Provide(
+		NewLimiter,
+	),
+)
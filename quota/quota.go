@@ -0,0 +1,289 @@
+package quota
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teagan42/snidemind/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultStateFile = "limits.json"
+	// How often counters are written to disk while running, on top of the final save at shutdown.
+	saveInterval = 30 * time.Second
+)
+
+// Reasons a request is refused, matching the `type` OpenAI uses for its rate limit errors.
+const (
+	LimitRequests = "requests"
+	LimitTokens   = "tokens"
+)
+
+// Consumer is who a request is charged to.
+type Consumer struct {
+	ID   string // Stable and safe to store, API keys are hashed
+	Name string // What the consumer is called in config, an identity, an API key, a `user` or an address
+}
+
+// APIKeyConsumer charges requests to the API key they were made with.
+func APIKeyConsumer(key string) Consumer {
+	sum := sha256.Sum256([]byte(key))
+	return Consumer{ID: "key:" + hex.EncodeToString(sum[:8]), Name: key}
+}
+
+// IdentityConsumer charges requests to the identity their API key belongs to, see the `auth` section.
+func IdentityConsumer(name string) Consumer {
+	return Consumer{ID: "identity:" + name, Name: name}
+}
+
+// AddressConsumer charges requests to the address they came from, which unlike a key or a `user` the client
+// doesn't get to make up.
+func AddressConsumer(address string) Consumer {
+	return Consumer{ID: "address:" + address, Name: address}
+}
+
+// UserConsumer charges requests to the `user` the client put in the request. An empty user is "anonymous".
+func UserConsumer(user string) Consumer {
+	if user == "" {
+		user = "anonymous"
+	}
+	return Consumer{ID: "user:" + user, Name: user}
+}
+
+// Decision is the limiter's answer for one request, along with what's left for the x-ratelimit-* headers.
+// Remaining values are -1 when there is no limit.
+type Decision struct {
+	Allowed           bool
+	Limit             string // Which limit refused the request, LimitRequests or LimitTokens
+	RetryAfter        time.Duration
+	Config            config.LimitConfig
+	RemainingRequests int
+	RemainingTokens   int
+}
+
+type Limiter struct {
+	config    config.LimitsConfig
+	enabled   bool
+	consumers map[string]*Counter
+	dirty     bool
+	lock      sync.Mutex
+	now       func() time.Time
+	logger    *zap.Logger
+}
+
+type Params struct {
+	fx.In
+	Config    *config.Config
+	Logger    *zap.Logger
+	Lifecycle fx.Lifecycle
+}
+
+type Result struct {
+	fx.Out
+	Limiter *Limiter
+}
+
+// NewLimiter loads the saved counters and keeps saving them while the app runs.
+// Without a `limits` section every request is allowed and nothing is written.
+func NewLimiter(p Params) (Result, error) {
+	logger := p.Logger.Named("Limiter")
+	if p.Config.Limits == nil {
+		logger.Info("No limits configured")
+		return Result{Limiter: &Limiter{consumers: map[string]*Counter{}, now: time.Now, logger: logger}}, nil
+	}
+	limitsConfig := *p.Config.Limits
+	if limitsConfig.StateFile == "" {
+		limitsConfig.StateFile = DefaultStateFile
+	}
+	consumers, err := load(limitsConfig.StateFile)
+	if err != nil {
+		return Result{}, err
+	}
+	limiter := &Limiter{
+		config:    limitsConfig,
+		enabled:   true,
+		consumers: consumers,
+		now:       time.Now,
+		logger:    logger,
+	}
+	limiter.prune(limiter.now())
+	logger.Info("Limits loaded", zap.String("stateFile", limitsConfig.StateFile), zap.Int("consumers", len(consumers)))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go func() {
+				defer close(done)
+				ticker := time.NewTicker(saveInterval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						if err := limiter.Save(); err != nil {
+							logger.Error("Failed to save limit counters", zap.Error(err))
+						}
+					case <-stop:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(stop)
+			<-done
+			return limiter.Save()
+		},
+	})
+	return Result{Limiter: limiter}, nil
+}
+
+func (l *Limiter) Enabled() bool {
+	return l.enabled
+}
+
+// Configures reports whether `limits.consumers` has limits of its own for the name.
+func (l *Limiter) Configures(name string) bool {
+	for configured := range l.config.Consumers {
+		if strings.EqualFold(configured, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitsFor merges the consumer's own limits over the default ones. Viper lowercases config keys, so names are
+// compared case-insensitively.
+func (l *Limiter) limitsFor(consumer Consumer) config.LimitConfig {
+	limits := l.config.Default
+	for name, own := range l.config.Consumers {
+		if !strings.EqualFold(name, consumer.Name) {
+			continue
+		}
+		if own.RequestsPerMinute != nil {
+			limits.RequestsPerMinute = own.RequestsPerMinute
+		}
+		if own.TokensPerDay != nil {
+			limits.TokensPerDay = own.TokensPerDay
+		}
+	}
+	return limits
+}
+
+func (l *Limiter) counter(consumer Consumer, now time.Time) *Counter {
+	counter, ok := l.consumers[consumer.ID]
+	if !ok {
+		// Never refilled, so the first Allow fills the bucket
+		counter = &Counter{}
+		l.consumers[consumer.ID] = counter
+	}
+	if day := now.Format(time.DateOnly); counter.Day != day {
+		counter.Day = day
+		counter.DayTokens = 0
+	}
+	return counter
+}
+
+// Allow takes one request from the consumer's allowance, unless the request would go over one of its limits.
+func (l *Limiter) Allow(consumer Consumer) Decision {
+	if !l.enabled {
+		return Decision{Allowed: true, RemainingRequests: -1, RemainingTokens: -1}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	limits := l.limitsFor(consumer)
+	counter := l.counter(consumer, now)
+	decision := Decision{Allowed: true, Config: limits, RemainingRequests: -1, RemainingTokens: -1}
+
+	if limits.TokensPerDay != nil {
+		decision.RemainingTokens = max(*limits.TokensPerDay-counter.DayTokens, 0)
+		if decision.RemainingTokens == 0 {
+			decision.Allowed = false
+			decision.Limit = LimitTokens
+			decision.RetryAfter = untilTomorrow(now)
+			return decision
+		}
+	}
+	if limits.RequestsPerMinute != nil {
+		// A bucket holding a minute's worth of requests, refilled continuously
+		capacity := float64(*limits.RequestsPerMinute)
+		perSecond := capacity / 60
+		counter.Requests = math.Min(capacity, counter.Requests+now.Sub(counter.Refilled).Seconds()*perSecond)
+		counter.Refilled = now
+		if counter.Requests < 1 {
+			decision.Allowed = false
+			decision.Limit = LimitRequests
+			decision.RemainingRequests = 0
+			if perSecond > 0 {
+				decision.RetryAfter = time.Duration((1 - counter.Requests) / perSecond * float64(time.Second))
+			} else {
+				decision.RetryAfter = time.Minute
+			}
+			l.dirty = true
+			return decision
+		}
+		counter.Requests--
+		decision.RemainingRequests = int(counter.Requests)
+	}
+	l.dirty = true
+	return decision
+}
+
+// Charge adds the tokens a finished request spent to the consumer's daily total.
+func (l *Limiter) Charge(consumer Consumer, tokens int) {
+	if !l.enabled || tokens <= 0 {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.counter(consumer, l.now()).DayTokens += tokens
+	l.dirty = true
+}
+
+// Save writes the counters to the state file if anything changed since the last save.
+func (l *Limiter) Save() error {
+	if !l.enabled {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.prune(l.now()) > 0 {
+		l.dirty = true
+	}
+	if !l.dirty {
+		return nil
+	}
+	if err := save(l.config.StateFile, l.consumers); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// prune forgets the counters that have run their course: a full request bucket and no tokens spent today is
+// what a consumer the limiter has never seen starts with. It returns how many were forgotten.
+func (l *Limiter) prune(now time.Time) int {
+	today := now.Format(time.DateOnly)
+	pruned := 0
+	for id, counter := range l.consumers {
+		if now.Sub(counter.Refilled) >= time.Minute && (counter.Day != today || counter.DayTokens == 0) {
+			delete(l.consumers, id)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+func untilTomorrow(now time.Time) time.Duration {
+	year, month, day := now.Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location()).Sub(now)
+}
@@ -0,0 +1,148 @@
+package quota
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func intPtr(i int) *int { return &i }
+
+func newLimiter(t *testing.T, limits *config.LimitsConfig) (*Limiter, *fxtest.Lifecycle) {
+	lifecycle := fxtest.NewLifecycle(t)
+	result, err := NewLimiter(Params{Config: &config.Config{Limits: limits}, Logger: zap.NewNop(), Lifecycle: lifecycle})
+	require.NoError(t, err)
+	return result.Limiter, lifecycle
+}
+
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestLimiter_DisabledAllowsEverything(t *testing.T) {
+	limiter, _ := newLimiter(t, nil)
+	assert.False(t, limiter.Enabled())
+	for range 100 {
+		assert.True(t, limiter.Allow(UserConsumer("")).Allowed)
+	}
+	assert.NoError(t, limiter.Save())
+}
+
+func TestLimiter_RequestsPerMinute(t *testing.T) {
+	limiter, _ := newLimiter(t, &config.LimitsConfig{
+		StateFile: filepath.Join(t.TempDir(), "limits.json"),
+		Default:   config.LimitConfig{RequestsPerMinute: intPtr(2)},
+	})
+	now := &clock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	limiter.now = now.Now
+	alice := UserConsumer("alice")
+
+	first := limiter.Allow(alice)
+	assert.True(t, first.Allowed)
+	assert.Equal(t, 1, first.RemainingRequests)
+	assert.True(t, limiter.Allow(alice).Allowed)
+	refused := limiter.Allow(alice)
+	assert.False(t, refused.Allowed)
+	assert.Equal(t, LimitRequests, refused.Limit)
+	assert.Equal(t, 30*time.Second, refused.RetryAfter)
+	assert.True(t, limiter.Allow(UserConsumer("bob")).Allowed, "consumers have their own allowance")
+
+	now.Advance(30 * time.Second)
+	assert.True(t, limiter.Allow(alice).Allowed)
+	assert.False(t, limiter.Allow(alice).Allowed)
+}
+
+func TestLimiter_TokensPerDay(t *testing.T) {
+	limiter, _ := newLimiter(t, &config.LimitsConfig{
+		StateFile: filepath.Join(t.TempDir(), "limits.json"),
+		Default:   config.LimitConfig{TokensPerDay: intPtr(100)},
+	})
+	now := &clock{now: time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC)}
+	limiter.now = now.Now
+	alice := UserConsumer("alice")
+
+	assert.Equal(t, 100, limiter.Allow(alice).RemainingTokens)
+	limiter.Charge(alice, 60)
+	assert.Equal(t, 40, limiter.Allow(alice).RemainingTokens)
+	limiter.Charge(alice, 60)
+	refused := limiter.Allow(alice)
+	assert.False(t, refused.Allowed)
+	assert.Equal(t, LimitTokens, refused.Limit)
+	assert.Equal(t, 6*time.Hour, refused.RetryAfter)
+
+	now.Advance(6 * time.Hour)
+	assert.True(t, limiter.Allow(alice).Allowed, "the daily allowance starts over at midnight")
+}
+
+func TestLimiter_ConsumerOverridesDefault(t *testing.T) {
+	limiter, _ := newLimiter(t, &config.LimitsConfig{
+		StateFile: filepath.Join(t.TempDir(), "limits.json"),
+		Default:   config.LimitConfig{RequestsPerMinute: intPtr(1), TokensPerDay: intPtr(10)},
+		Consumers: map[string]config.LimitConfig{
+			// Viper hands keys over lowercased
+			"sk-kitchen": {RequestsPerMinute: intPtr(5)},
+		},
+	})
+	decision := limiter.Allow(APIKeyConsumer("SK-Kitchen"))
+	assert.Equal(t, 5, *decision.Config.RequestsPerMinute)
+	assert.Equal(t, 10, *decision.Config.TokensPerDay)
+}
+
+func TestLimiter_CountersSurviveRestart(t *testing.T) {
+	limits := &config.LimitsConfig{
+		StateFile: filepath.Join(t.TempDir(), "state", "limits.json"),
+		Default:   config.LimitConfig{RequestsPerMinute: intPtr(1), TokensPerDay: intPtr(100)},
+	}
+	key := APIKeyConsumer("sk-secret")
+	limiter, lifecycle := newLimiter(t, limits)
+	lifecycle.RequireStart()
+	assert.True(t, limiter.Allow(key).Allowed)
+	limiter.Charge(key, 30)
+	lifecycle.RequireStop()
+
+	restarted, _ := newLimiter(t, limits)
+	assert.False(t, restarted.Allow(key).Allowed, "the minute's request was already used before the restart")
+	assert.Equal(t, 30, restarted.consumers[key.ID].DayTokens)
+	assert.NotContains(t, restarted.consumers, "sk-secret", "API keys are never written to disk")
+}
+
+func TestLimiter_PrunesSpentCounters(t *testing.T) {
+	limits := &config.LimitsConfig{
+		StateFile: filepath.Join(t.TempDir(), "limits.json"),
+		Default:   config.LimitConfig{RequestsPerMinute: intPtr(60), TokensPerDay: intPtr(100)},
+	}
+	limiter, _ := newLimiter(t, limits)
+	now := &clock{now: time.Date(2026, 10, 19, 23, 0, 0, 0, time.Local)}
+	limiter.now = now.Now
+	for i := range 3 {
+		limiter.Allow(AddressConsumer(fmt.Sprintf("192.0.2.%d", i)))
+	}
+	limiter.Charge(AddressConsumer("192.0.2.1"), 10)
+
+	now.Advance(2 * time.Minute)
+	require.NoError(t, limiter.Save())
+	assert.Len(t, limiter.consumers, 1, "only the one with tokens spent today is kept")
+	assert.Contains(t, limiter.consumers, AddressConsumer("192.0.2.1").ID)
+
+	now.Advance(time.Hour)
+	require.NoError(t, limiter.Save())
+	assert.Empty(t, limiter.consumers, "yesterday's tokens don't count any more")
+	restarted, _ := newLimiter(t, limits)
+	assert.Empty(t, restarted.consumers)
+}
+
+func TestAPIKeyConsumer_HashesKey(t *testing.T) {
+	consumer := APIKeyConsumer("sk-secret")
+	assert.Equal(t, "sk-secret", consumer.Name)
+	assert.NotContains(t, consumer.ID, "sk-secret")
+	assert.Equal(t, consumer.ID, APIKeyConsumer("sk-secret").ID)
+	assert.Equal(t, "user:anonymous", UserConsumer("").ID)
+}
@@ -0,0 +1,63 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Counter is what the limiter remembers about one consumer.
+type Counter struct {
+	Requests  float64   `json:"requests"`   // Requests left in the per-minute bucket
+	Refilled  time.Time `json:"refilled"`   // When Requests was last topped up
+	Day       string    `json:"day"`        // Day DayTokens counts for, as YYYY-MM-DD in local time
+	DayTokens int       `json:"day_tokens"` // Tokens spent on Day
+}
+
+type state struct {
+	Consumers map[string]*Counter `json:"consumers"`
+}
+
+// load reads the counters saved by save. A missing file is a fresh start, not an error.
+func load(path string) (map[string]*Counter, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]*Counter{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if saved.Consumers == nil {
+		saved.Consumers = map[string]*Counter{}
+	}
+	return saved.Consumers, nil
+}
+
+// save writes the counters through a temporary file, so a crash mid-write can't leave a truncated state behind.
+func save(path string, consumers map[string]*Counter) error {
+	data, err := json.MarshalIndent(state{Consumers: consumers}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", temp, err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package responses
+
+import "go.uber.org/fx"
+
+var Module = fx.Module(
+	"responses",
+	fx.Provide(
+		NewStore,
+	),
+)
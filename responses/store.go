@@ -0,0 +1,73 @@
+package responses
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/filestore"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const DefaultDir = "responses"
+
+var ErrNotFound = errors.New("response not found")
+
+// Record is a stored response with the conversation that led to it, its own output included, which is what a
+// request naming it as `previous_response_id` continues from.
+type Record struct {
+	Response     models.Response      `json:"response"`
+	Conversation []models.ChatMessage `json:"conversation"`
+	Owner        string               `json:"owner,omitempty"` // The identity that created it, see the `auth` section
+}
+
+// Store keeps responses as one JSON file each. They're only ever looked up by ID, but the directory is small
+// enough to keep in memory too.
+type Store struct {
+	records *filestore.Store[Record]
+	logger  *zap.Logger
+}
+
+type Params struct {
+	fx.In
+	Config *config.Config
+	Logger *zap.Logger
+}
+
+type Result struct {
+	fx.Out
+	Store *Store
+}
+
+func NewStore(p Params) (Result, error) {
+	dir := DefaultDir
+	if p.Config.Responses != nil && p.Config.Responses.Dir != "" {
+		dir = p.Config.Responses.Dir
+	}
+	records, err := filestore.Open(dir, func(r Record) string { return r.Response.ID }, func(r Record) string { return r.Owner })
+	if err != nil {
+		return Result{}, err
+	}
+	store := &Store{records: records, logger: p.Logger.Named("ResponseStore")}
+	store.logger.Info("Stored responses loaded", zap.String("dir", dir), zap.Int("responses", records.Len()))
+	return Result{Store: store}, nil
+}
+
+// Save stores the record, replacing any earlier one with the same response ID.
+func (s *Store) Save(record Record) error {
+	record.Conversation = slices.Clone(record.Conversation)
+	return s.records.Put(record)
+}
+
+// Get returns the owner's response. As with stored completions, someone else's response doesn't exist.
+func (s *Store) Get(id string, owner string) (Record, error) {
+	record, ok := s.records.Get(id, owner)
+	if !ok {
+		return Record{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	record.Conversation = slices.Clone(record.Conversation)
+	return record, nil
+}
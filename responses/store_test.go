@@ -0,0 +1,47 @@
+package responses
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+func newStore(t *testing.T, dir string) *Store {
+	t.Helper()
+	result, err := NewStore(Params{Config: &config.Config{Responses: &config.ResponsesConfig{Dir: dir}}, Logger: zap.NewNop()})
+	require.NoError(t, err)
+	return result.Store
+}
+
+func TestStore_SaveGetAndReload(t *testing.T) {
+	dir := t.TempDir()
+	store := newStore(t, dir)
+	record := Record{
+		Response:     models.Response{ID: "resp_1", Object: "response", Status: "completed"},
+		Conversation: []models.ChatMessage{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "what"}},
+		Owner:        "kitchen",
+	}
+	require.NoError(t, store.Save(record))
+
+	got, err := store.Get("resp_1", "kitchen")
+	require.NoError(t, err)
+	assert.Equal(t, record, got)
+
+	reloaded, err := newStore(t, dir).Get("resp_1", "kitchen")
+	require.NoError(t, err)
+	assert.Equal(t, record, reloaded)
+}
+
+func TestStore_GetIsScopedToOwner(t *testing.T) {
+	store := newStore(t, t.TempDir())
+	require.NoError(t, store.Save(Record{Response: models.Response{ID: "resp_1"}, Owner: "kitchen"}))
+
+	_, err := store.Get("resp_1", "garage")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Get("resp_2", "kitchen")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,49 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/teagan42/snidemind/mcp"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type HealthControllerParams struct {
+	fx.In
+	Log     *zap.Logger
+	Clients *mcp.Clients
+}
+
+// HealthController reports how the connections to the MCP servers are doing. It answers 200 as long as
+// SnideMind itself is up; a server that's down is SnideMind degraded, not dead.
+type HealthController struct {
+	log     *zap.Logger
+	clients *mcp.Clients
+}
+
+func NewHealthController(p HealthControllerParams) *HealthController {
+	return &HealthController{
+		log:     p.Log.Named("HealthController"),
+		clients: p.Clients,
+	}
+}
+
+func (c *HealthController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(c.clients.Health()); err != nil {
+		c.log.Error("Error writing health", zap.Error(err))
+	}
+}
+
+func (c *HealthController) Pattern() string {
+	return ""
+}
+
+func (c *HealthController) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+var _ utils.Route = (*HealthController)(nil)
@@ -0,0 +1,15 @@
+package health
+
+import (
+	"github.com/teagan42/snidemind/server/utils"
+)
+
+// Module serves /health. Like /metrics it's open, so orchestrators can use it without a key.
+var Module = utils.ApiRouteModule(utils.ApiRouteModuleParams{
+	ParentRouter: "root",
+	ModuleName:   "health",
+	Prefix:       "health",
+	Routes: &[]any{
+		NewHealthController,
+	},
+})
@@ -0,0 +1,49 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpServer "github.com/mark3labs/mcp-go/server"
+	"github.com/teagan42/snidemind/memory"
+	"github.com/teagan42/snidemind/models"
+)
+
+const memoriesURI = "memory://"
+
+// addMemories serves what storeMemory remembered as resources: memory:// reads all of the caller's memories,
+// memory://{id} just the one. Like everywhere else, callers only get to see their own.
+func addMemories(server *mcpServer.MCPServer, store *memory.Store) {
+	server.AddResource(
+		mcp.NewResource(memoriesURI, "memories",
+			mcp.WithResourceDescription("Everything SnideMind remembers you saying, oldest first"),
+			mcp.WithMIMEType("text/plain"),
+		),
+		func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			memories := store.List(models.OwnerFromContext(ctx))
+			contents := make([]mcp.ResourceContents, 0, len(memories))
+			for _, remembered := range memories {
+				contents = append(contents, memoryContents(remembered))
+			}
+			return contents, nil
+		},
+	)
+	server.AddResourceTemplate(
+		mcp.NewResourceTemplate(memoriesURI+"{id}", "memory",
+			mcp.WithTemplateDescription("One thing SnideMind remembers you saying"),
+			mcp.WithTemplateMIMEType("text/plain"),
+		),
+		func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			remembered, err := store.Get(strings.TrimPrefix(request.Params.URI, memoriesURI), models.OwnerFromContext(ctx))
+			if err != nil {
+				return nil, err
+			}
+			return []mcp.ResourceContents{memoryContents(remembered)}, nil
+		},
+	)
+}
+
+func memoryContents(remembered memory.Memory) mcp.ResourceContents {
+	return mcp.TextResourceContents{URI: memoriesURI + remembered.ID, MIMEType: "text/plain", Text: remembered.Text}
+}
@@ -0,0 +1,30 @@
+package mcp
+
+import (
+	"net/http"
+
+	"github.com/teagan42/snidemind/server/utils"
+)
+
+// MessageController takes the messages of an SSE client, whose answers go out on its event stream.
+type MessageController struct {
+	handler http.Handler
+}
+
+func NewMessageController(p SSEControllerParams) *MessageController {
+	return &MessageController{handler: p.Server.MessageHandler()}
+}
+
+func (c *MessageController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.handler.ServeHTTP(w, r)
+}
+
+func (c *MessageController) Pattern() string {
+	return "/message"
+}
+
+func (c *MessageController) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+var _ utils.Route = (*MessageController)(nil)
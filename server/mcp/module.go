@@ -0,0 +1,24 @@
+package mcp
+
+import (
+	"github.com/teagan42/snidemind/server/utils"
+	v1 "github.com/teagan42/snidemind/server/v1"
+	"go.uber.org/fx"
+)
+
+// Module serves SnideMind as an MCP server on /mcp, behind the same API keys and limits as /v1.
+var Module = utils.ApiRouteModule(utils.ApiRouteModuleParams{
+	ParentRouter: "root",
+	ModuleName:   "mcp",
+	Prefix:       "mcp",
+	Routes: &[]any{
+		NewStreamableController,
+		NewSSEController,
+		NewMessageController,
+	},
+	SubModules: &[]fx.Option{
+		fx.Provide(NewServer, NewSSEServer),
+		fx.Invoke(fx.Annotate(v1.UseAuth, fx.ParamTags(`name:"mcpRouter"`))),
+		fx.Invoke(fx.Annotate(v1.UseQuota, fx.ParamTags(`name:"mcpRouter"`))),
+	},
+})
@@ -0,0 +1,47 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpServer "github.com/mark3labs/mcp-go/server"
+	"github.com/teagan42/snidemind/config"
+)
+
+// promptTemplate turns a prompt template from config into an MCP prompt. The template is parsed here so a
+// broken one fails startup rather than the first client that asks for it.
+func promptTemplate(prompt config.PromptConfig) (mcp.Prompt, mcpServer.PromptHandlerFunc, error) {
+	parsed, err := template.New(prompt.Name).Option("missingkey=zero").Parse(prompt.Template)
+	if err != nil {
+		return mcp.Prompt{}, nil, fmt.Errorf("template: %w", err)
+	}
+	options := []mcp.PromptOption{mcp.WithPromptDescription(prompt.Description)}
+	for _, argument := range prompt.Arguments {
+		argumentOptions := []mcp.ArgumentOption{mcp.ArgumentDescription(argument.Description)}
+		if argument.Required {
+			argumentOptions = append(argumentOptions, mcp.RequiredArgument())
+		}
+		options = append(options, mcp.WithArgument(argument.Name, argumentOptions...))
+	}
+	handler := func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		arguments := map[string]string{}
+		for _, argument := range prompt.Arguments {
+			value, ok := request.Params.Arguments[argument.Name]
+			if argument.Required && (!ok || value == "") {
+				return nil, fmt.Errorf("argument %s is required", argument.Name)
+			}
+			arguments[argument.Name] = value
+		}
+		var text strings.Builder
+		if err := parsed.Execute(&text, arguments); err != nil {
+			return nil, fmt.Errorf("prompt %s: %w", prompt.Name, err)
+		}
+		return mcp.NewGetPromptResult(prompt.Description, []mcp.PromptMessage{
+			mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(text.String())),
+		}), nil
+	}
+	return mcp.NewPrompt(prompt.Name, options...), handler, nil
+}
@@ -0,0 +1,153 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpServer "github.com/mark3labs/mcp-go/server"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/memory"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultName = "snidemind"
+	version     = "1.0.0"
+)
+
+type Params struct {
+	fx.In
+	Config   *config.Config
+	Pipeline *pipeline.Pipeline
+	Memories *memory.Store `optional:"true"`
+	Logger   *zap.Logger
+}
+
+type Result struct {
+	fx.Out
+	Server *mcpServer.MCPServer
+}
+
+// NewServer builds SnideMind's own MCP server: every named pipeline is a tool, and every prompt template under
+// `mcp.prompts` a prompt. With the memory module, what storeMemory remembered is there as resources. Clients only
+// see the pipelines their API key may run, and their own memories.
+func NewServer(p Params) (Result, error) {
+	logger := p.Logger.Named("MCPServer")
+	name := defaultName
+	var prompts []config.PromptConfig
+	if p.Config.MCP != nil {
+		if p.Config.MCP.Name != "" {
+			name = p.Config.MCP.Name
+		}
+		prompts = p.Config.MCP.Prompts
+	}
+	options := []mcpServer.ServerOption{
+		mcpServer.WithToolCapabilities(false),
+		mcpServer.WithPromptCapabilities(false),
+		mcpServer.WithToolFilter(allowedTools),
+		mcpServer.WithRecovery(),
+	}
+	if p.Memories != nil {
+		options = append(options, mcpServer.WithResourceCapabilities(false, false))
+	}
+	server := mcpServer.NewMCPServer(name, version, options...)
+	if p.Memories != nil {
+		addMemories(server, p.Memories)
+	}
+
+	names := make([]string, 0, len(p.Pipeline.Named))
+	for pipelineName := range p.Pipeline.Named {
+		names = append(names, pipelineName)
+	}
+	slices.Sort(names)
+	model := p.Config.ModelNames()[0]
+	for _, pipelineName := range names {
+		server.AddTool(pipelineTool(pipelineName, p.Config.Pipelines[pipelineName], model), callPipeline(p.Pipeline, pipelineName, model))
+	}
+	for i, prompt := range prompts {
+		template, handler, err := promptTemplate(prompt)
+		if err != nil {
+			return Result{}, fmt.Errorf("mcp.prompts[%d] (%s): %w", i, prompt.Name, err)
+		}
+		server.AddPrompt(template, handler)
+	}
+	logger.Info("MCP server ready", zap.String("name", name), zap.Int("tools", len(names)), zap.Int("prompts", len(prompts)))
+	return Result{Server: server}, nil
+}
+
+// allowedTools hides the pipelines the caller's API key may not run.
+func allowedTools(ctx context.Context, tools []mcp.Tool) []mcp.Tool {
+	identity := models.IdentityFromContext(ctx)
+	allowed := make([]mcp.Tool, 0, len(tools))
+	for _, tool := range tools {
+		if identity.AllowsPipeline(tool.Name) {
+			allowed = append(allowed, tool)
+		}
+	}
+	return allowed
+}
+
+func pipelineTool(name string, pipelineConfig config.PipelineConfig, model string) mcp.Tool {
+	description := pipelineConfig.Description
+	if description == "" {
+		description = fmt.Sprintf("Runs the %s pipeline on a prompt and returns its answer.", name)
+	}
+	return mcp.NewTool(name,
+		mcp.WithDescription(description),
+		mcp.WithString("prompt", mcp.Required(), mcp.Description("What to ask")),
+		mcp.WithString("system", mcp.Description("A system message to go before the prompt")),
+		mcp.WithString("model", mcp.Description(fmt.Sprintf("The model the pipeline is asked for, %s if left out", model))),
+	)
+}
+
+// callPipeline runs the named pipeline on the call's prompt. Failures are returned as error results rather
+// than protocol errors, so the calling model gets to read them.
+func callPipeline(p *pipeline.Pipeline, name string, model string) mcpServer.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		prompt, err := request.RequireString("prompt")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		messages := []models.ChatMessage{}
+		if system := request.GetString("system", ""); system != "" {
+			messages = append(messages, models.ChatMessage{Role: "system", Content: system})
+		}
+		messages = append(messages, models.ChatMessage{Role: "user", Content: prompt})
+		chat := models.ChatCompletionRequest{Model: request.GetString("model", model), Messages: messages}
+		if identity := models.IdentityFromContext(ctx); identity != nil {
+			chat.User = identity.Name
+		}
+		output, err := p.ProcessPipeline(ctx, name, chat, trace.NewResponseBuffer())
+		if err != nil {
+			_, detail := models.ErrorResponse(err)
+			return mcp.NewToolResultError(detail.Message), nil
+		}
+		return mcp.NewToolResultText(answer(output.Response)), nil
+	}
+}
+
+// answer is the pipeline's answer as text. A model that wanted to call tools of its own has them listed.
+func answer(chat *models.ChatCompletionResponse) string {
+	if chat == nil || len(chat.Choices) == 0 {
+		return ""
+	}
+	message := chat.Choices[0].Message
+	if message.ToolCalls == nil || len(*message.ToolCalls) == 0 {
+		return message.Content
+	}
+	lines := []string{}
+	if message.Content != "" {
+		lines = append(lines, message.Content)
+	}
+	for _, call := range *message.ToolCalls {
+		lines = append(lines, fmt.Sprintf("Tool call: %s(%s)", call.Function.Name, call.Function.Arguments))
+	}
+	return strings.Join(lines, "\n")
+}
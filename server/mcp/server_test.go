@@ -0,0 +1,197 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpServer "github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/memory"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline"
+	"go.uber.org/zap"
+)
+
+// echoStep answers with the request's last message, prefixed by who asked and for which model.
+type echoStep struct{}
+
+func (echoStep) Name() string { return "echo" }
+func (echoStep) Process(_ *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	messages := input.Request.Messages
+	content := input.Request.User + "@" + input.Request.Model + ": " + messages[len(messages)-1].Content
+	input.Response = &models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{Message: models.ChatMessage{Role: "assistant", Content: content}}}}
+	return input, nil
+}
+
+type failStep struct{}
+
+func (failStep) Name() string { return "fail" }
+func (failStep) Process(*[]models.PipelineStep, *models.PipelineMessage) (*models.PipelineMessage, error) {
+	return nil, errors.New("boom")
+}
+
+func newServer(t *testing.T) *mcpServer.MCPServer {
+	t.Helper()
+	result, err := NewServer(Params{
+		Config: &config.Config{
+			Models: []string{"assist"},
+			Pipelines: map[string]config.PipelineConfig{
+				"echo": {Description: "Repeats the prompt"},
+				"fail": {},
+			},
+			MCP: &config.MCPConfig{Prompts: []config.PromptConfig{{
+				Name:      "roast",
+				Arguments: []config.PromptArgumentConfig{{Name: "target", Required: true}, {Name: "tone"}},
+				Template:  "Roast {{.target}}{{if .tone}}, {{.tone}}{{end}}.",
+			}}},
+		},
+		Pipeline: &pipeline.Pipeline{Named: map[string]models.PipelineStep{"echo": echoStep{}, "fail": failStep{}}},
+		Logger:   zap.NewNop(),
+	})
+	require.NoError(t, err)
+	return result.Server
+}
+
+// call sends one JSON-RPC request to the server and decodes the response's result into out, returning the
+// error it carried instead, if any.
+func call(t *testing.T, ctx context.Context, server *mcpServer.MCPServer, method string, params any, out any) *mcp.JSONRPCError {
+	t.Helper()
+	request, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	require.NoError(t, err)
+	switch response := server.HandleMessage(ctx, request).(type) {
+	case mcp.JSONRPCResponse:
+		data, err := json.Marshal(response.Result)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, out))
+		return nil
+	case mcp.JSONRPCError:
+		return &response
+	default:
+		t.Fatalf("unexpected response %T", response)
+		return nil
+	}
+}
+
+// toolResult is a tools/call result; mcp.CallToolResult can't be decoded, its content is an interface.
+type toolResult struct {
+	Content []struct {
+		Text string `json:"text"`
+	} `json:"content"`
+	IsError bool `json:"isError"`
+}
+
+func TestServer_ListsPipelinesTheIdentityMayRun(t *testing.T) {
+	server := newServer(t)
+
+	var tools mcp.ListToolsResult
+	require.Nil(t, call(t, context.Background(), server, "tools/list", map[string]any{}, &tools))
+	require.Len(t, tools.Tools, 2)
+	assert.Equal(t, "echo", tools.Tools[0].Name)
+	assert.Equal(t, "Repeats the prompt", tools.Tools[0].Description)
+	assert.Equal(t, []string{"prompt"}, tools.Tools[0].InputSchema.Required)
+	assert.Equal(t, "Runs the fail pipeline on a prompt and returns its answer.", tools.Tools[1].Description)
+
+	ctx := models.WithIdentity(context.Background(), &models.Identity{Name: "kitchen", Pipelines: []string{"echo"}})
+	require.Nil(t, call(t, ctx, server, "tools/list", map[string]any{}, &tools))
+	require.Len(t, tools.Tools, 1)
+	assert.Equal(t, "echo", tools.Tools[0].Name)
+}
+
+func TestServer_CallsPipelines(t *testing.T) {
+	server := newServer(t)
+	ctx := models.WithIdentity(context.Background(), &models.Identity{Name: "kitchen"})
+
+	var result toolResult
+	require.Nil(t, call(t, ctx, server, "tools/call", map[string]any{"name": "echo", "arguments": map[string]any{"prompt": "hi"}}, &result))
+	assert.False(t, result.IsError)
+	assert.Equal(t, "kitchen@assist: hi", result.Content[0].Text)
+
+	result = toolResult{}
+	require.Nil(t, call(t, ctx, server, "tools/call", map[string]any{"name": "echo", "arguments": map[string]any{"prompt": "hi", "model": "mistral"}}, &result))
+	assert.Equal(t, "kitchen@mistral: hi", result.Content[0].Text)
+
+	result = toolResult{}
+	require.Nil(t, call(t, ctx, server, "tools/call", map[string]any{"name": "fail", "arguments": map[string]any{"prompt": "hi"}}, &result))
+	assert.True(t, result.IsError, "a failing pipeline is an error result the model can read")
+
+	result = toolResult{}
+	require.Nil(t, call(t, ctx, server, "tools/call", map[string]any{"name": "echo", "arguments": map[string]any{}}, &result))
+	assert.True(t, result.IsError, "the prompt is required")
+}
+
+func TestServer_RendersPrompts(t *testing.T) {
+	server := newServer(t)
+
+	var result struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	require.Nil(t, call(t, context.Background(), server, "prompts/get", map[string]any{"name": "roast", "arguments": map[string]string{"target": "the toaster", "tone": "gently"}}, &result))
+	require.Len(t, result.Messages, 1)
+	assert.Equal(t, "user", result.Messages[0].Role)
+	assert.Equal(t, "Roast the toaster, gently.", result.Messages[0].Content.Text)
+
+	rpcErr := call(t, context.Background(), server, "prompts/get", map[string]any{"name": "roast", "arguments": map[string]string{}}, &result)
+	require.NotNil(t, rpcErr)
+	assert.Contains(t, rpcErr.Error.Message, "target is required")
+}
+
+func TestServer_ServesTheCallersMemories(t *testing.T) {
+	store, err := memory.NewStore(memory.Params{Config: &config.Config{Memory: &config.MemoryConfig{Dir: t.TempDir()}}, Logger: zap.NewNop()})
+	require.NoError(t, err)
+	biscuit, err := store.Store.Add("kitchen", "the cat is called Biscuit", nil)
+	require.NoError(t, err)
+	jazz, err := store.Store.Add("kitchen", "never play jazz", nil)
+	require.NoError(t, err)
+	car, err := store.Store.Add("garage", "the car is blue", nil)
+	require.NoError(t, err)
+	result, err := NewServer(Params{Config: &config.Config{}, Pipeline: &pipeline.Pipeline{}, Memories: store.Store, Logger: zap.NewNop()})
+	require.NoError(t, err)
+	server := result.Server
+	ctx := models.WithIdentity(context.Background(), &models.Identity{Name: "kitchen"})
+
+	var resources mcp.ListResourcesResult
+	require.Nil(t, call(t, ctx, server, "resources/list", map[string]any{}, &resources))
+	require.Len(t, resources.Resources, 1)
+	assert.Equal(t, "memory://", resources.Resources[0].URI)
+	var templates mcp.ListResourceTemplatesResult
+	require.Nil(t, call(t, ctx, server, "resources/templates/list", map[string]any{}, &templates))
+	require.Len(t, templates.ResourceTemplates, 1)
+
+	// ResourceContents is an interface, so the contents are decoded as the text contents they are
+	var read struct {
+		Contents []mcp.TextResourceContents `json:"contents"`
+	}
+	require.Nil(t, call(t, ctx, server, "resources/read", map[string]any{"uri": "memory://"}, &read))
+	require.Len(t, read.Contents, 2)
+	texts := []string{read.Contents[0].Text, read.Contents[1].Text}
+	assert.ElementsMatch(t, []string{biscuit.Text, jazz.Text}, texts, "only the caller's own memories")
+
+	require.Nil(t, call(t, ctx, server, "resources/read", map[string]any{"uri": "memory://" + biscuit.ID}, &read))
+	require.Len(t, read.Contents, 1)
+	assert.Equal(t, "memory://"+biscuit.ID, read.Contents[0].URI)
+	assert.Equal(t, biscuit.Text, read.Contents[0].Text)
+
+	rpcErr := call(t, ctx, server, "resources/read", map[string]any{"uri": "memory://" + car.ID}, &read)
+	require.NotNil(t, rpcErr, "someone else's memory is not found")
+	assert.Contains(t, rpcErr.Error.Message, "memory not found")
+}
+
+func TestNewServer_RejectsBrokenTemplates(t *testing.T) {
+	_, err := NewServer(Params{
+		Config:   &config.Config{MCP: &config.MCPConfig{Prompts: []config.PromptConfig{{Name: "broken", Template: "{{.oops"}}}},
+		Pipeline: &pipeline.Pipeline{},
+		Logger:   zap.NewNop(),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "mcp.prompts[0] (broken)")
+}
@@ -0,0 +1,53 @@
+package mcp
+
+import (
+	"net/http"
+
+	mcpServer "github.com/mark3labs/mcp-go/server"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+)
+
+type SSEServerParams struct {
+	fx.In
+	Server *mcpServer.MCPServer
+}
+
+type SSEResult struct {
+	fx.Out
+	Server *mcpServer.SSEServer
+}
+
+// NewSSEServer serves the MCP server over the older SSE transport, for clients that haven't moved on. The
+// stream on /mcp/sse tells the client to post its messages to /mcp/message.
+func NewSSEServer(p SSEServerParams) SSEResult {
+	return SSEResult{Server: mcpServer.NewSSEServer(p.Server, mcpServer.WithStaticBasePath("/mcp"))}
+}
+
+type SSEControllerParams struct {
+	fx.In
+	Server *mcpServer.SSEServer
+}
+
+// SSEController holds a client's event stream open.
+type SSEController struct {
+	handler http.Handler
+}
+
+func NewSSEController(p SSEControllerParams) *SSEController {
+	return &SSEController{handler: p.Server.SSEHandler()}
+}
+
+func (c *SSEController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.handler.ServeHTTP(w, r)
+}
+
+func (c *SSEController) Pattern() string {
+	return "/sse"
+}
+
+func (c *SSEController) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+var _ utils.Route = (*SSEController)(nil)
@@ -0,0 +1,38 @@
+package mcp
+
+import (
+	"net/http"
+
+	mcpServer "github.com/mark3labs/mcp-go/server"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+)
+
+type StreamableControllerParams struct {
+	fx.In
+	Server *mcpServer.MCPServer
+}
+
+// StreamableController serves the MCP server over streamable HTTP, the transport current clients use.
+type StreamableController struct {
+	handler *mcpServer.StreamableHTTPServer
+}
+
+func NewStreamableController(p StreamableControllerParams) *StreamableController {
+	return &StreamableController{handler: mcpServer.NewStreamableHTTPServer(p.Server, mcpServer.WithEndpointPath("/mcp"))}
+}
+
+func (c *StreamableController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.handler.ServeHTTP(w, r)
+}
+
+// The pattern is empty so the route is /mcp itself.
+func (c *StreamableController) Pattern() string {
+	return ""
+}
+
+func (c *StreamableController) Methods() []string {
+	return []string{http.MethodGet, http.MethodPost, http.MethodDelete}
+}
+
+var _ utils.Route = (*StreamableController)(nil)
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/teagan42/snidemind/auth"
+	"github.com/teagan42/snidemind/models"
+)
+
+const (
+	invalidAPIKey   = "invalid_api_key"
+	modelNotAllowed = "model_not_allowed"
+	modelParam      = "model"
+)
+
+// bearerToken returns the token from the Authorization header, or "" when there isn't one. Anthropic's
+// clients send theirs as x-api-key instead, which is used when there's no Authorization header.
+func bearerToken(r *http.Request) string {
+	if r.Header.Get("Authorization") == "" {
+		return strings.TrimSpace(r.Header.Get("X-Api-Key"))
+	}
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(key)
+}
+
+// AuthMiddleware lets in requests with a configured API key and puts the key's identity in the request
+// context. It only guards the requests that routes matches, and is registered on the root router ahead of the
+// OpenAPI validation middleware so that a caller without a key is refused before its body is looked at.
+func AuthMiddleware(authenticator *auth.Authenticator, routes *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !authenticator.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !routes.Match(r, &mux.RouteMatch{}) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := bearerToken(r)
+			identity, ok := authenticator.Authenticate(key)
+			if !ok {
+				message := "Incorrect API key provided."
+				if key == "" {
+					message = "You didn't provide an API key. Send it in the Authorization header as a Bearer token."
+				}
+				code := invalidAPIKey
+				w.Header().Set("WWW-Authenticate", `Bearer realm="snidemind"`)
+				WriteRouteError(w, r, http.StatusUnauthorized, models.APIErrorDetail{Message: message, Type: models.ErrorTypeInvalidRequest, Code: &code})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(models.WithIdentity(r.Context(), identity)))
+		})
+	}
+}
+
+// ModelAccessMiddleware refuses with a 403 requests for a model the authenticated identity may not use. Like
+// QuotaMiddleware it needs the OpenAPI validation middleware to have read the body.
+func ModelAccessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := models.IdentityFromContext(r.Context())
+		if body, ok := r.Context().Value(BodyKey).(map[string]any); ok {
+			if model, _ := body["model"].(string); model != "" && !identity.AllowsModel(model) {
+				param, code := modelParam, modelNotAllowed
+				WriteRouteError(w, r, http.StatusForbidden, models.APIErrorDetail{
+					Message: fmt.Sprintf("The API key %s is not allowed to use the model %s.", identity.Name, model),
+					Type:    models.ErrorTypePermission,
+					Param:   &param,
+					Code:    &code,
+				})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
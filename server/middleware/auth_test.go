@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/teagan42/snidemind/auth"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.uber.org/zap"
+)
+
+func newAuthHandler(t *testing.T, next http.HandlerFunc) http.Handler {
+	result, err := auth.NewAuthenticator(auth.Params{
+		Config: &config.Config{Auth: &config.AuthConfig{Keys: []config.APIKeyConfig{
+			{Name: "kitchen", KeyHash: auth.HashKey("sk-kitchen"), Models: []string{"mistral"}},
+		}}},
+		Logger: zap.NewNop(),
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator returned error: %v", err)
+	}
+	routes := mux.NewRouter()
+	routes.Handle("/v1/chat/completions", next)
+	return AuthMiddleware(result.Authenticator, routes)(ModelAccessMiddleware(next))
+}
+
+func decodeAPIError(t *testing.T, rr *httptest.ResponseRecorder) models.APIErrorDetail {
+	var body models.APIError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected an OpenAI error body, got %s", rr.Body.String())
+	}
+	return body.Error
+}
+
+func TestAuthMiddleware_Unauthorized(t *testing.T) {
+	handler := newAuthHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called")
+	})
+	for _, header := range []string{"", "Bearer sk-garage", "Basic c2s6a2l0Y2hlbg=="} {
+		req := requestFrom("alice")
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("%q: expected 401, got %d", header, rr.Code)
+		}
+		if detail := decodeAPIError(t, rr); detail.Code == nil || *detail.Code != "invalid_api_key" {
+			t.Errorf("%q: unexpected error: %+v", header, detail)
+		}
+	}
+}
+
+func TestAuthMiddleware_ResolvesIdentity(t *testing.T) {
+	var identity *models.Identity
+	handler := newAuthHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		identity = models.IdentityFromContext(r.Context())
+	})
+	req := requestFrom("alice")
+	req.Header.Set("Authorization", "Bearer sk-kitchen")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || identity == nil || identity.Name != "kitchen" {
+		t.Fatalf("Expected the kitchen identity, got %d %+v", rr.Code, identity)
+	}
+}
+
+func TestAuthMiddleware_AcceptsXAPIKey(t *testing.T) {
+	var identity *models.Identity
+	handler := newAuthHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		identity = models.IdentityFromContext(r.Context())
+	})
+	req := requestFrom("alice")
+	req.Header.Set("X-Api-Key", "sk-kitchen")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || identity == nil || identity.Name != "kitchen" {
+		t.Fatalf("Expected the kitchen identity, got %d %+v", rr.Code, identity)
+	}
+}
+
+func TestAuthMiddleware_ForbiddenModel(t *testing.T) {
+	handler := newAuthHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called")
+	})
+	req := requestFrom("alice")
+	req.Header.Set("Authorization", "Bearer sk-kitchen")
+	body := req.Context().Value(BodyKey).(map[string]any)
+	body["model"] = "gpt-4o"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %d", rr.Code)
+	}
+	if detail := decodeAPIError(t, rr); detail.Param == nil || *detail.Param != "model" {
+		t.Errorf("Unexpected error: %+v", detail)
+	}
+}
+
+func TestAuthMiddleware_LeavesOtherRoutesAlone(t *testing.T) {
+	called := false
+	handler := newAuthHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rr.Code != http.StatusOK || !called {
+		t.Fatalf("Expected a route the API router doesn't serve to be let through, got %d", rr.Code)
+	}
+}
+
+func TestAuthMiddleware_RefusesBeforeTheBodyIsValidated(t *testing.T) {
+	result, err := auth.NewAuthenticator(auth.Params{
+		Config: &config.Config{Auth: &config.AuthConfig{Keys: []config.APIKeyConfig{
+			{Name: "kitchen", KeyHash: auth.HashKey("sk-kitchen")},
+		}}},
+		Logger: zap.NewNop(),
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator returned error: %v", err)
+	}
+	root := mux.NewRouter()
+	v1 := root.PathPrefix("/v1").Subrouter()
+	v1.HandleFunc("/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called")
+	})
+	root.Use(AuthMiddleware(result.Authenticator, v1))
+	// Stands in for the OpenAPI validation, which the server registers on the root router when it starts
+	root.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			WriteRouteError(w, r, http.StatusBadRequest, models.APIErrorDetail{Message: "bad body", Type: models.ErrorTypeInvalidRequest})
+		})
+	})
+
+	rr := httptest.NewRecorder()
+	root.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"messages":"nope"}`)))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a caller without a key, got %d", rr.Code)
+	}
+}
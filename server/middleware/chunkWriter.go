@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/teagan42/snidemind/models"
+)
+
+// ChunkWriter stands in for the client when the client doesn't speak chat completions. Steps stream to it as
+// they would to the client, and every chunk they send is handed to onChunk to be translated. Anything that
+// isn't a chunk (headers, a step answering in one piece) is dropped; the translation has the pipeline's final
+// response to go by for that.
+type ChunkWriter struct {
+	header  http.Header
+	pending []byte
+	onChunk func(models.ChatCompletionChunk) error
+}
+
+func NewChunkWriter(onChunk func(models.ChatCompletionChunk) error) *ChunkWriter {
+	return &ChunkWriter{header: http.Header{}, onChunk: onChunk}
+}
+
+func (c *ChunkWriter) Header() http.Header {
+	return c.header
+}
+
+func (c *ChunkWriter) WriteHeader(status int) {}
+
+func (c *ChunkWriter) Flush() {}
+
+// Write takes whatever the step writes, which needn't be whole lines. An error from onChunk (the client
+// hanging up, say) is returned to the step so it stops streaming.
+func (c *ChunkWriter) Write(data []byte) (int, error) {
+	c.pending = append(c.pending, data...)
+	for {
+		end := bytes.IndexByte(c.pending, '\n')
+		if end < 0 {
+			return len(data), nil
+		}
+		line := strings.TrimSpace(string(c.pending[:end]))
+		c.pending = c.pending[end+1:]
+		payload, ok := strings.CutPrefix(line, "data: ")
+		if !ok || payload == "[DONE]" {
+			continue
+		}
+		var chunk models.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			continue
+		}
+		if err := c.onChunk(chunk); err != nil {
+			return len(data), err
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/teagan42/snidemind/models"
+)
+
+func TestChunkWriter_DecodesChunksAcrossWrites(t *testing.T) {
+	var contents []string
+	writer := NewChunkWriter(func(chunk models.ChatCompletionChunk) error {
+		contents = append(contents, chunk.Choices[0].Delta.Content)
+		return nil
+	})
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.WriteHeader(200)
+	for _, part := range []string{
+		`data: {"choices":[{"delta":{"content":"Hel"}}]}` + "\n\n" + `data: {"choi`,
+		`ces":[{"delta":{"content":"lo"}}]}` + "\n\n",
+		`{"not":"a chunk"}` + "\n",
+		"data: [DONE]\n\n",
+	} {
+		if _, err := writer.Write([]byte(part)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(contents) != 2 || contents[0] != "Hel" || contents[1] != "lo" {
+		t.Errorf("expected the two chunks, got %q", contents)
+	}
+}
+
+func TestChunkWriter_ReturnsCallbackErrors(t *testing.T) {
+	writer := NewChunkWriter(func(chunk models.ChatCompletionChunk) error {
+		return errors.New("client went away")
+	})
+	if _, err := writer.Write([]byte(`data: {"choices":[]}` + "\n")); err == nil {
+		t.Error("expected the callback's error")
+	}
+}
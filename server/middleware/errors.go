@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/teagan42/snidemind/models"
+)
+
+// WriteError sends an error in the OpenAI error envelope.
+func WriteError(w http.ResponseWriter, status int, detail models.APIErrorDetail) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(models.APIError{Error: detail})
+}
+
+// RouteErrorWriter is implemented by routes whose clients expect errors in some other shape than OpenAI's, e.g.
+// /v1/messages answering the way Anthropic does. The middleware answers those routes' requests through it.
+type RouteErrorWriter interface {
+	WriteError(w http.ResponseWriter, status int, detail models.APIErrorDetail) error
+}
+
+// WriteRouteError sends an error the way the route the request matched wants it, in the OpenAI error envelope
+// unless the route is a RouteErrorWriter.
+func WriteRouteError(w http.ResponseWriter, r *http.Request, status int, detail models.APIErrorDetail) error {
+	if route := mux.CurrentRoute(r); route != nil {
+		if writer, ok := route.GetHandler().(RouteErrorWriter); ok {
+			return writer.WriteError(w, status, detail)
+		}
+	}
+	return WriteError(w, status, detail)
+}
+
+// WriteBadRequest sends an invalid_request_error about the named parameter.
+func WriteBadRequest(w http.ResponseWriter, param string, message string) error {
+	return WriteError(w, http.StatusBadRequest, models.APIErrorDetail{Message: message, Type: models.ErrorTypeInvalidRequest, Param: &param})
+}
+
+// WriteFailure sends an error returned while handling a request, see models.ErrorResponse for how it's mapped.
+func WriteFailure(w http.ResponseWriter, err error) error {
+	status, detail := models.ErrorResponse(err)
+	return WriteError(w, status, detail)
+}
+
+// TrackResponse wraps w to report whether anything has been sent yet. Once a step has started streaming to the
+// client an error can't be sent as a JSON body any more, it would end up in the middle of the stream.
+func TrackResponse(w http.ResponseWriter) (http.ResponseWriter, func() bool) {
+	written := false
+	wrapped := httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				written = true
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				written = true
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				written = true
+				return next(src)
+			}
+		},
+	})
+	return wrapped, func() bool { return written }
+}
+
+// NotFound answers a request for a route that doesn't exist.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusNotFound, models.APIErrorDetail{
+		Message: "Invalid URL (" + r.Method + " " + r.URL.Path + ")",
+		Type:    models.ErrorTypeInvalidRequest,
+	})
+}
+
+// MethodNotAllowed answers a request whose method the route doesn't handle.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusMethodNotAllowed, models.APIErrorDetail{
+		Message: "Method " + r.Method + " is not allowed for " + r.URL.Path,
+		Type:    models.ErrorTypeInvalidRequest,
+	})
+}
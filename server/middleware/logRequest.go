@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/teagan42/snidemind/models"
 	"go.uber.org/zap"
 )
 
@@ -13,7 +14,7 @@ func LogRequestMiddleware() func(http.Handler) http.Handler {
 			body, err := PeekBody(log, r)
 			if err != nil {
 				log.Error("Error reading request body", zap.Error(err))
-				http.Error(w, "Error reading request body", http.StatusInternalServerError)
+				WriteRouteError(w, r, http.StatusInternalServerError, models.APIErrorDetail{Message: "Error reading request body: " + err.Error(), Type: models.ErrorTypeServer})
 				return
 			}
 			log.Info(
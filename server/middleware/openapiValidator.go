@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/getkin/kin-openapi/routers"
+	"github.com/teagan42/snidemind/models"
 	"go.uber.org/zap"
 )
 
@@ -49,7 +52,10 @@ func OpenAPIValidationMiddleware(router routers.Router) func(http.Handler) http.
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			route, routeParams, err := router.FindRoute(r)
 			if err != nil {
-				http.Error(w, "Route not found: "+err.Error(), http.StatusNotFound)
+				WriteRouteError(w, r, http.StatusNotFound, models.APIErrorDetail{
+					Message: fmt.Sprintf("Invalid URL (%s %s): %s", r.Method, r.URL.Path, err.Error()),
+					Type:    models.ErrorTypeInvalidRequest,
+				})
 				return
 			}
 
@@ -71,7 +77,11 @@ func OpenAPIValidationMiddleware(router routers.Router) func(http.Handler) http.
 				Options:     &options,
 			}
 			if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
-				http.Error(w, "Request validation failed: "+err.Error(), http.StatusBadRequest)
+				WriteRouteError(w, r, http.StatusBadRequest, models.APIErrorDetail{
+					Message: "Request validation failed: " + err.Error(),
+					Type:    models.ErrorTypeInvalidRequest,
+					Param:   validationParam(err),
+				})
 				return
 			}
 			ctx := r.Context()
@@ -79,14 +89,17 @@ func OpenAPIValidationMiddleware(router routers.Router) func(http.Handler) http.
 				var raw any
 				body, err := PeekBody(logger, r)
 				if err != nil {
-					http.Error(w, "Error reading request body: "+err.Error(), http.StatusBadRequest)
+					WriteRouteError(w, r, http.StatusBadRequest, models.APIErrorDetail{Message: "Error reading request body: " + err.Error(), Type: models.ErrorTypeInvalidRequest})
 					return
 				}
-				if err := json.NewDecoder(io.NopCloser(bytes.NewBuffer(body))).Decode(&raw); err != nil {
-					http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
-					return
+				// GETs like /metrics arrive with an empty body, there's nothing to decode
+				if len(body) > 0 {
+					if err := json.NewDecoder(io.NopCloser(bytes.NewBuffer(body))).Decode(&raw); err != nil {
+						WriteRouteError(w, r, http.StatusBadRequest, models.APIErrorDetail{Message: "Invalid JSON body: " + err.Error(), Type: models.ErrorTypeInvalidRequest})
+						return
+					}
+					ctx = context.WithValue(ctx, BodyKey, raw)
 				}
-				ctx = context.WithValue(ctx, BodyKey, raw)
 			}
 			if r.URL.Query() != nil {
 				rawQuery := make(map[string]any)
@@ -106,6 +119,23 @@ func OpenAPIValidationMiddleware(router routers.Router) func(http.Handler) http.
 	}
 }
 
+// validationParam names the parameter or body field that failed validation, e.g. `messages.0.role`,
+// for the error's `param`.
+func validationParam(err error) *string {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		if path := schemaErr.JSONPointer(); len(path) > 0 {
+			param := strings.Join(path, ".")
+			return &param
+		}
+	}
+	var requestErr *openapi3filter.RequestError
+	if errors.As(err, &requestErr) && requestErr.Parameter != nil {
+		return &requestErr.Parameter.Name
+	}
+	return nil
+}
+
 func GetValidatedBody[T any](r *http.Request) (T, error) {
 	var zero T
 	val := r.Context().Value(BodyKey)
@@ -13,6 +13,7 @@ import (
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/getkin/kin-openapi/routers"
 	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/teagan42/snidemind/models"
 	"go.uber.org/zap"
 )
 
@@ -86,6 +87,45 @@ func TestOpenAPIValidationMiddleware_RouteNotFound(t *testing.T) {
 	if rr.Code != http.StatusNotFound {
 		t.Errorf("Expected 404, got %d", rr.Code)
 	}
+	if detail := decodeAPIError(t, rr); detail.Type != models.ErrorTypeInvalidRequest {
+		t.Errorf("Unexpected error: %+v", detail)
+	}
+}
+
+func TestOpenAPIValidationMiddleware_ValidationError(t *testing.T) {
+	spec := `{
+		"openapi": "3.0.0",
+		"info": {"title": "Test API", "version": "1.0.0"},
+		"paths": {"/": {"post": {
+			"requestBody": {"content": {"application/json": {"schema": {
+				"type": "object",
+				"properties": {"messages": {"type": "array", "items": {
+					"type": "object",
+					"properties": {"role": {"type": "string", "enum": ["user"]}}
+				}}}
+			}}}},
+			"responses": {"200": {"description": "OK"}}
+		}}}
+	}`
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(spec))
+	if err != nil {
+		t.Fatalf("Failed to load OpenAPI spec: %v", err)
+	}
+	router, _ := legacy.NewRouter(doc)
+	handler := OpenAPIValidationMiddleware(router)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called on an invalid request")
+	}))
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"messages":[{"role":"wizard"}]}`)))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", rr.Code)
+	}
+	detail := decodeAPIError(t, rr)
+	if detail.Type != models.ErrorTypeInvalidRequest || detail.Param == nil || *detail.Param != "messages.0.role" {
+		t.Errorf("Unexpected error: %+v", detail)
+	}
 }
 
 func TestOpenAPIValidationMiddleware_SetsContextValues(t *testing.T) {
@@ -257,3 +297,28 @@ func TestGetRouteParams_TypeMismatch(t *testing.T) {
 		t.Error("Expected error on route params type mismatch")
 	}
 }
+
+func TestOpenAPIValidationMiddleware_AllowsEmptyBody(t *testing.T) {
+	spec := `{
+		"openapi":"3.0.0",
+		"info":{"title":"Test API","version":"1.0.0"},
+		"paths":{"/metrics":{"get":{"responses":{"200":{"description":"OK"}}}}}
+	}`
+	doc, err := openapi3.NewLoader().LoadFromData([]byte(spec))
+	if err != nil {
+		t.Fatalf("Failed to load OpenAPI spec: %v", err)
+	}
+	router, _ := legacy.NewRouter(doc)
+	called := false
+	handler := OpenAPIValidationMiddleware(router)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if _, err := GetValidatedBody[map[string]any](r); err == nil {
+			t.Error("Expected no validated body for a request without one")
+		}
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !called {
+		t.Errorf("Handler was not called, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
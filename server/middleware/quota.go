@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/quota"
+)
+
+const rateLimitExceeded = "rate_limit_exceeded"
+
+// QuotaConsumer picks who a request is charged to: the identity it authenticated as or, without one, the address
+// it came from. The bearer token or the `user` in the body only count when `limits.consumers` names them, so a
+// client can't get a fresh allowance, and the limiter yet another counter to keep, by sending a new one each time.
+func QuotaConsumer(r *http.Request, limiter *quota.Limiter) quota.Consumer {
+	if identity := models.IdentityFromContext(r.Context()); identity != nil {
+		return quota.IdentityConsumer(identity.Name)
+	}
+	if key := bearerToken(r); key != "" && limiter.Configures(key) {
+		return quota.APIKeyConsumer(key)
+	}
+	if body, ok := r.Context().Value(BodyKey).(map[string]any); ok {
+		if user, _ := body["user"].(string); user != "" && limiter.Configures(user) {
+			return quota.UserConsumer(user)
+		}
+	}
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	return quota.AddressConsumer(address)
+}
+
+// QuotaMiddleware refuses requests over their consumer's limits with a 429 and a Retry-After header,
+// and charges the tokens spent on the ones it lets through. It has to run after the OpenAPI validation
+// middleware, which is what reads the body the `user` comes from.
+func QuotaMiddleware(limiter *quota.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limiter.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			consumer := QuotaConsumer(r, limiter)
+			decision := limiter.Allow(consumer)
+			setRateLimitHeaders(w.Header(), decision)
+			if !decision.Allowed {
+				code := rateLimitExceeded
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+				WriteRouteError(w, r, http.StatusTooManyRequests, models.APIErrorDetail{
+					Message: rateLimitMessage(consumer, decision),
+					Type:    decision.Limit,
+					Code:    &code,
+				})
+				return
+			}
+			meter := models.NewUsageMeter()
+			next.ServeHTTP(w, r.WithContext(models.WithUsageMeter(r.Context(), meter)))
+			limiter.Charge(consumer, meter.Total().TotalTokens)
+		})
+	}
+}
+
+func setRateLimitHeaders(header http.Header, decision quota.Decision) {
+	if limit := decision.Config.RequestsPerMinute; limit != nil {
+		header.Set("x-ratelimit-limit-requests", strconv.Itoa(*limit))
+		header.Set("x-ratelimit-remaining-requests", strconv.Itoa(decision.RemainingRequests))
+	}
+	if limit := decision.Config.TokensPerDay; limit != nil {
+		header.Set("x-ratelimit-limit-tokens", strconv.Itoa(*limit))
+		header.Set("x-ratelimit-remaining-tokens", strconv.Itoa(decision.RemainingTokens))
+	}
+}
+
+func rateLimitMessage(consumer quota.Consumer, decision quota.Decision) string {
+	if decision.Limit == quota.LimitTokens {
+		return fmt.Sprintf("Rate limit reached for %s on tokens per day: limit %d. Try again in %s.", consumer.ID, *decision.Config.TokensPerDay, decision.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("Rate limit reached for %s on requests per minute: limit %d. Try again in %s.", consumer.ID, *decision.Config.RequestsPerMinute, decision.RetryAfter.Round(time.Second))
+}
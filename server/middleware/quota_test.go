@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/quota"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func newQuotaLimiter(t *testing.T, limits config.LimitConfig) *quota.Limiter {
+	result, err := quota.NewLimiter(quota.Params{
+		Config:    &config.Config{Limits: &config.LimitsConfig{StateFile: filepath.Join(t.TempDir(), "limits.json"), Default: limits}},
+		Logger:    zap.NewNop(),
+		Lifecycle: fxtest.NewLifecycle(t),
+	})
+	if err != nil {
+		t.Fatalf("NewLimiter returned error: %v", err)
+	}
+	return result.Limiter
+}
+
+func requestFrom(user string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return req.WithContext(context.WithValue(req.Context(), BodyKey, map[string]any{"model": "mistral", "user": user}))
+}
+
+func TestQuotaConsumer(t *testing.T) {
+	result, err := quota.NewLimiter(quota.Params{
+		Config: &config.Config{Limits: &config.LimitsConfig{
+			StateFile: filepath.Join(t.TempDir(), "limits.json"),
+			Consumers: map[string]config.LimitConfig{"alice": {}, "sk-kitchen": {}},
+		}},
+		Logger:    zap.NewNop(),
+		Lifecycle: fxtest.NewLifecycle(t),
+	})
+	if err != nil {
+		t.Fatalf("NewLimiter returned error: %v", err)
+	}
+	limiter := result.Limiter
+
+	if got := QuotaConsumer(requestFrom("alice"), limiter).ID; got != "user:alice" {
+		t.Errorf("Expected the body's configured user, got %s", got)
+	}
+	req := requestFrom("alice")
+	req.Header.Set("Authorization", "Bearer sk-kitchen")
+	if got := QuotaConsumer(req, limiter); got != quota.APIKeyConsumer("sk-kitchen") {
+		t.Errorf("Expected the API key to win over the user, got %+v", got)
+	}
+	req = requestFrom("mallory-1")
+	req.Header.Set("Authorization", "Bearer sk-made-up")
+	req.RemoteAddr = "192.0.2.7:51234"
+	if got := QuotaConsumer(req, limiter).ID; got != "address:192.0.2.7" {
+		t.Errorf("Expected a key and user nobody configured to be charged to the address, got %s", got)
+	}
+	if got := QuotaConsumer(req.WithContext(models.WithIdentity(req.Context(), &models.Identity{Name: "kitchen"})), limiter).ID; got != "identity:kitchen" {
+		t.Errorf("Expected the identity to win, got %s", got)
+	}
+}
+
+func TestQuotaMiddleware_RefusesOverLimit(t *testing.T) {
+	requests := 1
+	handler := QuotaMiddleware(newQuotaLimiter(t, config.LimitConfig{RequestsPerMinute: &requests}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, requestFrom("alice"))
+	if rr.Code != http.StatusOK || rr.Header().Get("x-ratelimit-remaining-requests") != "0" {
+		t.Fatalf("Expected the first request through with none left, got %d %v", rr.Code, rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, requestFrom("alice"))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected Retry-After 60, got %q", rr.Header().Get("Retry-After"))
+	}
+	var body models.APIError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected an OpenAI error body, got %s", rr.Body.String())
+	}
+	if body.Error.Type != quota.LimitRequests || body.Error.Code == nil || *body.Error.Code != "rate_limit_exceeded" {
+		t.Errorf("Unexpected error: %+v", body.Error)
+	}
+}
+
+func TestQuotaMiddleware_ChargesTokens(t *testing.T) {
+	tokens := 10
+	handler := QuotaMiddleware(newQuotaLimiter(t, config.LimitConfig{TokensPerDay: &tokens}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		models.UsageMeterFromContext(r.Context()).Add("mistral", models.Usage{PromptTokens: 8, CompletionTokens: 4, TotalTokens: 12})
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), requestFrom("alice"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, requestFrom("alice"))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the day's tokens to be used up, got %d", rr.Code)
+	}
+	if rr.Header().Get("x-ratelimit-remaining-tokens") != "0" {
+		t.Errorf("Expected no tokens left, got %q", rr.Header().Get("x-ratelimit-remaining-tokens"))
+	}
+}
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"github.com/teagan42/snidemind/server/health"
+	"github.com/teagan42/snidemind/server/mcp"
+	"github.com/teagan42/snidemind/server/ollama"
 	v1 "github.com/teagan42/snidemind/server/v1"
 	"go.uber.org/fx"
 )
@@ -11,4 +14,7 @@ var Module = fx.Module(
 		NewServer,
 	),
 	v1.Module,
+	ollama.Module,
+	mcp.Module,
+	health.Module,
 )
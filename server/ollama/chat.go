@@ -0,0 +1,104 @@
+package ollama
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	"github.com/teagan42/snidemind/server/middleware"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type ChatControllerParams struct {
+	fx.In
+	Log      *zap.Logger
+	Pipeline *pipeline.Pipeline
+}
+
+// ChatController serves Ollama's /api/chat by translating it to and from the chat completion the pipeline runs.
+type ChatController struct {
+	log      *zap.Logger
+	pipeline *pipeline.Pipeline
+}
+
+func NewChatController(p ChatControllerParams) *ChatController {
+	return &ChatController{
+		log:      p.Log.Named("OllamaChatController"),
+		pipeline: p.Pipeline,
+	}
+}
+
+// writeError answers in Ollama's error shape, which is all its clients look for.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func writeFailure(w http.ResponseWriter, err error) {
+	status, detail := models.ErrorResponse(err)
+	writeError(w, status, detail.Message)
+}
+
+func (c *ChatController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	request, err := middleware.GetValidatedBody[models.OllamaChatRequest](r)
+	if err != nil {
+		c.log.Error("Error getting validated body", zap.Error(err))
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	chat, err := chatRequest(request)
+	if err != nil {
+		var requestErr *requestError
+		if errors.As(err, &requestErr) {
+			writeError(w, http.StatusBadRequest, requestErr.message)
+		} else {
+			writeFailure(w, err)
+		}
+		return
+	}
+
+	if *chat.Stream {
+		lines := newLineWriter(w, request.Model, start)
+		lines.begin()
+		output, err := c.pipeline.ProcessRequest(r.Context(), chat, middleware.NewChunkWriter(lines.chunk))
+		if err != nil {
+			c.log.Error("Error processing pipeline", zap.Error(err))
+			lines.failed(err)
+			return
+		}
+		lines.finish(output.Response, output.TotalUsage())
+		return
+	}
+	// The steps answer in chat completion format, which is of no use to this client
+	output, err := c.pipeline.ProcessRequest(r.Context(), chat, trace.NewResponseBuffer())
+	if err != nil {
+		c.log.Error("Error processing pipeline", zap.Error(err))
+		writeFailure(w, err)
+		return
+	}
+	answer := done(request.Model, output.Response, output.TotalUsage(), start)
+	answer.Message = message(output.Response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(answer); err != nil {
+		c.log.Error("Error writing answer", zap.Error(err))
+	}
+}
+
+func (c *ChatController) Pattern() string {
+	return "/chat"
+}
+
+func (c *ChatController) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+var _ utils.Route = (*ChatController)(nil)
@@ -0,0 +1,73 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/pipeline/steps/llm"
+	"github.com/teagan42/snidemind/server/middleware"
+	"go.uber.org/zap"
+)
+
+// serve runs the controller on a request with the body the validator would have left in its context.
+func serve(t *testing.T, handler http.Handler, method string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(method, "/", nil)
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		var raw any
+		require.NoError(t, json.Unmarshal(data, &raw))
+		request = httptest.NewRequest(method, "/", bytes.NewReader(data))
+		request = request.WithContext(context.WithValue(request.Context(), middleware.BodyKey, raw))
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestChatController_AsksUpstreamForTheStepsModel(t *testing.T) {
+	var asked models.ChatCompletionRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&asked))
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"mistral","choices":[{"index":0,"message":{"role":"assistant","content":"No."},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+
+	var cfg config.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"pipeline":{"steps":[{"type":"llm","llm":{"model":"mistral","base_url":"`+upstream.URL+`"}}]}}`), &cfg))
+	p, err := pipeline.NewPipeline(pipeline.Params{
+		Config:        &cfg,
+		Logger:        zap.NewNop(),
+		StepFactories: map[string]models.PipelineStepFactory{"llm": llm.LLMFactory{Logger: zap.NewNop()}},
+	})
+	require.NoError(t, err)
+
+	var tags models.OllamaTagsResponse
+	require.NoError(t, json.NewDecoder(serve(t, NewTagsController(TagsControllerParams{Log: zap.NewNop(), Config: &cfg}), http.MethodGet, nil).Body).Decode(&tags))
+	require.Len(t, tags.Models, 1)
+	offered := tags.Models[0].Name
+	assert.Equal(t, config.DefaultModel, offered)
+
+	stream := false
+	recorder := serve(t, NewChatController(ChatControllerParams{Log: zap.NewNop(), Pipeline: p}), http.MethodPost, models.OllamaChatRequest{
+		Model:    offered,
+		Messages: []models.OllamaMessage{{Role: "user", Content: "lights"}},
+		Stream:   &stream,
+	})
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Equal(t, "mistral", asked.Model, "the virtual model stays with SnideMind")
+	var answer models.OllamaChatResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&answer))
+	assert.Equal(t, offered, answer.Model, "the client is answered as the model it asked for")
+}
@@ -0,0 +1,145 @@
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/utils"
+)
+
+// requestError is a request the pipeline can't be given, reported to the client as a 400.
+type requestError struct {
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// call is a tool call waiting for its result.
+type call struct {
+	id   string
+	name string
+}
+
+// chatRequest turns an Ollama chat request into the chat completion request the pipeline runs. Ollama streams
+// unless asked not to, so the request does too.
+func chatRequest(request models.OllamaChatRequest) (models.ChatCompletionRequest, error) {
+	stream := request.Stream == nil || *request.Stream
+	chat := models.ChatCompletionRequest{
+		Messages:         make([]models.ChatMessage, 0, len(request.Messages)),
+		Model:            request.Model,
+		Stream:           &stream,
+		Temperature:      option(request.Options, "temperature"),
+		TopP:             option(request.Options, "top_p"),
+		FrequencyPenalty: option(request.Options, "frequency_penalty"),
+		PresencePenalty:  option(request.Options, "presence_penalty"),
+	}
+	if numPredict := option(request.Options, "num_predict"); numPredict != nil && *numPredict > 0 {
+		maxTokens := int64(*numPredict)
+		chat.MaxCompletionTokens = &maxTokens
+	}
+	if len(request.Tools) > 0 {
+		chat.Tools = &request.Tools
+	}
+	var pending []call
+	for i, message := range request.Messages {
+		if len(message.Images) > 0 {
+			return models.ChatCompletionRequest{}, &requestError{fmt.Sprintf("messages.%d.images: images are not supported", i)}
+		}
+		converted := models.ChatMessage{Role: message.Role, Content: message.Content}
+		if len(message.ToolCalls) > 0 {
+			calls := make([]models.ChatCompletionsMessageToolCall, len(message.ToolCalls))
+			for j, toolCall := range message.ToolCalls {
+				arguments := string(toolCall.Function.Arguments)
+				if arguments == "" || arguments == "null" {
+					arguments = "{}"
+				}
+				calls[j] = models.ChatCompletionsMessageToolCall{
+					ID:       utils.NewID("call_"),
+					Type:     "function",
+					Function: models.ChatCompletionsMessageFunctionCall{Name: toolCall.Function.Name, Arguments: arguments},
+				}
+				pending = append(pending, call{id: calls[j].ID, name: toolCall.Function.Name})
+			}
+			converted.ToolCalls = &calls
+		}
+		if message.Role == "tool" {
+			converted.ToolCallID, pending = resultFor(pending, message.ToolName)
+		}
+		chat.Messages = append(chat.Messages, converted)
+	}
+	return chat, nil
+}
+
+// resultFor finds the call a tool result is for, the first one still waiting for a result from the named tool.
+// Results that don't say which tool they're from answer the oldest call.
+func resultFor(pending []call, name string) (string, []call) {
+	for i, waiting := range pending {
+		if name == "" || waiting.name == name {
+			return waiting.id, append(pending[:i:i], pending[i+1:]...)
+		}
+	}
+	return "", pending
+}
+
+// option reads a number from Ollama's options, which arrive as whatever JSON decoded them into.
+func option(options map[string]any, key string) *float64 {
+	switch value := options[key].(type) {
+	case float64:
+		return &value
+	case int:
+		converted := float64(value)
+		return &converted
+	}
+	return nil
+}
+
+// message is the model's answer as an Ollama message.
+func message(chat *models.ChatCompletionResponse) models.OllamaMessage {
+	answer := models.OllamaMessage{Role: "assistant"}
+	if chat == nil || len(chat.Choices) == 0 {
+		return answer
+	}
+	answer.Content = chat.Choices[0].Message.Content
+	answer.ToolCalls = toolCalls(chat)
+	return answer
+}
+
+// toolCalls lists the model's tool calls. Ollama's arguments are objects; ones that don't parse (a model cut
+// off mid-call, say) are sent as an empty one.
+func toolCalls(chat *models.ChatCompletionResponse) []models.OllamaToolCall {
+	if chat == nil || len(chat.Choices) == 0 || chat.Choices[0].Message.ToolCalls == nil {
+		return nil
+	}
+	calls := []models.OllamaToolCall{}
+	for _, toolCall := range *chat.Choices[0].Message.ToolCalls {
+		arguments := json.RawMessage(toolCall.Function.Arguments)
+		if !json.Valid(arguments) {
+			arguments = json.RawMessage(`{}`)
+		}
+		calls = append(calls, models.OllamaToolCall{Function: models.OllamaFunction{Name: toolCall.Function.Name, Arguments: arguments}})
+	}
+	return calls
+}
+
+// done is the last line of the answer, the one with the counts. The pipeline has no notion of prompt
+// evaluation versus generation, so only the total duration is reported.
+func done(model string, chat *models.ChatCompletionResponse, usage models.Usage, start time.Time) models.OllamaChatResponse {
+	reason := "stop"
+	if chat != nil && len(chat.Choices) > 0 && chat.Choices[0].FinishReason == "length" {
+		reason = "length"
+	}
+	return models.OllamaChatResponse{
+		Model:           model,
+		CreatedAt:       time.Now().UTC(),
+		Message:         models.OllamaMessage{Role: "assistant"},
+		Done:            true,
+		DoneReason:      reason,
+		TotalDuration:   time.Since(start).Nanoseconds(),
+		PromptEvalCount: usage.PromptTokens,
+		EvalCount:       usage.CompletionTokens,
+	}
+}
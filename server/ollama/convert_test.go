@@ -0,0 +1,83 @@
+package ollama
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+)
+
+func TestChatRequest_MatchesToolResultsToCalls(t *testing.T) {
+	chat, err := chatRequest(models.OllamaChatRequest{
+		Model: "assist",
+		Messages: []models.OllamaMessage{
+			{Role: "user", Content: "lights and fan"},
+			{Role: "assistant", ToolCalls: []models.OllamaToolCall{
+				{Function: models.OllamaFunction{Name: "lights", Arguments: json.RawMessage(`{"on":true}`)}},
+				{Function: models.OllamaFunction{Name: "fan"}},
+			}},
+			{Role: "tool", ToolName: "fan", Content: "off"},
+			{Role: "tool", Content: "on"},
+		},
+		Tools: []models.Tool{{Type: "function", Function: models.ToolFunction{Name: "lights"}}},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, chat.Messages, 4)
+	calls := *chat.Messages[1].ToolCalls
+	assert.Equal(t, `{"on":true}`, calls[0].Function.Arguments)
+	assert.Equal(t, "{}", calls[1].Function.Arguments)
+	assert.Equal(t, calls[1].ID, chat.Messages[2].ToolCallID, "answered by name")
+	assert.Equal(t, calls[0].ID, chat.Messages[3].ToolCallID, "an unnamed result answers the oldest call left")
+	require.NotNil(t, chat.Tools)
+	assert.Equal(t, "lights", (*chat.Tools)[0].Function.Name)
+}
+
+func TestChatRequest_Options(t *testing.T) {
+	chat, err := chatRequest(models.OllamaChatRequest{
+		Model:    "assist",
+		Messages: []models.OllamaMessage{{Role: "user", Content: "hi"}},
+		Options:  map[string]any{"temperature": 0.2, "top_p": 0.9, "num_predict": float64(128), "num_ctx": float64(8192)},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, *chat.Stream, "Ollama streams unless told not to")
+	assert.Equal(t, 0.2, *chat.Temperature)
+	assert.Equal(t, 0.9, *chat.TopP)
+	assert.Equal(t, int64(128), *chat.MaxCompletionTokens)
+	assert.Nil(t, chat.PresencePenalty)
+
+	stream := false
+	chat, err = chatRequest(models.OllamaChatRequest{Model: "assist", Stream: &stream, Options: map[string]any{"num_predict": float64(-1)}})
+	require.NoError(t, err)
+	assert.False(t, *chat.Stream)
+	assert.Nil(t, chat.MaxCompletionTokens, "-1 is Ollama for no limit")
+}
+
+func TestChatRequest_RefusesImages(t *testing.T) {
+	_, err := chatRequest(models.OllamaChatRequest{Model: "assist", Messages: []models.OllamaMessage{{Role: "user", Images: []string{"aGk="}}}})
+
+	var requestErr *requestError
+	require.True(t, errors.As(err, &requestErr))
+	assert.Contains(t, requestErr.message, "messages.0.images")
+}
+
+func TestDone(t *testing.T) {
+	calls := []models.ChatCompletionsMessageToolCall{{Function: models.ChatCompletionsMessageFunctionCall{Name: "lights", Arguments: `{"on":`}}}
+	chat := &models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{FinishReason: "length", Message: models.ChatMessage{Content: "No.", ToolCalls: &calls}}}}
+
+	answer := done("assist", chat, models.Usage{PromptTokens: 10, CompletionTokens: 3}, time.Now().Add(-time.Second))
+	assert.True(t, answer.Done)
+	assert.Equal(t, "length", answer.DoneReason)
+	assert.Equal(t, 10, answer.PromptEvalCount)
+	assert.Equal(t, 3, answer.EvalCount)
+	assert.GreaterOrEqual(t, answer.TotalDuration, time.Second.Nanoseconds())
+
+	converted := message(chat)
+	assert.Equal(t, "No.", converted.Content)
+	assert.JSONEq(t, `{}`, string(converted.ToolCalls[0].Function.Arguments), "broken arguments are sent as an empty object")
+}
@@ -0,0 +1,23 @@
+package ollama
+
+import (
+	"github.com/teagan42/snidemind/server/utils"
+	v1 "github.com/teagan42/snidemind/server/v1"
+	"go.uber.org/fx"
+)
+
+// Module serves Ollama's API under /api, behind the same API keys and limits as /v1.
+var Module = utils.ApiRouteModule(utils.ApiRouteModuleParams{
+	ParentRouter: "root",
+	ModuleName:   "ollama",
+	Prefix:       "api",
+	Routes: &[]any{
+		NewChatController,
+		NewShowController,
+		NewTagsController,
+	},
+	SubModules: &[]fx.Option{
+		fx.Invoke(fx.Annotate(v1.UseAuth, fx.ParamTags(`name:"ollamaRouter"`))),
+		fx.Invoke(fx.Annotate(v1.UseQuota, fx.ParamTags(`name:"ollamaRouter"`))),
+	},
+})
@@ -0,0 +1,90 @@
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/middleware"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type ShowControllerParams struct {
+	fx.In
+	Log    *zap.Logger
+	Config *config.Config
+}
+
+// ShowController describes a configured model. Clients check its capabilities before offering it tools.
+type ShowController struct {
+	log     *zap.Logger
+	models  []string
+	started time.Time
+}
+
+func NewShowController(p ShowControllerParams) *ShowController {
+	return &ShowController{
+		log:     p.Log.Named("OllamaShowController"),
+		models:  p.Config.ModelNames(),
+		started: time.Now().UTC(),
+	}
+}
+
+// find looks the model up the way Ollama does, where a name without a tag means :latest.
+func (c *ShowController) find(name string) (string, bool) {
+	for _, candidate := range []string{name, strings.TrimSuffix(name, ":latest")} {
+		if slices.Contains(c.models, candidate) {
+			return candidate, true
+		}
+	}
+	if !strings.Contains(name, ":") && slices.Contains(c.models, name+":latest") {
+		return name + ":latest", true
+	}
+	return "", false
+}
+
+func (c *ShowController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := middleware.GetValidatedBody[models.OllamaShowRequest](r)
+	if err != nil {
+		c.log.Error("Error getting validated body", zap.Error(err))
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if request.Model == "" {
+		request.Model = request.Name
+	}
+	name, ok := c.find(request.Model)
+	if !ok || !models.IdentityFromContext(r.Context()).AllowsModel(name) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", request.Model))
+		return
+	}
+	show := models.OllamaShowResponse{
+		Modelfile:    fmt.Sprintf("# Served by SnideMind, which decides what actually answers\nFROM %s\n", name),
+		Details:      details(),
+		ModelInfo:    map[string]any{"general.architecture": "snidemind", "general.basename": name},
+		Capabilities: []string{"completion", "tools"},
+		ModifiedAt:   c.started,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(show); err != nil {
+		c.log.Error("Error writing model", zap.Error(err))
+	}
+}
+
+func (c *ShowController) Pattern() string {
+	return "/show"
+}
+
+func (c *ShowController) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+var _ utils.Route = (*ShowController)(nil)
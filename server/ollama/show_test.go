@@ -0,0 +1,23 @@
+package ollama
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShowController_FindsModelsLikeOllama(t *testing.T) {
+	c := &ShowController{models: []string{"assist:latest", "mistral", "qwen:7b"}}
+	for name, want := range map[string]string{
+		"assist":         "assist:latest",
+		"assist:latest":  "assist:latest",
+		"mistral:latest": "mistral",
+		"qwen:7b":        "qwen:7b",
+	} {
+		found, ok := c.find(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, found, name)
+	}
+	_, ok := c.find("qwen")
+	assert.False(t, ok, "only :latest is implied")
+}
@@ -0,0 +1,74 @@
+package ollama
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/teagan42/snidemind/models"
+)
+
+// lineWriter sends the client the answer as Ollama's NDJSON, one line per piece of text, translated from the
+// chat completion stream the steps write to its ChunkWriter. Ollama sends tool calls whole, so they're sent
+// once the pipeline is done, from its final response.
+type lineWriter struct {
+	client   http.ResponseWriter
+	model    string
+	start    time.Time
+	streamed bool  // Whether any text was sent
+	err      error // The first error writing to the client, the stream is over after that
+}
+
+func newLineWriter(client http.ResponseWriter, model string, start time.Time) *lineWriter {
+	return &lineWriter{client: client, model: model, start: start}
+}
+
+func (l *lineWriter) chunk(chunk models.ChatCompletionChunk) error {
+	if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+		l.text(chunk.Choices[0].Delta.Content)
+	}
+	return l.err
+}
+
+func (l *lineWriter) text(content string) {
+	l.streamed = true
+	l.emit(models.OllamaChatResponse{Model: l.model, CreatedAt: time.Now().UTC(), Message: models.OllamaMessage{Role: "assistant", Content: content}})
+}
+
+// begin opens the stream, before the pipeline runs.
+func (l *lineWriter) begin() {
+	l.client.Header().Set("Content-Type", "application/x-ndjson")
+	l.client.WriteHeader(http.StatusOK)
+}
+
+// finish sends the tool calls and the closing line once the pipeline is done. If no text was streamed, the
+// answer's text is sent now, as if it had been.
+func (l *lineWriter) finish(chat *models.ChatCompletionResponse, usage models.Usage) {
+	answer := message(chat)
+	if !l.streamed && answer.Content != "" {
+		l.text(answer.Content)
+	}
+	if len(answer.ToolCalls) > 0 {
+		l.emit(models.OllamaChatResponse{Model: l.model, CreatedAt: time.Now().UTC(), Message: models.OllamaMessage{Role: "assistant", ToolCalls: answer.ToolCalls}})
+	}
+	l.emit(done(l.model, chat, usage, l.start))
+}
+
+// failed ends the stream with the error that failed the pipeline, the way Ollama does.
+func (l *lineWriter) failed(err error) {
+	_, detail := models.ErrorResponse(err)
+	l.emit(map[string]string{"error": detail.Message})
+}
+
+func (l *lineWriter) emit(line any) {
+	if l.err != nil {
+		return
+	}
+	if err := json.NewEncoder(l.client).Encode(line); err != nil {
+		l.err = err
+		return
+	}
+	if flusher, ok := l.client.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,83 @@
+package ollama
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/middleware"
+)
+
+func readLines(t *testing.T, body string) []map[string]any {
+	t.Helper()
+	lines := []map[string]any{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestLineWriter_TranslatesChatStream(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	lines := newLineWriter(recorder, "assist", time.Now())
+	lines.begin()
+
+	writer := middleware.NewChunkWriter(lines.chunk)
+	for _, line := range []string{
+		`data: {"choices":[{"delta":{"role":"assistant","content":"No"}}]}`,
+		`data: {"choices":[{"delta":{"content":"."}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"lights","arguments":"{\"on\""}}]}}]}`,
+		`data: [DONE]`,
+	} {
+		_, err := writer.Write([]byte(line + "\n\n"))
+		require.NoError(t, err)
+	}
+	calls := []models.ChatCompletionsMessageToolCall{{ID: "call_1", Function: models.ChatCompletionsMessageFunctionCall{Name: "lights", Arguments: `{"on":true}`}}}
+	lines.finish(&models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{FinishReason: "tool_calls", Message: models.ChatMessage{Content: "No.", ToolCalls: &calls}}}}, models.Usage{PromptTokens: 4, CompletionTokens: 3})
+
+	assert.Equal(t, "application/x-ndjson", recorder.Header().Get("Content-Type"))
+	got := readLines(t, recorder.Body.String())
+	require.Len(t, got, 4)
+	assert.Equal(t, "No", got[0]["message"].(map[string]any)["content"])
+	assert.Equal(t, ".", got[1]["message"].(map[string]any)["content"])
+	assert.Equal(t, false, got[1]["done"])
+	call := got[2]["message"].(map[string]any)["tool_calls"].([]any)[0].(map[string]any)["function"]
+	assert.Equal(t, map[string]any{"name": "lights", "arguments": map[string]any{"on": true}}, call, "tool calls go out whole, from the final response")
+	assert.Equal(t, true, got[3]["done"])
+	assert.Equal(t, "assist", got[3]["model"])
+	assert.Equal(t, float64(4), got[3]["prompt_eval_count"])
+	assert.Equal(t, float64(3), got[3]["eval_count"])
+}
+
+func TestLineWriter_SendsUnstreamedAnswer(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	lines := newLineWriter(recorder, "assist", time.Now())
+	lines.begin()
+	lines.finish(&models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{Message: models.ChatMessage{Content: "Fine."}}}}, models.Usage{})
+
+	got := readLines(t, recorder.Body.String())
+	require.Len(t, got, 2)
+	assert.Equal(t, "Fine.", got[0]["message"].(map[string]any)["content"])
+	assert.Equal(t, "stop", got[1]["done_reason"])
+}
+
+func TestLineWriter_Failed(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	lines := newLineWriter(recorder, "assist", time.Now())
+	lines.begin()
+	lines.failed(errors.New("boom"))
+
+	got := readLines(t, recorder.Body.String())
+	require.Len(t, got, 1)
+	assert.NotEmpty(t, got[0]["error"])
+}
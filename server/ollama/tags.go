@@ -0,0 +1,74 @@
+package ollama
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type TagsControllerParams struct {
+	fx.In
+	Log    *zap.Logger
+	Config *config.Config
+}
+
+// TagsController lists the configured models as if they had been pulled, which is how Ollama clients find
+// out what they can ask for.
+type TagsController struct {
+	log     *zap.Logger
+	models  []string
+	started time.Time // Passed off as when the models were pulled
+}
+
+func NewTagsController(p TagsControllerParams) *TagsController {
+	return &TagsController{
+		log:     p.Log.Named("OllamaTagsController"),
+		models:  p.Config.ModelNames(),
+		started: time.Now().UTC(),
+	}
+}
+
+func details() models.OllamaModelDetails {
+	return models.OllamaModelDetails{Format: "snidemind", Family: "snidemind", Families: []string{"snidemind"}}
+}
+
+// model describes one of the configured models. There are no weights, so the size is 0 and the digest is the
+// name's.
+func model(name string, modified time.Time) models.OllamaModel {
+	digest := sha256.Sum256([]byte(name))
+	return models.OllamaModel{Name: name, Model: name, ModifiedAt: modified, Digest: hex.EncodeToString(digest[:]), Details: details()}
+}
+
+// ServeHTTP lists the models the caller's API key may use.
+func (c *TagsController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	identity := models.IdentityFromContext(r.Context())
+	tags := models.OllamaTagsResponse{Models: []models.OllamaModel{}}
+	for _, name := range c.models {
+		if identity.AllowsModel(name) {
+			tags.Models = append(tags.Models, model(name, c.started))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		c.log.Error("Error writing tags", zap.Error(err))
+	}
+}
+
+func (c *TagsController) Pattern() string {
+	return "/tags"
+}
+
+func (c *TagsController) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+var _ utils.Route = (*TagsController)(nil)
@@ -12,6 +12,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/teagan42/snidemind/config"
 	"github.com/teagan42/snidemind/server/middleware"
+	"github.com/teagan42/snidemind/telemetry"
 
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/getkin/kin-openapi/routers"
@@ -113,7 +114,12 @@ func NewServer(p Params) (Result, error) {
 	})
 
 	// server.Router.Use(middleware.LogRequestMiddleware())
-	server.HttpServer.Handler = server.Router
+	// Middleware only runs for matched routes, unknown ones go straight to these
+	server.Router.NotFoundHandler = http.HandlerFunc(middleware.NotFound)
+	server.Router.MethodNotAllowedHandler = http.HandlerFunc(middleware.MethodNotAllowed)
+	server.Router.Use(telemetry.MetricsMiddleware)
+	server.Router.Handle("/metrics", telemetry.MetricsHandler()).Methods(http.MethodGet)
+	server.HttpServer.Handler = telemetry.Handler(server.Router)
 
 	return Result{
 		Server: server,
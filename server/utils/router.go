@@ -43,7 +43,8 @@ func ProvidePathPrefix(name string, prefix string) fx.Option {
 func RegisterGroupedRoutes(router *mux.Router, prefix PathPrefix, routes []Route) {
 	for _, route := range routes {
 		pattern := route.Pattern()
-		if !strings.HasPrefix(pattern, "/") {
+		// An empty pattern is the prefix itself, e.g. /v1/responses rather than /v1/responses/
+		if pattern != "" && !strings.HasPrefix(pattern, "/") {
 			pattern = "/" + pattern
 		}
 		router.Handle(pattern, route).Methods(route.Methods()...)
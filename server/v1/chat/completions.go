@@ -3,8 +3,12 @@ package chat
 import (
 	"net/http"
 
+	"github.com/teagan42/snidemind/completions"
 	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
 	"github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	"github.com/teagan42/snidemind/server/middleware"
 	"github.com/teagan42/snidemind/server/utils"
 	utilities "github.com/teagan42/snidemind/utils"
 	"go.uber.org/fx"
@@ -17,33 +21,83 @@ type ChatCompletionsControllerParams struct {
 	Lifecycle fx.Lifecycle
 	Config    *config.Config
 	Pipeline  *pipeline.Pipeline
+	Store     *completions.Store `optional:"true"`
 }
 
 type ChatCompletionsController struct {
 	log      *zap.Logger
+	config   *config.Config
 	pipeline *pipeline.Pipeline
+	store    *completions.Store
 }
 
 func NewChatCompletionsController(p ChatCompletionsControllerParams) *ChatCompletionsController {
 	return &ChatCompletionsController{
 		log:      p.Log.Named("ChatCompletionsController"),
+		config:   p.Config,
 		pipeline: p.Pipeline,
+		store:    p.Store,
 	}
 }
 
 func (c *ChatCompletionsController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		middleware.MethodNotAllowed(w, r)
 		return
 	}
+	if r.URL.Query().Get("debug") == "trace" {
+		c.trace(w, r)
+		return
+	}
+	if body, err := middleware.GetValidatedBody[models.ChatCompletionRequest](r); err == nil {
+		if err := completions.ValidateMetadata(body.Metadata); err != nil {
+			middleware.WriteBadRequest(w, "metadata", err.Error())
+			return
+		}
+	}
 	c.log.Info("Processing pipeline")
+	w, written := middleware.TrackResponse(w)
 	message, err := utilities.TimeFunc2WithErr("pipeline.Process", c.pipeline.Process)(r, w)
 	if err != nil {
 		c.log.Error("Error processing pipeline", zap.Error(err))
-		http.Error(w, `{"error":"internal server error"}`, http.StatusInternalServerError)
+		if !written() {
+			middleware.WriteFailure(w, err)
+		}
 		return
 	}
 	c.log.Info("Pipeline processed successfully", zap.Any("message", message))
+	if c.store != nil && completions.ShouldStore(&message, c.config) {
+		c.storeCompletion(r, &message)
+	}
+}
+
+// storeCompletion keeps the completion with the messages the client sent, not the ones steps added to them.
+func (c *ChatCompletionsController) storeCompletion(r *http.Request, message *models.PipelineMessage) {
+	request, err := middleware.GetValidatedBody[models.ChatCompletionRequest](r)
+	if err != nil {
+		request = *message.Request
+	}
+	stored, err := c.store.Save(&request, message.Response, models.OwnerFromContext(r.Context()))
+	if err != nil {
+		// The client already has its answer, all that's lost is the copy
+		c.log.Error("Error storing completion", zap.Error(err))
+		return
+	}
+	c.log.Info("Completion stored", zap.String("id", stored.ID))
+}
+
+// trace answers with the pipeline trace instead of the completion, like /v1/pipeline/trace would.
+func (c *ChatCompletionsController) trace(w http.ResponseWriter, r *http.Request) {
+	body, err := middleware.GetValidatedBody[models.ChatCompletionRequest](r)
+	if err != nil {
+		c.log.Error("Error getting validated body", zap.Error(err))
+		middleware.WriteError(w, http.StatusBadRequest, models.APIErrorDetail{Message: "Invalid request body: " + err.Error(), Type: models.ErrorTypeInvalidRequest})
+		return
+	}
+	options := trace.OptionsFromQuery(r.URL.Query())
+	if err := trace.Write(w, c.pipeline.Trace(r.Context(), body, options)); err != nil {
+		c.log.Error("Error writing trace", zap.Error(err))
+	}
 }
 
 func (c *ChatCompletionsController) Pattern() string {
@@ -64,18 +64,26 @@ var testConfig = &config.Config{
 	},
 }
 
+func newTestPipeline(t *testing.T) *pipeline.Pipeline {
+	p, err := pipeline.NewPipeline(pipeline.Params{
+		Config: testConfig,
+		Logger: zap.NewNop(),
+		StepFactories: map[string]models.PipelineStepFactory{
+			"MockStep": MockStepFactory{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build pipeline: %v", err)
+	}
+	return p
+}
+
 func TestChatCompletionsController_ServeHTTP_MethodNotAllowed(t *testing.T) {
 	mockStep.called = false
 	ctrl := NewChatCompletionsController(ChatCompletionsControllerParams{
-		Log:    zap.L().Named("TestChatCompletionsController"),
-		Config: testConfig,
-		Pipeline: pipeline.NewPipeline(pipeline.Params{
-			Config: testConfig,
-			Logger: zap.L().Named("TestChatCompletionsController"),
-			StepFactories: map[string]models.PipelineStepFactory{
-				"MockStep": MockStepFactory{},
-			},
-		}),
+		Log:      zap.L().Named("TestChatCompletionsController"),
+		Config:   testConfig,
+		Pipeline: newTestPipeline(t),
 	})
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	rr := httptest.NewRecorder()
@@ -90,11 +98,8 @@ func TestChatCompletionsController_ServeHTTP_MethodNotAllowed(t *testing.T) {
 func TestChatCompletionsController_ServeHTTP_PipelineError(t *testing.T) {
 	mockStep.called = false
 	ctrl := &ChatCompletionsController{
-		log: zap.NewNop(),
-		pipeline: pipeline.NewPipeline(pipeline.Params{
-			Config: testConfig,
-			Logger: zap.NewNop(),
-		}),
+		log:      zap.NewNop(),
+		pipeline: newTestPipeline(t),
 	}
 	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{}`))
 	rr := httptest.NewRecorder()
@@ -104,23 +109,20 @@ func TestChatCompletionsController_ServeHTTP_PipelineError(t *testing.T) {
 	if rr.Code != http.StatusInternalServerError {
 		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, rr.Code)
 	}
-	want := `{"error":"internal server error"}`
-	if rr.Body.String() != want+"\n" && rr.Body.String() != want {
-		t.Errorf("expected body %q, got %q", want, rr.Body.String())
+	var body models.APIError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected an OpenAI error body, got %q", rr.Body.String())
+	}
+	if body.Error.Type != models.ErrorTypeServer || body.Error.Message == "" {
+		t.Errorf("unexpected error %+v", body.Error)
 	}
 }
 
 func TestChatCompletionsController_ServeHTTP_PipelineSuccess(t *testing.T) {
 	mockStep.called = false
 	ctrl := &ChatCompletionsController{
-		log: zap.NewNop(),
-		pipeline: pipeline.NewPipeline(pipeline.Params{
-			Config: testConfig,
-			Logger: zap.NewNop(),
-			StepFactories: map[string]models.PipelineStepFactory{
-				"MockStep": MockStepFactory{},
-			},
-		}),
+		log:      zap.NewNop(),
+		pipeline: newTestPipeline(t),
 	}
 	body := models.ChatCompletionRequest{
 		Messages: []models.ChatMessage{
@@ -161,3 +163,38 @@ func TestChatCompletionsController_ServeHTTP_PipelineSuccess(t *testing.T) {
 		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
 	}
 }
+
+func TestChatCompletionsController_ServeHTTP_DebugTrace(t *testing.T) {
+	mockStep.called = false
+	ctrl := &ChatCompletionsController{
+		log:      zap.NewNop(),
+		pipeline: newTestPipeline(t),
+	}
+	raw := map[string]any{
+		"model":    "gpt-3.5-turbo",
+		"messages": []any{map[string]any{"role": "user", "content": "Hello, world!"}},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/?debug=trace", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.BodyKey, any(raw)))
+	rr := httptest.NewRecorder()
+	ctrl.ServeHTTP(rr, req)
+
+	if !mockStep.called {
+		t.Error("expected the pipeline to run")
+	}
+	var result struct {
+		Steps []struct {
+			Name string `json:"name"`
+		} `json:"steps"`
+		Output string `json:"output"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected a JSON trace, got %q: %v", rr.Body.String(), err)
+	}
+	if len(result.Steps) != 1 || result.Steps[0].Name != "MockStep" {
+		t.Errorf("expected one MockStep in the trace, got %+v", result.Steps)
+	}
+	if result.Output != "MockStep called" {
+		t.Errorf("expected the step's output to be captured, got %q", result.Output)
+	}
+}
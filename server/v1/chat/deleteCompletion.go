@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"net/http"
+
+	"github.com/teagan42/snidemind/completions"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type DeleteCompletionControllerParams struct {
+	fx.In
+	Log   *zap.Logger
+	Store *completions.Store
+}
+
+type DeleteCompletionController struct {
+	log   *zap.Logger
+	store *completions.Store
+}
+
+func NewDeleteCompletionController(p DeleteCompletionControllerParams) *DeleteCompletionController {
+	return &DeleteCompletionController{
+		log:   p.Log.Named("DeleteCompletionController"),
+		store: p.Store,
+	}
+}
+
+func (c *DeleteCompletionController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	deleted, err := c.store.Delete(completionID(r), models.OwnerFromContext(r.Context()))
+	if err != nil {
+		writeStoreError(c.log, w, completionID(r), err)
+		return
+	}
+	writeJSON(c.log, w, deleted)
+}
+
+func (c *DeleteCompletionController) Pattern() string {
+	return "completions/{completion_id}"
+}
+
+func (c *DeleteCompletionController) Methods() []string {
+	return []string{http.MethodDelete}
+}
+
+var _ utils.Route = (*DeleteCompletionController)(nil)
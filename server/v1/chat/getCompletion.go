@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"net/http"
+
+	"github.com/teagan42/snidemind/completions"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type GetCompletionControllerParams struct {
+	fx.In
+	Log   *zap.Logger
+	Store *completions.Store
+}
+
+type GetCompletionController struct {
+	log   *zap.Logger
+	store *completions.Store
+}
+
+func NewGetCompletionController(p GetCompletionControllerParams) *GetCompletionController {
+	return &GetCompletionController{
+		log:   p.Log.Named("GetCompletionController"),
+		store: p.Store,
+	}
+}
+
+func (c *GetCompletionController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	completion, err := c.store.Get(completionID(r), models.OwnerFromContext(r.Context()))
+	if err != nil {
+		writeStoreError(c.log, w, completionID(r), err)
+		return
+	}
+	writeJSON(c.log, w, completion)
+}
+
+func (c *GetCompletionController) Pattern() string {
+	return "completions/{completion_id}"
+}
+
+func (c *GetCompletionController) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+var _ utils.Route = (*GetCompletionController)(nil)
@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"net/http"
+
+	"github.com/teagan42/snidemind/completions"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type ListCompletionMessagesControllerParams struct {
+	fx.In
+	Log   *zap.Logger
+	Store *completions.Store
+}
+
+type ListCompletionMessagesController struct {
+	log   *zap.Logger
+	store *completions.Store
+}
+
+func NewListCompletionMessagesController(p ListCompletionMessagesControllerParams) *ListCompletionMessagesController {
+	return &ListCompletionMessagesController{
+		log:   p.Log.Named("ListCompletionMessagesController"),
+		store: p.Store,
+	}
+}
+
+// ServeHTTP lists the messages a stored completion answered.
+func (c *ListCompletionMessagesController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	after, limit, order, ok := pageQuery(w, r)
+	if !ok {
+		return
+	}
+	messages, err := c.store.Messages(completionID(r), models.OwnerFromContext(r.Context()), after, limit, order)
+	if err != nil {
+		writeStoreError(c.log, w, completionID(r), err)
+		return
+	}
+	writeJSON(c.log, w, messages)
+}
+
+func (c *ListCompletionMessagesController) Pattern() string {
+	return "completions/{completion_id}/messages"
+}
+
+func (c *ListCompletionMessagesController) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+var _ utils.Route = (*ListCompletionMessagesController)(nil)
@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/teagan42/snidemind/completions"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type ListCompletionsControllerParams struct {
+	fx.In
+	Log   *zap.Logger
+	Store *completions.Store
+}
+
+type ListCompletionsController struct {
+	log   *zap.Logger
+	store *completions.Store
+}
+
+func NewListCompletionsController(p ListCompletionsControllerParams) *ListCompletionsController {
+	return &ListCompletionsController{
+		log:   p.Log.Named("ListCompletionsController"),
+		store: p.Store,
+	}
+}
+
+// ServeHTTP lists stored completions, filtered by `model` and `metadata[key]=value`.
+func (c *ListCompletionsController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	after, limit, order, ok := pageQuery(w, r)
+	if !ok {
+		return
+	}
+	options := completions.ListOptions{Model: r.URL.Query().Get("model"), After: after, Limit: limit, Order: order}
+	for name, values := range r.URL.Query() {
+		key, isMetadata := strings.CutPrefix(name, "metadata[")
+		if !isMetadata || !strings.HasSuffix(key, "]") || len(values) == 0 {
+			continue
+		}
+		if options.Metadata == nil {
+			options.Metadata = map[string]string{}
+		}
+		options.Metadata[strings.TrimSuffix(key, "]")] = values[0]
+	}
+	writeJSON(c.log, w, c.store.List(models.OwnerFromContext(r.Context()), options))
+}
+
+func (c *ListCompletionsController) Pattern() string {
+	return "completions"
+}
+
+func (c *ListCompletionsController) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+var _ utils.Route = (*ListCompletionsController)(nil)
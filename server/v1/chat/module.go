@@ -10,5 +10,10 @@ var Module = utils.ApiRouteModule(utils.ApiRouteModuleParams{
 	Prefix:       "chat",
 	Routes: &[]any{
 		NewChatCompletionsController,
+		NewListCompletionsController,
+		NewGetCompletionController,
+		NewUpdateCompletionController,
+		NewDeleteCompletionController,
+		NewListCompletionMessagesController,
 	},
 })
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/gorilla/mux"
+	"github.com/teagan42/snidemind/completions"
 	"github.com/teagan42/snidemind/config"
 	"github.com/teagan42/snidemind/models"
 	"github.com/teagan42/snidemind/pipeline"
@@ -33,8 +34,9 @@ func TestChatModule_ProvidesChatRoutes(t *testing.T) {
 			return zap.NewNop()
 		}),
 		fx.Provide(func() *config.Config {
-			return &config.Config{}
+			return &config.Config{Completions: &config.CompletionsConfig{Dir: t.TempDir()}}
 		}),
+		fx.Provide(completions.NewStore),
 		fx.Provide(func() *pipeline.Pipeline {
 			return &pipeline.Pipeline{
 				Steps:  []models.PipelineStep{},
@@ -64,11 +66,14 @@ func TestChatModule_ProvidesChatRoutes(t *testing.T) {
 	if routes[0] == nil {
 		t.Error("Expected chat routes to be non-nil, but got nil")
 	}
-	if route, ok := routes[0].(utils.Route); !ok {
-		t.Error("Expected chat routes to be of type utils.Route, but got a different type")
-	} else {
-		if route.Pattern() != "completions" {
-			t.Errorf("Expected route pattern to be '/v1/chat', but got '%s'", route.Pattern())
+	// Value groups come in no particular order, so look for the completions route among them
+	patterns := map[string]bool{}
+	for _, route := range routes {
+		patterns[route.Pattern()] = true
+	}
+	for _, pattern := range []string{"completions", "completions/{completion_id}", "completions/{completion_id}/messages"} {
+		if !patterns[pattern] {
+			t.Errorf("Expected a route with pattern '%s', got %v", pattern, patterns)
 		}
 	}
 	app.RequireStop()
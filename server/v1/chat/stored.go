@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/teagan42/snidemind/completions"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/middleware"
+	"go.uber.org/zap"
+)
+
+// The stored completion routes share these helpers. Completions are scoped to the identity that stored them,
+// which is "" for everyone when authentication is off.
+
+func completionID(r *http.Request) string {
+	return mux.Vars(r)["completion_id"]
+}
+
+func writeJSON(log *zap.Logger, w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Error("Error writing response", zap.Error(err))
+	}
+}
+
+func writeStoreError(log *zap.Logger, w http.ResponseWriter, id string, err error) {
+	if errors.Is(err, completions.ErrNotFound) {
+		middleware.WriteError(w, http.StatusNotFound, models.APIErrorDetail{
+			Message: "No chat completion found with id '" + id + "'.",
+			Type:    models.ErrorTypeInvalidRequest,
+		})
+		return
+	}
+	log.Error("Error using the completion store", zap.Error(err))
+	middleware.WriteFailure(w, err)
+}
+
+// pageQuery reads the `after`, `limit` and `order` query parameters every list takes.
+func pageQuery(w http.ResponseWriter, r *http.Request) (after string, limit int, order string, ok bool) {
+	query := r.URL.Query()
+	after, order = query.Get("after"), query.Get("order")
+	if order == "" {
+		order = completions.OrderAsc
+	}
+	if order != completions.OrderAsc && order != completions.OrderDesc {
+		middleware.WriteBadRequest(w, "order", "order must be asc or desc")
+		return "", 0, "", false
+	}
+	limit = completions.DefaultLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > 100 {
+			middleware.WriteBadRequest(w, "limit", "limit must be a number between 1 and 100")
+			return "", 0, "", false
+		}
+		limit = parsed
+	}
+	return after, limit, order, true
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"net/http"
+
+	"github.com/teagan42/snidemind/completions"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/middleware"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type UpdateCompletionRequest struct {
+	Metadata map[string]string `json:"metadata"`
+}
+
+type UpdateCompletionControllerParams struct {
+	fx.In
+	Log   *zap.Logger
+	Store *completions.Store
+}
+
+type UpdateCompletionController struct {
+	log   *zap.Logger
+	store *completions.Store
+}
+
+func NewUpdateCompletionController(p UpdateCompletionControllerParams) *UpdateCompletionController {
+	return &UpdateCompletionController{
+		log:   p.Log.Named("UpdateCompletionController"),
+		store: p.Store,
+	}
+}
+
+// ServeHTTP replaces a stored completion's metadata, the only thing about it that can be changed.
+func (c *UpdateCompletionController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := middleware.GetValidatedBody[UpdateCompletionRequest](r)
+	if err != nil {
+		middleware.WriteBadRequest(w, "metadata", "Invalid request body: "+err.Error())
+		return
+	}
+	if err := completions.ValidateMetadata(body.Metadata); err != nil {
+		middleware.WriteBadRequest(w, "metadata", err.Error())
+		return
+	}
+	completion, err := c.store.UpdateMetadata(completionID(r), models.OwnerFromContext(r.Context()), body.Metadata)
+	if err != nil {
+		writeStoreError(c.log, w, completionID(r), err)
+		return
+	}
+	writeJSON(c.log, w, completion)
+}
+
+func (c *UpdateCompletionController) Pattern() string {
+	return "completions/{completion_id}"
+}
+
+func (c *UpdateCompletionController) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+var _ utils.Route = (*UpdateCompletionController)(nil)
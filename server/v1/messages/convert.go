@@ -0,0 +1,177 @@
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/utils"
+)
+
+const (
+	messageType = "message"
+
+	blockText       = "text"
+	blockToolUse    = "tool_use"
+	blockToolResult = "tool_result"
+
+	stopEndTurn   = "end_turn"
+	stopMaxTokens = "max_tokens"
+	stopToolUse   = "tool_use"
+)
+
+// requestError is a request the pipeline can't be given, reported to the client as a 400.
+type requestError struct {
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// chatRequest turns a Messages request into the chat completion request the pipeline runs.
+func chatRequest(request models.MessagesRequest) (models.ChatCompletionRequest, error) {
+	messages := []models.ChatMessage{}
+	if request.System != nil {
+		system, err := request.System.Join()
+		if err != nil {
+			return models.ChatCompletionRequest{}, &requestError{"system: " + err.Error()}
+		}
+		if system != "" {
+			messages = append(messages, models.ChatMessage{Role: "system", Content: system})
+		}
+	}
+	for i, message := range request.Messages {
+		converted, err := chatMessages(message)
+		if err != nil {
+			return models.ChatCompletionRequest{}, &requestError{fmt.Sprintf("messages.%d.content: %s", i, err.Error())}
+		}
+		messages = append(messages, converted...)
+	}
+	chat := models.ChatCompletionRequest{
+		Messages:            messages,
+		Model:               request.Model,
+		MaxCompletionTokens: &request.MaxTokens,
+		Stream:              request.Stream,
+		Temperature:         request.Temperature,
+		TopP:                request.TopP,
+	}
+	if request.Metadata != nil {
+		chat.User = request.Metadata.UserID
+	}
+	if len(request.Tools) > 0 {
+		tools := make([]models.Tool, len(request.Tools))
+		for i, tool := range request.Tools {
+			tools[i] = models.Tool{Type: "function", Function: models.ToolFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.InputSchema}}
+		}
+		chat.Tools = &tools
+	}
+	return chat, nil
+}
+
+// chatMessages turns one message into chat messages. An assistant's tool_use blocks become its tool calls.
+// A user's tool_result blocks become `tool` messages, ahead of whatever the user said besides, since they
+// answer the assistant message before it.
+func chatMessages(message models.MessagesMessage) ([]models.ChatMessage, error) {
+	if message.Content.Blocks == nil {
+		return []models.ChatMessage{{Role: message.Role, Content: message.Content.Text}}, nil
+	}
+	messages := []models.ChatMessage{}
+	converted := models.ChatMessage{Role: message.Role}
+	for _, block := range message.Content.Blocks {
+		switch {
+		case block.Type == blockText:
+			converted.Content += block.Text
+		case block.Type == blockToolUse && message.Role == "assistant":
+			if converted.ToolCalls == nil {
+				converted.ToolCalls = &[]models.ChatCompletionsMessageToolCall{}
+			}
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			*converted.ToolCalls = append(*converted.ToolCalls, models.ChatCompletionsMessageToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: models.ChatCompletionsMessageFunctionCall{Name: block.Name, Arguments: arguments},
+			})
+		case block.Type == blockToolResult && message.Role == "user":
+			content, err := block.Content.Join()
+			if err != nil {
+				return nil, err
+			}
+			if block.IsError {
+				content = "Error: " + content
+			}
+			messages = append(messages, models.ChatMessage{Role: "tool", ToolCallID: block.ToolUseID, Content: content})
+		case block.Type == "thinking" || block.Type == "redacted_thinking":
+			// The model's earlier reasoning, which chat models have no place for
+		default:
+			return nil, fmt.Errorf("%s blocks are not supported in %s messages", block.Type, message.Role)
+		}
+	}
+	if converted.Content != "" || converted.ToolCalls != nil {
+		messages = append(messages, converted)
+	}
+	return messages, nil
+}
+
+// newMessage is the answer before anything has been generated for it.
+func newMessage(model string) models.Message {
+	return models.Message{ID: utils.NewID("msg_"), Type: messageType, Role: "assistant", Model: model, Content: []models.MessagesContentBlock{}}
+}
+
+// contentBlocks lists what the model answered: its text, then a tool_use block per tool call it made.
+func contentBlocks(chat *models.ChatCompletionResponse) []models.MessagesContentBlock {
+	blocks := []models.MessagesContentBlock{}
+	if chat == nil || len(chat.Choices) == 0 {
+		return blocks
+	}
+	message := chat.Choices[0].Message
+	if message.Content != "" {
+		blocks = append(blocks, models.MessagesContentBlock{Type: blockText, Text: message.Content})
+	}
+	if message.ToolCalls != nil {
+		for _, call := range *message.ToolCalls {
+			blocks = append(blocks, toolUse(call.ID, call.Function.Name, call.Function.Arguments))
+		}
+	}
+	return blocks
+}
+
+// toolUse is a tool call as a tool_use block. Tool inputs are objects; arguments that don't parse as JSON
+// (a model that got cut off mid-call, say) are sent as an empty one rather than as broken JSON.
+func toolUse(id string, name string, arguments string) models.MessagesContentBlock {
+	if id == "" {
+		id = utils.NewID("toolu_")
+	}
+	input := json.RawMessage(arguments)
+	if !json.Valid(input) {
+		input = json.RawMessage(`{}`)
+	}
+	return models.MessagesContentBlock{Type: blockToolUse, ID: id, Name: name, Input: input}
+}
+
+// stopReason translates the chat completion's finish reason. Any tool call means the client has tools to run.
+func stopReason(chat *models.ChatCompletionResponse, blocks []models.MessagesContentBlock) string {
+	for _, block := range blocks {
+		if block.Type == blockToolUse {
+			return stopToolUse
+		}
+	}
+	if chat != nil && len(chat.Choices) > 0 && chat.Choices[0].FinishReason == "length" {
+		return stopMaxTokens
+	}
+	return stopEndTurn
+}
+
+// complete fills in the message once the pipeline is done.
+func complete(message *models.Message, chat *models.ChatCompletionResponse, blocks []models.MessagesContentBlock, usage models.Usage) {
+	message.Content = blocks
+	if chat != nil && chat.Model != "" {
+		message.Model = chat.Model
+	}
+	reason := stopReason(chat, blocks)
+	message.StopReason = &reason
+	message.Usage = models.MessagesUsage{InputTokens: usage.PromptTokens, OutputTokens: usage.CompletionTokens}
+}
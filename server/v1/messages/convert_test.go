@@ -0,0 +1,105 @@
+package messages
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+)
+
+func decodeRequest(t *testing.T, body string) models.MessagesRequest {
+	t.Helper()
+	var request models.MessagesRequest
+	require.NoError(t, json.Unmarshal([]byte(body), &request))
+	return request
+}
+
+func TestChatRequest(t *testing.T) {
+	request := decodeRequest(t, `{"model":"claude","max_tokens":100,"metadata":{"user_id":"alice"},
+		"system":[{"type":"text","text":"be snide"}],
+		"tools":[{"name":"lights","input_schema":{"type":"object"}}],
+		"messages":[
+			{"role":"user","content":"lights"},
+			{"role":"assistant","content":[{"type":"thinking","thinking":"hmm"},{"type":"text","text":"fine"},{"type":"tool_use","id":"toolu_1","name":"lights","input":{"on":true}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"on"}]},{"type":"tool_result","tool_use_id":"toolu_2","content":"broken","is_error":true},{"type":"text","text":"thanks"}]}
+		]}`)
+
+	chat, err := chatRequest(request)
+	require.NoError(t, err)
+	assert.Equal(t, "claude", chat.Model)
+	assert.Equal(t, int64(100), *chat.MaxCompletionTokens)
+	assert.Equal(t, "alice", chat.User)
+	require.NotNil(t, chat.Tools)
+	assert.Equal(t, models.ToolFunctionParameters{"type": "object"}, (*chat.Tools)[0].Function.Parameters)
+	assert.Equal(t, []models.ChatMessage{
+		{Role: "system", Content: "be snide"},
+		{Role: "user", Content: "lights"},
+		{Role: "assistant", Content: "fine", ToolCalls: &[]models.ChatCompletionsMessageToolCall{
+			{ID: "toolu_1", Type: "function", Function: models.ChatCompletionsMessageFunctionCall{Name: "lights", Arguments: `{"on":true}`}},
+		}},
+		{Role: "tool", ToolCallID: "toolu_1", Content: "on"},
+		{Role: "tool", ToolCallID: "toolu_2", Content: "Error: broken"},
+		{Role: "user", Content: "thanks"},
+	}, chat.Messages)
+}
+
+func TestChatRequest_Unsupported(t *testing.T) {
+	for name, body := range map[string]string{
+		"image":                `{"model":"m","max_tokens":1,"messages":[{"role":"user","content":[{"type":"image","source":{}}]}]}`,
+		"tool_use from a user": `{"model":"m","max_tokens":1,"messages":[{"role":"user","content":[{"type":"tool_use","id":"x","name":"y","input":{}}]}]}`,
+		"image in system":      `{"model":"m","max_tokens":1,"system":[{"type":"image"}],"messages":[]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := chatRequest(decodeRequest(t, body))
+			var requestErr *requestError
+			assert.ErrorAs(t, err, &requestErr)
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := map[string]struct {
+		message      models.ChatMessage
+		finishReason string
+		stopReason   string
+		blocks       string
+	}{
+		"text": {
+			message:      models.ChatMessage{Content: "No."},
+			finishReason: "stop",
+			stopReason:   "end_turn",
+			blocks:       `[{"type":"text","text":"No."}]`,
+		},
+		"cut off": {
+			message:      models.ChatMessage{Content: "No"},
+			finishReason: "length",
+			stopReason:   "max_tokens",
+			blocks:       `[{"type":"text","text":"No"}]`,
+		},
+		"tool call": {
+			message: models.ChatMessage{ToolCalls: &[]models.ChatCompletionsMessageToolCall{
+				{ID: "call_1", Function: models.ChatCompletionsMessageFunctionCall{Name: "lights", Arguments: `{"on":true}`}},
+				{ID: "call_2", Function: models.ChatCompletionsMessageFunctionCall{Name: "fan", Arguments: `{"on":`}},
+			}},
+			finishReason: "stop",
+			stopReason:   "tool_use",
+			blocks:       `[{"type":"tool_use","id":"call_1","name":"lights","input":{"on":true}},{"type":"tool_use","id":"call_2","name":"fan","input":{}}]`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			chat := &models.ChatCompletionResponse{Model: "mistral-7b", Choices: []models.ChatCompletionChoice{{FinishReason: test.finishReason, Message: test.message}}}
+			message := newMessage("mistral")
+			complete(&message, chat, contentBlocks(chat), models.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5})
+
+			assert.Equal(t, "mistral-7b", message.Model)
+			assert.Equal(t, test.stopReason, *message.StopReason)
+			assert.Equal(t, models.MessagesUsage{InputTokens: 3, OutputTokens: 2}, message.Usage)
+			blocks, err := json.Marshal(message.Content)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.blocks, string(blocks))
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	"github.com/teagan42/snidemind/server/middleware"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type CreateMessageControllerParams struct {
+	fx.In
+	Log      *zap.Logger
+	Pipeline *pipeline.Pipeline
+}
+
+// CreateMessageController serves Anthropic's Messages API by translating it to and from the chat completion
+// the pipeline runs.
+type CreateMessageController struct {
+	log      *zap.Logger
+	pipeline *pipeline.Pipeline
+}
+
+func NewCreateMessageController(p CreateMessageControllerParams) *CreateMessageController {
+	return &CreateMessageController{
+		log:      p.Log.Named("CreateMessageController"),
+		pipeline: p.Pipeline,
+	}
+}
+
+// errorType is the Anthropic error type for a status.
+func errorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case 529:
+		return "overloaded_error"
+	}
+	if status < http.StatusInternalServerError {
+		return "invalid_request_error"
+	}
+	return "api_error"
+}
+
+// writeError answers in Anthropic's error shape, which is what this route's clients know how to read.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"type": "error", "error": models.MessagesError{Type: errorType(status), Message: message}})
+}
+
+// WriteError makes the middleware's errors, a missing API key say, Anthropic-shaped on this route too.
+func (c *CreateMessageController) WriteError(w http.ResponseWriter, status int, detail models.APIErrorDetail) error {
+	writeError(w, status, detail.Message)
+	return nil
+}
+
+func writeFailure(w http.ResponseWriter, err error) {
+	status, detail := models.ErrorResponse(err)
+	writeError(w, status, detail.Message)
+}
+
+func (c *CreateMessageController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := middleware.GetValidatedBody[models.MessagesRequest](r)
+	if err != nil {
+		c.log.Error("Error getting validated body", zap.Error(err))
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	chat, err := chatRequest(request)
+	if err != nil {
+		var requestErr *requestError
+		if errors.As(err, &requestErr) {
+			writeError(w, http.StatusBadRequest, requestErr.message)
+		} else {
+			writeFailure(w, err)
+		}
+		return
+	}
+	message := newMessage(request.Model)
+
+	if request.Stream != nil && *request.Stream {
+		events := newEventWriter(w, message)
+		events.begin()
+		output, err := c.pipeline.ProcessRequest(r.Context(), chat, middleware.NewChunkWriter(events.chunk))
+		if err != nil {
+			c.log.Error("Error processing pipeline", zap.Error(err))
+			events.failed(err)
+			return
+		}
+		events.finish(output.Response, output.TotalUsage())
+		return
+	}
+	// The steps answer in chat completion format, which is of no use to this client
+	output, err := c.pipeline.ProcessRequest(r.Context(), chat, trace.NewResponseBuffer())
+	if err != nil {
+		c.log.Error("Error processing pipeline", zap.Error(err))
+		writeFailure(w, err)
+		return
+	}
+	complete(&message, output.Response, contentBlocks(output.Response), output.TotalUsage())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		c.log.Error("Error writing message", zap.Error(err))
+	}
+}
+
+// The pattern is empty so the route is /v1/messages itself.
+func (c *CreateMessageController) Pattern() string {
+	return ""
+}
+
+func (c *CreateMessageController) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+var _ utils.Route = (*CreateMessageController)(nil)
@@ -0,0 +1,52 @@
+package messages
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/auth"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/middleware"
+	"go.uber.org/zap"
+)
+
+func TestCreateMessageController_MiddlewareErrorsAreAnthropicShaped(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(auth.Params{
+		Config: &config.Config{Auth: &config.AuthConfig{Keys: []config.APIKeyConfig{{Name: "kitchen", KeyHash: auth.HashKey("sk-kitchen")}}}},
+		Logger: zap.NewNop(),
+	})
+	require.NoError(t, err)
+	router := mux.NewRouter()
+	router.Use(middleware.AuthMiddleware(authenticator.Authenticator, router))
+	controller := NewCreateMessageController(CreateMessageControllerParams{Log: zap.NewNop()})
+	router.Handle("/v1/messages", controller).Methods(controller.Methods()...)
+	router.HandleFunc("/v1/chat/completions", func(http.ResponseWriter, *http.Request) {
+		t.Error("the request should have been refused")
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{}`)))
+	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	var body struct {
+		Type  string               `json:"type"`
+		Error models.MessagesError `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body), recorder.Body.String())
+	assert.Equal(t, "error", body.Type)
+	assert.Equal(t, "authentication_error", body.Error.Type)
+	assert.Contains(t, body.Error.Message, "didn't provide an API key")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`)))
+	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	var openAI models.APIError
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &openAI))
+	assert.Equal(t, models.ErrorTypeInvalidRequest, openAI.Error.Type, "other routes keep OpenAI's shape")
+}
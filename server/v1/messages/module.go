@@ -0,0 +1,14 @@
+package messages
+
+import (
+	"github.com/teagan42/snidemind/server/utils"
+)
+
+var Module = utils.ApiRouteModule(utils.ApiRouteModuleParams{
+	ParentRouter: "v1",
+	ModuleName:   "messages",
+	Prefix:       "messages",
+	Routes: &[]any{
+		NewCreateMessageController,
+	},
+})
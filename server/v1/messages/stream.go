@@ -0,0 +1,158 @@
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/utils"
+)
+
+// block is a content block being streamed, with the tool input received for it so far.
+type block struct {
+	content   models.MessagesContentBlock
+	arguments string
+}
+
+// eventWriter sends the client the answer as Messages events, translated from the chat completion stream the
+// steps write to its ChunkWriter. Blocks are streamed one at a time: a new block stops the one before it.
+type eventWriter struct {
+	client  http.ResponseWriter
+	message models.Message
+	blocks  []*block
+	current *block // The block being streamed, nil between blocks
+	calls   map[int]*block
+	err     error // The first error writing to the client, the stream is over after that
+}
+
+func newEventWriter(client http.ResponseWriter, message models.Message) *eventWriter {
+	return &eventWriter{client: client, message: message, calls: map[int]*block{}}
+}
+
+func (e *eventWriter) chunk(chunk models.ChatCompletionChunk) error {
+	if len(chunk.Choices) == 0 {
+		return e.err
+	}
+	delta := chunk.Choices[0].Delta
+	if delta.Content != "" {
+		e.text(delta.Content)
+	}
+	for _, call := range delta.ToolCalls {
+		e.call(call.Index, call.ID, call.Function.Name, call.Function.Arguments)
+	}
+	return e.err
+}
+
+func index(i int) *int {
+	return &i
+}
+
+func (e *eventWriter) start(content models.MessagesContentBlock) *block {
+	e.stop()
+	started := &block{content: content}
+	e.blocks = append(e.blocks, started)
+	e.current = started
+	e.emit(models.MessagesStreamEvent{Type: "content_block_start", Index: index(len(e.blocks) - 1), ContentBlock: &content})
+	return started
+}
+
+func (e *eventWriter) stop() {
+	if e.current == nil {
+		return
+	}
+	e.emit(models.MessagesStreamEvent{Type: "content_block_stop", Index: index(len(e.blocks) - 1)})
+	e.current = nil
+}
+
+func (e *eventWriter) text(delta string) {
+	if e.current == nil || e.current.content.Type != blockText {
+		e.start(models.MessagesContentBlock{Type: blockText})
+	}
+	e.current.content.Text += delta
+	e.emit(models.MessagesStreamEvent{Type: "content_block_delta", Index: index(len(e.blocks) - 1), Delta: &models.MessagesDelta{Type: "text_delta", Text: delta}})
+}
+
+// call adds a piece of the tool call at position i of the model's tool calls. The rare model that goes back to
+// a call after starting another still gets its arguments into the final message, they just can't be streamed.
+func (e *eventWriter) call(i int, id string, name string, arguments string) {
+	call, ok := e.calls[i]
+	if !ok {
+		if id == "" {
+			id = utils.NewID("toolu_")
+		}
+		call = e.start(models.MessagesContentBlock{Type: blockToolUse, ID: id, Name: name, Input: json.RawMessage(`{}`)})
+		e.calls[i] = call
+	}
+	if arguments == "" {
+		return
+	}
+	call.arguments += arguments
+	if call == e.current {
+		e.emit(models.MessagesStreamEvent{Type: "content_block_delta", Index: index(len(e.blocks) - 1), Delta: &models.MessagesDelta{Type: "input_json_delta", PartialJSON: arguments}})
+	}
+}
+
+// begin opens the stream, before the pipeline runs.
+func (e *eventWriter) begin() {
+	e.client.Header().Set("Content-Type", "text/event-stream")
+	e.client.Header().Set("Cache-Control", "no-cache")
+	e.client.WriteHeader(http.StatusOK)
+	message := e.message
+	e.emit(models.MessagesStreamEvent{Type: "message_start", Message: &message})
+}
+
+// finish stops the last block and the message once the pipeline is done. If nothing was streamed, the answer
+// is sent now, as if it had been. It returns the message as the client was told about it.
+func (e *eventWriter) finish(chat *models.ChatCompletionResponse, usage models.Usage) models.Message {
+	if len(e.blocks) == 0 {
+		for i, content := range contentBlocks(chat) {
+			switch content.Type {
+			case blockText:
+				e.text(content.Text)
+			case blockToolUse:
+				e.call(i, content.ID, content.Name, string(content.Input))
+			}
+		}
+	}
+	e.stop()
+	blocks := make([]models.MessagesContentBlock, len(e.blocks))
+	for i, streamed := range e.blocks {
+		blocks[i] = streamed.content
+		if streamed.content.Type == blockToolUse {
+			blocks[i] = toolUse(streamed.content.ID, streamed.content.Name, streamed.arguments)
+		}
+	}
+	complete(&e.message, chat, blocks, usage)
+	e.emit(models.MessagesStreamEvent{
+		Type:  "message_delta",
+		Delta: &models.MessagesDelta{StopReason: e.message.StopReason},
+		Usage: &models.MessagesUsage{OutputTokens: e.message.Usage.OutputTokens},
+	})
+	e.emit(models.MessagesStreamEvent{Type: "message_stop"})
+	return e.message
+}
+
+// failed ends the stream with the error that failed the pipeline.
+func (e *eventWriter) failed(err error) {
+	status, detail := models.ErrorResponse(err)
+	e.emit(models.MessagesStreamEvent{Type: "error", Error: &models.MessagesError{Type: errorType(status), Message: detail.Message}})
+}
+
+func (e *eventWriter) emit(event models.MessagesStreamEvent) {
+	if e.err != nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		e.err = err
+		return
+	}
+	if _, err := fmt.Fprintf(e.client, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		e.err = err
+		return
+	}
+	if flusher, ok := e.client.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
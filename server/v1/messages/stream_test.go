@@ -0,0 +1,104 @@
+package messages
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/middleware"
+)
+
+func readEvents(t *testing.T, body string) []models.MessagesStreamEvent {
+	t.Helper()
+	events := []models.MessagesStreamEvent{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		if payload, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var event models.MessagesStreamEvent
+			require.NoError(t, json.Unmarshal([]byte(payload), &event))
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func eventTypes(events []models.MessagesStreamEvent) []string {
+	types := []string{}
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestEventWriter_TranslatesChatStream(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	events := newEventWriter(recorder, newMessage("mistral"))
+	events.begin()
+
+	writer := middleware.NewChunkWriter(events.chunk)
+	for _, line := range []string{
+		`data: {"choices":[{"delta":{"role":"assistant","content":"No"}}]}`,
+		`data: {"choices":[{"delta":{"content":"."}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"lights","arguments":"{\"on\""}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":":true}"}}]}}]}`,
+		`data: [DONE]`,
+	} {
+		_, err := writer.Write([]byte(line + "\n\n"))
+		require.NoError(t, err)
+	}
+	message := events.finish(&models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{FinishReason: "tool_calls"}}}, models.Usage{PromptTokens: 4, CompletionTokens: 3})
+
+	got := readEvents(t, recorder.Body.String())
+	assert.Equal(t, []string{
+		"message_start",
+		"content_block_start",
+		"content_block_delta",
+		"content_block_delta",
+		"content_block_stop",
+		"content_block_start",
+		"content_block_delta",
+		"content_block_delta",
+		"content_block_stop",
+		"message_delta",
+		"message_stop",
+	}, eventTypes(got))
+	assert.Equal(t, 1, *got[5].Index)
+	assert.Equal(t, "input_json_delta", got[6].Delta.Type)
+	assert.Equal(t, "tool_use", *got[9].Delta.StopReason)
+	assert.Equal(t, 3, got[9].Usage.OutputTokens)
+
+	require.Len(t, message.Content, 2)
+	assert.Equal(t, "No.", message.Content[0].Text)
+	assert.JSONEq(t, `{"on":true}`, string(message.Content[1].Input))
+}
+
+func TestEventWriter_SendsUnstreamedAnswerAtTheEnd(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	events := newEventWriter(recorder, newMessage("mistral"))
+	events.begin()
+	message := events.finish(&models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{Message: models.ChatMessage{Content: "No."}}}}, models.Usage{})
+
+	got := readEvents(t, recorder.Body.String())
+	assert.Equal(t, []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}, eventTypes(got))
+	assert.Equal(t, "end_turn", *message.StopReason)
+}
+
+func TestEventWriter_Failed(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	events := newEventWriter(recorder, newMessage("mistral"))
+	events.begin()
+	events.failed(models.UpstreamError(429, []byte(`{"error":{"message":"slow down","type":"rate_limit_exceeded"}}`)))
+	events.failed(errors.New("boom"))
+
+	got := readEvents(t, recorder.Body.String())
+	assert.Equal(t, "error", got[1].Type)
+	assert.Equal(t, "rate_limit_error", got[1].Error.Type)
+	assert.Equal(t, "slow down", got[1].Error.Message)
+	assert.Equal(t, "api_error", got[2].Error.Type)
+}
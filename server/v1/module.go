@@ -1,18 +1,43 @@
 package v1
 
 import (
+	"github.com/gorilla/mux"
+	"github.com/teagan42/snidemind/auth"
+	"github.com/teagan42/snidemind/quota"
+	"github.com/teagan42/snidemind/server/middleware"
 	"github.com/teagan42/snidemind/server/utils"
 	"github.com/teagan42/snidemind/server/v1/chat"
+	"github.com/teagan42/snidemind/server/v1/messages"
 	"github.com/teagan42/snidemind/server/v1/models"
+	"github.com/teagan42/snidemind/server/v1/pipeline"
+	"github.com/teagan42/snidemind/server/v1/responses"
 	"go.uber.org/fx"
 )
 
+// UseAuth requires an API key on every API route when `auth` is configured. The key is checked on the root router,
+// ahead of the OpenAPI validation, so a caller without one gets a 401 whatever its body. The requested model is
+// checked once the body has been read, before UseQuota so forbidden requests aren't charged.
+func UseAuth(root *mux.Router, router *mux.Router, authenticator *auth.Authenticator) {
+	root.Use(middleware.AuthMiddleware(authenticator, router))
+	router.Use(middleware.ModelAccessMiddleware)
+}
+
+// UseQuota puts every API route behind the rate limiter. /metrics and friends stay unmetered.
+func UseQuota(router *mux.Router, limiter *quota.Limiter) {
+	router.Use(middleware.QuotaMiddleware(limiter))
+}
+
 var Module = utils.ApiRouteModule(utils.ApiRouteModuleParams{
 	ParentRouter: "root",
 	ModuleName:   "v1",
 	Prefix:       "v1",
 	SubModules: &[]fx.Option{
+		fx.Invoke(fx.Annotate(UseAuth, fx.ParamTags(`name:"rootRouter"`, `name:"v1Router"`))),
+		fx.Invoke(fx.Annotate(UseQuota, fx.ParamTags(`name:"v1Router"`))),
 		chat.Module,
+		messages.Module,
 		models.Module,
+		pipeline.Module,
+		responses.Module,
 	},
 })
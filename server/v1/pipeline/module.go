@@ -0,0 +1,14 @@
+package pipeline
+
+import (
+	"github.com/teagan42/snidemind/server/utils"
+)
+
+var Module = utils.ApiRouteModule(utils.ApiRouteModuleParams{
+	ParentRouter: "v1",
+	ModuleName:   "pipeline",
+	Prefix:       "pipeline",
+	Routes: &[]any{
+		NewTraceController,
+	},
+})
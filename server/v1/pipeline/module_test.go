@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/teagan42/snidemind/models"
+	core "github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func TestPipelineModule_ProvidesTraceRoute(t *testing.T) {
+	var routes []utils.Route
+	app := fxtest.New(
+		t,
+		fx.Provide(func() *zap.Logger {
+			return zap.NewNop()
+		}),
+		fx.Provide(func() *core.Pipeline {
+			return &core.Pipeline{
+				Steps:  []models.PipelineStep{},
+				Logger: zap.NewNop(),
+			}
+		}),
+		fx.Provide(
+			fx.Annotate(
+				func() *mux.Router {
+					return mux.NewRouter()
+				},
+				fx.ResultTags(`name:"v1Router"`),
+			),
+		),
+		Module,
+		fx.Populate(
+			fx.Annotate(
+				&routes,
+				fx.ParamTags(`group:"pipelineRoutes"`),
+			),
+		),
+	)
+	app.RequireStart()
+	if len(routes) != 1 {
+		t.Fatalf("Expected one pipeline route, got %d", len(routes))
+	}
+	if routes[0].Pattern() != "trace" {
+		t.Errorf("Expected route pattern to be 'trace', but got '%s'", routes[0].Pattern())
+	}
+	app.RequireStop()
+}
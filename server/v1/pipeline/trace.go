@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"net/http"
+
+	"github.com/teagan42/snidemind/models"
+	core "github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	"github.com/teagan42/snidemind/server/middleware"
+	"github.com/teagan42/snidemind/server/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type TraceController struct {
+	log      *zap.Logger
+	pipeline *core.Pipeline
+}
+
+type TraceControllerParams struct {
+	fx.In
+	Log      *zap.Logger
+	Pipeline *core.Pipeline
+}
+
+func NewTraceController(p TraceControllerParams) *TraceController {
+	return &TraceController{
+		log:      p.Log.Named("TraceController"),
+		pipeline: p.Pipeline,
+	}
+}
+
+// ServeHTTP runs the request as a dry-run, see trace.OptionsFromQuery for whether the model is called.
+func (c *TraceController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.MethodNotAllowed(w, r)
+		return
+	}
+	body, err := middleware.GetValidatedBody[models.ChatCompletionRequest](r)
+	if err != nil {
+		c.log.Error("Error getting validated body", zap.Error(err))
+		middleware.WriteError(w, http.StatusBadRequest, models.APIErrorDetail{Message: "Invalid request body: " + err.Error(), Type: models.ErrorTypeInvalidRequest})
+		return
+	}
+	options := trace.OptionsFromQuery(r.URL.Query())
+	if err := trace.Write(w, c.pipeline.Trace(r.Context(), body, options)); err != nil {
+		c.log.Error("Error writing trace", zap.Error(err))
+	}
+}
+
+func (c *TraceController) Pattern() string {
+	return "trace"
+}
+
+func (c *TraceController) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+var _ utils.Route = (*TraceController)(nil)
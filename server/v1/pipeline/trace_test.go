@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+	core "github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	"github.com/teagan42/snidemind/server/middleware"
+	"go.uber.org/zap"
+)
+
+type modelStep struct{}
+
+func (modelStep) Name() string { return "LLM" }
+func (modelStep) Process(_ *[]models.PipelineStep, input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	input.ResponseWriter.Write([]byte("real answer"))
+	return input, nil
+}
+func (modelStep) Stub(input *models.PipelineMessage) (*models.PipelineMessage, error) {
+	input.Response = &models.ChatCompletionResponse{ID: "stub"}
+	return input, nil
+}
+
+func newRequest(t *testing.T, url string) *http.Request {
+	body := map[string]any{
+		"model":    "mistral",
+		"messages": []any{map[string]any{"role": "user", "content": "hi"}},
+	}
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewBuffer(data))
+	return req.WithContext(context.WithValue(req.Context(), middleware.BodyKey, any(body)))
+}
+
+func newController() *TraceController {
+	return NewTraceController(TraceControllerParams{
+		Log: zap.NewNop(),
+		Pipeline: &core.Pipeline{
+			Steps:  []models.PipelineStep{trace.Wrap(modelStep{})},
+			Logger: zap.NewNop(),
+		},
+	})
+}
+
+func TestTraceController_StubsByDefault(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newController().ServeHTTP(rr, newRequest(t, "/v1/pipeline/trace"))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var result map[string]any
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	steps := result["steps"].([]any)
+	require.Len(t, steps, 1)
+	assert.Equal(t, "LLM", steps[0].(map[string]any)["name"])
+	assert.Equal(t, true, steps[0].(map[string]any)["stubbed"])
+	assert.Equal(t, "stub", result["response"].(map[string]any)["id"])
+}
+
+func TestTraceController_RealCall(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newController().ServeHTTP(rr, newRequest(t, "/v1/pipeline/trace?stub_llm=false"))
+
+	var result map[string]any
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, "real answer", result["output"])
+	assert.Nil(t, result["steps"].([]any)[0].(map[string]any)["stubbed"])
+}
+
+func TestTraceController_InvalidBody(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newController().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/pipeline/trace", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,230 @@
+package responses
+
+import (
+	"fmt"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/utils"
+)
+
+const (
+	responseObject = "response"
+
+	statusInProgress = "in_progress"
+	statusCompleted  = "completed"
+	statusIncomplete = "incomplete"
+	statusFailed     = "failed"
+
+	itemMessage            = "message"
+	itemFunctionCall       = "function_call"
+	itemFunctionCallOutput = "function_call_output"
+	contentOutputText      = "output_text"
+)
+
+// requestError is a request the pipeline can't be given, reported to the client as a 400 on param.
+type requestError struct {
+	param   string
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// chatRequest turns a Responses request into the chat completion request the pipeline runs. previous is the
+// conversation of the response the request continues, if any. Besides the request it returns the conversation
+// without the instructions, which OpenAI doesn't carry over to the next response either.
+func chatRequest(request models.ResponseRequest, previous []models.ChatMessage) (models.ChatCompletionRequest, []models.ChatMessage, error) {
+	input, err := inputMessages(request.Input)
+	if err != nil {
+		return models.ChatCompletionRequest{}, nil, err
+	}
+	conversation := append(append([]models.ChatMessage{}, previous...), input...)
+	messages := conversation
+	if request.Instructions != "" {
+		messages = append([]models.ChatMessage{{Role: "system", Content: request.Instructions}}, conversation...)
+	}
+	chat := models.ChatCompletionRequest{
+		Messages:            messages,
+		Model:               request.Model,
+		MaxCompletionTokens: request.MaxOutputTokens,
+		ParallelToolCalls:   request.ParallelToolCalls,
+		Stream:              request.Stream,
+		Temperature:         request.Temperature,
+		TopP:                request.TopP,
+		User:                request.User,
+	}
+	if len(request.Tools) > 0 {
+		tools := make([]models.Tool, len(request.Tools))
+		for i, tool := range request.Tools {
+			if tool.Type != "function" {
+				return models.ChatCompletionRequest{}, nil, &requestError{fmt.Sprintf("tools[%d].type", i), fmt.Sprintf("tools of type %s are not supported, only function tools are", tool.Type)}
+			}
+			tools[i] = models.Tool{Type: "function", Function: models.ToolFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters}}
+		}
+		chat.Tools = &tools
+	}
+	return chat, conversation, nil
+}
+
+// inputMessages turns `input` into chat messages. Function calls become tool calls of an assistant message,
+// following the assistant's text when there is some, and their outputs `tool` messages.
+func inputMessages(input models.ResponseInput) ([]models.ChatMessage, error) {
+	if input.Items == nil {
+		return []models.ChatMessage{{Role: "user", Content: input.Text}}, nil
+	}
+	messages := []models.ChatMessage{}
+	for i, item := range input.Items {
+		switch item.Type {
+		case "", itemMessage:
+			content, err := item.Content.Join()
+			if err != nil {
+				return nil, &requestError{fmt.Sprintf("input[%d].content", i), err.Error()}
+			}
+			role := item.Role
+			switch role {
+			case "developer":
+				role = "system" // Not every upstream knows the developer role, they all know system
+			case "user", "assistant", "system":
+			default:
+				return nil, &requestError{fmt.Sprintf("input[%d].role", i), fmt.Sprintf("role %q is not supported", item.Role)}
+			}
+			messages = append(messages, models.ChatMessage{Role: role, Content: content})
+		case itemFunctionCall:
+			call := models.ChatCompletionsMessageToolCall{ID: item.CallID, Type: "function", Function: models.ChatCompletionsMessageFunctionCall{Name: item.Name, Arguments: item.Arguments}}
+			if len(messages) == 0 || messages[len(messages)-1].Role != "assistant" {
+				messages = append(messages, models.ChatMessage{Role: "assistant"})
+			}
+			last := &messages[len(messages)-1]
+			if last.ToolCalls == nil {
+				last.ToolCalls = &[]models.ChatCompletionsMessageToolCall{}
+			}
+			*last.ToolCalls = append(*last.ToolCalls, call)
+		case itemFunctionCallOutput:
+			messages = append(messages, models.ChatMessage{Role: "tool", ToolCallID: item.CallID, Content: item.Output})
+		default:
+			return nil, &requestError{fmt.Sprintf("input[%d].type", i), fmt.Sprintf("input items of type %s are not supported", item.Type)}
+		}
+	}
+	return messages, nil
+}
+
+// newResponse is the response to request before anything has been generated for it.
+func newResponse(request models.ResponseRequest, id string, createdAt int64, store bool) models.Response {
+	response := models.Response{
+		ID:                id,
+		Object:            responseObject,
+		CreatedAt:         createdAt,
+		Status:            statusInProgress,
+		Model:             request.Model,
+		Output:            []models.ResponseOutputItem{},
+		Metadata:          map[string]string{},
+		ParallelToolCalls: request.ParallelToolCalls == nil || *request.ParallelToolCalls,
+		Store:             store,
+		Temperature:       request.Temperature,
+		TopP:              request.TopP,
+		Tools:             []models.ResponseTool{},
+		User:              request.User,
+	}
+	if request.Instructions != "" {
+		response.Instructions = &request.Instructions
+	}
+	if request.PreviousResponseID != "" {
+		response.PreviousResponseID = &request.PreviousResponseID
+	}
+	for key, value := range request.Metadata {
+		response.Metadata[key] = value
+	}
+	if request.Tools != nil {
+		response.Tools = request.Tools
+	}
+	return response
+}
+
+// outputItems lists what the model answered: its text as a message, then one item per tool call it made.
+func outputItems(chat *models.ChatCompletionResponse) []models.ResponseOutputItem {
+	items := []models.ResponseOutputItem{}
+	if chat == nil || len(chat.Choices) == 0 {
+		return items
+	}
+	message := chat.Choices[0].Message
+	if message.Content != "" {
+		items = append(items, models.ResponseOutputItem{
+			Type:    itemMessage,
+			ID:      utils.NewID("msg_"),
+			Status:  statusCompleted,
+			Role:    "assistant",
+			Content: []models.ResponseOutputContent{{Type: contentOutputText, Text: message.Content, Annotations: []any{}}},
+		})
+	}
+	if message.ToolCalls != nil {
+		for _, call := range *message.ToolCalls {
+			items = append(items, models.ResponseOutputItem{
+				Type:      itemFunctionCall,
+				ID:        utils.NewID("fc_"),
+				Status:    statusCompleted,
+				CallID:    call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
+	}
+	return items
+}
+
+// complete fills in the response once the pipeline is done. A model that stopped at the token limit or
+// was filtered leaves the response incomplete, like OpenAI's would be.
+func complete(response *models.Response, chat *models.ChatCompletionResponse, output []models.ResponseOutputItem, usage models.Usage) {
+	response.Status = statusCompleted
+	response.Output = output
+	if chat != nil && chat.Model != "" {
+		response.Model = chat.Model
+	}
+	if chat != nil && len(chat.Choices) > 0 {
+		switch chat.Choices[0].FinishReason {
+		case "length":
+			response.Status = statusIncomplete
+			response.IncompleteDetails = &models.ResponseIncompleteDetails{Reason: "max_output_tokens"}
+		case "content_filter":
+			response.Status = statusIncomplete
+			response.IncompleteDetails = &models.ResponseIncompleteDetails{Reason: "content_filter"}
+		}
+	}
+	if usage != (models.Usage{}) {
+		response.Usage = models.NewResponseUsage(usage)
+	}
+}
+
+// fail marks the response failed with the error the client would have been given for err.
+func fail(response *models.Response, err error) {
+	_, detail := models.ErrorResponse(err)
+	code := detail.Type
+	if detail.Code != nil {
+		code = *detail.Code
+	}
+	response.Status = statusFailed
+	response.Error = &models.ResponseError{Code: code, Message: detail.Message}
+}
+
+// outputMessage is the response's output as the assistant message a later response continues from.
+func outputMessage(output []models.ResponseOutputItem) models.ChatMessage {
+	message := models.ChatMessage{Role: "assistant"}
+	for _, item := range output {
+		switch item.Type {
+		case itemMessage:
+			for _, content := range item.Content {
+				message.Content += content.Text
+			}
+		case itemFunctionCall:
+			if message.ToolCalls == nil {
+				message.ToolCalls = &[]models.ChatCompletionsMessageToolCall{}
+			}
+			*message.ToolCalls = append(*message.ToolCalls, models.ChatCompletionsMessageToolCall{
+				ID:       item.CallID,
+				Type:     "function",
+				Function: models.ChatCompletionsMessageFunctionCall{Name: item.Name, Arguments: item.Arguments},
+			})
+		}
+	}
+	return message
+}
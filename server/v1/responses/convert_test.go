@@ -0,0 +1,105 @@
+package responses
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+)
+
+func decodeRequest(t *testing.T, body string) models.ResponseRequest {
+	t.Helper()
+	var request models.ResponseRequest
+	require.NoError(t, json.Unmarshal([]byte(body), &request))
+	return request
+}
+
+func TestChatRequest_StringInputAndInstructions(t *testing.T) {
+	request := decodeRequest(t, `{"model":"mistral","instructions":"be snide","input":"hi","max_output_tokens":50}`)
+	previous := []models.ChatMessage{{Role: "user", Content: "before"}, {Role: "assistant", Content: "what"}}
+
+	chat, conversation, err := chatRequest(request, previous)
+	require.NoError(t, err)
+	assert.Equal(t, "mistral", chat.Model)
+	assert.Equal(t, int64(50), *chat.MaxCompletionTokens)
+	assert.Equal(t, []models.ChatMessage{
+		{Role: "system", Content: "be snide"},
+		{Role: "user", Content: "before"},
+		{Role: "assistant", Content: "what"},
+		{Role: "user", Content: "hi"},
+	}, chat.Messages)
+	assert.Equal(t, chat.Messages[1:], conversation, "instructions aren't part of the conversation")
+}
+
+func TestChatRequest_Items(t *testing.T) {
+	request := decodeRequest(t, `{"model":"mistral","input":[
+		{"role":"developer","content":"be snide"},
+		{"type":"message","role":"user","content":[{"type":"input_text","text":"lights"},{"type":"input_text","text":"on"}]},
+		{"type":"function_call","call_id":"call_1","name":"lights","arguments":"{}"},
+		{"type":"function_call","call_id":"call_2","name":"fan","arguments":"{}"},
+		{"type":"function_call_output","call_id":"call_1","output":"done"}
+	],"tools":[{"type":"function","name":"lights","parameters":{"type":"object"}}]}`)
+
+	chat, _, err := chatRequest(request, nil)
+	require.NoError(t, err)
+	require.Len(t, chat.Messages, 4)
+	assert.Equal(t, models.ChatMessage{Role: "system", Content: "be snide"}, chat.Messages[0])
+	assert.Equal(t, models.ChatMessage{Role: "user", Content: "lights\non"}, chat.Messages[1])
+	assert.Equal(t, "assistant", chat.Messages[2].Role)
+	require.NotNil(t, chat.Messages[2].ToolCalls)
+	assert.Equal(t, []string{"call_1", "call_2"}, []string{(*chat.Messages[2].ToolCalls)[0].ID, (*chat.Messages[2].ToolCalls)[1].ID})
+	assert.Equal(t, models.ChatMessage{Role: "tool", ToolCallID: "call_1", Content: "done"}, chat.Messages[3])
+	require.NotNil(t, chat.Tools)
+	assert.Equal(t, "lights", (*chat.Tools)[0].Function.Name)
+}
+
+func TestChatRequest_Unsupported(t *testing.T) {
+	tests := map[string]struct {
+		body  string
+		param string
+	}{
+		"image content": {`{"model":"m","input":[{"role":"user","content":[{"type":"input_image","image_url":"x"}]}]}`, "input[0].content"},
+		"item type":     {`{"model":"m","input":[{"role":"user","content":"hi"},{"type":"item_reference","id":"x"}]}`, "input[1].type"},
+		"role":          {`{"model":"m","input":[{"role":"robot","content":"hi"}]}`, "input[0].role"},
+		"tool type":     {`{"model":"m","input":"hi","tools":[{"type":"web_search_preview"}]}`, "tools[0].type"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := chatRequest(decodeRequest(t, test.body), nil)
+			var requestErr *requestError
+			require.ErrorAs(t, err, &requestErr)
+			assert.Equal(t, test.param, requestErr.param)
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	chat := &models.ChatCompletionResponse{
+		Model: "mistral-7b",
+		Choices: []models.ChatCompletionChoice{{
+			FinishReason: "length",
+			Message: models.ChatMessage{Role: "assistant", Content: "No.", ToolCalls: &[]models.ChatCompletionsMessageToolCall{
+				{ID: "call_1", Type: "function", Function: models.ChatCompletionsMessageFunctionCall{Name: "lights", Arguments: `{"on":true}`}},
+			}},
+		}},
+	}
+	response := newResponse(decodeRequest(t, `{"model":"mistral","input":"hi"}`), "resp_1", 100, true)
+	output := outputItems(chat)
+	complete(&response, chat, output, models.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5})
+
+	assert.Equal(t, "incomplete", response.Status)
+	assert.Equal(t, "max_output_tokens", response.IncompleteDetails.Reason)
+	assert.Equal(t, "mistral-7b", response.Model)
+	assert.Equal(t, &models.ResponseUsage{InputTokens: 3, OutputTokens: 2, TotalTokens: 5}, response.Usage)
+	require.Len(t, response.Output, 2)
+	assert.Equal(t, "No.", response.Output[0].Content[0].Text)
+	assert.Equal(t, "function_call", response.Output[1].Type)
+	assert.Equal(t, "call_1", response.Output[1].CallID)
+
+	message := outputMessage(response.Output)
+	assert.Equal(t, "No.", message.Content)
+	require.NotNil(t, message.ToolCalls)
+	assert.Equal(t, `{"on":true}`, (*message.ToolCalls)[0].Function.Arguments)
+}
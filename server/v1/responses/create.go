@@ -0,0 +1,138 @@
+package responses
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/teagan42/snidemind/completions"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/pipeline"
+	"github.com/teagan42/snidemind/pipeline/trace"
+	responseStore "github.com/teagan42/snidemind/responses"
+	"github.com/teagan42/snidemind/server/middleware"
+	"github.com/teagan42/snidemind/server/utils"
+	utilities "github.com/teagan42/snidemind/utils"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type CreateResponseControllerParams struct {
+	fx.In
+	Log      *zap.Logger
+	Pipeline *pipeline.Pipeline
+	Store    *responseStore.Store `optional:"true"`
+}
+
+// CreateResponseController serves the Responses API by translating it to and from the chat completion the
+// pipeline runs, so every pipeline works with both.
+type CreateResponseController struct {
+	log      *zap.Logger
+	pipeline *pipeline.Pipeline
+	store    *responseStore.Store
+}
+
+func NewCreateResponseController(p CreateResponseControllerParams) *CreateResponseController {
+	return &CreateResponseController{
+		log:      p.Log.Named("CreateResponseController"),
+		pipeline: p.Pipeline,
+		store:    p.Store,
+	}
+}
+
+func (c *CreateResponseController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := middleware.GetValidatedBody[models.ResponseRequest](r)
+	if err != nil {
+		c.log.Error("Error getting validated body", zap.Error(err))
+		middleware.WriteBadRequest(w, "input", "Invalid request body: "+err.Error())
+		return
+	}
+	if err := completions.ValidateMetadata(request.Metadata); err != nil {
+		middleware.WriteBadRequest(w, "metadata", err.Error())
+		return
+	}
+	previous, ok := c.previous(w, r, request)
+	if !ok {
+		return
+	}
+	chat, conversation, err := chatRequest(request, previous)
+	if err != nil {
+		var requestErr *requestError
+		if errors.As(err, &requestErr) {
+			middleware.WriteBadRequest(w, requestErr.param, requestErr.message)
+		} else {
+			middleware.WriteFailure(w, err)
+		}
+		return
+	}
+	// Responses are stored unless the client says otherwise, that's what makes previous_response_id work
+	store := c.store != nil && (request.Store == nil || *request.Store)
+	response := newResponse(request, utilities.NewID("resp_"), time.Now().Unix(), store)
+
+	if request.Stream != nil && *request.Stream {
+		events := newEventWriter(w, response)
+		events.start()
+		message, err := c.pipeline.ProcessRequest(r.Context(), chat, middleware.NewChunkWriter(events.chunk))
+		if err != nil {
+			c.log.Error("Error processing pipeline", zap.Error(err))
+			events.failed(err)
+			return
+		}
+		response = events.finish(message.Response, message.TotalUsage())
+	} else {
+		// The steps answer in chat completion format, which is of no use to this client
+		message, err := c.pipeline.ProcessRequest(r.Context(), chat, trace.NewResponseBuffer())
+		if err != nil {
+			c.log.Error("Error processing pipeline", zap.Error(err))
+			middleware.WriteFailure(w, err)
+			return
+		}
+		complete(&response, message.Response, outputItems(message.Response), message.TotalUsage())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			c.log.Error("Error writing response", zap.Error(err))
+		}
+	}
+	if store {
+		record := responseStore.Record{Response: response, Conversation: append(conversation, outputMessage(response.Output)), Owner: models.OwnerFromContext(r.Context())}
+		if err := c.store.Save(record); err != nil {
+			c.log.Error("Error storing response", zap.Error(err))
+		}
+	}
+}
+
+// previous is the conversation the request continues, nil when it doesn't continue one. Responses belong to the
+// identity that created them; anyone else is told it doesn't exist.
+func (c *CreateResponseController) previous(w http.ResponseWriter, r *http.Request, request models.ResponseRequest) ([]models.ChatMessage, bool) {
+	if request.PreviousResponseID == "" {
+		return nil, true
+	}
+	if c.store == nil {
+		middleware.WriteBadRequest(w, "previous_response_id", "Responses are not stored, previous_response_id can't be used.")
+		return nil, false
+	}
+	record, err := c.store.Get(request.PreviousResponseID, models.OwnerFromContext(r.Context()))
+	if err != nil {
+		if errors.Is(err, responseStore.ErrNotFound) {
+			middleware.WriteBadRequest(w, "previous_response_id", "Previous response with id '"+request.PreviousResponseID+"' not found.")
+		} else {
+			c.log.Error("Error reading the response store", zap.Error(err))
+			middleware.WriteFailure(w, err)
+		}
+		return nil, false
+	}
+	return record.Conversation, true
+}
+
+// The pattern is empty so the route is /v1/responses itself.
+func (c *CreateResponseController) Pattern() string {
+	return ""
+}
+
+func (c *CreateResponseController) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+var _ utils.Route = (*CreateResponseController)(nil)
@@ -0,0 +1,14 @@
+package responses
+
+import (
+	"github.com/teagan42/snidemind/server/utils"
+)
+
+var Module = utils.ApiRouteModule(utils.ApiRouteModuleParams{
+	ParentRouter: "v1",
+	ModuleName:   "responses",
+	Prefix:       "responses",
+	Routes: &[]any{
+		NewCreateResponseController,
+	},
+})
@@ -0,0 +1,173 @@
+package responses
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/utils"
+)
+
+// eventWriter sends the client the answer as Responses events, translated from the chat completion stream
+// the steps write to its ChunkWriter as it's generated. If nothing was streamed (a step answering in one
+// piece) the answer is sent in full once the pipeline is done instead.
+type eventWriter struct {
+	client   http.ResponseWriter
+	response models.Response
+	sequence int
+	output   []*models.ResponseOutputItem
+	message  *models.ResponseOutputItem // The item the text goes to, nil until there's text
+	calls    map[int]*models.ResponseOutputItem
+	err      error // The first error writing to the client, the stream is over after that
+}
+
+func newEventWriter(client http.ResponseWriter, response models.Response) *eventWriter {
+	return &eventWriter{client: client, response: response, calls: map[int]*models.ResponseOutputItem{}}
+}
+
+func (e *eventWriter) chunk(chunk models.ChatCompletionChunk) error {
+	if len(chunk.Choices) == 0 {
+		return e.err
+	}
+	delta := chunk.Choices[0].Delta
+	if delta.Content != "" {
+		e.text(delta.Content)
+	}
+	for _, call := range delta.ToolCalls {
+		e.call(call.Index, call.ID, call.Function.Name, call.Function.Arguments)
+	}
+	return e.err
+}
+
+func index(i int) *int {
+	return &i
+}
+
+func (e *eventWriter) add(item *models.ResponseOutputItem) int {
+	e.output = append(e.output, item)
+	added := *item
+	e.emit(models.ResponseStreamEvent{Type: "response.output_item.added", OutputIndex: index(len(e.output) - 1), Item: &added})
+	return len(e.output) - 1
+}
+
+func (e *eventWriter) outputIndex(item *models.ResponseOutputItem) int {
+	for i, candidate := range e.output {
+		if candidate == item {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e *eventWriter) text(delta string) {
+	if e.message == nil {
+		e.message = &models.ResponseOutputItem{Type: itemMessage, ID: utils.NewID("msg_"), Status: statusInProgress, Role: "assistant", Content: []models.ResponseOutputContent{}}
+		i := e.add(e.message)
+		e.emit(models.ResponseStreamEvent{
+			Type:         "response.content_part.added",
+			ItemID:       e.message.ID,
+			OutputIndex:  index(i),
+			ContentIndex: index(0),
+			Part:         &models.ResponseOutputContent{Type: contentOutputText, Annotations: []any{}},
+		})
+		e.message.Content = []models.ResponseOutputContent{{Type: contentOutputText, Annotations: []any{}}}
+	}
+	e.message.Content[0].Text += delta
+	e.emit(models.ResponseStreamEvent{Type: "response.output_text.delta", ItemID: e.message.ID, OutputIndex: index(e.outputIndex(e.message)), ContentIndex: index(0), Delta: delta})
+}
+
+// call adds a piece of the tool call at position i of the model's tool calls. The first piece names the
+// function, the ones after it bring the arguments a few characters at a time.
+func (e *eventWriter) call(i int, id string, name string, arguments string) {
+	item, ok := e.calls[i]
+	if !ok {
+		item = &models.ResponseOutputItem{Type: itemFunctionCall, ID: utils.NewID("fc_"), Status: statusInProgress, CallID: id, Name: name}
+		e.calls[i] = item
+		e.add(item)
+	}
+	if item.CallID == "" {
+		item.CallID = id
+	}
+	if item.Name == "" {
+		item.Name = name
+	}
+	if arguments != "" {
+		item.Arguments += arguments
+		e.emit(models.ResponseStreamEvent{Type: "response.function_call_arguments.delta", ItemID: item.ID, OutputIndex: index(e.outputIndex(item)), Delta: arguments})
+	}
+}
+
+// start opens the stream, before the pipeline runs.
+func (e *eventWriter) start() {
+	e.client.Header().Set("Content-Type", "text/event-stream")
+	e.client.Header().Set("Cache-Control", "no-cache")
+	e.client.WriteHeader(http.StatusOK)
+	e.emit(models.ResponseStreamEvent{Type: "response.created", Response: e.snapshot()})
+	e.emit(models.ResponseStreamEvent{Type: "response.in_progress", Response: e.snapshot()})
+}
+
+// finish closes the items and the stream once the pipeline is done. If nothing was streamed, the answer is
+// sent now, as if it had been. It returns the response as the client was last told about it.
+func (e *eventWriter) finish(chat *models.ChatCompletionResponse, usage models.Usage) models.Response {
+	if len(e.output) == 0 {
+		for i, item := range outputItems(chat) {
+			switch item.Type {
+			case itemMessage:
+				e.text(item.Content[0].Text)
+			case itemFunctionCall:
+				e.call(i, item.CallID, item.Name, item.Arguments)
+			}
+		}
+	}
+	output := make([]models.ResponseOutputItem, len(e.output))
+	for i, item := range e.output {
+		item.Status = statusCompleted
+		switch item.Type {
+		case itemMessage:
+			part := item.Content[0]
+			e.emit(models.ResponseStreamEvent{Type: "response.output_text.done", ItemID: item.ID, OutputIndex: index(i), ContentIndex: index(0), Text: &part.Text})
+			e.emit(models.ResponseStreamEvent{Type: "response.content_part.done", ItemID: item.ID, OutputIndex: index(i), ContentIndex: index(0), Part: &part})
+		case itemFunctionCall:
+			arguments := item.Arguments
+			e.emit(models.ResponseStreamEvent{Type: "response.function_call_arguments.done", ItemID: item.ID, OutputIndex: index(i), Arguments: &arguments})
+		}
+		done := *item
+		e.emit(models.ResponseStreamEvent{Type: "response.output_item.done", OutputIndex: index(i), Item: &done})
+		output[i] = *item
+	}
+	complete(&e.response, chat, output, usage)
+	e.emit(models.ResponseStreamEvent{Type: "response." + e.response.Status, Response: e.snapshot()})
+	return e.response
+}
+
+// failed ends the stream with the error that failed the pipeline.
+func (e *eventWriter) failed(err error) {
+	fail(&e.response, err)
+	e.emit(models.ResponseStreamEvent{Type: "response.failed", Response: e.snapshot()})
+}
+
+func (e *eventWriter) snapshot() *models.Response {
+	response := e.response
+	return &response
+}
+
+func (e *eventWriter) emit(event models.ResponseStreamEvent) {
+	if e.err != nil {
+		return
+	}
+	event.SequenceNumber = e.sequence
+	e.sequence++
+	data, err := json.Marshal(event)
+	if err != nil {
+		e.err = err
+		return
+	}
+	if _, err := fmt.Fprintf(e.client, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		e.err = err
+		return
+	}
+	if flusher, ok := e.client.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,116 @@
+package responses
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+	"github.com/teagan42/snidemind/server/middleware"
+)
+
+func readEvents(t *testing.T, body string) []models.ResponseStreamEvent {
+	t.Helper()
+	events := []models.ResponseStreamEvent{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		if payload, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var event models.ResponseStreamEvent
+			require.NoError(t, json.Unmarshal([]byte(payload), &event))
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func eventTypes(events []models.ResponseStreamEvent) []string {
+	types := []string{}
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestEventWriter_TranslatesChatStream(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	events := newEventWriter(recorder, newResponse(models.ResponseRequest{Model: "mistral"}, "resp_1", 100, false))
+	events.start()
+
+	// Chunks arrive split anywhere, not a line at a time
+	stream := `data: {"choices":[{"delta":{"role":"assistant","content":"No"}}]}` + "\n\n" +
+		`data: {"choices":[{"delta":{"content":"."}}]}` + "\n\n" +
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"lights","arguments":"{\"on\""}}]}}]}` + "\n\n" +
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":":true}"}}]}}]}` + "\n\n" +
+		"data: [DONE]\n\n"
+	writer := middleware.NewChunkWriter(events.chunk)
+	for len(stream) > 0 {
+		n := min(7, len(stream))
+		_, err := writer.Write([]byte(stream[:n]))
+		require.NoError(t, err)
+		stream = stream[n:]
+	}
+	response := events.finish(&models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{FinishReason: "tool_calls"}}}, models.Usage{TotalTokens: 7})
+
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	got := readEvents(t, recorder.Body.String())
+	assert.Equal(t, []string{
+		"response.created",
+		"response.in_progress",
+		"response.output_item.added",
+		"response.content_part.added",
+		"response.output_text.delta",
+		"response.output_text.delta",
+		"response.output_item.added",
+		"response.function_call_arguments.delta",
+		"response.function_call_arguments.delta",
+		"response.output_text.done",
+		"response.content_part.done",
+		"response.output_item.done",
+		"response.function_call_arguments.done",
+		"response.output_item.done",
+		"response.completed",
+	}, eventTypes(got))
+	for i, event := range got {
+		assert.Equal(t, i, event.SequenceNumber)
+	}
+
+	assert.Equal(t, "completed", response.Status)
+	require.Len(t, response.Output, 2)
+	assert.Equal(t, "No.", response.Output[0].Content[0].Text)
+	assert.Equal(t, `{"on":true}`, response.Output[1].Arguments)
+	assert.Equal(t, "call_1", response.Output[1].CallID)
+	assert.Equal(t, 7, response.Usage.TotalTokens)
+	assert.Equal(t, response.Output, got[len(got)-1].Response.Output)
+}
+
+func TestEventWriter_SendsUnstreamedAnswerAtTheEnd(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	events := newEventWriter(recorder, newResponse(models.ResponseRequest{Model: "mistral"}, "resp_1", 100, false))
+	events.start()
+	_, err := middleware.NewChunkWriter(events.chunk).Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion"}` + "\n"))
+	require.NoError(t, err)
+
+	response := events.finish(&models.ChatCompletionResponse{Choices: []models.ChatCompletionChoice{{Message: models.ChatMessage{Content: "No."}}}}, models.Usage{})
+
+	got := readEvents(t, recorder.Body.String())
+	assert.Contains(t, eventTypes(got), "response.output_text.delta")
+	assert.Equal(t, "No.", response.Output[0].Content[0].Text)
+}
+
+func TestEventWriter_Failed(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	events := newEventWriter(recorder, newResponse(models.ResponseRequest{Model: "mistral"}, "resp_1", 100, false))
+	events.start()
+	events.failed(errors.New("boom"))
+
+	got := readEvents(t, recorder.Body.String())
+	last := got[len(got)-1]
+	assert.Equal(t, "response.failed", last.Type)
+	assert.Equal(t, "failed", last.Response.Status)
+	assert.Equal(t, "server_error", last.Response.Error.Code)
+}
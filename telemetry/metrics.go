@@ -0,0 +1,188 @@
+package telemetry
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/teagan42/snidemind/models"
+)
+
+const metricsNamespace = "snidemind"
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// OtherModel is the model label of requests for a model that isn't configured, which the client is free to name.
+const OtherModel = "other"
+
+// Registry holds every metric served on /metrics, along with the Go runtime and process collectors.
+var Registry = func() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return registry
+}()
+
+var metrics = promauto.With(Registry)
+
+var (
+	httpRequests = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests, including streamed responses.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"method", "route"})
+	pipelineRuns = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pipeline_runs_total",
+		Help:      "Pipeline runs by pipeline, requested model and outcome.",
+	}, []string{"pipeline", "model", "outcome"})
+	pipelineDuration = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "pipeline_duration_seconds",
+		Help:      "Time to run a request through a pipeline.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"pipeline", "model"})
+	stepDuration = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "step_duration_seconds",
+		Help:      "Time spent in each pipeline step, including the steps it runs.",
+		Buckets:   prometheus.ExponentialBuckets(.001, 4, 10),
+	}, []string{"step"})
+	stepErrors = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "step_errors_total",
+		Help:      "Pipeline steps that returned an error.",
+	}, []string{"step"})
+	llmTimeToFirstToken = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "llm_time_to_first_token_seconds",
+		Help:      "Time from sending a request to an upstream model until the first token arrives. For buffered responses, that's the whole response.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2, 4, 8, 15, 30, 60},
+	}, []string{"model"})
+	llmTokensPerSecond = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "llm_tokens_per_second",
+		Help:      "Generation rate of streamed responses from upstream models, after the first token.",
+		Buckets:   []float64{1, 2.5, 5, 10, 20, 40, 80, 160, 320},
+	}, []string{"model"})
+	embedderDuration = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "embedder_duration_seconds",
+		Help:      "Time for an embedding request, by embedding model and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(.005, 2.5, 10),
+	}, []string{"model", "outcome"})
+	tokens = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tokens_total",
+		Help:      "Tokens spent on requests by pipeline, identity, model and type (prompt or completion).",
+	}, []string{"pipeline", "identity", "model", "type"})
+	memoryStoreEntries = metrics.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "memory_store_entries",
+		Help:      "Memories kept by storeMemory, whoever they belong to.",
+	})
+	mcpToolCalls = metrics.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "mcp_tool_calls_total",
+		Help:      "Tool calls made to MCP servers by server, tool and outcome.",
+	}, []string{"server", "tool", "outcome"})
+)
+
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+// ObservePipeline records a run of the named pipeline for the model the client asked for, or OtherModel
+// when that isn't one of the configured models.
+func ObservePipeline(pipeline string, model string, configured []string, err error, duration time.Duration) {
+	if !slices.Contains(configured, model) {
+		model = OtherModel
+	}
+	pipelineRuns.WithLabelValues(pipeline, model, outcome(err)).Inc()
+	pipelineDuration.WithLabelValues(pipeline, model).Observe(duration.Seconds())
+}
+
+func ObserveStep(step string, err error, duration time.Duration) {
+	stepDuration.WithLabelValues(step).Observe(duration.Seconds())
+	if err != nil {
+		stepErrors.WithLabelValues(step).Inc()
+	}
+}
+
+func ObserveTimeToFirstToken(model string, duration time.Duration) {
+	llmTimeToFirstToken.WithLabelValues(model).Observe(duration.Seconds())
+}
+
+// ObserveTokenRate records how fast tokens arrived once the first one had. Responses too short to time are ignored.
+func ObserveTokenRate(model string, tokens int, duration time.Duration) {
+	if tokens <= 0 || duration <= 0 {
+		return
+	}
+	llmTokensPerSecond.WithLabelValues(model).Observe(float64(tokens) / duration.Seconds())
+}
+
+func ObserveEmbedding(model string, err error, duration time.Duration) {
+	embedderDuration.WithLabelValues(model, outcome(err)).Observe(duration.Seconds())
+}
+
+// ObserveUsage counts the tokens a request spent, per model it called. Requests are counted by the identity they
+// were authenticated as, "" when authentication is off, rather than the `user` the client made up, which could
+// be anything.
+func ObserveUsage(pipeline string, identity *models.Identity, byModel map[string]models.Usage) {
+	name := ""
+	if identity != nil {
+		name = identity.Name
+	}
+	for model, usage := range byModel {
+		tokens.WithLabelValues(pipeline, name, model, "prompt").Add(float64(max(usage.PromptTokens, 0)))
+		tokens.WithLabelValues(pipeline, name, model, "completion").Add(float64(max(usage.CompletionTokens, 0)))
+	}
+}
+
+// ObserveToolCall counts a tool call. A call that succeeded but returned a tool error counts as an error.
+func ObserveToolCall(server string, tool string, err error) {
+	mcpToolCalls.WithLabelValues(server, tool, outcome(err)).Inc()
+}
+
+func ObserveMemoryStoreSize(entries int) {
+	memoryStoreEntries.Set(float64(entries))
+}
+
+// MetricsHandler serves the Registry in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// MetricsMiddleware counts requests by the route they matched, so path parameters don't blow up the label count.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		// httpsnoop keeps the writer's Flusher, which streamed responses depend on
+		snoop := httpsnoop.CaptureMetrics(next, w, r)
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(snoop.Code)).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, route).Observe(snoop.Duration.Seconds())
+	})
+}
@@ -0,0 +1,102 @@
+package telemetry
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/models"
+)
+
+func TestObservePipeline_CountsByOutcome(t *testing.T) {
+	succeeded := testutil.ToFloat64(pipelineRuns.WithLabelValues("main", "mistral", OutcomeSuccess))
+	failed := testutil.ToFloat64(pipelineRuns.WithLabelValues("main", "mistral", OutcomeError))
+
+	ObservePipeline("main", "mistral", []string{"mistral"}, nil, time.Second)
+	ObservePipeline("main", "mistral", []string{"mistral"}, errors.New("boom"), time.Second)
+	ObservePipeline("main", "mistral", []string{"mistral"}, nil, time.Second)
+
+	assert.Equal(t, succeeded+2, testutil.ToFloat64(pipelineRuns.WithLabelValues("main", "mistral", OutcomeSuccess)))
+	assert.Equal(t, failed+1, testutil.ToFloat64(pipelineRuns.WithLabelValues("main", "mistral", OutcomeError)))
+}
+
+func TestObservePipeline_UnconfiguredModelIsOther(t *testing.T) {
+	other := testutil.ToFloat64(pipelineRuns.WithLabelValues("main", OtherModel, OutcomeSuccess))
+	series := testutil.CollectAndCount(pipelineRuns)
+
+	ObservePipeline("main", "made-up-by-the-client", []string{"mistral"}, nil, time.Second)
+	ObservePipeline("main", "made-up-again", []string{"mistral"}, nil, time.Second)
+
+	assert.Equal(t, other+2, testutil.ToFloat64(pipelineRuns.WithLabelValues("main", OtherModel, OutcomeSuccess)))
+	assert.Equal(t, series, testutil.CollectAndCount(pipelineRuns), "expected no series for the client's made-up models")
+}
+
+func TestObserveStep_CountsErrors(t *testing.T) {
+	errorsBefore := testutil.ToFloat64(stepErrors.WithLabelValues("flaky"))
+	ObserveStep("flaky", nil, time.Millisecond)
+	ObserveStep("flaky", errors.New("boom"), time.Millisecond)
+	assert.Equal(t, errorsBefore+1, testutil.ToFloat64(stepErrors.WithLabelValues("flaky")))
+}
+
+func TestObserveToolCall(t *testing.T) {
+	before := testutil.ToFloat64(mcpToolCalls.WithLabelValues("home", "lights_off", OutcomeError))
+	ObserveToolCall("home", "lights_off", errors.New("no lights"))
+	assert.Equal(t, before+1, testutil.ToFloat64(mcpToolCalls.WithLabelValues("home", "lights_off", OutcomeError)))
+}
+
+func TestObserveUsage_CountsByIdentity(t *testing.T) {
+	kitchen := testutil.ToFloat64(tokens.WithLabelValues("main", "kitchen", "mistral", "prompt"))
+	anonymous := testutil.ToFloat64(tokens.WithLabelValues("main", "", "mistral", "completion"))
+
+	ObserveUsage("main", &models.Identity{Name: "kitchen"}, map[string]models.Usage{"mistral": {PromptTokens: 7, CompletionTokens: 3}})
+	ObserveUsage("main", nil, map[string]models.Usage{"mistral": {PromptTokens: 1, CompletionTokens: 2}})
+
+	assert.Equal(t, kitchen+7, testutil.ToFloat64(tokens.WithLabelValues("main", "kitchen", "mistral", "prompt")))
+	assert.Equal(t, anonymous+2, testutil.ToFloat64(tokens.WithLabelValues("main", "", "mistral", "completion")))
+}
+
+func TestObserveTokenRate_IgnoresUntimedResponses(t *testing.T) {
+	ObserveTokenRate("tiny", 0, time.Second)
+	ObserveTokenRate("tiny", 5, 0)
+	assert.Equal(t, 0, testutil.CollectAndCount(llmTokensPerSecond.MustCurryWith(map[string]string{"model": "tiny"})))
+	ObserveTokenRate("tiny", 5, time.Second)
+	assert.Equal(t, 1, testutil.CollectAndCount(llmTokensPerSecond.MustCurryWith(map[string]string{"model": "tiny"})))
+}
+
+func TestMetricsMiddleware_LabelsByRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(MetricsMiddleware)
+	router.HandleFunc("/v1/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		_, flushes := w.(http.Flusher)
+		assert.True(t, flushes, "streamed responses need the writer to stay a Flusher")
+		w.WriteHeader(http.StatusTeapot)
+	}).Methods(http.MethodGet)
+	counter := httpRequests.WithLabelValues(http.MethodGet, "/v1/models/{model}", "418")
+	before := testutil.ToFloat64(counter)
+
+	for _, model := range []string{"mistral", "llama"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/models/"+model, nil))
+	}
+
+	assert.Equal(t, before+2, testutil.ToFloat64(counter))
+}
+
+func TestMetricsHandler_ServesRegistry(t *testing.T) {
+	ObserveEmbedding("nomic-embed-text", nil, 10*time.Millisecond)
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	body, err := io.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(string(body), `snidemind_embedder_duration_seconds_count{model="nomic-embed-text",outcome="success"}`))
+	assert.True(t, strings.Contains(string(body), "go_goroutines"))
+}
@@ -0,0 +1,15 @@
+package telemetry
+
+import (
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+)
+
+var Module = fx.Module(
+	"telemetry",
+	fx.Provide(
+		NewTracerProvider,
+	),
+	// Nothing asks for the provider, it's reached through the otel globals, so make sure it gets built.
+	fx.Invoke(func(trace.TracerProvider) {}),
+)
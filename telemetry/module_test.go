@@ -0,0 +1,26 @@
+package telemetry
+
+import (
+	"testing"
+
+	"github.com/teagan42/snidemind/config"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func TestModule_ShutsDownProvider(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	app := fxtest.New(
+		t,
+		fx.Provide(func() *zap.Logger { return zap.NewNop() }),
+		fx.Provide(func() *config.Config {
+			return &config.Config{Telemetry: &config.TelemetryConfig{Exporter: ExporterStdout}}
+		}),
+		Module,
+	)
+	app.RequireStart()
+	app.RequireStop()
+}
@@ -0,0 +1,178 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	InstrumentationName = "github.com/teagan42/snidemind"
+	DefaultServiceName  = "snidemind"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Attributes recorded on step spans.
+const (
+	StepNameKey    = attribute.Key("snidemind.step.name")
+	StepSkippedKey = attribute.Key("snidemind.step.skipped")
+	TagCountKey    = attribute.Key("snidemind.message.tags")
+	ToolCountKey   = attribute.Key("snidemind.message.tools")
+	ForkBranchKey  = attribute.Key("snidemind.fork.branch")
+)
+
+type Params struct {
+	fx.In
+	Config    *config.Config
+	Logger    *zap.Logger
+	Lifecycle fx.Lifecycle
+}
+
+type Result struct {
+	fx.Out
+	TracerProvider trace.TracerProvider
+}
+
+// NewTracerProvider installs the global tracer provider and W3C trace context propagator.
+// The provider is flushed and shut down when the app stops.
+func NewTracerProvider(p Params) (Result, error) {
+	logger := p.Logger.Named("Telemetry")
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	telemetryConfig := p.Config.Telemetry
+	if telemetryConfig == nil || telemetryConfig.Exporter == ExporterNone {
+		logger.Info("Telemetry export disabled")
+		return Result{TracerProvider: otel.GetTracerProvider()}, nil
+	}
+	exporter, err := newExporter(*telemetryConfig)
+	if err != nil {
+		return Result{}, fmt.Errorf("telemetry: failed to create %s exporter: %w", telemetryConfig.Exporter, err)
+	}
+	serviceName := telemetryConfig.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+	serviceResource, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return Result{}, fmt.Errorf("telemetry: failed to build resource: %w", err)
+	}
+	ratio := 1.0
+	if telemetryConfig.SampleRatio != nil {
+		ratio = *telemetryConfig.SampleRatio
+	}
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(serviceResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	}
+	if telemetryConfig.Exporter == ExporterStdout {
+		// Batching would only delay the output, and stdout is for watching spans go by
+		options = append(options, sdktrace.WithSyncer(exporter))
+	} else {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	logger.Info("Telemetry export enabled", zap.String("exporter", telemetryConfig.Exporter), zap.String("endpoint", telemetryConfig.Endpoint), zap.Float64("sampleRatio", ratio))
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return provider.Shutdown(ctx)
+		},
+	})
+	return Result{TracerProvider: provider}, nil
+}
+
+func newExporter(telemetryConfig config.TelemetryConfig) (sdktrace.SpanExporter, error) {
+	switch telemetryConfig.Exporter {
+	case ExporterStdout:
+		return stdouttrace.New()
+	case ExporterOTLP:
+		// Without an endpoint the exporter falls back to OTEL_EXPORTER_OTLP_* or localhost:4318
+		options := []otlptracehttp.Option{}
+		if telemetryConfig.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(telemetryConfig.Endpoint))
+		}
+		return otlptracehttp.New(context.Background(), options...)
+	}
+	return nil, fmt.Errorf("unknown exporter %q", telemetryConfig.Exporter)
+}
+
+// Tracer returns the tracer used for SnideMind's own spans. It follows the global provider,
+// so it can be grabbed before the provider is installed.
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// StartSpan starts a span under the message's current span and makes it the current one, so anything the
+// caller does with the message is recorded beneath it. It returns the span and the context it replaced.
+func StartSpan(msg *models.PipelineMessage, name string, attributes ...attribute.KeyValue) (trace.Span, context.Context) {
+	parent := msg.Context()
+	ctx, span := Tracer().Start(parent, name, trace.WithAttributes(attributes...))
+	msg.SetContext(ctx)
+	return span, parent
+}
+
+// EndSpan marks the span as failed when err is set and ends it.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// MessageAttributes describe the message's size on a span.
+func MessageAttributes(msg *models.PipelineMessage) []attribute.KeyValue {
+	tags, tools := 0, 0
+	if msg.Tags != nil {
+		tags = len(*msg.Tags)
+	}
+	if msg.Tools != nil {
+		tools = len(*msg.Tools)
+	}
+	return []attribute.KeyValue{TagCountKey.Int(tags), ToolCountKey.Int(tools)}
+}
+
+// NewHTTPClient returns a client whose requests are recorded as `<name> <method>` spans and carry the
+// W3C trace context of the request's context upstream.
+func NewHTTPClient(name string) *http.Client {
+	return &http.Client{
+		Transport: otelhttp.NewTransport(
+			http.DefaultTransport,
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return name + " " + r.Method
+			}),
+		),
+	}
+}
+
+// Handler records every request as a span, continuing the caller's trace when it sent one.
+func Handler(handler http.Handler) http.Handler {
+	return otelhttp.NewHandler(
+		handler,
+		DefaultServiceName,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
@@ -0,0 +1,137 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/teagan42/snidemind/config"
+	"github.com/teagan42/snidemind/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func newProvider(t *testing.T, telemetryConfig *config.TelemetryConfig) (Result, error) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return NewTracerProvider(Params{
+		Config:    &config.Config{Telemetry: telemetryConfig},
+		Logger:    zap.NewNop(),
+		Lifecycle: fxtest.NewLifecycle(t),
+	})
+}
+
+func TestNewTracerProvider_DisabledKeepsGlobalProvider(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	for _, telemetryConfig := range []*config.TelemetryConfig{nil, {Exporter: ExporterNone}} {
+		result, err := newProvider(t, telemetryConfig)
+		require.NoError(t, err)
+		assert.Equal(t, previous, result.TracerProvider)
+	}
+}
+
+func TestNewTracerProvider_InstallsExporter(t *testing.T) {
+	for _, telemetryConfig := range []*config.TelemetryConfig{
+		{Exporter: ExporterStdout},
+		{Exporter: ExporterOTLP, Endpoint: "http://localhost:4318"},
+	} {
+		t.Run(telemetryConfig.Exporter, func(t *testing.T) {
+			result, err := newProvider(t, telemetryConfig)
+			require.NoError(t, err)
+			assert.IsType(t, &sdktrace.TracerProvider{}, result.TracerProvider)
+			assert.Equal(t, result.TracerProvider, otel.GetTracerProvider())
+		})
+	}
+}
+
+func TestNewTracerProvider_UnknownExporter(t *testing.T) {
+	_, err := newProvider(t, &config.TelemetryConfig{Exporter: "carrier-pigeon"})
+	assert.EqualError(t, err, `telemetry: failed to create carrier-pigeon exporter: unknown exporter "carrier-pigeon"`)
+}
+
+func TestStartSpan_NestsUnderMessageContext(t *testing.T) {
+	recorder := recordSpans(t)
+	msg := &models.PipelineMessage{Tags: &map[string]string{"a": "a"}}
+
+	outer, outerParent := StartSpan(msg, "outer")
+	inner, innerParent := StartSpan(msg, "inner")
+	assert.Equal(t, inner.SpanContext(), trace.SpanContextFromContext(msg.Context()))
+	msg.SetContext(innerParent)
+	EndSpan(inner, errors.New("boom"))
+	msg.SetContext(outerParent)
+	EndSpan(outer, nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "inner", spans[0].Name())
+	assert.Equal(t, outer.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, codes.Unset, spans[1].Status().Code)
+	assert.Equal(t, context.Background(), msg.Context())
+}
+
+func TestMessageAttributes(t *testing.T) {
+	msg := &models.PipelineMessage{Tags: &map[string]string{"a": "a", "b": "b"}, Tools: &[]models.MCPTool{{}}}
+	assert.Equal(t, []attribute.KeyValue{TagCountKey.Int(2), ToolCountKey.Int(1)}, MessageAttributes(msg))
+	assert.Equal(t, []attribute.KeyValue{TagCountKey.Int(0), ToolCountKey.Int(0)}, MessageAttributes(&models.PipelineMessage{}))
+}
+
+func TestNewHTTPClient_PropagatesTraceContext(t *testing.T) {
+	recorder := recordSpans(t)
+	_, err := newProvider(t, nil) // installs the W3C propagator
+	require.NoError(t, err)
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+
+	msg := &models.PipelineMessage{}
+	span, _ := StartSpan(msg, "step")
+	req, err := http.NewRequestWithContext(msg.Context(), "POST", upstream.URL, nil)
+	require.NoError(t, err)
+	resp, err := NewHTTPClient("llm").Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	EndSpan(span, nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "llm POST", spans[0].Name())
+	assert.Equal(t, span.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+}
+
+func TestHandler_ContinuesCallerTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	_, err := newProvider(t, nil)
+	require.NoError(t, err)
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "POST /v1/chat/completions", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+}
@@ -0,0 +1,16 @@
+package utils
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNewID(t *testing.T) {
+	id := NewID("msg_")
+	if !regexp.MustCompile(`^msg_[0-9a-f]{24}$`).MatchString(id) {
+		t.Errorf("expected msg_ and 24 hex digits, got %s", id)
+	}
+	if NewID("msg_") == id {
+		t.Error("expected a different ID every time")
+	}
+}
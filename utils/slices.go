@@ -17,3 +17,23 @@ func Intersection[T comparable](a []T, b []T) []T {
 
 	return intersection
 }
+
+func Union[T comparable](a []T, b []T) []T {
+	return UnionFunc(a, b, func(item T) T { return item })
+}
+
+func UnionFunc[T any, K comparable](a []T, b []T, key func(T) K) []T {
+	// Keep the first occurrence of each key, preserving order
+	seen := make(map[K]struct{}, len(a)+len(b))
+	union := make([]T, 0, len(a)+len(b))
+	for _, item := range append(append([]T{}, a...), b...) {
+		k := key(item)
+		if _, found := seen[k]; found {
+			continue
+		}
+		seen[k] = struct{}{}
+		union = append(union, item)
+	}
+
+	return union
+}
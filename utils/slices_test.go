@@ -70,3 +70,54 @@ func TestIntersection_Structs(t *testing.T) {
 		}
 	}
 }
+
+func TestUnion_Ints(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        []int
+		b        []int
+		expected []int
+	}{
+		{"BothEmpty", []int{}, []int{}, []int{}},
+		{"AEmpty", []int{}, []int{1, 2}, []int{1, 2}},
+		{"Disjoint", []int{1, 2}, []int{3, 4}, []int{1, 2, 3, 4}},
+		{"Overlap", []int{1, 2, 3}, []int{2, 3, 4}, []int{1, 2, 3, 4}},
+		{"DuplicatesInA", []int{1, 1, 2}, []int{2}, []int{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Union(tt.a, tt.b)
+			if len(got) != len(tt.expected) {
+				t.Errorf("Union(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.expected)
+				return
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("Union(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.expected)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestUnionFunc_KeepsFirstOccurrence(t *testing.T) {
+	type item struct {
+		Key   string
+		Value int
+	}
+	a := []item{{"x", 1}, {"y", 2}}
+	b := []item{{"y", 3}, {"z", 4}}
+	expected := []item{{"x", 1}, {"y", 2}, {"z", 4}}
+
+	got := UnionFunc(a, b, func(i item) string { return i.Key })
+	if len(got) != len(expected) {
+		t.Fatalf("UnionFunc(%v, %v) = %v, want %v", a, b, got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("UnionFunc(%v, %v) = %v, want %v", a, b, got, expected)
+		}
+	}
+}